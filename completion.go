@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// completionCommand describes a command for the purpose of shell completion
+type completionCommand struct {
+	Name  string
+	Desc  string
+	Flags []string
+
+	// Subcommands are offered as the first argument of the command
+	Subcommands []string
+
+	// Images is true if the first argument of the command is an image
+	Images bool
+
+	// Dirs is true if the command takes a directory as argument
+	Dirs bool
+
+	// Files is true if the command takes a file as argument
+	Files bool
+}
+
+// completions lists the commands offered by roots, keep this in sync with
+// the commands registered in main
+var completions = []completionCommand{
+	{Name: "version", Desc: "Show version"},
+	{Name: "digest", Desc: "Show the latest digest", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--arch", "--os", "--variant",
+			"--strict-platform", "--first-platform", "--layers", "--json"}},
+	{Name: "platforms", Desc: "List the platforms of an image", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--json"}},
+	{Name: "lock", Desc: "Pin the images in a file to their digests", Files: true,
+		Flags: []string{"--auth", "--auth-file", "--lockfile"}},
+	{Name: "ping", Desc: "Check the connection and authentication to a registry", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--json"}},
+	{Name: "tags", Desc: "List the tags of a repository", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--match", "--semver", "--created", "--latest",
+			"--json"}},
+	{Name: "referrers", Desc: "List the artifacts attached to an image", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--arch", "--os", "--variant", "--artifact-type",
+			"--json"}},
+	{Name: "inspect", Desc: "Show the manifest and config of an image", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--arch", "--os", "--variant",
+			"--strict-platform", "--first-platform"}},
+	{Name: "config", Desc: "Show the config of an image", Images: true,
+		Flags: []string{"--auth", "--auth-file", "--arch", "--os", "--variant",
+			"--strict-platform", "--first-platform", "--json"}},
+	{Name: "diff", Desc: "Compare two images", Images: true,
+		Flags: []string{"--config", "--files", "--auth", "--auth-file", "--arch", "--os", "--variant",
+			"--cache", "--json"}},
+	{Name: "purge", Desc: "Purge unused files from the cache",
+		Flags: []string{"--cache"}},
+	{Name: "pull", Desc: "Download and extract", Images: true, Dirs: true,
+		Flags: []string{"--auth", "--auth-file", "--arch", "--os", "--variant", "--cache",
+			"--force", "--merge", "--update", "--layout", "--preserve-owner", "--preserve-xattrs",
+			"--id-map-file", "--ignore-chown-errors", "--selinux-label", "--validate-only",
+			"--dry-run", "--dedup", "--json", "--no-history", "--write-config", "--policy", "--resolve",
+			"--strict-platform", "--first-platform", "--require-digest", "--locked", "--lockfile",
+			"--verify-reproducible", "--tree-hash", "--notify-url",
+			"--pre-hook", "--post-hook", "--expansion-factor",
+			"--extract-workers", "--share", "--tmpdir", "--verify-tag", "--if-changed", "--timeout",
+			"--retries", "--max-concurrent-downloads", "--verify-signature", "--signature-key",
+			"--certificate-identity", "--certificate-oidc-issuer", "--sigstore-root",
+			"--allow-schema1"}},
+	{Name: "save", Desc: "Save an image to a directory or tarball", Images: true, Dirs: true,
+		Flags: []string{"--format", "--auth", "--auth-file", "--arch", "--os", "--variant",
+			"--strict-platform", "--first-platform", "--retries"}},
+	{Name: "push", Desc: "Push a directory or tarball as image", Dirs: true,
+		Flags: []string{"--auth", "--arch", "--os", "--variant", "--platform", "--numeric-owner",
+			"--owner", "--group", "--tmpdir"}},
+	{Name: "tag", Desc: "Tag an image on the registry", Images: true,
+		Flags: []string{"--auth"}},
+	{Name: "delete", Desc: "Delete an image from the registry", Images: true,
+		Flags: []string{"--auth"}},
+	{Name: "login", Desc: "Log in to a registry",
+		Flags: []string{"--device", "--client-id"}},
+	{Name: "list", Desc: "List destinations known to the cache",
+		Flags: []string{"--cache", "--json"}},
+	{Name: "status", Desc: "Show the provenance of a destination", Dirs: true,
+		Flags: []string{"--verify", "--json"}},
+	{Name: "serve", Desc: "Serve pull, digest, purge and status on a unix socket",
+		Flags: []string{"--socket", "--cache", "--auth-file", "--policy", "--dedup",
+			"--notify-url"}},
+	{Name: "tree-hash", Desc: "Show the hash of a directory tree", Dirs: true},
+	{Name: "cache", Desc: "Inspect the cache", Subcommands: []string{"ls", "info"},
+		Flags: []string{"--cache", "--json"}},
+	{Name: "completion", Desc: "Generate shell completion scripts"},
+}
+
+// completionShells lists the shells for which completion scripts exist
+var completionShells = []string{"bash", "fish", "zsh"}
+
+// writeCompletion writes the completion script for the given shell
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w)
+	case "fish":
+		writeFishCompletion(w)
+	case "zsh":
+		writeZshCompletion(w)
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	return nil
+}
+
+func completionCommandNames() string {
+	names := make([]string, len(completions))
+	for i, c := range completions {
+		names[i] = c.Name
+	}
+
+	return strings.Join(names, " ")
+}
+
+func writeBashCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# bash completion for roots")
+	fmt.Fprintln(w, "_roots() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `    local cmd="${COMP_WORDS[1]}"`)
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    if [ \"$COMP_CWORD\" -eq 1 ]; then")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", completionCommandNames())
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    case \"$cmd\" in")
+
+	for _, c := range completions {
+		fmt.Fprintf(w, "    %s)\n", c.Name)
+
+		if c.Name == "completion" {
+			fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(completionShells, " "))
+			fmt.Fprintln(w, "        ;;")
+			continue
+		}
+
+		if len(c.Flags) > 0 {
+			fmt.Fprintln(w, "        if [[ \"$cur\" == -* ]]; then")
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(c.Flags, " "))
+			fmt.Fprintln(w, "            return")
+			fmt.Fprintln(w, "        fi")
+		}
+
+		if len(c.Subcommands) > 0 {
+			fmt.Fprintln(w, "        if [ \"$COMP_CWORD\" -eq 2 ]; then")
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(c.Subcommands, " "))
+			fmt.Fprintln(w, "            return")
+			fmt.Fprintln(w, "        fi")
+		}
+
+		if c.Images {
+			fmt.Fprintln(w, "        if [ \"$COMP_CWORD\" -eq 2 ]; then")
+			fmt.Fprintln(w, "            COMPREPLY=($(compgen -W \"$(roots __images 2>/dev/null)\" -- \"$cur\"))")
+			fmt.Fprintln(w, "            return")
+			fmt.Fprintln(w, "        fi")
+		}
+
+		if c.Dirs {
+			fmt.Fprintln(w, "        COMPREPLY=($(compgen -d -- \"$cur\"))")
+		}
+
+		if c.Files {
+			fmt.Fprintln(w, "        COMPREPLY=($(compgen -f -- \"$cur\"))")
+		}
+
+		fmt.Fprintln(w, "        ;;")
+	}
+
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "complete -F _roots roots")
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintln(w, "#compdef roots")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "_roots() {")
+	fmt.Fprintln(w, "    local -a commands")
+	fmt.Fprintln(w, "    commands=(")
+
+	for _, c := range completions {
+		fmt.Fprintf(w, "        '%s:%s'\n", c.Name, c.Desc)
+	}
+
+	fmt.Fprintln(w, "    )")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    if (( CURRENT == 2 )); then")
+	fmt.Fprintln(w, "        _describe 'command' commands")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    case \"$words[2]\" in")
+
+	for _, c := range completions {
+		fmt.Fprintf(w, "    %s)\n", c.Name)
+
+		if c.Name == "completion" {
+			fmt.Fprintf(w, "        _values 'shell' %s\n", strings.Join(completionShells, " "))
+			fmt.Fprintln(w, "        ;;")
+			continue
+		}
+
+		fmt.Fprintln(w, "        if [[ \"$PREFIX\" == -* ]]; then")
+		fmt.Fprintf(w, "            compadd -- %s\n", strings.Join(c.Flags, " "))
+
+		if len(c.Subcommands) > 0 {
+			fmt.Fprintln(w, "        elif (( CURRENT == 3 )); then")
+			fmt.Fprintf(w, "            compadd -- %s\n", strings.Join(c.Subcommands, " "))
+		}
+
+		if c.Images {
+			fmt.Fprintln(w, "        elif (( CURRENT == 3 )); then")
+			fmt.Fprintln(w, "            compadd -- ${(f)\"$(roots __images 2>/dev/null)\"}")
+		}
+
+		if c.Dirs {
+			fmt.Fprintln(w, "        else")
+			fmt.Fprintln(w, "            _files -/")
+		}
+
+		if c.Files {
+			fmt.Fprintln(w, "        else")
+			fmt.Fprintln(w, "            _files")
+		}
+
+		fmt.Fprintln(w, "        fi")
+		fmt.Fprintln(w, "        ;;")
+	}
+
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "_roots \"$@\"")
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# fish completion for roots")
+	fmt.Fprintln(w, "complete -c roots -f")
+
+	for _, c := range completions {
+		fmt.Fprintf(w, "complete -c roots -n '__fish_use_subcommand' -a %s -d '%s'\n", c.Name, c.Desc)
+	}
+
+	for _, c := range completions {
+		cond := fmt.Sprintf("__fish_seen_subcommand_from %s", c.Name)
+
+		if c.Name == "completion" {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -a '%s'\n", cond, strings.Join(completionShells, " "))
+			continue
+		}
+
+		for _, flag := range c.Flags {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -l %s\n", cond, strings.TrimPrefix(flag, "--"))
+		}
+
+		if len(c.Subcommands) > 0 {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -a '%s'\n", cond, strings.Join(c.Subcommands, " "))
+		}
+
+		if c.Images {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -a '(roots __images 2>/dev/null)'\n", cond)
+		}
+
+		if c.Dirs {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -a '(__fish_complete_directories)'\n", cond)
+		}
+
+		if c.Files {
+			fmt.Fprintf(w, "complete -c roots -n '%s' -F\n", cond)
+		}
+	}
+}
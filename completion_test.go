@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompletionCommands tests that the completions list the commands
+// registered in main, except for the hidden ones
+func TestCompletionCommands(t *testing.T) {
+	source, err := os.ReadFile("roots.go")
+	assert.NoError(t, err)
+
+	registered := []string{}
+	for _, m := range regexp.MustCompile(`app\.Command\("([a-z-]+)"`).FindAllStringSubmatch(string(source), -1) {
+		registered = append(registered, m[1])
+	}
+
+	listed := strings.Fields(completionCommandNames())
+
+	assert.NotEmpty(t, registered)
+	assert.ElementsMatch(t, registered, listed)
+}
+
+// TestCompletionScripts tests that every command and its flags are offered
+// by the completion script of each shell
+func TestCompletionScripts(t *testing.T) {
+	tests := []struct {
+		shell   string
+		command func(c completionCommand) string
+		flag    func(c completionCommand, flag string) string
+		check   []string
+	}{
+		{
+			shell:   "bash",
+			command: func(c completionCommand) string { return fmt.Sprintf("\n    %s)\n", c.Name) },
+			flag:    func(c completionCommand, flag string) string { return flag },
+			check:   []string{"bash", "-n"},
+		},
+		{
+			shell:   "zsh",
+			command: func(c completionCommand) string { return fmt.Sprintf("'%s:%s'", c.Name, c.Desc) },
+			flag:    func(c completionCommand, flag string) string { return flag },
+			check:   []string{"zsh", "-n"},
+		},
+		{
+			shell: "fish",
+			command: func(c completionCommand) string {
+				return fmt.Sprintf("-n '__fish_use_subcommand' -a %s -d '%s'", c.Name, c.Desc)
+			},
+			flag: func(c completionCommand, flag string) string {
+				return fmt.Sprintf("-n '__fish_seen_subcommand_from %s' -l %s", c.Name, strings.TrimPrefix(flag, "--"))
+			},
+			check: []string{"fish", "--no-execute"},
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		assert.NoError(t, writeCompletion(&buf, test.shell))
+
+		script := buf.String()
+
+		for _, c := range completions {
+			assert.Contains(t, script, test.command(c), test.shell)
+
+			for _, flag := range c.Flags {
+				assert.Contains(t, script, test.flag(c, flag), test.shell)
+			}
+
+			for _, sub := range c.Subcommands {
+				assert.Contains(t, script, sub, test.shell)
+			}
+		}
+
+		// the syntax is checked by the shell, if it is installed
+		if _, err := exec.LookPath(test.check[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(test.check[0], test.check[1:]...)
+		cmd.Stdin = strings.NewReader(script)
+
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, "%s: %s", test.shell, out)
+	}
+
+	assert.ErrorContains(t, writeCompletion(&bytes.Buffer{}, "powershell"), "unsupported shell")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// Config holds the settings read from the configuration file, flags and env
+// vars take precedence over it
+type Config struct {
+
+	// Cache is the cache folder (like --cache)
+	Cache string `json:"cache"`
+
+	// Retain is the number of pulls per destination whose layers are kept
+	// in the cache by purge
+	Retain int `json:"retain"`
+
+	// Policy is the path to a containers-policy.json file (like --policy)
+	Policy string `json:"policy"`
+
+	// VerifySignature refuses images without valid cosign signature, made
+	// with SignatureKey or by the keyless CertificateIdentity, which is
+	// checked against the Fulcio roots and Rekor key in SigstoreRoot (like
+	// --verify-signature and the flags of the same name)
+	VerifySignature       bool   `json:"verify_signature"`
+	SignatureKey          string `json:"signature_key"`
+	CertificateIdentity   string `json:"certificate_identity"`
+	CertificateOIDCIssuer string `json:"certificate_oidc_issuer"`
+	SigstoreRoot          string `json:"sigstore_root"`
+
+	// RequireDigest refuses to pull images without digest (like
+	// --require-digest)
+	RequireDigest bool `json:"require_digest"`
+
+	// DestinationDir and DestinationTemplate define the destination of pulls
+	// without DEST, supporting {repository}, {name} and {tag} (defaults
+	// to {name}-{tag} in the working directory)
+	DestinationDir      string `json:"destination_dir"`
+	DestinationTemplate string `json:"destination_template"`
+
+	// ExpansionFactor is the ratio of uncompressed to compressed layer sizes
+	// assumed by the disk space check (like --expansion-factor)
+	ExpansionFactor float64 `json:"expansion_factor"`
+
+	// ExtractWorkers is the number of files written concurrently within a
+	// layer (like --extract-workers)
+	ExtractWorkers int `json:"extract_workers"`
+
+	// Share is how the files of destinations are shared with the cache,
+	// either "none", "hardlink" or "reflink" (like --share)
+	Share string `json:"share"`
+
+	// MaxConcurrentDownloads is the number of layers downloaded at the same
+	// time (like --max-concurrent-downloads)
+	MaxConcurrentDownloads int `json:"max_concurrent_downloads"`
+
+	// Retries is the number of times failed registry requests are retried
+	// (like --retries, defaults to 3)
+	Retries int `json:"retries"`
+
+	// Proxy is the proxy used to connect to registries (like --proxy)
+	Proxy string `json:"proxy"`
+
+	// CACert is a PEM file with additional certificate authorities and
+	// InsecureSkipTLSVerify accepts any certificate (like --ca-cert and
+	// --insecure-skip-tls-verify)
+	CACert                string `json:"ca_cert"`
+	InsecureSkipTLSVerify bool   `json:"insecure_skip_tls_verify"`
+
+	// InsecureHTTP lists the registries reached through plain HTTP (like
+	// --insecure-http)
+	InsecureHTTP []string `json:"insecure_http"`
+
+	// TmpDir holds temporary files, like the images exported from other
+	// stores (like --tmpdir)
+	TmpDir string `json:"tmpdir"`
+
+	// HooksDir contains the pre and post directories with the executables
+	// run for each pull (defaults to hooks.d next to the config file)
+	HooksDir string `json:"hooks_dir"`
+
+	// NotifyURL receives a summary of each pull (like --notify-url)
+	NotifyURL string `json:"notify_url"`
+
+	// Destinations holds settings for specific destinations
+	Destinations map[string]DestinationConfig `json:"destinations"`
+}
+
+// DestinationConfig holds the settings of a specific destination
+type DestinationConfig struct {
+	Retain int `json:"retain"`
+}
+
+// config is the configuration loaded at startup
+var config = &Config{}
+
+// defaultConfigPath returns the path to the configuration file
+func defaultConfigPath() string {
+	if p := os.Getenv("ROOTS_CONFIG"); p != "" {
+		return p
+	}
+
+	usr, err := user.Current()
+	if err != nil || usr.Uid == "0" || usr.HomeDir == "" {
+		return "/etc/roots/config.json"
+	}
+
+	return path.Join(usr.HomeDir, ".config", "seantis", "roots", "config.json")
+}
+
+// storedCredentialsPath returns the path to the credentials stored by login,
+// which is next to the configuration file
+func storedCredentialsPath() string {
+	return path.Join(path.Dir(defaultConfigPath()), "auth.json")
+}
+
+// registries is the registries.conf (including its drop-ins) loaded at
+// startup, nil if there is none
+var registries *image.RegistriesConfig
+
+// defaultRegistriesConfigPath returns the path to the registries.conf shared
+// with other containers tools, or an empty string if there's none
+func defaultRegistriesConfigPath() string {
+	if p := os.Getenv("CONTAINERS_REGISTRIES_CONF"); p != "" {
+		return p
+	}
+
+	candidates := []string{"/etc/containers/registries.conf"}
+
+	if usr, err := user.Current(); err == nil && usr.Uid != "0" && usr.HomeDir != "" {
+		candidates = append([]string{
+			path.Join(usr.HomeDir, ".config", "containers", "registries.conf"),
+		}, candidates...)
+	}
+
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+
+		if _, err := os.Stat(p + ".d"); err == nil {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// loadRegistries reads the registries.conf at the given path, if any
+func loadRegistries(file string) (*image.RegistriesConfig, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	return image.LoadRegistriesConfig(file)
+}
+
+// loadConfig reads the configuration file at the given path, a missing file
+// results in the default configuration
+func loadConfig(file string) (*Config, error) {
+	c := &Config{}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", file, err)
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", file, err)
+	}
+
+	return c, nil
+}
+
+// retention returns the retention policy of the configuration
+func (c *Config) retention() *image.Retention {
+	r := &image.Retention{
+		Default:      c.Retain,
+		Destinations: make(map[string]int),
+	}
+
+	for dst, d := range c.Destinations {
+		if d.Retain > 0 {
+			r.Destinations[path.Clean(dst)] = d.Retain
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadConfig tests reading valid, invalid and missing config files
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		config  *Config
+		err     string
+	}{
+		{
+			name: "valid",
+			content: `{
+				"cache": "/var/cache/roots",
+				"retain": 2,
+				"require_digest": true,
+				"extract_workers": 4,
+				"insecure_http": ["registry:5000"],
+				"destinations": {"/srv/app/": {"retain": 5}}
+			}`,
+			config: &Config{
+				Cache:          "/var/cache/roots",
+				Retain:         2,
+				RequireDigest:  true,
+				ExtractWorkers: 4,
+				InsecureHTTP:   []string{"registry:5000"},
+				Destinations:   map[string]DestinationConfig{"/srv/app/": {Retain: 5}},
+			},
+		},
+		{
+			name:    "unknown keys",
+			content: `{"cache": "/var/cache/roots", "colour": "blue"}`,
+			config:  &Config{Cache: "/var/cache/roots"},
+		},
+		{
+			name:    "invalid json",
+			content: `{"cache": "/var/cache/roots",}`,
+			err:     "error parsing",
+		},
+		{
+			name:    "invalid type",
+			content: `{"retain": "two"}`,
+			err:     "error parsing",
+		},
+		{
+			name:   "missing",
+			config: &Config{},
+		},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "config.json")
+
+		if test.content != "" {
+			assert.NoError(t, os.WriteFile(file, []byte(test.content), 0644))
+		}
+
+		c, err := loadConfig(file)
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.config, c, test.name)
+	}
+
+	// directories cannot be read
+	_, err := loadConfig(t.TempDir())
+	assert.ErrorContains(t, err, "error reading")
+}
+
+// TestConfigPath tests that the config file may be moved through the env,
+// together with the files next to it
+func TestConfigPath(t *testing.T) {
+	previous := config
+	config = &Config{}
+	t.Cleanup(func() { config = previous })
+
+	t.Setenv("ROOTS_CONFIG", "/opt/roots/config.json")
+
+	assert.Equal(t, "/opt/roots/config.json", defaultConfigPath())
+	assert.Equal(t, "/opt/roots/auth.json", storedCredentialsPath())
+	assert.Equal(t, "/opt/roots/hooks.d", defaultHooksDir())
+
+	config.HooksDir = "/etc/roots/hooks"
+	assert.Equal(t, "/etc/roots/hooks", defaultHooksDir())
+}
+
+// TestConfigPrecedence tests that flags take precedence over the env, which
+// takes precedence over the config file
+func TestConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		flag   string
+		env    string
+		config *Config
+		value  func(flag string) interface{}
+		result interface{}
+	}{
+		{
+			name:   "cache from config",
+			config: &Config{Cache: "/config"},
+			value:  func(flag string) interface{} { return valueOrEnv(flag, "ROOTS_CACHE", config.Cache) },
+			result: "/config",
+		},
+		{
+			name:   "cache from env",
+			env:    "/env",
+			config: &Config{Cache: "/config"},
+			value:  func(flag string) interface{} { return valueOrEnv(flag, "ROOTS_CACHE", config.Cache) },
+			result: "/env",
+		},
+		{
+			name:   "cache from flag",
+			flag:   "/flag",
+			env:    "/env",
+			config: &Config{Cache: "/config"},
+			value:  func(flag string) interface{} { return valueOrEnv(flag, "ROOTS_CACHE", config.Cache) },
+			result: "/flag",
+		},
+		{
+			name:   "default expansion factor",
+			config: &Config{},
+			value:  func(flag string) interface{} { return expansionFactor(flag) },
+			result: image.DefaultExpansionFactor,
+		},
+		{
+			name:   "expansion factor from config",
+			config: &Config{ExpansionFactor: 3},
+			value:  func(flag string) interface{} { return expansionFactor(flag) },
+			result: 3.0,
+		},
+		{
+			name:   "expansion factor from env",
+			env:    "1.5",
+			config: &Config{ExpansionFactor: 3},
+			value:  func(flag string) interface{} { return expansionFactor(flag) },
+			result: 1.5,
+		},
+		{
+			name:   "extract workers from config",
+			config: &Config{ExtractWorkers: 4},
+			value:  func(flag string) interface{} { return extractWorkers(flag) },
+			result: 4,
+		},
+		{
+			name:   "extract workers from flag",
+			flag:   "8",
+			env:    "2",
+			config: &Config{ExtractWorkers: 4},
+			value:  func(flag string) interface{} { return extractWorkers(flag) },
+			result: 8,
+		},
+		{
+			name:   "concurrent downloads from env",
+			env:    "2",
+			config: &Config{MaxConcurrentDownloads: 6},
+			value:  func(flag string) interface{} { return maxConcurrentDownloads(flag) },
+			result: 2,
+		},
+		{
+			name:   "share mode from config",
+			config: &Config{Share: "hardlink"},
+			value:  func(flag string) interface{} { return shareMode(flag) },
+			result: image.ShareHardlink,
+		},
+		{
+			name:   "share mode from flag",
+			flag:   "none",
+			env:    "reflink",
+			config: &Config{Share: "hardlink"},
+			value:  func(flag string) interface{} { return shareMode(flag) },
+			result: image.ShareNone,
+		},
+		{
+			name:   "retries from config",
+			config: &Config{Retries: 5},
+			value:  func(flag string) interface{} { setRetries(flag); return retries },
+			result: 5,
+		},
+		{
+			name:   "retries from env",
+			env:    "0",
+			config: &Config{Retries: 5},
+			value:  func(flag string) interface{} { setRetries(flag); return retries },
+			result: 0,
+		},
+	}
+
+	previous, previousRetries := config, retries
+	t.Cleanup(func() { config, retries = previous, previousRetries })
+
+	envs := []string{
+		"ROOTS_CACHE", "ROOTS_EXPANSION_FACTOR", "ROOTS_EXTRACT_WORKERS",
+		"ROOTS_MAX_CONCURRENT_DOWNLOADS", "ROOTS_SHARE", "ROOTS_RETRIES",
+	}
+
+	for _, test := range tests {
+		config, retries = test.config, image.DefaultRetries
+
+		// only the variable of the tested value is read
+		for _, env := range envs {
+			t.Setenv(env, test.env)
+		}
+
+		assert.Equal(t, test.result, test.value(test.flag), test.name)
+	}
+}
+
+// TestRetention tests the retention policy of the config
+func TestRetention(t *testing.T) {
+	c := &Config{
+		Retain: 2,
+		Destinations: map[string]DestinationConfig{
+			"/srv/app/":  {Retain: 5},
+			"/srv/other": {},
+		},
+	}
+
+	assert.Equal(t, &image.Retention{
+		Default:      2,
+		Destinations: map[string]int{"/srv/app": 5},
+	}, c.retention())
+}
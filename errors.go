@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/provider"
+	"github.com/seantis/roots/pkg/roots"
+)
+
+// jsonErrors prints the errors passed to fail as JSON objects on stdout,
+// instead of logging them (set by --json or --output json)
+var jsonErrors bool
+
+// errorReport describes why a command failed, so scripts can react to the
+// class of the error, while humans get a hint on how to fix it
+type errorReport struct {
+	Error  string `json:"error"`
+	Class  string `json:"class"`
+	Status int    `json:"status,omitempty"`
+	Code   string `json:"code,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// newErrorReport classifies the given error, which occurred while handling
+// the given image (if any)
+func newErrorReport(name string, err error) *errorReport {
+	report := &errorReport{Error: err.Error(), Class: "error"}
+
+	var requestErr *image.RequestError
+	var urlErr *url.Error
+	var layerErr *image.UnsupportedLayerError
+	var tagErr *image.TagMismatchError
+	var signatureErr *image.SignatureError
+	var digestErr *roots.DigestRequiredError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		report.Class = "timeout"
+		report.Hint = "raise the limit with --timeout"
+	case errors.Is(err, context.Canceled):
+		report.Class = "interrupted"
+	case errors.As(err, &layerErr):
+		report.Class = "unsupported_layer"
+	case errors.As(err, &tagErr):
+		report.Class = "tag_mismatch"
+		report.Hint = "the tag was pushed again, check the new image and update the pinned digest"
+	case errors.As(err, &signatureErr):
+		report.Class = "signature"
+		report.Hint = "check that the image was signed with the expected key or identity"
+	case errors.As(err, &digestErr):
+		report.Class = "policy"
+		report.Hint = "pin the image to a digest (name@sha256:...)"
+	case errors.As(err, &requestErr):
+		report.Status = requestErr.StatusCode
+		report.Code = requestErr.Code()
+		report.URL = requestErr.URL
+		report.Class, report.Hint = classifyStatus(requestErr.StatusCode, registryHost(name, requestErr.URL))
+	case errors.As(err, &urlErr):
+		report.Class = "network"
+		report.URL = urlErr.URL
+		report.Hint = fmt.Sprintf("check the connection to %s", registryHost(name, urlErr.URL))
+	}
+
+	return report
+}
+
+// classifyStatus returns the class and the hint for the given status code of
+// a registry response
+func classifyStatus(status int, host string) (string, string) {
+	switch {
+	case status == 401 && provider.SupportsDeviceLogin(host):
+		return "auth", fmt.Sprintf("run roots login --device %s", host)
+	case status == 401:
+		return "auth", fmt.Sprintf("pass credentials for %s through --auth or --auth-file", host)
+	case status == 403:
+		return "auth", fmt.Sprintf("check that the credentials for %s may access the image", host)
+	case status == 404:
+		return "not_found", fmt.Sprintf("check that the image and tag exist on %s", host)
+	case status == 429:
+		return "rate_limit", fmt.Sprintf("try again later, or pass credentials for %s to raise the limit", host)
+	case status >= 500:
+		return "registry", fmt.Sprintf("%s is unavailable, try again later", host)
+	}
+
+	return "registry", ""
+}
+
+// registryHost returns the registry of the given image, or the host of the
+// request if the image is local or unknown
+func registryHost(name string, request string) string {
+	if _, _, local := roots.LocalSource(name); name != "" && !local {
+		if u, err := image.Parse(name); err == nil {
+			return u.Registry()
+		}
+	}
+
+	if u, err := url.Parse(request); err == nil {
+		return u.Host
+	}
+
+	return request
+}
+
+// exit prints the report and exits the process
+func (r *errorReport) exit() {
+	if jsonErrors {
+		printJSON(r)
+		exit(1)
+	}
+
+	// structured logs carry the report as attributes
+	if jsonLogs {
+		attrs := []any{"class", r.Class}
+
+		if r.Status != 0 {
+			attrs = append(attrs, "status", r.Status)
+		}
+
+		for _, attr := range [][2]string{{"code", r.Code}, {"url", r.URL}, {"hint", r.Hint}} {
+			if attr[1] != "" {
+				attrs = append(attrs, attr[0], attr[1])
+			}
+		}
+
+		slog.Error(r.Error, attrs...)
+		exit(1)
+	}
+
+	slog.Error(r.Error)
+
+	if r.Hint != "" {
+		slog.Error(fmt.Sprintf("hint: %s", r.Hint))
+	}
+
+	exit(1)
+}
+
+// fail exits with the given error, which occurred while handling the given
+// image (if any)
+func fail(name string, err error) {
+	newErrorReport(name, err).exit()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/roots"
+	"github.com/stretchr/testify/assert"
+)
+
+// requestError returns the error of a registry response with the given status
+func requestError(status int, code string) error {
+	return &image.RequestError{
+		Method:     "GET",
+		URL:        "https://registry.example.org/v2/team/app/manifests/1.0",
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Errors:     []image.RegistryError{{Code: code}},
+	}
+}
+
+// TestErrorReport tests the classification of errors and their hints, which
+// decide the status codes of the server as well
+func TestErrorReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		image  string
+		err    error
+		class  string
+		hint   string
+		status int
+	}{
+		{
+			name:   "other",
+			err:    errors.New("no space left on device"),
+			class:  "error",
+			status: http.StatusConflict,
+		},
+		{
+			name:   "timeout",
+			err:    fmt.Errorf("error during pull: %w", context.DeadlineExceeded),
+			class:  "timeout",
+			hint:   "raise the limit with --timeout",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "interrupted",
+			err:    fmt.Errorf("error during pull: %w", context.Canceled),
+			class:  "interrupted",
+			status: http.StatusConflict,
+		},
+		{
+			name:   "unsupported layer",
+			err:    fmt.Errorf("error during pull: %w", &image.UnsupportedLayerError{Digest: "sha256:abc", MediaType: "application/x-custom"}),
+			class:  "unsupported_layer",
+			status: http.StatusConflict,
+		},
+		{
+			name:   "tag mismatch",
+			err:    &image.TagMismatchError{URL: image.URL{Host: "registry.example.org", Repository: "team", Name: "app", Tag: "1.0", Digest: "sha256:abc"}, Current: "sha256:def"},
+			class:  "tag_mismatch",
+			hint:   "the tag was pushed again, check the new image and update the pinned digest",
+			status: http.StatusConflict,
+		},
+		{
+			name:   "signature",
+			err:    &image.SignatureError{Image: "team/app", Reason: "no signatures found"},
+			class:  "signature",
+			hint:   "check that the image was signed with the expected key or identity",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "digest required",
+			err:    &roots.DigestRequiredError{Ref: "team/app:1.0"},
+			class:  "policy",
+			hint:   "pin the image to a digest (name@sha256:...)",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unauthorized",
+			image:  "registry.example.org/team/app:1.0",
+			err:    fmt.Errorf("failed to connect: %w", requestError(401, "UNAUTHORIZED")),
+			class:  "auth",
+			hint:   "pass credentials for registry.example.org through --auth or --auth-file",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "unauthorized with device login",
+			image:  "ghcr.io/team/app:1.0",
+			err:    requestError(401, "UNAUTHORIZED"),
+			class:  "auth",
+			hint:   "run roots login --device ghcr.io",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "denied",
+			image:  "registry.example.org/team/app:1.0",
+			err:    requestError(403, "DENIED"),
+			class:  "auth",
+			hint:   "check that the credentials for registry.example.org may access the image",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "not found",
+			image:  "debian:bookworm",
+			err:    requestError(404, "MANIFEST_UNKNOWN"),
+			class:  "not_found",
+			hint:   "check that the image and tag exist on docker.io",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "rate limit",
+			err:    requestError(429, "TOOMANYREQUESTS"),
+			class:  "rate_limit",
+			hint:   "try again later, or pass credentials for registry.example.org to raise the limit",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "unavailable",
+			image:  "docker-daemon:app",
+			err:    requestError(503, ""),
+			class:  "registry",
+			hint:   "registry.example.org is unavailable, try again later",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "unexpected status",
+			err:    requestError(400, "MANIFEST_INVALID"),
+			class:  "registry",
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "network",
+			image:  "registry.example.org/team/app:1.0",
+			err:    &url.Error{Op: "Get", URL: "https://registry.example.org/v2/", Err: errors.New("connection refused")},
+			class:  "network",
+			hint:   "check the connection to registry.example.org",
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, test := range tests {
+		report := newErrorReport(test.image, test.err)
+
+		assert.Equal(t, test.err.Error(), report.Error, test.name)
+		assert.Equal(t, test.class, report.Class, test.name)
+		assert.Equal(t, test.hint, report.Hint, test.name)
+		assert.Equal(t, test.status, pullStatus(test.err), test.name)
+	}
+
+	// registry responses are described by their status, code and url
+	report := newErrorReport("", requestError(404, "MANIFEST_UNKNOWN"))
+	assert.Equal(t, 404, report.Status)
+	assert.Equal(t, "MANIFEST_UNKNOWN", report.Code)
+	assert.Equal(t, "https://registry.example.org/v2/team/app/manifests/1.0", report.URL)
+}
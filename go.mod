@@ -3,11 +3,17 @@ module github.com/seantis/roots
 go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/alexflint/go-filemutex v1.3.0
 	github.com/dankinder/httpmock v1.0.4
 	github.com/jawher/mow.cli v1.2.0
+	github.com/klauspost/compress v1.17.9
 	github.com/stretchr/testify v1.9.0
+	github.com/vbatts/tar-split v0.11.6
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/oauth2 v0.21.0
+	golang.org/x/sys v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,6 +21,4 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	golang.org/x/sys v0.16.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
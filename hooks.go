@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// defaultHooksDir returns the directory with the hooks run for all pulls,
+// which is next to the configuration file
+func defaultHooksDir() string {
+	if config.HooksDir != "" {
+		return config.HooksDir
+	}
+
+	return path.Join(path.Dir(defaultConfigPath()), "hooks.d")
+}
+
+// hookEnv returns the environment passed to hooks, describing the pull
+func hookEnv(stage string, remote image.Source, dst string, result *image.ExtractResult) []string {
+	env := append(os.Environ(),
+		"ROOTS_HOOK="+stage,
+		"ROOTS_IMAGE="+remote.Name(),
+		"ROOTS_DESTINATION="+dst,
+	)
+
+	if result != nil {
+		env = append(env,
+			"ROOTS_DIGEST="+result.Digest,
+			"ROOTS_PLATFORM="+result.Platform,
+		)
+	}
+
+	return env
+}
+
+// runHooks runs the executables in the directory of the given stage (e.g.
+// hooks.d/pre), sorted by name, followed by the given hook. The first hook
+// that fails stops the others.
+func runHooks(stage string, hook string, env []string) error {
+	hooks, err := stageHooks(path.Join(defaultHooksDir(), stage))
+	if err != nil {
+		return err
+	}
+
+	if hook != "" {
+		hooks = append(hooks, hook)
+	}
+
+	for _, h := range hooks {
+		cmd := exec.Command(h)
+		cmd.Env = env
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %s failed: %v", stage, h, err)
+		}
+	}
+
+	return nil
+}
+
+// stageHooks returns the executables in the given directory, sorted by name
+func stageHooks(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read hooks in %s: %v", dir, err)
+	}
+
+	hooks := []string{}
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			hooks = append(hooks, path.Join(dir, e.Name()))
+		}
+	}
+
+	sort.Strings(hooks)
+	return hooks, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedSource is a source which is only asked for its name
+type namedSource struct {
+	image.Source
+	name string
+}
+
+func (s *namedSource) Name() string {
+	return s.name
+}
+
+// writeHook writes a hook script which appends its name to the given log
+// and exits with the given code
+func writeHook(t *testing.T, file string, log string, code int, mode os.FileMode) {
+	script := fmt.Sprintf("#!/bin/sh\necho \"$ROOTS_HOOK %s\" >> %s\nexit %d\n", filepath.Base(file), log, code)
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, os.WriteFile(file, []byte(script), mode))
+}
+
+// readLog returns the lines written by the hooks to the given log
+func readLog(log string) []string {
+	data, err := os.ReadFile(log)
+	if err != nil {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// TestHookEnv tests the environment describing the pull to the hooks
+func TestHookEnv(t *testing.T) {
+	tests := []struct {
+		stage  string
+		result *image.ExtractResult
+		env    []string
+	}{
+		{
+			stage: "pre",
+			env: []string{
+				"ROOTS_HOOK=pre",
+				"ROOTS_IMAGE=registry.example.org/team/app:1.0",
+				"ROOTS_DESTINATION=/srv/app",
+			},
+		},
+		{
+			stage:  "post",
+			result: &image.ExtractResult{Digest: "sha256:abc", Platform: "linux/amd64"},
+			env: []string{
+				"ROOTS_HOOK=post",
+				"ROOTS_IMAGE=registry.example.org/team/app:1.0",
+				"ROOTS_DESTINATION=/srv/app",
+				"ROOTS_DIGEST=sha256:abc",
+				"ROOTS_PLATFORM=linux/amd64",
+			},
+		},
+	}
+
+	t.Setenv("ROOTS_TEST_INHERITED", "yes")
+
+	for _, test := range tests {
+		source := &namedSource{name: "registry.example.org/team/app:1.0"}
+		env := hookEnv(test.stage, source, "/srv/app", test.result)
+
+		// the environment of roots is passed on
+		assert.Contains(t, env, "ROOTS_TEST_INHERITED=yes", test.stage)
+
+		described := []string{}
+		for _, e := range env {
+			if strings.HasPrefix(e, "ROOTS_") && !strings.HasPrefix(e, "ROOTS_TEST_") {
+				described = append(described, e)
+			}
+		}
+
+		assert.Equal(t, test.env, described, test.stage)
+	}
+}
+
+// TestRunHooks tests running the hooks of a stage in order, followed by the
+// hook given for the pull, until one of them fails
+func TestRunHooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		hooks map[string]int
+		hook  int
+		ran   []string
+		err   string
+	}{
+		{
+			name: "no hooks",
+			hook: -1,
+			ran:  []string{},
+		},
+		{
+			name:  "hooks in order",
+			hooks: map[string]int{"20-start": 0, "10-stop": 0},
+			hook:  0,
+			ran:   []string{"pre 10-stop", "pre 20-start", "pre hook"},
+		},
+		{
+			name:  "failing hook stops the others",
+			hooks: map[string]int{"10-stop": 0, "20-check": 1, "30-start": 0},
+			hook:  0,
+			ran:   []string{"pre 10-stop", "pre 20-check"},
+			err:   "20-check failed: exit status 1",
+		},
+		{
+			name: "failing hook of the pull",
+			hook: 2,
+			ran:  []string{"pre hook"},
+			err:  "exit status 2",
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		log := filepath.Join(dir, "log")
+
+		previous := config
+		config = &Config{HooksDir: filepath.Join(dir, "hooks.d")}
+
+		for name, code := range test.hooks {
+			writeHook(t, filepath.Join(dir, "hooks.d", "pre", name), log, code, 0755)
+		}
+
+		// files which are not executable are skipped
+		writeHook(t, filepath.Join(dir, "hooks.d", "pre", "README"), log, 0, 0644)
+
+		hook := ""
+		if test.hook >= 0 {
+			hook = filepath.Join(dir, "hook")
+			writeHook(t, hook, log, test.hook, 0755)
+		}
+
+		err := runHooks("pre", hook, []string{"ROOTS_HOOK=pre"})
+		config = previous
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+		} else {
+			assert.NoError(t, err, test.name)
+		}
+
+		assert.Equal(t, test.ran, readLog(log), test.name)
+	}
+}
+
+// TestPreHookAbortsPull tests that pulls are aborted before the destination
+// is touched if a pre hook fails, in which case the post hooks do not run
+func TestPreHookAbortsPull(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	pushTestImage(registry, "team/app", "1.0")
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+
+	writeHook(t, filepath.Join(dir, "hooks.d", "pre", "10-stop"), log, 1, 0755)
+	writeHook(t, filepath.Join(dir, "hooks.d", "post", "10-start"), log, 0, 0755)
+
+	s := newTestServer(t, &Config{HooksDir: filepath.Join(dir, "hooks.d")})
+	dest := filepath.Join(t.TempDir(), "app")
+
+	w, _ := servePull(s, registry.Host()+"/team/app:1.0", dest)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "pre hook")
+	assert.NoDirExists(t, dest)
+	assert.Equal(t, []string{"pre 10-stop"}, readLog(log))
+}
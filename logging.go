@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// logLevel is the level of the messages that are logged, raised by --quiet
+// and lowered by --verbose
+var logLevel = new(slog.LevelVar)
+
+// jsonLogs is true if messages are logged as JSON objects (set by
+// --log-format json)
+var jsonLogs bool
+
+// setupLogging routes the messages of roots, the log package (at info level)
+// and pkg/image through a leveled logger, which writes plain lines or JSON to
+// stderr
+func setupLogging(verbose bool, quiet bool, format string) {
+	switch {
+	case verbose:
+		logLevel.Set(slog.LevelDebug)
+	case quiet:
+		logLevel.Set(slog.LevelWarn)
+	default:
+		logLevel.Set(slog.LevelInfo)
+	}
+
+	var handler slog.Handler
+
+	switch format {
+	case "", "text":
+		jsonLogs = false
+		handler = &plainHandler{w: os.Stderr, level: logLevel, mu: &sync.Mutex{}}
+	case "json":
+		jsonLogs = true
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})
+	default:
+		fatalf("unknown log format %s, expected text or json", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	image.Logger = logger
+}
+
+// cleanups are run by exit, as os.Exit skips the deferred functions
+var cleanups []func()
+
+// atExit registers a function to run before the process exits through exit,
+// e.g. to remove temporary files if a command fails
+func atExit(f func()) {
+	cleanups = append(cleanups, f)
+}
+
+// exit runs the functions registered by atExit in reverse order and exits
+// with the given code
+func exit(code int) {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+
+	os.Exit(code)
+}
+
+// fatal logs the given values as error and exits
+func fatal(v ...any) {
+	slog.Error(fmt.Sprint(v...))
+	exit(1)
+}
+
+// fatalf logs the given message as error and exits
+func fatalf(format string, v ...any) {
+	slog.Error(fmt.Sprintf(format, v...))
+	exit(1)
+}
+
+// plainHandler writes the messages as lines, like the log package without
+// flags, followed by the attributes of the record as key=value pairs
+type plainHandler struct {
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+	mu    *sync.Mutex
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+
+	for _, a := range h.attrs {
+		writeAttr(&b, "", a)
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, h.group, a)
+		return true
+	})
+
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+
+		c.attrs = append(c.attrs, a)
+	}
+
+	return &c
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	c := *h
+
+	if c.group != "" {
+		c.group += "." + name
+	} else {
+		c.group = name
+	}
+
+	return &c
+}
+
+// writeAttr writes the attribute as key=value, quoting values with spaces
+func writeAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	key := a.Key
+	if group != "" {
+		key = group + "." + key
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		for _, member := range a.Value.Group() {
+			writeAttr(b, key, member)
+		}
+
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+
+	fmt.Fprintf(b, " %s=%s", key, value)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// notifyClient is used to post notifications, which must not hold up pulls
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// pullNotification is posted to the notify url after each pull
+type pullNotification struct {
+	Image       string               `json:"image"`
+	Digest      string               `json:"digest,omitempty"`
+	Destination string               `json:"destination"`
+	Duration    float64              `json:"duration"`
+	Result      string               `json:"result"`
+	Error       string               `json:"error,omitempty"`
+	Details     *image.ExtractResult `json:"details,omitempty"`
+}
+
+// notifyPull posts the outcome of a pull to the given url, if any. Failed
+// notifications are logged, but they do not fail the pull.
+func notifyPull(url string, remote image.Source, dst string, start time.Time, result *image.ExtractResult, err error) {
+	if url == "" {
+		return
+	}
+
+	n := &pullNotification{
+		Image:       remote.Name(),
+		Destination: dst,
+		Duration:    time.Since(start).Seconds(),
+		Result:      "success",
+		Details:     result,
+	}
+
+	if result != nil {
+		n.Digest = result.Digest
+	}
+
+	if err != nil {
+		n.Result = "error"
+		n.Error = err.Error()
+	}
+
+	if err := postNotification(url, n); err != nil {
+		log.Printf("could not notify %s: %v", url, err)
+	}
+}
+
+// postNotification posts the given value as JSON
+func postNotification(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := notifyClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("POST %s failed with %s", url, res.Status)
+	}
+
+	return nil
+}
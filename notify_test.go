@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// notifications collects the bodies posted to a test endpoint
+type notifications struct {
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+// endpoint returns a server answering notifications with the given status
+func (n *notifications) endpoint(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		body := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(data, &body))
+
+		n.mu.Lock()
+		n.bodies = append(n.bodies, body)
+		n.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestNotifyPull tests the summaries posted for successful and failed pulls
+func TestNotifyPull(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *image.ExtractResult
+		err    error
+		status int
+		body   map[string]interface{}
+	}{
+		{
+			name:   "success",
+			result: &image.ExtractResult{Digest: "sha256:abc", Platform: "linux/amd64", Layers: 2, CacheHits: 1},
+			status: http.StatusOK,
+			body: map[string]interface{}{
+				"image":       "registry.example.org/team/app:1.0",
+				"digest":      "sha256:abc",
+				"destination": "/srv/app",
+				"result":      "success",
+				"details": map[string]interface{}{
+					"digest": "sha256:abc", "platform": "linux/amd64", "layers": 2.0,
+					"cache_hits": 1.0, "cache_misses": 0.0, "cached_bytes": 0.0,
+					"downloaded_bytes": 0.0, "skipped_chowns": 0.0, "lock_wait": 0.0,
+				},
+			},
+		},
+		{
+			name:   "error",
+			err:    errors.New("no space left on device"),
+			status: http.StatusOK,
+			body: map[string]interface{}{
+				"image":       "registry.example.org/team/app:1.0",
+				"destination": "/srv/app",
+				"result":      "error",
+				"error":       "no space left on device",
+			},
+		},
+		{
+			name:   "endpoint failing",
+			result: &image.ExtractResult{Digest: "sha256:abc"},
+			status: http.StatusInternalServerError,
+			body: map[string]interface{}{
+				"image":       "registry.example.org/team/app:1.0",
+				"digest":      "sha256:abc",
+				"destination": "/srv/app",
+				"result":      "success",
+				"details": map[string]interface{}{
+					"digest": "sha256:abc", "layers": 0.0,
+					"cache_hits": 0.0, "cache_misses": 0.0, "cached_bytes": 0.0,
+					"downloaded_bytes": 0.0, "skipped_chowns": 0.0, "lock_wait": 0.0,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		n := &notifications{}
+		server := n.endpoint(t, test.status)
+
+		source := &namedSource{name: "registry.example.org/team/app:1.0"}
+		notifyPull(server.URL, source, "/srv/app", time.Now().Add(-time.Second), test.result, test.err)
+
+		assert.Len(t, n.bodies, 1, test.name)
+
+		// the duration is measured from the start of the pull
+		assert.GreaterOrEqual(t, n.bodies[0]["duration"], 1.0, test.name)
+		delete(n.bodies[0], "duration")
+
+		assert.Equal(t, test.body, n.bodies[0], test.name)
+	}
+
+	// without url, nothing is posted
+	notifyPull("", &namedSource{name: "app"}, "/srv/app", time.Now(), nil, nil)
+}
+
+// TestServeNotify tests that the server notifies pulls, except for those of
+// destinations which are up to date
+func TestServeNotify(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := pushTestImage(registry, "team/app", "1.0")
+
+	n := &notifications{}
+	endpoint := n.endpoint(t, http.StatusOK)
+
+	s := newTestServer(t, &Config{})
+	s.notify = endpoint.URL
+
+	dest := filepath.Join(t.TempDir(), "app")
+
+	servePull(s, registry.Host()+"/team/app:1.0", dest)
+	servePull(s, registry.Host()+"/team/app:1.0", dest)
+
+	// files cannot be replaced by destinations
+	other := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(other, []byte("data"), 0644))
+
+	servePull(s, registry.Host()+"/team/app:1.0", other)
+
+	if assert.Len(t, n.bodies, 2) {
+		assert.Equal(t, "success", n.bodies[0]["result"])
+		assert.Equal(t, digest, n.bodies[0]["digest"])
+		assert.Equal(t, dest, n.bodies[0]["destination"])
+
+		assert.Equal(t, "error", n.bodies[1]["result"])
+		assert.Equal(t, other, n.bodies[1]["destination"])
+		assert.NotEmpty(t, n.bodies[1]["error"])
+	}
+}
@@ -0,0 +1,128 @@
+// Package cdc implements content-defined chunking using the FastCDC
+// algorithm, which splits a stream into chunks whose boundaries depend on
+// the content, so that insertions and deletions only affect nearby chunks.
+// See: https://www.usenix.org/conference/atc16/technical-sessions/presentation/xia
+package cdc
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Chunker splits streams into chunks with sizes between MinSize and MaxSize,
+// averaging around AvgSize
+type Chunker struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultChunker uses sizes that work well for container layers
+var DefaultChunker = &Chunker{
+	MinSize: 16 * 1024,
+	AvgSize: 64 * 1024,
+	MaxSize: 256 * 1024,
+}
+
+// gear is the table of random values used by the rolling hash, it must never
+// change, as this would change all chunk boundaries
+var gear = func() [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64 with a fixed seed
+	seed := uint64(0x726f6f7473)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}()
+
+// mask returns a mask with the given number of high bits set
+func mask(n int) uint64 {
+	return ((1 << uint(n)) - 1) << uint(64-n)
+}
+
+// cut returns the length of the next chunk in data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+
+	if n <= c.MinSize {
+		return n
+	}
+
+	if n > c.MaxSize {
+		n = c.MaxSize
+	}
+
+	normal := c.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	// normalized chunking: a stricter mask before the average size and a
+	// looser one after it, to narrow the distribution of chunk sizes
+	avgbits := bits.Len(uint(c.AvgSize)) - 1
+	small, large := mask(avgbits+2), mask(avgbits-2)
+
+	var fp uint64
+	i := c.MinSize
+
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&small == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&large == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// Split reads the given reader until EOF and calls the handler for each
+// chunk. The chunk is only valid until the handler returns.
+func (c *Chunker) Split(r io.Reader, handler func(chunk []byte) error) error {
+	if c.MinSize <= 0 || c.MinSize > c.AvgSize || c.AvgSize > c.MaxSize {
+		return errors.New("invalid chunk sizes")
+	}
+
+	buf := make([]byte, 0, 2*c.MaxSize)
+	eof := false
+
+	for {
+
+		// fill the buffer until it holds at least one maximum-sized chunk
+		for !eof && len(buf) < c.MaxSize {
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(buf) == 0 {
+			return nil
+		}
+
+		n := c.cut(buf)
+		if err := handler(buf[:n]); err != nil {
+			return err
+		}
+
+		buf = buf[:copy(buf, buf[n:])]
+	}
+}
@@ -0,0 +1,68 @@
+package cdc
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func split(t *testing.T, data []byte) [][]byte {
+	chunks := [][]byte{}
+
+	err := DefaultChunker.Split(bytes.NewReader(data), func(chunk []byte) error {
+		chunks = append(chunks, append([]byte{}, chunk...))
+		return nil
+	})
+
+	assert.NoError(t, err, "error splitting data")
+	return chunks
+}
+
+// TestSplit tests that chunks are within bounds and add up to the input
+func TestSplit(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := split(t, data)
+	assert.Greater(t, len(chunks), 1, "expected multiple chunks")
+
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), DefaultChunker.MaxSize)
+
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), DefaultChunker.MinSize)
+		}
+	}
+
+	assert.Equal(t, data, bytes.Join(chunks, nil), "chunks do not add up")
+}
+
+// TestShiftResilience tests that prepending data only affects the first chunks
+func TestShiftResilience(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	before := split(t, data)
+	after := split(t, append([]byte("a few more bytes"), data...))
+
+	known := make(map[string]bool)
+	for _, chunk := range before {
+		known[string(chunk)] = true
+	}
+
+	shared := 0
+	for _, chunk := range after {
+		if known[string(chunk)] {
+			shared++
+		}
+	}
+
+	assert.GreaterOrEqual(t, shared, len(before)-2, "too few shared chunks")
+}
+
+// TestSplitEmpty tests that empty input produces no chunks
+func TestSplitEmpty(t *testing.T) {
+	assert.Empty(t, split(t, []byte{}))
+}
@@ -0,0 +1,252 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// DockerLayerMimeType is the mime type of the uncompressed layers found in
+// archives written by `docker save`
+const DockerLayerMimeType = "application/vnd.docker.image.rootfs.diff.tar"
+
+// Archive is an image in a tarball written by `docker save`, it is a Source.
+// The digests of its layers are the digests of the uncompressed layers (the
+// diff ids) and the digest of its manifest is the image id.
+type Archive struct {
+	file     *os.File
+	name     string
+	entries  map[string]archiveEntry
+	blobs    map[string]string
+	manifest *Manifest
+}
+
+// archiveEntry is the location of a file inside the archive
+type archiveEntry struct {
+	offset int64
+	size   int64
+}
+
+// archiveManifest is an entry of the manifest.json of docker save
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// OpenArchive opens the archive at the given path. If the archive contains
+// more than one image, ref selects the image by one of its tags.
+func OpenArchive(file string, ref string) (*Archive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newArchive(f, ref)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading %s: %v", file, err)
+	}
+
+	a.name = fmt.Sprintf("docker-archive:%s", file)
+	if ref != "" {
+		a.name = fmt.Sprintf("%s:%s", a.name, ref)
+	}
+
+	return a, nil
+}
+
+// newArchive indexes the given tarball and reads the manifest of the image
+func newArchive(f *os.File, ref string) (*Archive, error) {
+	a := &Archive{
+		file:    f,
+		entries: make(map[string]archiveEntry),
+		blobs:   make(map[string]string),
+	}
+
+	// the tar reader reads headers in blocks and the contents on demand,
+	// so the position after each header is the offset of the file contents
+	counter := &countingReader{r: f}
+	tr := tar.NewReader(counter)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if h.Typeflag == tar.TypeReg {
+			a.entries[path.Clean(h.Name)] = archiveEntry{counter.n, h.Size}
+		}
+	}
+
+	var manifests []archiveManifest
+	if err := json.Unmarshal(a.read("manifest.json"), &manifests); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %v", err)
+	}
+
+	m, err := selectArchiveManifest(manifests, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, a.loadManifest(m)
+}
+
+// selectArchiveManifest returns the manifest of the image with the given tag
+func selectArchiveManifest(manifests []archiveManifest, ref string) (*archiveManifest, error) {
+	if ref == "" {
+		if len(manifests) != 1 {
+			return nil, fmt.Errorf("archive contains %d images, select one by tag", len(manifests))
+		}
+
+		return &manifests[0], nil
+	}
+
+	wanted, err := Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range manifests {
+		for _, tag := range manifests[i].RepoTags {
+			if t, err := Parse(tag); err == nil && t.String() == wanted.String() {
+				return &manifests[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no image tagged %s in archive", ref)
+}
+
+// loadManifest builds the manifest of the given image, using the diff ids as
+// layer digests
+func (a *Archive) loadManifest(m *archiveManifest) error {
+	data := a.read(m.Config)
+	if data == nil {
+		return fmt.Errorf("config %s not found", m.Config)
+	}
+
+	config := &ImageConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("invalid config %s: %v", m.Config, err)
+	}
+
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return fmt.Errorf("config lists %d layers, archive %d",
+			len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	a.blobs[digest] = path.Clean(m.Config)
+
+	a.manifest = &Manifest{
+		Digest:        digest,
+		SchemaVersion: 2,
+		MediaType:     ManifestMimeType,
+		Config: ManifestLayer{
+			MediaType: ImageConfigMimeTypes[0],
+			Size:      len(data),
+			Digest:    digest,
+		},
+		Layers: make([]ManifestLayer, len(m.Layers)),
+	}
+
+	for i, layer := range m.Layers {
+		entry, ok := a.entries[path.Clean(layer)]
+		if !ok {
+			return fmt.Errorf("layer %s not found", layer)
+		}
+
+		a.blobs[config.RootFS.DiffIDs[i]] = path.Clean(layer)
+		a.manifest.Layers[i] = ManifestLayer{
+			MediaType: DockerLayerMimeType,
+			Size:      int(entry.size),
+			Digest:    config.RootFS.DiffIDs[i],
+		}
+	}
+
+	return nil
+}
+
+// read returns the content of the given (small) file, or nil
+func (a *Archive) read(name string) []byte {
+	entry, ok := a.entries[path.Clean(name)]
+	if !ok {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(a.file, entry.offset, entry.size))
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func (a *Archive) String() string {
+	return a.name
+}
+
+// Name returns the name of the archive and the selected tag
+func (a *Archive) Name() string {
+	return a.name
+}
+
+// Platform returns nil, as an archive contains a single platform
+func (a *Archive) Platform() *Platform {
+	return nil
+}
+
+// Manifest returns the manifest of the selected image
+func (a *Archive) Manifest() (*Manifest, error) {
+	return a.manifest, nil
+}
+
+// DownloadLayer copies the blob with the given digest to the writer,
+// verifying its digest
+func (a *Archive) DownloadLayer(digest string, w io.Writer) error {
+	name, ok := a.blobs[digest]
+	if !ok {
+		return fmt.Errorf("blob %s not found in %s", digest, a)
+	}
+
+	h, err := newDigester(digest)
+	if err != nil {
+		return err
+	}
+
+	entry := a.entries[name]
+	r := io.NewSectionReader(a.file, entry.offset, entry.size)
+
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return fmt.Errorf("error reading %s: %v", digest, err)
+	}
+
+	return checkDigest(digest, h)
+}
+
+// Close closes the archive
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,206 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tarball returns a tar archive with the given files (names ending with a
+// slash are written as directories)
+func tarball(t *testing.T, files map[string][]byte, order ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, name := range order {
+		if strings.HasSuffix(name, "/") {
+			assert.NoError(t, tw.WriteHeader(&tar.Header{
+				Name:     name,
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+			}))
+			continue
+		}
+
+		assert.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := tw.Write(files[name])
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// dockerSave returns an archive in the format of docker save with a single
+// layer containing the given file
+func dockerSave(t *testing.T, name string, content []byte) []byte {
+	layer := tarball(t, map[string][]byte{name: content}, name)
+	diffID := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	config := []byte(fmt.Sprintf(`{"rootfs": {"type": "layers", "diff_ids": [%q]}}`, diffID))
+	id := fmt.Sprintf("%x", sha256.Sum256(config))
+
+	manifest := []byte(fmt.Sprintf(
+		`[{"Config": "%s.json", "RepoTags": ["app:1.0"], "Layers": ["abc/layer.tar"]}]`, id))
+
+	return tarball(t, map[string][]byte{
+		"manifest.json": manifest,
+		id + ".json":    config,
+		"abc/layer.tar": layer,
+	}, id+".json", "abc/layer.tar", "manifest.json")
+}
+
+// TestExtractArchive tests extracting an image written by docker save
+func TestExtractArchive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "image.tar")
+	assert.NoError(t, os.WriteFile(file, dockerSave(t, "hello", []byte("world")), 0644))
+
+	_, err := OpenArchive(file, "app:2.0")
+	assert.Error(t, err, "the tag does not exist")
+
+	archive, err := OpenArchive(file, "app:1.0")
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	assert.Equal(t, fmt.Sprintf("docker-archive:%s:app:1.0", file), archive.Name())
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	progress := []*LayerProgress{}
+	opts := &ExtractOptions{Progress: func(p *LayerProgress) {
+		progress = append(progress, p)
+	}}
+
+	dst := t.TempDir()
+	result, err := store.Extract(context.Background(), archive, dst, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Layers)
+	assert.Len(t, progress, 1)
+	assert.Equal(t, 1, progress[0].Layers)
+	assert.False(t, progress[0].Cached)
+
+	content, err := os.ReadFile(filepath.Join(dst, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+}
+
+// TestDiskSpaceCheck tests that extractions that do not fit fail early
+func TestDiskSpaceCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "image.tar")
+	assert.NoError(t, os.WriteFile(file, dockerSave(t, "hello", []byte("world")), 0644))
+
+	archive, err := OpenArchive(file, "")
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	if _, _, ok := diskSpace(store.Path); !ok {
+		t.Skip("disk space is not available on this platform")
+	}
+
+	dst := t.TempDir()
+	_, err = store.Extract(context.Background(), archive, dst, &ExtractOptions{ExpansionFactor: 1e15})
+	assert.ErrorContains(t, err, "not enough space")
+
+	_, err = os.Stat(filepath.Join(dst, "hello"))
+	assert.True(t, os.IsNotExist(err), "nothing must be extracted")
+
+	_, err = store.Extract(context.Background(), archive, dst, &ExtractOptions{ExpansionFactor: 2})
+	assert.NoError(t, err)
+}
+
+// failingSource writes part of each layer before failing the download
+type failingSource struct {
+	Source
+}
+
+func (s *failingSource) DownloadLayer(digest string, w io.Writer) error {
+	w.Write([]byte("partial"))
+	return fmt.Errorf("connection reset")
+}
+
+// TestFailedDownload tests that failed downloads leave no layers behind
+func TestFailedDownload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "image.tar")
+	assert.NoError(t, os.WriteFile(file, dockerSave(t, "hello", []byte("world")), 0644))
+
+	archive, err := OpenArchive(file, "")
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	_, err = store.Extract(context.Background(), &failingSource{archive}, t.TempDir(), nil)
+	assert.ErrorContains(t, err, "connection reset")
+
+	files, err := filepath.Glob(filepath.Join(store.Path, "layers", "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, files, "no partial layers must remain")
+
+	dst := t.TempDir()
+	_, err = store.Extract(context.Background(), archive, dst, nil)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dst, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+}
+
+// TestDaemonSource tests exporting an image from the Docker daemon socket
+func TestDaemonSource(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/images/get" || r.URL.Query().Get("names") != "app:1.0" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message": "no such image"}`))
+			return
+		}
+
+		w.Write(dockerSave(t, "hello", []byte("world")))
+	})}
+
+	go server.Serve(listener)
+	defer server.Close()
+
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+
+	_, err = NewDaemonSource(context.Background(), "app:2.0")
+	assert.ErrorContains(t, err, "no such image")
+
+	source, err := NewDaemonSource(context.Background(), "app:1.0")
+	assert.NoError(t, err)
+	defer source.Close()
+
+	manifest, err := source.Manifest()
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Layers, 1)
+
+	var buf bytes.Buffer
+	assert.NoError(t, source.DownloadLayer(manifest.Layers[0].Digest, &buf))
+	assert.Equal(t, int64(manifest.Layers[0].Size), int64(buf.Len()))
+}
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// artifactFileName returns the name of the file a blob of an artifact is
+// written to, which is its title or, if missing or unsafe, its digest
+func artifactFileName(l ManifestLayer) string {
+	title := l.Annotations[TitleAnnotation]
+
+	if title == "" || title != filepath.Base(title) || title == "." || title == ".." {
+		return strings.ReplaceAll(l.Digest, ":", "-")
+	}
+
+	return title
+}
+
+// extractArtifact writes the blobs of an artifact to the destination as they
+// are, without caching them
+//
+// note that this function does not do any locking -> it assumes the
+// destination has been locked already
+func (s *Store) extractArtifact(ctx context.Context, r Source, m *Manifest, dst string) (*ExtractResult, error) {
+	result := &ExtractResult{ArtifactType: m.Type()}
+	seen := make(map[string]bool)
+
+	for _, l := range m.Layers {
+		name := artifactFileName(l)
+
+		if seen[name] {
+			return nil, fmt.Errorf("artifact contains %s more than once", name)
+		}
+		seen[name] = true
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("interrupted")
+		default:
+		}
+
+		file := filepath.Join(dst, name)
+
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s: %v", file, err)
+		}
+
+		err = r.DownloadLayer(l.Digest, f)
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Layers++
+		result.CacheMisses++
+		result.DownloadedBytes += int64(l.Size)
+	}
+
+	return result, nil
+}
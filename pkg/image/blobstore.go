@@ -0,0 +1,129 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blobStore reads blobs stored by digest, like the content store of
+// containerd or the blobs of an OCI image layout
+type blobStore struct {
+	dir string
+}
+
+// blobPath returns the path to the blob with the given digest
+func (c *blobStore) blobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(c.dir, algorithm, hex)
+}
+
+// manifest returns the manifest with the given digest. If the digest is the
+// one of an index, the manifest of the given platform is used, or the one
+// of the host if there is none.
+func (c *blobStore) manifest(digest string, platform *Platform, name string) (*Manifest, error) {
+	digest, err := c.manifestDigest(digest, platform, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.readBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest@%s: %v", digest, err)
+	}
+
+	m.Digest = digest
+	return m, nil
+}
+
+// manifestDigest returns the digest of the manifest, resolving indexes
+func (c *blobStore) manifestDigest(digest string, platform *Platform, name string) (string, error) {
+	data, err := c.readBlob(digest)
+	if err != nil {
+		return "", err
+	}
+
+	probe := struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}{}
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", fmt.Errorf("error parsing %s: %v", digest, err)
+	}
+
+	if !isMimeType(probe.MediaType, manifestListMimeTypes...) && probe.Manifests == nil {
+		return digest, nil
+	}
+
+	lst := &ManifestList{}
+	if err := json.Unmarshal(data, lst); err != nil {
+		return "", fmt.Errorf("error parsing index %s: %v", digest, err)
+	}
+
+	wanted := platform
+	if wanted == nil {
+		wanted = HostPlatform()
+	}
+
+	if m := lst.Select(wanted); m != nil {
+		return m.Digest, nil
+	}
+
+	// without explicit platform, take the first manifest that is present, as
+	// containerd usually only fetches the host platform
+	if platform == nil {
+		for _, m := range lst.Manifests {
+			if _, err := os.Stat(c.blobPath(m.Digest)); err == nil {
+				return m.Digest, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no manifest found for %s", name)
+}
+
+// readBlob returns the content of the given (small) blob
+func (c *blobStore) readBlob(digest string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := c.DownloadLayer(digest, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DownloadLayer copies the blob with the given digest from the content
+// store to the writer, verifying its digest
+func (c *blobStore) DownloadLayer(digest string, w io.Writer) error {
+	h, err := newDigester(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.blobPath(digest))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("blob %s not found in %s", digest, c.dir)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return fmt.Errorf("error reading %s: %v", digest, err)
+	}
+
+	return checkDigest(digest, h)
+}
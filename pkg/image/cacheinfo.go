@@ -0,0 +1,81 @@
+package image
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// CacheInfo summarizes the contents of a cache
+type CacheInfo struct {
+	Path string `json:"path"`
+
+	// Layers and LayerBytes count the cached layers, UnusedLayers and
+	// UnusedBytes the ones no existing destination pins (which are purged)
+	Layers       int   `json:"layers"`
+	LayerBytes   int64 `json:"layer_bytes"`
+	UnusedLayers int   `json:"unused_layers"`
+	UnusedBytes  int64 `json:"unused_bytes"`
+
+	// Destinations is the number of destinations known to the cache,
+	// MissingDestinations the number of those which no longer exist
+	Destinations        int `json:"destinations"`
+	MissingDestinations int `json:"missing_destinations"`
+
+	// Size is the size of all files in the cache, including the chunks of
+	// deduplicated layers and the cached manifests
+	Size int64 `json:"size"`
+}
+
+// Info returns a summary of the contents of the cache
+func (s *Store) Info() (*CacheInfo, error) {
+	info := &CacheInfo{Path: s.Path}
+
+	layers, err := s.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range layers {
+		info.Layers++
+		info.LayerBytes += l.Size
+
+		if len(l.Destinations) == 0 {
+			info.UnusedLayers++
+			info.UnusedBytes += l.Size
+		}
+	}
+
+	destinations, err := s.Destinations()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range destinations {
+		info.Destinations++
+
+		if !d.Exists {
+			info.MissingDestinations++
+		}
+	}
+
+	err = filepath.WalkDir(s.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		info.Size += stat.Size()
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", s.Path, err)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCacheInfo tests that cached layers list the destinations pinning them
+// and that the summary counts the layers which would be purged
+func TestCacheInfo(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	kept := filepath.Join(t.TempDir(), "kept")
+	removed := filepath.Join(t.TempDir(), "removed")
+
+	for name, dst := range map[string]string{"kept": kept, "removed": removed} {
+		assert.NoError(t, os.Mkdir(dst, 0755))
+
+		files := map[string][]byte{name: []byte(name)}
+		_, err := store.Extract(context.Background(), newLayeredSource(tarball(t, files, name)), dst, nil)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, os.RemoveAll(removed))
+
+	layers, err := store.Layers()
+	assert.NoError(t, err)
+	assert.Len(t, layers, 2)
+
+	pinned := 0
+	for _, l := range layers {
+		assert.False(t, l.Cached.IsZero())
+
+		if len(l.Destinations) > 0 {
+			assert.Equal(t, []string{kept}, l.Destinations)
+			pinned++
+		}
+	}
+	assert.Equal(t, 1, pinned)
+
+	info, err := store.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, info.Layers)
+	assert.Equal(t, 1, info.UnusedLayers)
+	assert.Equal(t, 2, info.Destinations)
+	assert.Equal(t, 1, info.MissingDestinations)
+	assert.GreaterOrEqual(t, info.Size, info.LayerBytes)
+}
@@ -0,0 +1,286 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/seantis/roots/pkg/cdc"
+)
+
+// recipeEntry is a chunk referenced by a recipe
+type recipeEntry struct {
+	Sum  string
+	Size int64
+}
+
+// RecipePath returns the path to the recipe of a deduplicated layer
+func (s *Store) RecipePath(digest string) string {
+	return filepath.Join(s.Path, "layers", fmt.Sprintf("%s.recipe", digest))
+}
+
+// ChunkPath returns the path to the chunk with the given sha256 sum
+func (s *Store) ChunkPath(sum string) string {
+	return filepath.Join(s.Path, "chunks", sum[:2], fmt.Sprintf("%s.chunk", sum))
+}
+
+// dedupLayer splits the uncompressed tar stream of a downloaded layer into
+// content-defined chunks, stores the ones that are not known yet and replaces
+// the layer file with a recipe, returning the path to the recipe
+func (s *Store) dedupLayer(digest string, mediaType string) (string, error) {
+	src := s.LayerPath(digest)
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	stream, err := tarStream(f, mediaType)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %v", src, err)
+	}
+	defer stream.Close()
+
+	var recipe bytes.Buffer
+
+	err = cdc.DefaultChunker.Split(stream, func(chunk []byte) error {
+		sum := fmt.Sprintf("%x", sha256.Sum256(chunk))
+
+		if err := s.writeChunk(sum, chunk); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&recipe, "%s %d\n", sum, len(chunk))
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error splitting %s: %v", src, err)
+	}
+
+	dst := s.RecipePath(digest)
+	if err := writeFileAtomic(dst, recipe.Bytes()); err != nil {
+		return "", err
+	}
+
+	if err := os.Remove(src); err != nil {
+		return "", fmt.Errorf("error removing %s: %v", src, err)
+	}
+
+	return dst, nil
+}
+
+// writeChunk stores the given chunk compressed, unless it exists already
+func (s *Store) writeChunk(sum string, chunk []byte) error {
+	file := s.ChunkPath(sum)
+
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(file), err)
+	}
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+
+	if _, err := gzw.Write(chunk); err != nil {
+		return err
+	}
+
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, buf.Bytes())
+}
+
+// openLayer opens a cached layer, which is either a layer file or a recipe
+func (s *Store) openLayer(file string) (io.ReadSeekCloser, error) {
+	if !strings.HasSuffix(file, ".recipe") {
+		return os.Open(file)
+	}
+
+	entries, err := readRecipe(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &chunkReader{store: s, entries: entries, current: -1}
+	r.offsets = make([]int64, len(entries))
+
+	for i, e := range entries {
+		r.offsets[i] = r.size
+		r.size += e.Size
+	}
+
+	return r, nil
+}
+
+// readRecipe reads the chunks listed in the given recipe
+func readRecipe(file string) ([]recipeEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []recipeEntry{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, size, found := strings.Cut(scanner.Text(), " ")
+		if !found || len(sum) < 2 {
+			return nil, fmt.Errorf("invalid recipe line in %s: %s", file, scanner.Text())
+		}
+
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size in %s: %v", file, err)
+		}
+
+		entries = append(entries, recipeEntry{Sum: sum, Size: n})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", file, err)
+	}
+
+	return entries, nil
+}
+
+// purgeChunks removes all chunks which are not referenced by a recipe
+//
+// note that this function does not do any locking -> it assumes the cache
+// has been locked already
+func (s *Store) purgeChunks() error {
+	recipes, err := filepath.Glob(fmt.Sprintf("%s/layers/*.recipe", s.Path))
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool)
+
+	for _, recipe := range recipes {
+		entries, err := readRecipe(recipe)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			known[e.Sum] = true
+		}
+	}
+
+	chunks, err := filepath.Glob(fmt.Sprintf("%s/chunks/*/*.chunk", s.Path))
+	if err != nil {
+		return err
+	}
+
+	unused := []string{}
+	for _, chunk := range chunks {
+		if !known[strings.TrimSuffix(filepath.Base(chunk), ".chunk")] {
+			unused = append(unused, chunk)
+		}
+	}
+
+	return removeFiles(unused)
+}
+
+// chunkReader reads the content of a recipe, one chunk at a time
+type chunkReader struct {
+	store   *Store
+	entries []recipeEntry
+	offsets []int64
+	size    int64
+	pos     int64
+
+	// the currently loaded chunk
+	current int
+	buf     []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	// find the chunk containing the current position
+	i := sort.Search(len(r.offsets), func(i int) bool {
+		return r.offsets[i] > r.pos
+	}) - 1
+
+	if i != r.current {
+		if err := r.load(i); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf[r.pos-r.offsets[i]:])
+	r.pos += int64(n)
+
+	return n, nil
+}
+
+// load decompresses and verifies the chunk with the given index
+func (r *chunkReader) load(i int) error {
+	file := r.store.ChunkPath(r.entries[i].Sum)
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", file, err)
+	}
+
+	buf, err := io.ReadAll(gzr)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", file, err)
+	}
+
+	if fmt.Sprintf("%x", sha256.Sum256(buf)) != r.entries[i].Sum {
+		return fmt.Errorf("chunk %s is corrupted", file)
+	}
+
+	r.current, r.buf = i, buf
+	return nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.buf = nil
+	return nil
+}
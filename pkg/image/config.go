@@ -0,0 +1,136 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ImageConfig represents the configuration of an image, as referenced by the
+// config descriptor of the manifest
+// * https://github.com/opencontainers/image-spec/blob/main/config.md
+// * application/vnd.oci.image.config.v1+json
+// * application/vnd.docker.container.image.v1+json
+type ImageConfig struct {
+	Created      *time.Time      `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// Platform returns the platform the image was built for
+func (c *ImageConfig) Platform() *Platform {
+	return &Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}
+}
+
+// ContainerConfig holds the execution parameters of a container
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// RootFS references the layer content addresses used by the image
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History describes the history of a layer
+type History struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// PulledConfig is the config of an image extracted to a destination,
+// together with the name and the digest of the image
+type PulledConfig struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+	*ImageConfig
+}
+
+// ConfigPath returns the path to the config of the image extracted to a
+// destination, as written by the last pull
+func ConfigPath(dst string) string {
+	return filepath.Join(dst, MetadataDir, "config.json")
+}
+
+// SourceConfig downloads the config of the image provided by the source
+func SourceConfig(src Source) (*ImageConfig, error) {
+	if r, ok := src.(*Remote); ok {
+		return r.Config()
+	}
+
+	m, err := src.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest@%s has no config", m.Digest)
+	}
+
+	return downloadConfig(src, m.Config.Digest)
+}
+
+// downloadConfig downloads and parses the config blob with the given digest
+func downloadConfig(src Source, digest string) (*ImageConfig, error) {
+	var buf bytes.Buffer
+	if err := src.DownloadLayer(digest, &buf); err != nil {
+		return nil, fmt.Errorf("error downloading config: %w", err)
+	}
+
+	c := &ImageConfig{}
+	if err := json.Unmarshal(buf.Bytes(), c); err != nil {
+		return nil, fmt.Errorf("error parsing config: %v", err)
+	}
+
+	return c, nil
+}
+
+// WriteConfig writes the config of a pulled image to the given file, which
+// is replaced atomically
+func WriteConfig(file string, c *PulledConfig) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", file, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(file), err)
+	}
+
+	return writeFileAtomic(file, append(data, '\n'))
+}
+
+// ReadConfig reads the config of a pulled image written by WriteConfig
+func ReadConfig(file string) (*PulledConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PulledConfig{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", file, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,163 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConfigChange is a difference between the configs of two images
+type ConfigChange struct {
+
+	// Field is the changed property (env, label, entrypoint, cmd, user,
+	// working_dir, exposed_port, volume, stop_signal, platform)
+	Field string `json:"field"`
+
+	// Key is the name of the env var, the label, the port or the volume
+	Key string `json:"key,omitempty"`
+
+	// Change is either "added", "removed" or "modified"
+	Change string `json:"change"`
+
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// DiffConfigs returns the changes from the first to the second config, in
+// the order of the fields, keyed values are sorted by key
+func DiffConfigs(a *ImageConfig, b *ImageConfig) []*ConfigChange {
+	changes := []*ConfigChange{}
+
+	value := func(field string, old string, new string) {
+		if change := diffValue(field, old, new); change != nil {
+			changes = append(changes, change)
+		}
+	}
+
+	value("platform", platformOf(a).String(), platformOf(b).String())
+	value("user", a.Config.User, b.Config.User)
+	value("entrypoint", encodeList(a.Config.Entrypoint), encodeList(b.Config.Entrypoint))
+	value("cmd", encodeList(a.Config.Cmd), encodeList(b.Config.Cmd))
+	value("working_dir", a.Config.WorkingDir, b.Config.WorkingDir)
+	value("stop_signal", a.Config.StopSignal, b.Config.StopSignal)
+
+	changes = append(changes, diffMaps("env", envMap(a.Config.Env), envMap(b.Config.Env))...)
+	changes = append(changes, diffMaps("label", a.Config.Labels, b.Config.Labels)...)
+	changes = append(changes, diffMaps("exposed_port", setMap(a.Config.ExposedPorts), setMap(b.Config.ExposedPorts))...)
+	changes = append(changes, diffMaps("volume", setMap(a.Config.Volumes), setMap(b.Config.Volumes))...)
+
+	return changes
+}
+
+// diffValue returns the change between the given values, or nil
+func diffValue(field string, old string, new string) *ConfigChange {
+	c := &ConfigChange{Field: field, Old: old, New: new}
+
+	switch {
+	case old == new:
+		return nil
+	case old == "":
+		c.Change = "added"
+	case new == "":
+		c.Change = "removed"
+	default:
+		c.Change = "modified"
+	}
+
+	return c
+}
+
+// diffMaps returns the changes between the given maps, sorted by key
+func diffMaps(field string, old map[string]string, new map[string]string) []*ConfigChange {
+	keys := []string{}
+
+	for k := range old {
+		keys = append(keys, k)
+	}
+
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	changes := []*ConfigChange{}
+
+	for _, k := range keys {
+		o, inOld := old[k]
+		n, inNew := new[k]
+
+		c := &ConfigChange{Field: field, Key: k, Old: o, New: n}
+
+		switch {
+		case inOld && inNew && o == n:
+			continue
+		case !inOld:
+			c.Change = "added"
+		case !inNew:
+			c.Change = "removed"
+		default:
+			c.Change = "modified"
+		}
+
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// platformOf returns the platform recorded in the config
+func platformOf(c *ImageConfig) *Platform {
+	return &Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}
+}
+
+// encodeList returns the list as JSON, as used in Dockerfiles
+func encodeList(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	encoded, _ := json.Marshal(list)
+	return string(encoded)
+}
+
+// envMap returns the env vars (NAME=value) by name
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		m[name] = value
+	}
+
+	return m
+}
+
+// setMap returns the keys of the given set (e.g. exposed ports) as map
+func setMap(set map[string]struct{}) map[string]string {
+	m := make(map[string]string, len(set))
+
+	for k := range set {
+		m[k] = k
+	}
+
+	return m
+}
+
+func (c *ConfigChange) String() string {
+	name := c.Field
+	if c.Key != "" {
+		name = fmt.Sprintf("%s %s", c.Field, c.Key)
+	}
+
+	switch c.Change {
+	case "added":
+		return fmt.Sprintf("%s: added (%q)", name, c.New)
+	case "removed":
+		return fmt.Sprintf("%s: removed (%q)", name, c.Old)
+	}
+
+	return fmt.Sprintf("%s: modified (%q -> %q)", name, c.Old, c.New)
+}
@@ -0,0 +1,51 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffConfigs tests comparing the configs of two images
+func TestDiffConfigs(t *testing.T) {
+	a := &ImageConfig{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: ContainerConfig{
+			User:         "app",
+			Env:          []string{"PATH=/usr/bin", "DEBUG=1"},
+			Entrypoint:   []string{"/app"},
+			Labels:       map[string]string{"version": "1.2"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		},
+	}
+
+	b := &ImageConfig{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: ContainerConfig{
+			Env:          []string{"PATH=/usr/local/bin:/usr/bin", "LANG=C.UTF-8"},
+			Entrypoint:   []string{"/app", "--serve"},
+			Labels:       map[string]string{"version": "1.3"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}, "9090/tcp": {}},
+		},
+	}
+
+	assert.Empty(t, DiffConfigs(a, a))
+
+	changes := DiffConfigs(a, b)
+	lines := make([]string, len(changes))
+	for i, c := range changes {
+		lines[i] = c.String()
+	}
+
+	assert.Equal(t, []string{
+		`user: removed ("app")`,
+		`entrypoint: modified ("[\"/app\"]" -> "[\"/app\",\"--serve\"]")`,
+		`env DEBUG: removed ("1")`,
+		`env LANG: added ("C.UTF-8")`,
+		`env PATH: modified ("/usr/bin" -> "/usr/local/bin:/usr/bin")`,
+		`label version: modified ("1.2" -> "1.3")`,
+		`exposed_port 9090/tcp: added ("9090/tcp")`,
+	}, lines)
+}
@@ -0,0 +1,167 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultContainerdRoot is the state directory of containerd
+const DefaultContainerdRoot = "/var/lib/containerd"
+
+// ContainerdSource reads images directly from the content store of
+// containerd, without going through its API. The content store must be
+// readable by the current user.
+type ContainerdSource struct {
+	blobStore
+
+	root      string
+	namespace string
+	name      string
+	digest    string
+	platform  *Platform
+}
+
+// NewContainerdSource looks up the image with the given name in the given
+// containerd namespace. Instead of a name, the digest of a manifest or an
+// index in the content store may be given.
+func NewContainerdSource(root string, namespace string, name string) (*ContainerdSource, error) {
+	s := &ContainerdSource{root: root, namespace: namespace, name: name}
+	s.dir = filepath.Join(root, "io.containerd.content.v1.content", "blobs")
+
+	if strings.HasPrefix(name, "sha256:") {
+		s.digest = name
+		return s, nil
+	}
+
+	digest, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	s.digest = digest
+	return s, nil
+}
+
+// lookup returns the target digest of the image from the metadata database
+func (s *ContainerdSource) lookup() (string, error) {
+	db, err := s.openMetadata()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	// containerd stores fully qualified names
+	names := []string{s.name}
+	if url, err := Parse(s.name); err == nil {
+		names = append(names, url.Canonical())
+	}
+
+	digest := ""
+
+	err = db.View(func(tx *bolt.Tx) error {
+		images := bucket(tx, "v1", s.namespace, "images")
+		if images == nil {
+			return fmt.Errorf("namespace %s has no images", s.namespace)
+		}
+
+		for _, name := range names {
+			if target := images.Bucket([]byte(name)); target != nil {
+				if target = target.Bucket([]byte("target")); target != nil {
+					digest = string(target.Get([]byte("digest")))
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("image %s not found in namespace %s", s.name, s.namespace)
+	})
+
+	return digest, err
+}
+
+// openMetadata opens a copy of the metadata database, as containerd keeps
+// an exclusive lock on the database while it is running
+func (s *ContainerdSource) openMetadata() (*bolt.DB, error) {
+	path := filepath.Join(s.root, "io.containerd.metadata.v1.bolt", "meta.db")
+
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(tempDir(), "roots-containerd-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dst.Name())
+
+	_, err = io.Copy(dst, src)
+	dst.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("error copying %s: %v", path, err)
+	}
+
+	db, err := bolt.Open(dst.Name(), 0400, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	return db, nil
+}
+
+// bucket returns the nested bucket with the given path, or nil
+func bucket(tx *bolt.Tx, names ...string) *bolt.Bucket {
+	b := tx.Bucket([]byte(names[0]))
+
+	for _, name := range names[1:] {
+		if b == nil {
+			return nil
+		}
+
+		b = b.Bucket([]byte(name))
+	}
+
+	return b
+}
+
+func (s *ContainerdSource) String() string {
+	if s.platform != nil {
+		return fmt.Sprintf("%s %s", s.Name(), s.platform)
+	}
+
+	return s.Name()
+}
+
+// Name returns the namespace and name of the image
+func (s *ContainerdSource) Name() string {
+	return fmt.Sprintf("containerd:%s/%s", s.namespace, s.name)
+}
+
+// Platform returns the platform bound through WithPlatform, or nil
+func (s *ContainerdSource) Platform() *Platform {
+	return s.platform
+}
+
+// WithPlatform binds the platform used to select a manifest from an index
+func (s *ContainerdSource) WithPlatform(p *Platform) {
+	s.platform = p
+}
+
+// Manifest returns the manifest of the image. If the image is an index, the
+// manifest of the bound platform is used, or the one of the host if there
+// is none.
+func (s *ContainerdSource) Manifest() (*Manifest, error) {
+	return s.manifest(s.digest, s.platform, s.String())
+}
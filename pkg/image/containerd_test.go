@@ -0,0 +1,102 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// contentStore is a fake containerd state directory
+type contentStore struct {
+	t    *testing.T
+	root string
+}
+
+// add stores the given blob and returns its digest
+func (c *contentStore) add(data []byte) string {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	path := filepath.Join(c.root, "io.containerd.content.v1.content", "blobs", "sha256", digest[7:])
+
+	assert.NoError(c.t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(c.t, os.WriteFile(path, data, 0644))
+
+	return digest
+}
+
+// tag records the image in the metadata database
+func (c *contentStore) tag(namespace string, name string, digest string) {
+	path := filepath.Join(c.root, "io.containerd.metadata.v1.bolt", "meta.db")
+	assert.NoError(c.t, os.MkdirAll(filepath.Dir(path), 0755))
+
+	db, err := bolt.Open(path, 0644, nil)
+	assert.NoError(c.t, err)
+	defer db.Close()
+
+	assert.NoError(c.t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("v1"))
+		for _, key := range []string{namespace, "images", name, "target"} {
+			if err != nil {
+				return err
+			}
+			b, err = b.CreateBucketIfNotExists([]byte(key))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte("digest"), []byte(digest))
+	}))
+}
+
+// TestContainerdSource tests extracting a multi-platform image from the
+// content store, of which only the host platform is present
+func TestContainerdSource(t *testing.T) {
+	store := &contentStore{t: t, root: t.TempDir()}
+
+	layer := store.add(tarball(t, map[string][]byte{"hello": []byte("world")}, "hello"))
+	config := store.add([]byte(`{}`))
+
+	manifest := store.add([]byte(fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"config": {"mediaType": %q, "digest": %q, "size": 2},
+		"layers": [{"mediaType": %q, "digest": %q, "size": 1}]
+	}`, OCIManifestMimeType, ImageConfigMimeTypes[1], config, DockerLayerMimeType, layer)))
+
+	index := store.add([]byte(fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"manifests": [
+			{"digest": "sha256:0000", "platform": {"os": "linux", "architecture": "other"}},
+			{"digest": %q, "platform": {"os": "linux", "architecture": %q}}
+		]
+	}`, OCIIndexMimeType, manifest, runtime.GOARCH)))
+
+	store.tag("k8s.io", "docker.io/library/app:1.0", index)
+
+	_, err := NewContainerdSource(store.root, "default", "app:1.0")
+	assert.Error(t, err, "the image is in another namespace")
+
+	source, err := NewContainerdSource(store.root, "k8s.io", "app:1.0")
+	assert.NoError(t, err)
+
+	cache, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+	result, err := cache.Extract(context.Background(), source, dst, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, result.Digest)
+
+	content, err := os.ReadFile(filepath.Join(dst, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+}
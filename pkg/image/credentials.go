@@ -0,0 +1,174 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Credentials maps registry hosts to credentials in the form of
+// 'username:password'
+type Credentials map[string]string
+
+// dockerConfig is the format of ~/.docker/config.json and of the content of
+// kubernetes.io/dockerconfigjson secrets
+type dockerConfig struct {
+	Auths map[string]dockerAuth `yaml:"auths"`
+}
+
+// dockerAuth are the credentials of a single registry
+type dockerAuth struct {
+	Auth     string `yaml:"auth"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// kubernetesSecret is a secret of the type kubernetes.io/dockerconfigjson
+// or the older kubernetes.io/dockercfg, in JSON or YAML
+type kubernetesSecret struct {
+	Kind       string            `yaml:"kind"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+// LoadCredentials reads the credentials from the given file
+func LoadCredentials(path string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	c, err := ParseCredentials(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// ParseCredentials parses either a Docker config (.dockerconfigjson) or a
+// Kubernetes secret holding one
+func ParseCredentials(data []byte) (Credentials, error) {
+	secret := &kubernetesSecret{}
+	if err := yaml.Unmarshal(data, secret); err != nil {
+		return nil, err
+	}
+
+	if secret.Kind != "Secret" {
+		return parseDockerConfig(data, false)
+	}
+
+	for key, legacy := range map[string]bool{".dockerconfigjson": false, ".dockercfg": true} {
+		if content, ok := secret.StringData[key]; ok {
+			return parseDockerConfig([]byte(content), legacy)
+		}
+
+		if content, ok := secret.Data[key]; ok {
+			decoded, err := base64.StdEncoding.DecodeString(content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", key, err)
+			}
+
+			return parseDockerConfig(decoded, legacy)
+		}
+	}
+
+	return nil, fmt.Errorf("secret contains no docker config")
+}
+
+// parseDockerConfig parses a Docker config, the legacy format has no auths
+// key but lists the registries directly
+func parseDockerConfig(data []byte, legacy bool) (Credentials, error) {
+	config := &dockerConfig{}
+
+	var err error
+	if legacy {
+		err = yaml.Unmarshal(data, &config.Auths)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Auths == nil {
+		return nil, fmt.Errorf("no auths found")
+	}
+
+	c := make(Credentials)
+
+	for host, auth := range config.Auths {
+		credentials := fmt.Sprintf("%s:%s", auth.Username, auth.Password)
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for %s: %v", host, err)
+			}
+
+			credentials = string(decoded)
+		}
+
+		if credentials != ":" {
+			c[credentialHost(host)] = credentials
+		}
+	}
+
+	return c, nil
+}
+
+// credentialHost returns the registry host of a key in the Docker config,
+// which may be a host or an url (e.g. https://index.docker.io/v1/)
+func credentialHost(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+
+	switch host {
+	case "docker.io", "index.docker.io":
+		return dockerHubHost
+	}
+
+	return host
+}
+
+// Lookup returns the credentials for the given host, or an empty string
+func (c Credentials) Lookup(host string) string {
+	return c[credentialHost(host)]
+}
+
+// Set stores the credentials for the given host
+func (c Credentials) Set(host string, credentials string) {
+	c[credentialHost(host)] = credentials
+}
+
+// Save writes the credentials as Docker config to the given path, only
+// readable by the current user
+func (c Credentials) Save(path string) error {
+	auths := make(map[string]map[string]string, len(c))
+	for host, credentials := range c {
+		auths[host] = map[string]string{
+			"auth": base64.StdEncoding.EncodeToString([]byte(credentials)),
+		}
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"auths": auths}, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(path), err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const dockerConfigJSON = `{"auths": {
+	"https://index.docker.io/v1/": {"auth": "dXNlcjpzZWNyZXQ="},
+	"registry.example.org:5000": {"username": "bot", "password": "token"}
+}}`
+
+// TestParseCredentials tests the formats credentials may be given in
+func TestParseCredentials(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(dockerConfigJSON))
+
+	for _, data := range []string{
+		dockerConfigJSON,
+		fmt.Sprintf(`{"kind": "Secret", "type": "kubernetes.io/dockerconfigjson", "data": {".dockerconfigjson": %q}}`, encoded),
+		fmt.Sprintf("kind: Secret\ntype: kubernetes.io/dockerconfigjson\ndata:\n  .dockerconfigjson: %s\n", encoded),
+		fmt.Sprintf("kind: Secret\nstringData:\n  .dockerconfigjson: '%s'\n", dockerConfigJSON),
+	} {
+		c, err := ParseCredentials([]byte(data))
+		assert.NoError(t, err)
+
+		assert.Equal(t, "user:secret", c.Lookup("registry-1.docker.io"))
+		assert.Equal(t, "bot:token", c.Lookup("registry.example.org:5000"))
+		assert.Equal(t, "", c.Lookup("registry.example.org"))
+	}
+
+	_, err := ParseCredentials([]byte(`{"kind": "Secret", "data": {"token": "abc"}}`))
+	assert.Error(t, err)
+}
+
+// TestSaveCredentials tests that saved credentials can be loaded again
+func TestSaveCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "roots", "auth.json")
+
+	c := make(Credentials)
+	c.Set("ghcr.io", "oauth2:token")
+	c.Set("https://index.docker.io/v1/", "user:secret")
+	assert.NoError(t, c.Save(file))
+
+	loaded, err := LoadCredentials(file)
+	assert.NoError(t, err)
+	assert.Equal(t, c, loaded)
+
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultDockerSocket is the socket of the Docker daemon, unless DOCKER_HOST
+// says otherwise
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// NewDaemonSource exports the given image from the local Docker daemon and
+// returns it as archive. The export is written to a temporary file, which
+// is removed when the archive is closed.
+func NewDaemonSource(ctx context.Context, name string) (*Archive, error) {
+	socket, err := dockerSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	endpoint := fmt.Sprintf("http://docker/images/get?names=%s", url.QueryEscape(name))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the Docker daemon at %s: %v", socket, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("error exporting %s: %s", name, daemonError(res))
+	}
+
+	f, err := os.CreateTemp(tempDir(), "roots-docker-*.tar")
+	if err != nil {
+		return nil, err
+	}
+
+	// the file remains readable until it is closed
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error exporting %s: %v", name, err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// the export only contains the requested image
+	a, err := newArchive(f, "")
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading export of %s: %v", name, err)
+	}
+
+	a.name = fmt.Sprintf("docker-daemon:%s", name)
+	return a, nil
+}
+
+// dockerSocket returns the path to the socket of the Docker daemon
+func dockerSocket() (string, error) {
+	host := os.Getenv("DOCKER_HOST")
+
+	if host == "" {
+		return defaultDockerSocket, nil
+	}
+
+	if !strings.HasPrefix(host, "unix://") {
+		return "", fmt.Errorf("unsupported DOCKER_HOST %s, only unix sockets are supported", host)
+	}
+
+	return strings.TrimPrefix(host, "unix://"), nil
+}
+
+// daemonError returns the message of an error response of the Docker daemon
+func daemonError(res *http.Response) string {
+	body := struct {
+		Message string `json:"message"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Message == "" {
+		return res.Status
+	}
+
+	return body.Message
+}
@@ -0,0 +1,60 @@
+package image
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// newDigester returns a hash for the algorithm of the given digest
+func newDigester(digest string) (hash.Hash, error) {
+	algorithm, _, found := strings.Cut(digest, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid digest: %s", digest)
+	}
+
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+	}
+}
+
+// checkDigest compares the sum of the given hash against the digest
+func checkDigest(digest string, h hash.Hash) error {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	actual := fmt.Sprintf("%s:%x", algorithm, h.Sum(nil))
+
+	if actual != digest {
+		return fmt.Errorf("digest mismatch, expected %s, got %s", digest, actual)
+	}
+
+	return nil
+}
+
+// verifyFile checks that the content of the given file matches the digest
+func verifyFile(path string, digest string) error {
+	h, err := newDigester(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	return checkDigest(digest, h)
+}
@@ -0,0 +1,26 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckDigest tests the verification of content against a digest
+func TestCheckDigest(t *testing.T) {
+	digest := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	h, err := newDigester(digest)
+	assert.NoError(t, err)
+	h.Write([]byte("foo"))
+	assert.NoError(t, checkDigest(digest, h))
+
+	h.Write([]byte("bar"))
+	assert.Error(t, checkDigest(digest, h))
+
+	_, err = newDigester("md5:foo")
+	assert.EqualError(t, err, "unsupported digest algorithm: md5")
+
+	_, err = newDigester("foobar")
+	assert.EqualError(t, err, "invalid digest: foobar")
+}
@@ -0,0 +1,55 @@
+package image
+
+import (
+	"fmt"
+)
+
+// DefaultExpansionFactor is the ratio between the uncompressed and the
+// compressed size of layers assumed by the disk space check
+const DefaultExpansionFactor = 2.0
+
+// checkDiskSpace fails if the layers that still have to be downloaded do not
+// fit into the cache, or if the extracted layers (the sizes of the manifest
+// multiplied by the given factor) do not fit into the destination. If both
+// share a filesystem, the sum is checked.
+func (s *Store) checkDiskSpace(layers []ManifestLayer, dst string, factor float64) error {
+	var download, extract uint64
+
+	for _, l := range layers {
+		if s.cachedLayer(l.Digest) == "" {
+			download += uint64(l.Size)
+		}
+
+		extract += uint64(float64(l.Size) * factor)
+	}
+
+	cacheFree, cacheDevice, ok := diskSpace(s.Path)
+	if !ok {
+		return nil
+	}
+
+	dstFree, dstDevice, ok := diskSpace(dst)
+	if !ok {
+		return nil
+	}
+
+	if cacheDevice == dstDevice {
+		return requireSpace(dst, download+extract, dstFree)
+	}
+
+	if err := requireSpace(s.Path, download, cacheFree); err != nil {
+		return err
+	}
+
+	return requireSpace(dst, extract, dstFree)
+}
+
+// requireSpace returns an error if the required space is not available
+func requireSpace(path string, required uint64, free uint64) error {
+	if required <= free {
+		return nil
+	}
+
+	return fmt.Errorf("not enough space on the filesystem of %s: %d MiB required, %d MiB available",
+		path, required>>20, free>>20)
+}
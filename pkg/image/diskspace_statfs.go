@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd
+
+package image
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskSpace returns the space available to unprivileged users on the
+// filesystem of the given path, together with the id of its device
+func diskSpace(path string) (free uint64, device uint64, ok bool) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return uint64(fs.Bavail) * uint64(fs.Bsize), uint64(stat.Dev), true
+}
@@ -0,0 +1,211 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LayerChanges lists the paths a layer adds to the tree of the layers below,
+// the ones it removes through whiteouts and the ones it replaces
+type LayerChanges struct {
+	Digest   string   `json:"digest"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Replaced []string `json:"replaced"`
+}
+
+// DryRunResult describes what an extraction would write to the destination
+type DryRunResult struct {
+	Digest string          `json:"digest"`
+	Layers []*LayerChanges `json:"layers"`
+
+	// Files are the paths of the extracted tree, sorted by name
+	Files []string `json:"files"`
+}
+
+// DryRun downloads the layers of the source into the cache (or reads them
+// from there) and walks them, applying the whiteouts in memory. The tree the
+// extraction would produce is returned, nothing is written outside the cache.
+func (s *Store) DryRun(ctx context.Context, r Source) (*DryRunResult, error) {
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("error querying layers for %s: %w", r, err)
+	}
+
+	result := &DryRunResult{
+		Digest: manifest.Digest,
+		Layers: []*LayerChanges{},
+		Files:  []string{},
+	}
+
+	// artifacts are written as files, without layers to extract
+	if manifest.IsArtifact() {
+		for _, l := range manifest.Layers {
+			result.Files = append(result.Files, "/"+artifactFileName(l))
+		}
+
+		sort.Strings(result.Files)
+		return result, nil
+	}
+
+	layers := manifest.Layers
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers found for %s", r)
+	}
+
+	if err := requireLayerHandlers(layers); err != nil {
+		return nil, err
+	}
+
+	cacheLock, err := s.acquireSharedLock(ctx, s.CacheLockPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cacheLock.MustRUnlock()
+
+	results, err := s.fetchLayers(ctx, r, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[string]byte)
+
+	for i := range results {
+		res := <-results[i]
+
+		if res.Error != nil {
+			return nil, fmt.Errorf("error downloading %s: %w", res.Digest, res.Error)
+		}
+
+		changes, err := s.walkCachedLayer(ctx, res.Path, layers[i].MediaType, tree)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", res.Path, err)
+		}
+
+		changes.Digest = res.Digest
+		result.Layers = append(result.Layers, changes)
+	}
+
+	for name := range tree {
+		result.Files = append(result.Files, name)
+	}
+
+	sort.Strings(result.Files)
+	return result, nil
+}
+
+// fetchLayers downloads the given layers into the cache, unless they are
+// cached already, and returns the channels their paths are sent through.
+// The cache lock has to be held by the caller.
+func (s *Store) fetchLayers(ctx context.Context, r Source, layers []ManifestLayer) ([]chan *StoreResult, error) {
+	var err error
+
+	results := make([]chan *StoreResult, len(layers))
+	for i, l := range layers {
+		results[i], err = s.downloadLayer(ctx, r, l.Digest, l.MediaType)
+
+		if err != nil {
+			return nil, fmt.Errorf("error writing %s: %v", l.Digest, err)
+		}
+	}
+
+	return results, nil
+}
+
+// walkCachedLayer applies the given cached layer to the tree of the layers
+// below (paths with their tar type flag), returning the changes
+func (s *Store) walkCachedLayer(ctx context.Context, file string, mediaType string, tree map[string]byte) (*LayerChanges, error) {
+	archive, err := s.openLayer(file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	stream, err := tarStream(archive, cachedMediaType(file, mediaType))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	whiteouts := []string{}
+	entries := []*tar.Header{}
+
+	err = walkTar(ctx, stream, func(h *tar.Header, _ *tar.Reader) error {
+		if unsafepath.MatchString(h.Name) {
+			return fmt.Errorf("refusing to extract unsafe path: %s", h.Name)
+		}
+
+		if isWhiteoutPath(h.Name) {
+			whiteouts = append(whiteouts, h.Name)
+		} else {
+			entries = append(entries, h)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &LayerChanges{Added: []string{}, Removed: []string{}, Replaced: []string{}}
+
+	// the whiteouts only apply to the layers below
+	for _, w := range whiteouts {
+		dir, base := path.Split(treePath(w))
+
+		if base == ".wh..wh..opq" {
+			changes.Removed = append(changes.Removed, removeTree(tree, dir, false)...)
+		} else {
+			changes.Removed = append(changes.Removed, removeTree(tree, path.Join(dir, base[4:]), true)...)
+		}
+	}
+
+	for _, h := range entries {
+		name := treePath(h.Name)
+		if name == "/" {
+			continue
+		}
+
+		previous, exists := tree[name]
+
+		switch {
+		case !exists:
+			changes.Added = append(changes.Added, name)
+		case previous != tar.TypeDir || h.Typeflag != tar.TypeDir:
+			changes.Replaced = append(changes.Replaced, name)
+		}
+
+		tree[name] = h.Typeflag
+	}
+
+	sort.Strings(changes.Removed)
+	return changes, nil
+}
+
+// treePath returns the absolute, clean path of the given tar entry
+func treePath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// removeTree removes the descendants of the given path from the tree, as
+// well as the path itself if requested, returning the removed paths
+func removeTree[V any](tree map[string]V, name string, self bool) []string {
+	removed := []string{}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+
+	for p := range tree {
+		if (self && p == path.Clean(name)) || strings.HasPrefix(p, prefix) {
+			removed = append(removed, p)
+			delete(tree, p)
+		}
+	}
+
+	return removed
+}
@@ -0,0 +1,81 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// layeredSource is an image with the given uncompressed layers
+type layeredSource struct {
+	manifest *Manifest
+	blobs    map[string][]byte
+}
+
+func newLayeredSource(layers ...[]byte) *layeredSource {
+	s := &layeredSource{manifest: &Manifest{Digest: "sha256:image"}, blobs: make(map[string][]byte)}
+
+	for _, l := range layers {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(l))
+		s.blobs[digest] = l
+		s.manifest.Layers = append(s.manifest.Layers, ManifestLayer{
+			MediaType: OCITarLayerMimeType,
+			Size:      len(l),
+			Digest:    digest,
+		})
+	}
+
+	return s
+}
+
+func (s *layeredSource) String() string               { return "layered" }
+func (s *layeredSource) Name() string                 { return "layered" }
+func (s *layeredSource) Platform() *Platform          { return nil }
+func (s *layeredSource) Manifest() (*Manifest, error) { return s.manifest, nil }
+
+func (s *layeredSource) DownloadLayer(digest string, w io.Writer) error {
+	_, err := io.Copy(w, bytes.NewReader(s.blobs[digest]))
+	return err
+}
+
+// TestDryRun tests that the tree of an extraction is computed in memory
+func TestDryRun(t *testing.T) {
+	files := map[string][]byte{
+		"etc/passwd":           []byte("root"),
+		"etc/motd":             []byte("hello"),
+		"tmp/cache/a":          []byte("a"),
+		"var/log/old":          []byte("old"),
+		"etc/.wh.motd":         {},
+		"tmp/.wh.cache":        {},
+		"var/log/.wh..wh..opq": {},
+		"var/log/new":          []byte("new"),
+	}
+
+	source := newLayeredSource(
+		tarball(t, files, "etc/passwd", "etc/motd", "tmp/cache/a", "var/log/old"),
+		tarball(t, files, "etc/.wh.motd", "tmp/.wh.cache", "var/log/.wh..wh..opq", "var/log/new", "etc/passwd"),
+	)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	result, err := store.DryRun(context.Background(), source)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "sha256:image", result.Digest)
+	assert.Len(t, result.Layers, 2)
+
+	assert.Equal(t, []string{"/etc/passwd", "/etc/motd", "/tmp/cache/a", "/var/log/old"}, result.Layers[0].Added)
+	assert.Empty(t, result.Layers[0].Removed)
+
+	assert.Equal(t, []string{"/var/log/new"}, result.Layers[1].Added)
+	assert.Equal(t, []string{"/etc/motd", "/tmp/cache/a", "/var/log/old"}, result.Layers[1].Removed)
+	assert.Equal(t, []string{"/etc/passwd"}, result.Layers[1].Replaced)
+
+	assert.Equal(t, []string{"/etc/passwd", "/var/log/new"}, result.Files)
+}
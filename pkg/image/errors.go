@@ -0,0 +1,143 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxErrorBody is the number of bytes of an error response that are read to
+// parse the errors reported by registries
+const maxErrorBody = 64 * 1024
+
+// RequestError is returned if a registry responds with an unexpected status.
+// It is kept in the error chain, so callers may react to the status code
+// (e.g. by asking the user to log in on 401).
+type RequestError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+
+	// Errors are the errors reported in the body of the response, if any
+	Errors []RegistryError
+
+	// RetryAfter is the delay requested through the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+// RegistryError is an error reported by a registry, as defined by the
+// distribution spec (e.g. DENIED, MANIFEST_UNKNOWN or TOOMANYREQUESTS)
+type RegistryError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+func (e RegistryError) String() string {
+	switch {
+	case e.Message == "":
+		return e.Code
+	case e.Code == "":
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewRequestError returns the error for the given response, with the errors
+// reported in the body (which is read, but not closed)
+func NewRequestError(res *http.Response) *RequestError {
+	e := &RequestError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Errors:     parseRegistryErrors(res),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+
+	if res.Request != nil {
+		e.Method = res.Request.Method
+		e.URL = res.Request.URL.String()
+	}
+
+	return e
+}
+
+// Code returns the code of the first error reported by the registry, or an
+// empty string if there is none
+func (e *RequestError) Code() string {
+	if len(e.Errors) == 0 {
+		return ""
+	}
+
+	return e.Errors[0].Code
+}
+
+func (e *RequestError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("%s %s failed with %s", e.Method, e.URL, e.Status)
+	}
+
+	reported := make([]string, len(e.Errors))
+	for i, r := range e.Errors {
+		reported[i] = r.String()
+	}
+
+	return fmt.Sprintf("%s %s failed with %s: %s", e.Method, e.URL, e.Status, strings.Join(reported, "; "))
+}
+
+// parseRegistryErrors returns the errors in the body of the given response,
+// or nil if the body does not contain any
+func parseRegistryErrors(res *http.Response) []RegistryError {
+	if res.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+
+	payload := struct {
+		Errors []RegistryError `json:"errors"`
+	}{}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	reported := []RegistryError{}
+	for _, r := range payload.Errors {
+		if r.Code != "" || r.Message != "" {
+			reported = append(reported, r)
+		}
+	}
+
+	if len(reported) == 0 {
+		return nil
+	}
+
+	return reported
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, which is given
+// in seconds or as date, or 0 if there is none
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && time.Until(t) > 0 {
+		return time.Until(t)
+	}
+
+	return 0
+}
@@ -0,0 +1,22 @@
+package image
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchange atomically swaps the given paths, if supported by the filesystem
+func exchange(a string, b string) error {
+	return exchangeError(unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE))
+}
+
+// exchangeError reports the errors of filesystems (EINVAL), kernels (ENOSYS)
+// and seccomp filters (EOPNOTSUPP) without renameat2 exchanges as unsupported
+func exchangeError(err error) error {
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EOPNOTSUPP) {
+		return errors.ErrUnsupported
+	}
+
+	return err
+}
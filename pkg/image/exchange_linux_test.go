@@ -0,0 +1,59 @@
+package image
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// TestExchangeError tests that missing support for exchanges is reported as
+// unsupported, so that callers fall back to renames
+func TestExchangeError(t *testing.T) {
+	tests := []struct {
+		err         error
+		unsupported bool
+	}{
+		{unix.EINVAL, true},
+		{unix.ENOSYS, true},
+		{unix.EOPNOTSUPP, true},
+		{&os.LinkError{Op: "renameat2", Err: unix.ENOSYS}, true},
+		{unix.ENOENT, false},
+		{unix.EXDEV, false},
+	}
+
+	for _, test := range tests {
+		err := exchangeError(test.err)
+		assert.Equal(t, test.unsupported, errors.Is(err, errors.ErrUnsupported), test.err.Error())
+
+		if !test.unsupported {
+			assert.Equal(t, test.err, err, test.err.Error())
+		}
+	}
+
+	assert.NoError(t, exchangeError(nil))
+}
+
+// TestExchange tests swapping two files
+func TestExchange(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	assert.NoError(t, os.WriteFile(a, []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(b, []byte("b"), 0644))
+
+	err := exchange(a, b)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("the filesystem does not support exchanges")
+	}
+	assert.NoError(t, err)
+
+	content, _ := os.ReadFile(a)
+	assert.Equal(t, "b", string(content))
+
+	content, _ = os.ReadFile(b)
+	assert.Equal(t, "a", string(content))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package image
+
+import "errors"
+
+// exchange is not supported outside of Linux
+func exchange(a string, b string) error {
+	return errors.ErrUnsupported
+}
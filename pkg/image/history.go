@@ -0,0 +1,145 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MetadataDir is the directory within a destination holding the metadata
+// written by roots
+const MetadataDir = ".roots"
+
+// PullEvent records a pull to a destination
+type PullEvent struct {
+	Time     time.Time `json:"time"`
+	Image    string    `json:"image"`
+	Digest   string    `json:"digest"`
+	Platform string    `json:"platform,omitempty"`
+	Duration float64   `json:"duration"`
+	User     string    `json:"user"`
+}
+
+// HistoryPath returns the path to the pull history of a destination
+func HistoryPath(dst string) string {
+	return filepath.Join(dst, MetadataDir, "history.jsonl")
+}
+
+// DigestPath returns the path to the digest of the image extracted to a
+// destination, as recorded by the last pull
+func DigestPath(dst string) string {
+	return filepath.Join(dst, MetadataDir, "digest")
+}
+
+// ReadDigest returns the digest of the image extracted to the destination,
+// or an empty string if none has been recorded
+func ReadDigest(dst string) (string, error) {
+	data, err := os.ReadFile(DigestPath(dst))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(data)), nil
+}
+
+// WriteDigest records the digest of the image extracted to the destination
+func WriteDigest(dst string, digest string) error {
+	file := DigestPath(dst)
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(file), err)
+	}
+
+	return os.WriteFile(file, []byte(digest+"\n"), 0644)
+}
+
+// AppendHistory appends the event to the pull history of the destination
+func AppendHistory(dst string, e *PullEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return appendHistoryData(dst, append(data, '\n'))
+}
+
+// appendHistoryData appends the raw lines to the pull history
+func appendHistoryData(dst string, data []byte) error {
+	file := HistoryPath(dst)
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(file), err)
+	}
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", file, err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %v", file, err)
+	}
+
+	return f.Close()
+}
+
+// ReadHistory returns the pull history of the destination, oldest first. A
+// destination without history returns an empty list.
+func ReadHistory(dst string) ([]*PullEvent, error) {
+	data, err := os.ReadFile(HistoryPath(dst))
+	if os.IsNotExist(err) {
+		return []*PullEvent{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*PullEvent{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		e := &PullEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", HistoryPath(dst), err)
+		}
+
+		events = append(events, e)
+	}
+
+	return events, scanner.Err()
+}
+
+// RestoreHistory writes a previously read history back to the destination,
+// for example after the destination was removed for a forced pull
+func RestoreHistory(dst string, events []*PullEvent) error {
+	var buf bytes.Buffer
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(append(data, '\n'))
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	return appendHistoryData(dst, buf.Bytes())
+}
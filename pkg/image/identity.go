@@ -0,0 +1,160 @@
+package image
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenPath returns the path to the token identifying a destination to the
+// caches it was pulled with
+func TokenPath(dst string) string {
+	return filepath.Join(dst, MetadataDir, "token")
+}
+
+// readToken returns the token of the given destination, or an empty string
+// if it has none
+func readToken(dst string) string {
+	data, err := os.ReadFile(TokenPath(dst))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// destinationToken returns the token of the given destination, which is
+// created on the first pull and kept afterwards
+func destinationToken(dst string) (string, error) {
+	if token := readToken(dst); token != "" {
+		return token, nil
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(random)
+
+	if err := os.MkdirAll(filepath.Join(dst, MetadataDir), 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(TokenPath(dst), []byte(token+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// identify records the identity of the destination in the link: a token
+// written into it, the device and inode of the directory and the path with
+// symlinks resolved. Destinations which are not directories (e.g. written
+// by an unpacker) are only known by their path.
+func (l *Link) identify() error {
+	info, err := os.Stat(l.Destination)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	if l.Token, err = destinationToken(l.Destination); err != nil {
+		return fmt.Errorf("error writing token of %s: %v", l.Destination, err)
+	}
+
+	l.Device, l.Inode, _ = fileIdentity(info)
+
+	if resolved, err := filepath.EvalSymlinks(l.Destination); err == nil {
+		if resolved, err = filepath.Abs(resolved); err == nil && resolved != l.Destination {
+			l.Resolved = resolved
+		}
+	}
+
+	return nil
+}
+
+// alive returns true if the tree pulled to the destination of the link still
+// exists. Without token, that is the case if the destination exists. With a
+// token, the destination (or the path it resolved to) has to be the pulled
+// directory, which may also have been renamed within the same parent.
+// Directories which merely reuse the path, or a copy of the token, are not.
+func (l *Link) alive() (bool, error) {
+	if l.Token == "" {
+		return exists(l.Destination)
+	}
+
+	paths := []string{l.Destination}
+	if l.Resolved != "" {
+		paths = append(paths, l.Resolved)
+	}
+
+	for _, path := range paths {
+		if found, err := l.identifies(path); found || err != nil {
+			return found, err
+		}
+	}
+
+	// look for the directory next to where it was pulled to
+	for _, path := range paths {
+		entries, err := os.ReadDir(filepath.Dir(path))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			sibling := filepath.Join(filepath.Dir(path), entry.Name())
+			if found, _ := l.identifies(sibling); found {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// identifies returns true if the given path is the pulled directory: it holds
+// the token of the link and, where known, it is the same directory on disk
+func (l *Link) identifies(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	if readToken(path) != l.Token {
+		return false, nil
+	}
+
+	device, inode, ok := fileIdentity(info)
+	if !ok || l.Inode == 0 {
+		return true, nil
+	}
+
+	return device == l.Device && inode == l.Inode, nil
+}
+
+// exists returns true if the given path exists
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+
+	if err == nil {
+		return true, nil
+	}
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("error reading %s: %v", path, err)
+}
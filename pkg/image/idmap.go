@@ -0,0 +1,103 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IDRange maps a contiguous range of ids inside the image to a range of
+// ids on the host
+type IDRange struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// IDMap shifts the ids found in image layers to ids on the host, like it is
+// done for unprivileged containers. An empty map leaves all ids unchanged.
+type IDMap []IDRange
+
+// Map returns the host id for the given container id, or an error if the id
+// is not covered by the map
+func (m IDMap) Map(id int) (int, error) {
+	if len(m) == 0 {
+		return id, nil
+	}
+
+	for _, r := range m {
+		if id >= r.ContainerID && id < r.ContainerID+r.Size {
+			return r.HostID + id - r.ContainerID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("id %d is not mapped", id)
+}
+
+// ParseSubIDs reads a file in the format of /etc/subuid or /etc/subgid and
+// returns the ranges of the given user (matched by name or by id) as a map
+// that starts at container id 0.
+func ParseSubIDs(r io.Reader, name string, id string) (IDMap, error) {
+	m := IDMap{}
+	next := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+
+		if fields[0] != name && fields[0] != id {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start in %s: %v", line, err)
+		}
+
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid count in %s: %v", line, err)
+		}
+
+		m = append(m, IDRange{ContainerID: next, HostID: start, Size: size})
+		next += size
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no ranges found for %s", name)
+	}
+
+	return m, nil
+}
+
+// LoadSubIDs reads the ranges of the given user from a subuid/subgid file
+func LoadSubIDs(path string, name string, id string) (IDMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := ParseSubIDs(f, name, id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	return m, nil
+}
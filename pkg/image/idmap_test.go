@@ -0,0 +1,46 @@
+package image
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseSubIDs tests the parsing of subuid files
+func TestParseSubIDs(t *testing.T) {
+	file := `
+		# comment
+		alice:100000:65536
+		bob:165536:65536
+		1000:300000:10
+	`
+
+	m, err := ParseSubIDs(strings.NewReader(file), "alice", "1000")
+	assert.NoError(t, err)
+	assert.Equal(t, IDMap{
+		{ContainerID: 0, HostID: 100000, Size: 65536},
+		{ContainerID: 65536, HostID: 300000, Size: 10},
+	}, m)
+
+	id, err := m.Map(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 100000, id)
+
+	id, err = m.Map(65537)
+	assert.NoError(t, err)
+	assert.Equal(t, 300001, id)
+
+	_, err = m.Map(65546)
+	assert.EqualError(t, err, "id 65546 is not mapped")
+
+	_, err = ParseSubIDs(strings.NewReader(file), "carol", "1001")
+	assert.EqualError(t, err, "no ranges found for carol")
+}
+
+// TestEmptyIDMap tests that an empty map does not change ids
+func TestEmptyIDMap(t *testing.T) {
+	id, err := IDMap{}.Map(42)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, id)
+}
@@ -0,0 +1,297 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LayerChange is a difference between the layers of two images, which are
+// compared by their position
+type LayerChange struct {
+
+	// Index is the position of the layer, starting at 1 for the lowest one
+	Index int `json:"index"`
+
+	// Change is either "added", "removed" or "modified"
+	Change string `json:"change"`
+
+	Old     string `json:"old,omitempty"`
+	OldSize int64  `json:"old_size,omitempty"`
+	New     string `json:"new,omitempty"`
+	NewSize int64  `json:"new_size,omitempty"`
+}
+
+// ImageDiff describes the differences between two images
+type ImageDiff struct {
+	Layers []*LayerChange `json:"layers"`
+
+	// Shared is the number of layers of the second image, which are part of
+	// the first one as well (at any position)
+	Shared int `json:"shared_layers"`
+
+	// SizeDelta is the difference between the total sizes of the layers
+	SizeDelta int64 `json:"size_delta"`
+
+	// Download is the size of the layers of the second image, which are not
+	// part of the first one and have to be downloaded to update to it
+	Download int64 `json:"download_size"`
+
+	// Files are the changes between the trees of the images, if compared
+	Files []*TreeChange `json:"files,omitempty"`
+}
+
+// DiffImages compares the layers of the given manifests, from the first to
+// the second image
+func DiffImages(a *Manifest, b *Manifest) *ImageDiff {
+	diff := &ImageDiff{Layers: []*LayerChange{}}
+	known := make(map[string]bool, len(a.Layers))
+
+	for _, l := range a.Layers {
+		known[l.Digest] = true
+		diff.SizeDelta -= int64(l.Size)
+	}
+
+	for _, l := range b.Layers {
+		diff.SizeDelta += int64(l.Size)
+
+		if known[l.Digest] {
+			diff.Shared++
+		} else {
+			diff.Download += int64(l.Size)
+		}
+	}
+
+	for i := 0; i < max(len(a.Layers), len(b.Layers)); i++ {
+		c := &LayerChange{Index: i + 1}
+
+		if i < len(a.Layers) {
+			c.Old, c.OldSize = a.Layers[i].Digest, int64(a.Layers[i].Size)
+		}
+
+		if i < len(b.Layers) {
+			c.New, c.NewSize = b.Layers[i].Digest, int64(b.Layers[i].Size)
+		}
+
+		switch {
+		case c.Old == c.New:
+			continue
+		case c.Old == "":
+			c.Change = "added"
+		case c.New == "":
+			c.Change = "removed"
+		default:
+			c.Change = "modified"
+		}
+
+		diff.Layers = append(diff.Layers, c)
+	}
+
+	return diff
+}
+
+// DiffFiles compares the trees the extractions of the given images would
+// produce, returning the changes from the first to the second, sorted by
+// path. The trees are read from the layers in the cache, missing layers are
+// downloaded into the cache first.
+func (s *Store) DiffFiles(ctx context.Context, a Source, b Source) ([]*TreeChange, error) {
+	before, err := s.ImageTree(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := s.ImageTree(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffTrees(before, after, false), nil
+}
+
+// ImageTree returns the tree the extraction of the given image would
+// produce, sorted by path, without extracting it
+func (s *Store) ImageTree(ctx context.Context, r Source) ([]*TreeEntry, error) {
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("error querying layers for %s: %w", r, err)
+	}
+
+	if manifest.IsArtifact() {
+		return nil, fmt.Errorf("%s is an artifact without a tree", r)
+	}
+
+	layers := manifest.Layers
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers found for %s", r)
+	}
+
+	if err := requireLayerHandlers(layers); err != nil {
+		return nil, err
+	}
+
+	cacheLock, err := s.acquireSharedLock(ctx, s.CacheLockPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cacheLock.MustRUnlock()
+
+	results, err := s.fetchLayers(ctx, r, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[string]*TreeEntry)
+
+	for i := range results {
+		res := <-results[i]
+
+		if res.Error != nil {
+			return nil, fmt.Errorf("error downloading %s: %w", res.Digest, res.Error)
+		}
+
+		if err := s.scanCachedLayer(ctx, res.Path, layers[i].MediaType, tree); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", res.Path, err)
+		}
+	}
+
+	entries := make([]*TreeEntry, 0, len(tree))
+	for _, e := range tree {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+// scanCachedLayer applies the given cached layer to the tree of the layers
+// below (entries by absolute path), hashing the content of its files
+func (s *Store) scanCachedLayer(ctx context.Context, file string, mediaType string, tree map[string]*TreeEntry) error {
+	archive, err := s.openLayer(file)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	stream, err := tarStream(archive, cachedMediaType(file, mediaType))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	whiteouts := []string{}
+	entries := []*TreeEntry{}
+
+	// hardlinks share the entry of their target, once it is known
+	hardlinks := make(map[*TreeEntry]string)
+
+	err = walkTar(ctx, stream, func(h *tar.Header, tr *tar.Reader) error {
+		if unsafepath.MatchString(h.Name) {
+			return fmt.Errorf("refusing to extract unsafe path: %s", h.Name)
+		}
+
+		if isWhiteoutPath(h.Name) {
+			whiteouts = append(whiteouts, h.Name)
+			return nil
+		}
+
+		if treePath(h.Name) == "/" {
+			return nil
+		}
+
+		e, err := newTarTreeEntry(h, tr)
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag == tar.TypeLink {
+			hardlinks[e] = treePath(h.Linkname)
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// the whiteouts only apply to the layers below
+	for _, w := range whiteouts {
+		dir, base := path.Split(treePath(w))
+
+		if base == ".wh..wh..opq" {
+			removeTree(tree, dir, false)
+		} else {
+			removeTree(tree, path.Join(dir, base[4:]), true)
+		}
+	}
+
+	for _, e := range entries {
+		name := "/" + e.Path
+
+		if target, ok := hardlinks[e]; ok {
+			linked, found := tree[target]
+			if !found {
+				return fmt.Errorf("hardlink %s to unknown file %s", e.Path, target)
+			}
+
+			e.Type, e.Mode, e.Size, e.SHA256 = linked.Type, linked.Mode, linked.Size, linked.SHA256
+		}
+
+		// files replacing directories replace their content as well
+		if previous, exists := tree[name]; exists && previous.Type == "dir" && e.Type != "dir" {
+			removeTree(tree, name, false)
+		}
+
+		tree[name] = e
+	}
+
+	return nil
+}
+
+// newTarTreeEntry describes the given tar entry, reading the content of
+// regular files from the tar reader
+func newTarTreeEntry(h *tar.Header, r io.Reader) (*TreeEntry, error) {
+	mode := h.FileInfo().Mode()
+
+	e := &TreeEntry{
+		Path: relTreePath(h.Name),
+		Type: fileType(mode),
+		Mode: mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
+		UID:  h.Uid,
+		GID:  h.Gid,
+	}
+
+	switch {
+	case h.Typeflag == tar.TypeSymlink:
+		e.Link = h.Linkname
+	case h.Typeflag != tar.TypeLink && e.Type == "file":
+		e.Size = h.Size
+
+		sum := sha256.New()
+		if _, err := io.Copy(sum, r); err != nil {
+			return nil, fmt.Errorf("error hashing %s: %v", h.Name, err)
+		}
+
+		e.SHA256 = fmt.Sprintf("%x", sum.Sum(nil))
+	}
+
+	return e, nil
+}
+
+// relTreePath returns the clean path of the given tar entry, relative to the
+// root of the tree, as used by tree entries
+func relTreePath(name string) string {
+	return strings.TrimPrefix(treePath(name), "/")
+}
@@ -0,0 +1,88 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffImages tests comparing the layers of two images
+func TestDiffImages(t *testing.T) {
+	a := &Manifest{Layers: []ManifestLayer{
+		{Digest: "sha256:base", Size: 1000},
+		{Digest: "sha256:deps", Size: 200},
+		{Digest: "sha256:app", Size: 30},
+	}}
+
+	b := &Manifest{Layers: []ManifestLayer{
+		{Digest: "sha256:base", Size: 1000},
+		{Digest: "sha256:deps2", Size: 250},
+		{Digest: "sha256:app", Size: 30},
+		{Digest: "sha256:assets", Size: 40},
+	}}
+
+	diff := DiffImages(a, b)
+
+	assert.Equal(t, []*LayerChange{
+		{Index: 2, Change: "modified", Old: "sha256:deps", OldSize: 200, New: "sha256:deps2", NewSize: 250},
+		{Index: 4, Change: "added", New: "sha256:assets", NewSize: 40},
+	}, diff.Layers)
+
+	assert.Equal(t, 2, diff.Shared)
+	assert.Equal(t, int64(90), diff.SizeDelta)
+	assert.Equal(t, int64(290), diff.Download)
+
+	// the other way around
+	diff = DiffImages(b, a)
+	assert.Equal(t, "removed", diff.Layers[1].Change)
+	assert.Equal(t, int64(-90), diff.SizeDelta)
+	assert.Equal(t, int64(200), diff.Download)
+
+	// identical images
+	assert.Empty(t, DiffImages(a, a).Layers)
+}
+
+// TestDiffFiles tests comparing the trees of two images
+func TestDiffFiles(t *testing.T) {
+	files := map[string][]byte{
+		"etc/passwd":   []byte("root"),
+		"etc/motd":     []byte("hello"),
+		"etc/.wh.motd": {},
+		"etc/hosts":    []byte("localhost"),
+		"usr/bin/app":  []byte("v1"),
+	}
+
+	a := newLayeredSource(
+		tarball(t, files, "etc/passwd", "etc/motd", "usr/bin/app"),
+	)
+
+	files["usr/bin/app"] = []byte("v2")
+
+	b := newLayeredSource(
+		tarball(t, files, "etc/passwd", "etc/motd"),
+		tarball(t, files, "etc/.wh.motd", "etc/hosts", "usr/bin/app"),
+	)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	tree, err := store.ImageTree(context.Background(), b)
+	assert.NoError(t, err)
+
+	paths := []string{}
+	for _, e := range tree {
+		paths = append(paths, e.Path)
+	}
+
+	assert.Equal(t, []string{"etc/hosts", "etc/passwd", "usr/bin/app"}, paths)
+
+	changes, err := store.DiffFiles(context.Background(), a, b)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*TreeChange{
+		{Path: "etc/hosts", Change: "added"},
+		{Path: "etc/motd", Change: "removed"},
+		{Path: "usr/bin/app", Change: "modified", Fields: []string{"content"}},
+	}, changes)
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Inspection describes an image without pulling it
+type Inspection struct {
+	Name     string    `json:"name"`
+	Digest   string    `json:"digest"`
+	Platform *Platform `json:"platform,omitempty"`
+
+	// Manifest and Config are the documents as sent by the registry
+	Manifest json.RawMessage `json:"manifest"`
+	Config   json.RawMessage `json:"config,omitempty"`
+
+	// Layers lists the layers with their compressed and uncompressed size
+	Layers []LayerSize `json:"layers"`
+}
+
+// Inspect fetches the manifest and the config of the image, respecting the
+// platform if one was set through WithPlatform
+func (r *Remote) Inspect() (*Inspection, error) {
+	m, err := r.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := r.rawManifest(m.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &Inspection{
+		Name:     r.url.Familiar(),
+		Digest:   m.Digest,
+		Manifest: manifest,
+		Layers:   r.layerSizes(m.Layers),
+	}
+
+	// artifacts may not have a config
+	if m.Config.Digest == "" {
+		return inspection, nil
+	}
+
+	var buf bytes.Buffer
+	if err := r.DownloadLayer(m.Config.Digest, &buf); err != nil {
+		return nil, fmt.Errorf("error downloading config: %w", err)
+	}
+
+	inspection.Config = buf.Bytes()
+
+	if !m.IsArtifact() {
+		c := &ImageConfig{}
+		if err := json.Unmarshal(buf.Bytes(), c); err != nil {
+			return nil, fmt.Errorf("error parsing config: %v", err)
+		}
+
+		inspection.Platform = c.Platform()
+	}
+
+	return inspection, nil
+}
+
+// rawManifest returns the manifest with the given digest as sent by the
+// registry, which is what the digest is computed from
+func (r *Remote) rawManifest(digest string) ([]byte, error) {
+	res, err := r.request("GET", strings.Join(manifestMimeTypes, ", "), "manifests", digest)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting manifest@%s: %w", digest, err)
+	}
+
+	return readManifest(res, digest)
+}
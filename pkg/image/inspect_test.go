@@ -0,0 +1,51 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInspect tests that the manifest and config of the selected platform
+// are returned as sent by the registry
+func TestInspect(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	arm := registrytest.Image{OS: "linux", Architecture: "arm64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "world"}),
+	}}
+
+	digest := registry.Push("team/app", "", arm)
+	registry.PushIndex("team/app", "1.0", registrytest.Image{OS: "linux", Architecture: "amd64"}, arm)
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+	remote.WithPlatform(&Platform{OS: "linux", Architecture: "arm64"})
+
+	inspection, err := remote.Inspect()
+	assert.NoError(t, err)
+
+	assert.Equal(t, digest, inspection.Digest)
+	assert.Equal(t, &Platform{OS: "linux", Architecture: "arm64"}, inspection.Platform)
+	assert.Len(t, inspection.Layers, 1)
+	assert.Equal(t, int64(len(registrytest.Tar(map[string]string{"hello": "world"}))), inspection.Layers[0].Uncompressed)
+
+	m := &Manifest{}
+	assert.NoError(t, json.Unmarshal(inspection.Manifest, m))
+	assert.Equal(t, registrytest.ManifestMimeType, m.MediaType)
+	assert.Equal(t, m.Layers[0].Digest, inspection.Layers[0].Digest)
+
+	c := &ImageConfig{}
+	assert.NoError(t, json.Unmarshal(inspection.Config, c))
+	assert.Len(t, c.RootFS.DiffIDs, 1)
+}
@@ -0,0 +1,148 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// OCITarLayerMimeType is the mime type of uncompressed OCI layers
+	OCITarLayerMimeType = "application/vnd.oci.image.layer.v1.tar"
+
+	// OCIZstdLayerMimeType is the mime type of zstd compressed OCI layers
+	OCIZstdLayerMimeType = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	// DockerGzipLayerMimeType is the mime type of the layers pushed by docker
+	DockerGzipLayerMimeType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// LayerHandler takes the content of a layer and returns its tar stream,
+// e.g. by decompressing it
+type LayerHandler func(r io.Reader) (io.ReadCloser, error)
+
+// layerHandlers are the handlers by layer media type
+var layerHandlers = map[string]LayerHandler{
+	OCITarLayerMimeType:      plainLayer,
+	OCILayerMimeType:         gzipLayer,
+	OCIZstdLayerMimeType:     zstdLayer,
+	DockerLayerMimeType:      plainLayer,
+	DockerGzipLayerMimeType:  gzipLayer,
+	ForeignLayerMimeTypes[0]: gzipLayer,
+	ForeignLayerMimeTypes[1]: plainLayer,
+	ForeignLayerMimeTypes[2]: gzipLayer,
+	ForeignLayerMimeTypes[3]: zstdLayer,
+}
+
+// UnsupportedLayerError is returned for layers with a media type that has no
+// handler (see RegisterLayerHandler)
+type UnsupportedLayerError struct {
+	Digest    string
+	MediaType string
+}
+
+func (e *UnsupportedLayerError) Error() string {
+	if e.Digest == "" {
+		return fmt.Sprintf("unsupported layer media type %s", e.MediaType)
+	}
+
+	return fmt.Sprintf("layer %s has unsupported media type %s", e.Digest, e.MediaType)
+}
+
+// RegisterLayerHandler registers the handler for layers of the given media
+// type. Handlers are meant to be registered once during initialization and
+// doing so concurrently is not safe. Existing handlers are overwritten.
+func RegisterLayerHandler(mediaType string, handler LayerHandler) {
+	layerHandlers[mediaType] = handler
+}
+
+// layerHandler returns the handler for the given media type. Layers without
+// media type (e.g. tarballs given to push) are detected by their content.
+func layerHandler(mediaType string) (LayerHandler, error) {
+	if mediaType == "" {
+		return detectLayer, nil
+	}
+
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+
+	handler, ok := layerHandlers[strings.TrimSpace(mediaType)]
+	if !ok {
+		return nil, &UnsupportedLayerError{MediaType: mediaType}
+	}
+
+	return handler, nil
+}
+
+// requireLayerHandlers returns an error if one of the layers cannot be
+// extracted, before any of them are downloaded
+func requireLayerHandlers(layers []ManifestLayer) error {
+	for _, l := range layers {
+		if _, err := layerHandler(l.MediaType); err != nil {
+			return &UnsupportedLayerError{Digest: l.Digest, MediaType: l.MediaType}
+		}
+	}
+
+	return nil
+}
+
+// tarStream rewinds the given layer and returns a reader for the tar stream
+// within, using the handler for the media type of the layer
+func tarStream(archive io.ReadSeeker, mediaType string) (io.ReadCloser, error) {
+	handler, err := layerHandler(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return handler(archive)
+}
+
+func plainLayer(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func gzipLayer(r io.Reader) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading gzip layer: %v", err)
+	}
+
+	return gz, nil
+}
+
+func zstdLayer(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zstd layer: %v", err)
+	}
+
+	return zr.IOReadCloser(), nil
+}
+
+// detectLayer recognizes gzip and zstd compressed layers by their magic
+// bytes, other layers are considered uncompressed
+func detectLayer(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading layer: %v", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzipLayer(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return zstdLayer(buffered)
+	}
+
+	return plainLayer(buffered)
+}
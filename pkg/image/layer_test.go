@@ -0,0 +1,98 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTar returns a tar stream with a single file
+func testTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err := tw.Write([]byte("world"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+// TestLayerHandlers tests that layers are read according to their media type
+func TestLayerHandlers(t *testing.T) {
+	plain := testTar(t)
+
+	var gzipped bytes.Buffer
+	gzw := gzip.NewWriter(&gzipped)
+	gzw.Write(plain)
+	gzw.Close()
+
+	var zstded bytes.Buffer
+	zw, _ := zstd.NewWriter(&zstded)
+	zw.Write(plain)
+	zw.Close()
+
+	layers := []struct {
+		mediaType string
+		content   []byte
+	}{
+		{OCITarLayerMimeType, plain},
+		{DockerLayerMimeType, plain},
+		{OCILayerMimeType, gzipped.Bytes()},
+		{DockerGzipLayerMimeType, gzipped.Bytes()},
+		{ForeignLayerMimeTypes[0], gzipped.Bytes()},
+		{OCIZstdLayerMimeType, zstded.Bytes()},
+		{OCILayerMimeType + "; foo=bar", gzipped.Bytes()},
+		{"", plain},
+		{"", gzipped.Bytes()},
+		{"", zstded.Bytes()},
+	}
+
+	for _, l := range layers {
+		stream, err := tarStream(bytes.NewReader(l.content), l.mediaType)
+		assert.NoError(t, err, l.mediaType)
+
+		data, err := io.ReadAll(stream)
+		assert.NoError(t, err, l.mediaType)
+		assert.Equal(t, plain, data, l.mediaType)
+		stream.Close()
+
+		assert.NoError(t, testLayer(context.Background(), bytes.NewReader(l.content), l.mediaType))
+	}
+
+	// the handler is chosen by media type, not by content
+	err := testLayer(context.Background(), bytes.NewReader(plain), OCILayerMimeType)
+	assert.Error(t, err)
+}
+
+// TestUnsupportedLayer tests that unknown media types are refused
+func TestUnsupportedLayer(t *testing.T) {
+	defer delete(layerHandlers, "application/x-custom")
+
+	layers := []ManifestLayer{
+		{MediaType: OCILayerMimeType, Digest: "sha256:a"},
+		{MediaType: "application/x-custom", Digest: "sha256:b"},
+	}
+
+	err := requireLayerHandlers(layers)
+
+	var unsupported *UnsupportedLayerError
+	assert.True(t, errors.As(err, &unsupported))
+	assert.Equal(t, "sha256:b", unsupported.Digest)
+	assert.EqualError(t, err, "layer sha256:b has unsupported media type application/x-custom")
+
+	_, err = tarStream(bytes.NewReader(nil), "application/x-custom")
+	assert.EqualError(t, err, "unsupported layer media type application/x-custom")
+
+	// custom handlers can be registered
+	RegisterLayerHandler("application/x-custom", plainLayer)
+	assert.NoError(t, requireLayerHandlers(layers))
+}
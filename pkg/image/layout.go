@@ -0,0 +1,105 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LayoutSource reads images from an OCI image layout, as written by roots
+// save or skopeo. It is a Source.
+type LayoutSource struct {
+	blobStore
+
+	path     string
+	ref      string
+	digest   string
+	platform *Platform
+}
+
+// NewLayoutSource looks up the image with the given name in the index of the
+// layout at the given path. The name is the tag the image was saved as, or
+// the digest of a manifest or an index in the layout. Without name, the
+// layout has to contain a single image.
+func NewLayoutSource(path string, ref string) (*LayoutSource, error) {
+	s := &LayoutSource{path: path, ref: ref}
+	s.dir = filepath.Join(path, "blobs")
+
+	if strings.HasPrefix(ref, "sha256:") {
+		s.digest = ref
+		return s, nil
+	}
+
+	digest, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	s.digest = digest
+	return s, nil
+}
+
+// lookup returns the digest of the image from the index of the layout
+func (s *LayoutSource) lookup() (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.path, "index.json"))
+	if err != nil {
+		return "", fmt.Errorf("error reading layout %s: %v", s.path, err)
+	}
+
+	index := &ociIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return "", fmt.Errorf("invalid index of %s: %v", s.path, err)
+	}
+
+	if s.ref == "" {
+		if len(index.Manifests) != 1 {
+			return "", fmt.Errorf("layout contains %d images, select one by tag", len(index.Manifests))
+		}
+
+		return index.Manifests[0].Digest, nil
+	}
+
+	for _, m := range index.Manifests {
+		if m.Annotations[RefNameAnnotation] == s.ref {
+			return m.Digest, nil
+		}
+	}
+
+	return "", fmt.Errorf("no image tagged %s in %s", s.ref, s.path)
+}
+
+func (s *LayoutSource) String() string {
+	if s.platform != nil {
+		return fmt.Sprintf("%s %s", s.Name(), s.platform)
+	}
+
+	return s.Name()
+}
+
+// Name returns the path of the layout and the selected tag
+func (s *LayoutSource) Name() string {
+	if s.ref == "" {
+		return fmt.Sprintf("oci:%s", s.path)
+	}
+
+	return fmt.Sprintf("oci:%s:%s", s.path, s.ref)
+}
+
+// Platform returns the platform bound through WithPlatform, or nil
+func (s *LayoutSource) Platform() *Platform {
+	return s.platform
+}
+
+// WithPlatform binds the platform used to select a manifest from an index
+func (s *LayoutSource) WithPlatform(p *Platform) {
+	s.platform = p
+}
+
+// Manifest returns the manifest of the image. If the image is an index, the
+// manifest of the bound platform is used, or the one of the host if there
+// is none.
+func (s *LayoutSource) Manifest() (*Manifest, error) {
+	return s.manifest(s.digest, s.platform, s.String())
+}
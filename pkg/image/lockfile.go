@@ -0,0 +1,111 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LockFile pins image references to the digests they resolved to, like the
+// lockfiles of package managers
+type LockFile struct {
+	Images []*LockedImage `json:"images"`
+}
+
+// LockedImage is an image reference pinned to a digest. For multi-arch
+// images, the digest is the one of the manifest list, the digests of the
+// manifests are recorded by platform.
+type LockedImage struct {
+	Image     string            `json:"image"`
+	Digest    string            `json:"digest"`
+	Platforms map[string]string `json:"platforms,omitempty"`
+}
+
+// LoadLockFile reads the lockfile at the given path
+func LoadLockFile(path string) (*LockFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LockFile{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return l, nil
+}
+
+// Save writes the lockfile to the given path
+func (l *LockFile) Save(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Lookup returns the pinned image of the given name, or nil if the name is not
+// part of the lockfile. Names are compared in their normalized form, without
+// digest.
+func (l *LockFile) Lookup(name string) *LockedImage {
+	url, err := Parse(name)
+	if err != nil {
+		return nil
+	}
+
+	url.Digest = ""
+
+	for _, img := range l.Images {
+		u, err := Parse(img.Image)
+		if err != nil {
+			continue
+		}
+
+		u.Digest = ""
+		if u.String() == url.String() {
+			return img
+		}
+	}
+
+	return nil
+}
+
+// Pin returns the given name, pinned to the digest of the locked image
+func (img *LockedImage) Pin(name string) string {
+	name, _, _ = strings.Cut(strings.Trim(name, " \n\t"), "@")
+	return name + "@" + img.Digest
+}
+
+// LockImage resolves the reference of the remote to its digest and the
+// digests of all its platforms. The image is recorded under the given name.
+func LockImage(name string, r *Remote) (*LockedImage, error) {
+	accept := append(append([]string{}, manifestListMimeTypes...), manifestMimeTypes...)
+
+	digest, err := r.referenceDigest(strings.Join(accept, ", "))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+
+	img := &LockedImage{
+		Image:  name,
+		Digest: digest,
+	}
+
+	lst, err := r.ManifestList()
+	if err != nil {
+		return nil, err
+	}
+
+	if lst != nil && len(lst.Manifests) > 0 {
+		img.Platforms = make(map[string]string, len(lst.Manifests))
+
+		for _, m := range lst.Manifests {
+			img.Platforms[m.Platform.String()] = m.Digest
+		}
+	}
+
+	return img, nil
+}
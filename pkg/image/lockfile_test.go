@@ -0,0 +1,56 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLockFile tests locking an image and looking it up again
+func TestLockFile(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	server := mockServer()
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "ubuntu",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	img, err := LockImage("ubuntu", remote)
+	assert.NoError(t, err, "error locking image")
+	assert.Equal(t, "ubuntu", img.Image)
+	assert.Equal(t, "foobar", img.Digest)
+	assert.Equal(t, map[string]string{"linux/amd64": "foobar"}, img.Platforms)
+
+	dir, err := os.MkdirTemp("", "lockfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "roots.lock")
+	assert.NoError(t, (&LockFile{Images: []*LockedImage{img}}).Save(path))
+
+	lock, err := LoadLockFile(path)
+	assert.NoError(t, err, "error loading lockfile")
+	assert.Equal(t, []*LockedImage{img}, lock.Images)
+
+	// names are normalized, digests are ignored
+	assert.Equal(t, img, lock.Lookup("docker.io/library/ubuntu:latest"))
+	assert.Equal(t, img, lock.Lookup("ubuntu@sha256:old"))
+	assert.Nil(t, lock.Lookup("ubuntu:22.04"))
+	assert.Nil(t, lock.Lookup("debian"))
+
+	assert.Equal(t, "ubuntu@foobar", img.Pin("ubuntu"))
+	assert.Equal(t, "ubuntu@foobar", img.Pin("ubuntu@sha256:old"))
+}
@@ -0,0 +1,20 @@
+package image
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Logger receives the progress of downloads and extractions: the start and
+// end of downloads, cache hits and misses and the statistics of extractions
+// are logged at debug level, retried requests as warnings. The events are
+// discarded by default.
+var Logger = slog.New(discardHandler{})
+
+// discardHandler is a slog handler which drops all records
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -1,6 +1,11 @@
 package image
 
-import "fmt"
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
 
 var (
 	// ManifestListMimeType is the mime type used to get the manifest list
@@ -8,13 +13,65 @@ var (
 
 	// ManifestMimeType is the mime type used to get the manifest
 	ManifestMimeType = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// OCIIndexMimeType is the mime type of the OCI image index, which is
+	// the OCI equivalent of the manifest list
+	OCIIndexMimeType = "application/vnd.oci.image.index.v1+json"
+
+	// OCIManifestMimeType is the mime type of the OCI image manifest
+	OCIManifestMimeType = "application/vnd.oci.image.manifest.v1+json"
+
+	// ImageConfigMimeTypes are the config mime types of container images,
+	// manifests with other config types are considered artifacts
+	ImageConfigMimeTypes = []string{
+		"application/vnd.docker.container.image.v1+json",
+		"application/vnd.oci.image.config.v1+json",
+	}
+
+	// ForeignLayerMimeTypes are the mime types of layers which registries
+	// may not store, they are downloaded from the urls of the layer instead
+	ForeignLayerMimeTypes = []string{
+		"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+		"application/vnd.oci.image.layer.nondistributable.v1.tar",
+		"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+		"application/vnd.oci.image.layer.nondistributable.v1.tar+zstd",
+	}
+
+	// TitleAnnotation holds the file name of a layer in artifacts
+	TitleAnnotation = "org.opencontainers.image.title"
+
+	// CreatedAnnotation holds the creation time of an image (RFC 3339)
+	CreatedAnnotation = "org.opencontainers.image.created"
 )
 
+// manifestListMimeTypes are the accepted mime types for manifest lists
+var manifestListMimeTypes = []string{ManifestListMimeType, OCIIndexMimeType}
+
+// manifestMimeTypes are the accepted mime types for manifests
+var manifestMimeTypes = []string{ManifestMimeType, OCIManifestMimeType}
+
+// isMimeType returns true if the given content type is one of the mime types,
+// ignoring any parameters
+func isMimeType(contentType string, mimeTypes ...string) bool {
+	mime, _, _ := strings.Cut(contentType, ";")
+	mime = strings.TrimSpace(mime)
+
+	for _, m := range mimeTypes {
+		if m == mime {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ManifestList represents the Docker Manifest List:
 // * https://github.com/docker/distribution/blob/master/docs/spec/manifest-v2-2.md
 // * application/vnd.docker.distribution.manifest.list.v2+json
 type ManifestList struct {
-	Manifests []PlatformManifest `json:"manifests"`
+	SchemaVersion int                `json:"schemaVersion,omitempty"`
+	MediaType     string             `json:"mediaType,omitempty"`
+	Manifests     []PlatformManifest `json:"manifests"`
 }
 
 // PlatformManifest represents an entry in a Manifest List
@@ -27,25 +84,160 @@ type PlatformManifest struct {
 type Platform struct {
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
 }
 
 func (p *Platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+
 	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
 }
 
+// ParsePlatform parses a platform given as OS/ARCH or OS/ARCH/VARIANT, e.g.
+// linux/amd64 or linux/arm/v7
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid platform %s, expected os/arch[/variant]", s)
+	}
+
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+// Matches returns true if the platform is the wanted platform. The variants
+// are only compared if both platforms have one.
+func (p *Platform) Matches(wanted *Platform) bool {
+	if p.OS != wanted.OS || p.Architecture != wanted.Architecture {
+		return false
+	}
+
+	return p.Variant == "" || wanted.Variant == "" || p.Variant == wanted.Variant
+}
+
+// HostPlatform returns the platform of the images that run on this host
+func HostPlatform() *Platform {
+	return &Platform{Architecture: runtime.GOARCH, OS: "linux", Variant: hostVariant()}
+}
+
+// hostVariant returns the CPU variant of the host on ARM, which is derived
+// from the GOARM setting roots was built with (v7 if unknown)
+func hostVariant() string {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "v8"
+	case "arm":
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "GOARM" && s.Value != "" {
+					version, _, _ := strings.Cut(s.Value, ",")
+					return "v" + version
+				}
+			}
+		}
+
+		return "v7"
+	}
+
+	return ""
+}
+
+// defaultVariant returns the variant preferred for the given architecture if
+// none is given, which is the one of the host for its architecture, or the
+// most common one otherwise (v7 for arm, v8 for arm64)
+func defaultVariant(arch string) string {
+	if arch == runtime.GOARCH {
+		return hostVariant()
+	}
+
+	switch arch {
+	case "arm":
+		return "v7"
+	case "arm64":
+		return "v8"
+	}
+
+	return ""
+}
+
+// Select returns the manifest for the given platform, or nil if there is
+// none. Manifests with the exact variant are preferred over manifests that
+// have no variant. Without variant, the default variant of the architecture
+// is preferred over the others.
+func (l *ManifestList) Select(p *Platform) *PlatformManifest {
+	var selected *PlatformManifest
+
+	variant := p.Variant
+	if variant == "" {
+		variant = defaultVariant(p.Architecture)
+	}
+
+	for i := range l.Manifests {
+		m := &l.Manifests[i]
+
+		if !m.Platform.Matches(p) {
+			continue
+		}
+
+		if variant == "" || m.Platform.Variant == variant {
+			return m
+		}
+
+		if selected == nil {
+			selected = m
+		}
+	}
+
+	return selected
+}
+
 // Manifest represents a Docker Image Manifest
 // * https://github.com/docker/distribution/blob/master/docs/spec/manifest-v2-2.md
 // * application/vnd.docker.distribution.manifest.v2+json
 type Manifest struct {
-	Digest        string
-	SchemaVersion int             `json:"schemaVersion"`
-	MediaType     string          `json:"mediaType"`
-	Layers        []ManifestLayer `json:"layers"`
+	Digest        string            `json:"-"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ManifestLayer     `json:"config"`
+	Layers        []ManifestLayer   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// IsArtifact returns true if the manifest describes an OCI artifact (e.g. a
+// Helm chart) instead of a container image
+func (m *Manifest) IsArtifact() bool {
+	return m.ArtifactType != "" || (m.Config.MediaType != "" && !isMimeType(m.Config.MediaType, ImageConfigMimeTypes...))
+}
+
+// Type returns the artifact type of the manifest, which is the config mime
+// type if no explicit artifact type is set
+func (m *Manifest) Type() string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
+	}
+
+	return m.Config.MediaType
 }
 
 // ManifestLayer represents a Docker Image Layer
 type ManifestLayer struct {
-	MediaType string `json:"mediaType"`
-	Size      int    `json:"size"`
-	Digest    string `json:"digest"`
+	MediaType   string            `json:"mediaType"`
+	Size        int               `json:"size"`
+	Digest      string            `json:"digest"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// IsForeign returns true if the layer is not distributed by the registry,
+// but downloaded from its urls (e.g. Windows base layers)
+func (l *ManifestLayer) IsForeign() bool {
+	return isMimeType(l.MediaType, ForeignLayerMimeTypes...)
 }
@@ -0,0 +1,86 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsArtifact tests the detection of artifact manifests
+func TestIsArtifact(t *testing.T) {
+	image := &Manifest{Config: ManifestLayer{MediaType: "application/vnd.oci.image.config.v1+json"}}
+	assert.False(t, image.IsArtifact())
+
+	helm := &Manifest{Config: ManifestLayer{MediaType: "application/vnd.cncf.helm.config.v1+json"}}
+	assert.True(t, helm.IsArtifact())
+	assert.Equal(t, "application/vnd.cncf.helm.config.v1+json", helm.Type())
+
+	oras := &Manifest{
+		ArtifactType: "application/vnd.example+type",
+		Config:       ManifestLayer{MediaType: "application/vnd.oci.empty.v1+json"},
+	}
+	assert.True(t, oras.IsArtifact())
+	assert.Equal(t, "application/vnd.example+type", oras.Type())
+}
+
+// TestArtifactFileName tests that artifact blobs get safe file names
+func TestArtifactFileName(t *testing.T) {
+	layer := func(title string) ManifestLayer {
+		return ManifestLayer{
+			Digest:      "sha256:abc",
+			Annotations: map[string]string{TitleAnnotation: title},
+		}
+	}
+
+	assert.Equal(t, "chart.tgz", artifactFileName(layer("chart.tgz")))
+	assert.Equal(t, "sha256-abc", artifactFileName(layer("")))
+	assert.Equal(t, "sha256-abc", artifactFileName(layer("../passwd")))
+	assert.Equal(t, "sha256-abc", artifactFileName(layer("..")))
+}
+
+// TestSelectPlatform tests the selection of manifests by platform and variant
+func TestSelectPlatform(t *testing.T) {
+	lst := &ManifestList{Manifests: []PlatformManifest{
+		{ManifestLayer: &ManifestLayer{Digest: "amd64"}, Platform: Platform{OS: "linux", Architecture: "amd64"}},
+		{ManifestLayer: &ManifestLayer{Digest: "armv6"}, Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{ManifestLayer: &ManifestLayer{Digest: "arm"}, Platform: Platform{OS: "linux", Architecture: "arm"}},
+		{ManifestLayer: &ManifestLayer{Digest: "armv7"}, Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+	}}
+
+	digest := func(p *Platform) string {
+		if m := lst.Select(p); m != nil {
+			return m.Digest
+		}
+
+		return ""
+	}
+
+	assert.Equal(t, "amd64", digest(&Platform{OS: "linux", Architecture: "amd64", Variant: "v2"}))
+	assert.Equal(t, "armv7", digest(&Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+	assert.Equal(t, "armv6", digest(&Platform{OS: "linux", Architecture: "arm", Variant: "v6"}))
+	assert.Equal(t, "armv7", digest(&Platform{OS: "linux", Architecture: "arm"}))
+	assert.Equal(t, "arm", digest(&Platform{OS: "linux", Architecture: "arm", Variant: "v5"}))
+	assert.Equal(t, "", digest(&Platform{OS: "windows", Architecture: "amd64"}))
+
+	assert.Equal(t, "linux/arm/v7", lst.Manifests[3].Platform.String())
+
+	// without the default variant, the first variant is taken
+	lst.Manifests = lst.Manifests[:3]
+	assert.Equal(t, "armv6", digest(&Platform{OS: "linux", Architecture: "arm"}))
+}
+
+// TestParsePlatform tests parsing platforms given on the command line
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm/v7")
+	assert.NoError(t, err)
+	assert.Equal(t, &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, p)
+
+	p, err = ParsePlatform("linux/amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, "linux/amd64", p.String())
+
+	for _, s := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/x"} {
+		_, err := ParsePlatform(s)
+		assert.Error(t, err, s)
+	}
+}
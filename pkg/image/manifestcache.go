@@ -0,0 +1,185 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestCache keeps the manifest and index responses of registries with
+// their ETags, so that they are revalidated with conditional requests instead
+// of being transferred again. Manifests requested by digest cannot change and
+// are served from the cache without any request.
+type ManifestCache struct {
+	Path string
+}
+
+// NewManifestCache returns a manifest cache in the given folder, which is
+// created once the first response is stored
+func NewManifestCache(folder string) *ManifestCache {
+	return &ManifestCache{Path: folder}
+}
+
+// cachedManifest is a response stored in the manifest cache
+type cachedManifest struct {
+	ETag        string `json:"etag"`
+	ContentType string `json:"content_type"`
+	Digest      string `json:"digest"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// isManifestRequest returns true if the request fetches a manifest or index
+func isManifestRequest(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	return path.Base(path.Dir(req.URL.Path)) == "manifests"
+}
+
+// do sends the given request, conditionally if a response has been stored
+func (c *ManifestCache) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	key := c.key(req)
+	cached := c.load(key)
+
+	if cached != nil && cached.immutable(req) {
+		return cached.response(req), nil
+	}
+
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %w", req.URL, err)
+	}
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		res.Body.Close()
+		return cached.response(req), nil
+	}
+
+	if res.StatusCode != 200 {
+		defer res.Body.Close()
+		return nil, NewRequestError(res)
+	}
+
+	// responses without ETag cannot be revalidated, except by digest
+	if res.Header.Get("ETag") == "" && !strings.HasPrefix(path.Base(req.URL.Path), "sha256:") {
+		return res, nil
+	}
+
+	entry := &cachedManifest{
+		ETag:        res.Header.Get("ETag"),
+		ContentType: res.Header.Get("Content-Type"),
+		Digest:      res.Header.Get("Docker-Content-Digest"),
+	}
+
+	if req.Method == "GET" {
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %v", err)
+		}
+
+		entry.Body = body
+		res.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// the cache is merely an optimization, failing to write it is no error
+	_ = c.save(key, entry)
+
+	return res, nil
+}
+
+// key returns the name of the file storing the response to the request
+func (c *ManifestCache) key(req *http.Request) string {
+	id := strings.Join([]string{req.Method, req.URL.String(), req.Header.Get("Accept")}, "\n")
+	return fmt.Sprintf("%x.json", sha256.Sum256([]byte(id)))
+}
+
+func (c *ManifestCache) load(key string) *cachedManifest {
+	data, err := os.ReadFile(filepath.Join(c.Path, key))
+	if err != nil {
+		return nil
+	}
+
+	entry := &cachedManifest{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+func (c *ManifestCache) save(key string, entry *cachedManifest) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Path, 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, as others might read the cache
+	f, err := os.CreateTemp(c.Path, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(c.Path, key))
+}
+
+// immutable returns true if the request is for the manifest with the digest
+// of the stored body, which cannot change
+func (m *cachedManifest) immutable(req *http.Request) bool {
+	if req.Method != "GET" || m.Body == nil {
+		return false
+	}
+
+	return path.Base(req.URL.Path) == fmt.Sprintf("sha256:%x", sha256.Sum256(m.Body))
+}
+
+// response returns the stored response for the given request
+func (m *cachedManifest) response(req *http.Request) *http.Response {
+	header := make(http.Header)
+
+	for name, value := range map[string]string{
+		"Content-Type":          m.ContentType,
+		"Docker-Content-Digest": m.Digest,
+		"ETag":                  m.ETag,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(m.Body)),
+		ContentLength: int64(len(m.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestManifestCache tests that cached manifests are revalidated using their
+// ETag and that manifests referenced by digest are not requested again
+func TestManifestCache(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "layers": []}`, ManifestMimeType))
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	etag := `"v1"`
+
+	requests, transfers := 0, 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/manifests/latest", "/v2/library/busybox/manifests/" + digest:
+			requests++
+
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			transfers++
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Type", ManifestMimeType)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Write(manifest)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: server.URL, Repository: "library", Name: "busybox", Tag: "latest"}
+	cache := NewManifestCache(t.TempDir())
+
+	for i := 0; i < 3; i++ {
+		remote, err := NewRepository(context.Background(), url, "")
+		assert.NoError(t, err)
+		remote.WithManifestCache(cache)
+
+		m, err := remote.Manifest()
+		assert.NoError(t, err)
+		assert.Equal(t, digest, m.Digest)
+	}
+
+	// the list, the digest and the manifest by digest are requested first,
+	// then the list and the digest are revalidated
+	assert.Equal(t, 3, transfers)
+	assert.Equal(t, 7, requests)
+
+	// a changed manifest is transferred again
+	etag = `"v2"`
+
+	remote, err := NewRepository(context.Background(), url, "")
+	assert.NoError(t, err)
+	remote.WithManifestCache(cache)
+
+	d, err := remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, digest, d)
+	assert.Equal(t, 5, transfers)
+}
@@ -0,0 +1,118 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// OverlayLowerDirPath returns the path of the file holding the lowerdir
+// option of an image extracted with ExtractOptions.Overlay
+func OverlayLowerDirPath(dst string) string {
+	return filepath.Join(dst, "lowerdir")
+}
+
+// overlayLayers extracts each layer into its own directory of the target
+// (l1, l2, ... from the lowest to the highest), keeping the whiteouts in the
+// format of overlayfs, so the layers can be mounted instead of flattened
+type overlayLayers struct {
+	x *extraction
+
+	// dst is the absolute path of the destination, which the lowerdir
+	// option refers to (the target differs from it while updating)
+	dst  string
+	dirs []string
+}
+
+func newOverlayLayers(x *extraction, dst string) (*overlayLayers, error) {
+	if x.opts.Merge {
+		return nil, errors.New("overlay layouts cannot be merged")
+	}
+
+	abs, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return &overlayLayers{x: x, dst: abs}, nil
+}
+
+// ApplyLayer extracts the layer into the next layer directory
+func (o *overlayLayers) ApplyLayer(ctx context.Context, layer *LayerStream, target string) error {
+	name := fmt.Sprintf("l%d", len(o.dirs)+1)
+	dir := filepath.Join(target, name)
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", dir, err)
+	}
+
+	// the layers share the result and the exploded layer of the extraction
+	lx := newExtraction(dir, o.x.opts)
+	lx.result = o.x.result
+	lx.exploded = o.x.exploded
+	lx.overlay = true
+
+	if err := untarLayer(ctx, layer, lx); err != nil {
+		return err
+	}
+
+	if err := setDirectoryPermissions(lx.dirmodes); err != nil {
+		return fmt.Errorf("error setting directory permissions: %v", err)
+	}
+
+	o.dirs = append(o.dirs, name)
+
+	return nil
+}
+
+// Finish writes the lowerdir option, which lists the highest layer first
+func (o *overlayLayers) Finish(ctx context.Context, target string) error {
+	lower := make([]string, len(o.dirs))
+	for i, name := range o.dirs {
+		lower[len(o.dirs)-1-i] = filepath.Join(o.dst, name)
+	}
+
+	file := OverlayLowerDirPath(target)
+
+	if err := os.WriteFile(file, []byte(strings.Join(lower, ":")+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", file, err)
+	}
+
+	return nil
+}
+
+// overlayOpaqueXattr marks directories hiding the content of lower layers
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// extractOverlayWhiteout converts the given whiteout to the format of
+// overlayfs: a 0/0 character device hides a path of the lower layers and
+// the opaque xattr hides the content of a directory. Both usually require
+// root privileges.
+func (x *extraction) extractOverlayWhiteout(h *tar.Header) error {
+	dir := filepath.Join(x.dst, path.Dir(h.Name))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", dir, err)
+	}
+
+	if strings.HasSuffix(h.Name, ".wh..wh..opq") {
+		if err := setxattr(dir, overlayOpaqueXattr, []byte("y")); err != nil {
+			return fmt.Errorf("error marking %s as opaque: %v", dir, err)
+		}
+
+		return nil
+	}
+
+	file := filepath.Join(dir, path.Base(h.Name)[4:])
+
+	if err := mknod(file, &tar.Header{Name: h.Name, Typeflag: tar.TypeChar}); err != nil {
+		return fmt.Errorf("error creating whiteout %s: %v", file, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// TestOverlayLayout tests that each layer is extracted into its own
+// directory, with the whiteouts in the format of overlayfs
+func TestOverlayLayout(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("overlayfs whiteouts require root")
+	}
+
+	files := map[string][]byte{
+		"etc/passwd":           []byte("root"),
+		"etc/motd":             []byte("hello"),
+		"var/log/old":          []byte("old"),
+		"etc/.wh.motd":         {},
+		"var/log/.wh..wh..opq": {},
+		"var/log/new":          []byte("new"),
+	}
+
+	source := newLayeredSource(
+		tarball(t, files, "etc/passwd", "etc/motd", "var/log/old"),
+		tarball(t, files, "etc/.wh.motd", "var/log/.wh..wh..opq", "var/log/new"),
+	)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+
+	result, err := store.Extract(context.Background(), source, dst, &ExtractOptions{Overlay: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Layers)
+
+	// the lower layer is untouched
+	assert.FileExists(t, filepath.Join(dst, "l1/etc/motd"))
+	assert.FileExists(t, filepath.Join(dst, "l1/var/log/old"))
+
+	// the upper layer holds the whiteouts
+	info, err := os.Lstat(filepath.Join(dst, "l2/etc/motd"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeDevice|os.ModeCharDevice, info.Mode().Type())
+
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(filepath.Join(dst, "l2/var/log"), overlayOpaqueXattr, value)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", string(value[:n]))
+	assert.FileExists(t, filepath.Join(dst, "l2/var/log/new"))
+
+	lowerdir, err := os.ReadFile(OverlayLowerDirPath(dst))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dst, "l2")+":"+filepath.Join(dst, "l1")+"\n", string(lowerdir))
+
+	// overlay layouts cannot be merged
+	_, err = store.Extract(context.Background(), source, dst, &ExtractOptions{Overlay: true, Merge: true})
+	assert.ErrorContains(t, err, "cannot be merged")
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package image
+
+import "io/fs"
+
+// fileOwner is not supported outside of Unix, all files belong to root
+func fileOwner(info fs.FileInfo) (int, int) {
+	return 0, 0
+}
+
+// fileIdentity is not supported outside of Unix
+func fileIdentity(info fs.FileInfo) (uint64, uint64, bool) {
+	return 0, 0, false
+}
+
+// fileLinks is not supported outside of Unix, all files have a single link
+func fileLinks(info fs.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package image
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of the given file
+func fileOwner(info fs.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+
+	return 0, 0
+}
+
+// fileIdentity returns the device and the inode of the given file
+func fileIdentity(info fs.FileInfo) (uint64, uint64, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), uint64(stat.Ino), true
+	}
+
+	return 0, 0, false
+}
+
+// fileLinks returns the number of hardlinks of the given file
+func fileLinks(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+
+	return 1
+}
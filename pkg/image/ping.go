@@ -0,0 +1,138 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PingStep is a single step of a ping, the latency is in seconds
+type PingStep struct {
+	Name    string  `json:"name"`
+	URL     string  `json:"url,omitempty"`
+	Status  string  `json:"status,omitempty"`
+	Latency float64 `json:"latency"`
+	Error   string  `json:"error,omitempty"`
+
+	start time.Time
+}
+
+// PingResult describes the steps taken to reach a registry
+type PingResult struct {
+	Host string `json:"host"`
+
+	// Challenge is the WWW-Authenticate header returned by the registry to
+	// unauthenticated clients, if any
+	Challenge string `json:"challenge,omitempty"`
+
+	// Provider is the name of the provider used to authenticate
+	Provider string `json:"provider,omitempty"`
+
+	Steps []*PingStep `json:"steps"`
+}
+
+// Ping checks if the registry of the given URL can be reached (GET /v2/). If
+// the URL names an image, the provider authenticates (e.g. by exchanging a
+// token) and the manifest is requested (HEAD). The first failing step ends
+// the ping, its error is returned together with the steps taken so far.
+func Ping(ctx context.Context, url URL, auth string) (*PingResult, error) {
+	url.Host = normalizeHost(url.Host)
+	result := &PingResult{Host: url.Host}
+
+	// the api version check does not require authentication
+	endpoint := fmt.Sprintf("%s/v2/", url.base())
+	step := result.step("connect", endpoint)
+
+	res, err := pingRequest(ctx, HTTPClient(), "GET", endpoint, "")
+	step.done(res, err)
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Challenge = res.Header.Get("WWW-Authenticate")
+
+	if res.StatusCode != 200 && res.StatusCode != 401 {
+		err = NewRequestError(res)
+		step.Error = err.Error()
+		return result, err
+	}
+
+	if url.Name == "" {
+		return result, nil
+	}
+
+	// authenticate using the provider, which may request a token
+	provider, err := LookupProvider(url)
+	if err != nil {
+		return result, err
+	}
+
+	result.Provider = ProviderName(provider)
+	step = result.step("authenticate", "")
+
+	client, err := provider.GetClient(url, auth)
+	step.done(nil, err)
+
+	if err != nil {
+		return result, err
+	}
+
+	// check that the image can be accessed
+	endpoint = url.Endpoint("manifests", url.Reference())
+	step = result.step("manifest", endpoint)
+
+	res, err = pingRequest(ctx, client, "HEAD", endpoint, fmt.Sprintf("%s, %s",
+		strings.Join(manifestMimeTypes, ", "),
+		strings.Join(manifestListMimeTypes, ", ")))
+
+	if err == nil && res.StatusCode != 200 {
+		err = NewRequestError(res)
+	}
+
+	step.done(res, err)
+	return result, err
+}
+
+// step starts a new step of the ping
+func (p *PingResult) step(name string, url string) *PingStep {
+	s := &PingStep{Name: name, URL: url, start: time.Now()}
+	p.Steps = append(p.Steps, s)
+
+	return s
+}
+
+// done records the latency and the outcome of the step
+func (s *PingStep) done(res *http.Response, err error) {
+	s.Latency = time.Since(s.start).Seconds()
+
+	if res != nil {
+		s.Status = res.Status
+	}
+
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// pingRequest sends a request without body and discards the response body
+func pingRequest(ctx context.Context, client *http.Client, method string, url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+
+	res.Body.Close()
+	return res, nil
+}
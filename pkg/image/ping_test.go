@@ -0,0 +1,60 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/dankinder/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestPing tests the connectivity checks against a mock registry
+func TestPing(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	challenge := make(http.Header)
+	challenge.Add("WWW-Authenticate", `Bearer realm="https://auth.example.org/token"`)
+
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "GET", "/v2/", mock.Anything).Return(httpmock.Response{
+		Status: 401,
+		Header: challenge,
+	})
+	downstream.On("Handle", "HEAD", "/v2/library/ubuntu/manifests/latest", mock.Anything).Return(httpmock.Response{})
+	downstream.On("Handle", "HEAD", "/v2/library/missing/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	// only the host
+	result, err := Ping(context.Background(), URL{Host: server.URL()}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, `Bearer realm="https://auth.example.org/token"`, result.Challenge)
+	assert.Len(t, result.Steps, 1)
+	assert.Equal(t, "401 Unauthorized", result.Steps[0].Status)
+
+	// an existing image
+	url := URL{Host: server.URL(), Repository: "library", Name: "ubuntu", Tag: "latest"}
+
+	result, err = Ping(context.Background(), url, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "mock", result.Provider)
+	assert.Len(t, result.Steps, 3)
+	assert.Equal(t, "manifest", result.Steps[2].Name)
+	assert.Equal(t, "200 OK", result.Steps[2].Status)
+
+	// a missing image
+	url.Name = "missing"
+
+	result, err = Ping(context.Background(), url, "")
+	assert.Error(t, err)
+	assert.Len(t, result.Steps, 3)
+	assert.Equal(t, "404 Not Found", result.Steps[2].Status)
+	assert.NotEmpty(t, result.Steps[2].Error)
+}
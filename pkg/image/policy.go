@@ -0,0 +1,164 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Policy is a trust policy in the format of containers-policy.json(5), as
+// used by podman and skopeo. See:
+// https://github.com/containers/image/blob/main/docs/containers-policy.json.5.md
+//
+// Roots can not verify signatures of the signedBy and sigstoreSigned kind
+// yet, images which require them are rejected.
+type Policy struct {
+	Default    []PolicyRequirement                       `json:"default"`
+	Transports map[string]map[string][]PolicyRequirement `json:"transports"`
+}
+
+// PolicyRequirement is a single requirement of a policy, only the type is
+// evaluated by roots
+type PolicyRequirement struct {
+	Type string `json:"type"`
+}
+
+// policyTypes are the requirement types known to containers-policy.json
+var policyTypes = map[string]bool{
+	"insecureAcceptAnything": true,
+	"reject":                 true,
+	"signedBy":               true,
+	"sigstoreSigned":         true,
+}
+
+// LoadPolicy reads the policy at the given path
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return p, nil
+}
+
+// ParsePolicy parses the given policy and ensures it is valid
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := &Policy{}
+
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Default) == 0 {
+		return nil, fmt.Errorf("the policy requires a default")
+	}
+
+	requirements := [][]PolicyRequirement{p.Default}
+	for _, scopes := range p.Transports {
+		for _, r := range scopes {
+			requirements = append(requirements, r)
+		}
+	}
+
+	for _, list := range requirements {
+		for _, r := range list {
+			if !policyTypes[r.Type] {
+				return nil, fmt.Errorf("unknown requirement type: %s", r.Type)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Check returns an error if the policy does not allow pulling the given
+// image from its registry
+func (p *Policy) Check(url URL) error {
+	return p.check(url.String(), "docker", policyScopes(url))
+}
+
+// CheckTransport returns an error if the policy does not allow pulling the
+// given image through a transport without scopes (e.g. docker-daemon), in
+// which case only the transport default and the default apply
+func (p *Policy) CheckTransport(transport string, name string) error {
+	return p.check(fmt.Sprintf("%s:%s", transport, name), transport, nil)
+}
+
+// check returns an error if the requirements of the most specific scope are
+// not satisfied
+func (p *Policy) check(name string, transport string, scopes []string) error {
+	scope, requirements := p.requirements(transport, scopes)
+
+	// all requirements must be satisfied
+	for _, r := range requirements {
+		switch r.Type {
+		case "insecureAcceptAnything":
+			continue
+		case "reject":
+			return fmt.Errorf("%s is rejected by the policy (scope %s)", name, scope)
+		default:
+			return fmt.Errorf("%s requires %s, which is not supported (scope %s)", name, r.Type, scope)
+		}
+	}
+
+	return nil
+}
+
+// requirements returns the most specific scope matching the given scopes of
+// the transport and its requirements, falling back to the defaults
+func (p *Policy) requirements(transport string, scopes []string) (string, []PolicyRequirement) {
+	if t, ok := p.Transports[transport]; ok {
+		for _, scope := range scopes {
+			if r, ok := t[scope]; ok {
+				return scope, r
+			}
+		}
+
+		if r, ok := t[""]; ok {
+			return fmt.Sprintf("%s default", transport), r
+		}
+	}
+
+	return "default", p.Default
+}
+
+// policyScopes returns the scopes of the docker transport that match the
+// given url, from the most to the least specific
+func policyScopes(url URL) []string {
+	host := canonicalHost(url.Host)
+
+	repository := fmt.Sprintf("%s/%s/%s", host, url.Repository, url.Name)
+	if url.Repository == "" {
+		repository = fmt.Sprintf("%s/%s", host, url.Name)
+	}
+
+	scopes := []string{}
+
+	if url.Digest != "" {
+		scopes = append(scopes, fmt.Sprintf("%s@%s", repository, url.Digest))
+	} else {
+		scopes = append(scopes, fmt.Sprintf("%s:%s", repository, url.Tag))
+	}
+
+	// the repository and its namespaces, up to the host
+	for scope := repository; strings.Contains(scope, "/"); {
+		scopes = append(scopes, scope)
+		scope = scope[:strings.LastIndex(scope, "/")]
+	}
+
+	scopes = append(scopes, host)
+
+	// wildcards for the host's parent domains
+	parts := strings.Split(strings.Split(host, ":")[0], ".")
+	for i := 1; i < len(parts); i++ {
+		scopes = append(scopes, "*."+strings.Join(parts[i:], "."))
+	}
+
+	return scopes
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParse(t *testing.T, url string) URL {
+	u, err := Parse(url)
+	assert.NoError(t, err)
+	return *u
+}
+
+// TestPolicyScopes tests the scopes that are considered for an image
+func TestPolicyScopes(t *testing.T) {
+	assert.Equal(t, []string{
+		"docker.io/library/ubuntu:latest",
+		"docker.io/library/ubuntu",
+		"docker.io/library",
+		"docker.io",
+		"*.io",
+	}, policyScopes(mustParse(t, "ubuntu")))
+
+	assert.Equal(t, []string{
+		"registry.example.org/team/app@sha256:abc",
+		"registry.example.org/team/app",
+		"registry.example.org/team",
+		"registry.example.org",
+		"*.example.org",
+		"*.org",
+	}, policyScopes(mustParse(t, "registry.example.org/team/app@sha256:abc")))
+}
+
+// TestPolicyCheck tests that the most specific scope wins
+func TestPolicyCheck(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{
+		"default": [{"type": "reject"}],
+		"transports": {
+			"docker": {
+				"docker.io/library": [{"type": "insecureAcceptAnything"}],
+				"docker.io/library/debian:sid": [{"type": "reject"}],
+				"*.example.org": [{"type": "insecureAcceptAnything"}],
+				"quay.io": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "/key"}]
+			}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, policy.Check(mustParse(t, "debian:bookworm")))
+	assert.NoError(t, policy.Check(mustParse(t, "registry.example.org/team/app")))
+	assert.Error(t, policy.Check(mustParse(t, "debian:sid")))
+	assert.Error(t, policy.Check(mustParse(t, "gcr.io/team/app")))
+	assert.Error(t, policy.Check(mustParse(t, "quay.io/team/app")))
+}
+
+// TestPolicyCheckTransport tests the defaults of transports without scopes
+func TestPolicyCheckTransport(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{
+		"default": [{"type": "reject"}],
+		"transports": {"docker-daemon": {"": [{"type": "insecureAcceptAnything"}]}}
+	}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, policy.CheckTransport("docker-daemon", "app:1.0"))
+	assert.Error(t, policy.CheckTransport("containers-storage", "app:1.0"))
+}
+
+// TestParsePolicy tests that invalid policies are refused
+func TestParsePolicy(t *testing.T) {
+	_, err := ParsePolicy([]byte(`{"default": []}`))
+	assert.Error(t, err, "a default is required")
+
+	_, err = ParsePolicy([]byte(`{"default": [{"type": "maybe"}]}`))
+	assert.Error(t, err, "unknown types are refused")
+}
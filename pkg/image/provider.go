@@ -8,6 +8,7 @@ import (
 var (
 	registry = make(map[string]Provider)
 	priority = []string{}
+	fallback Provider
 )
 
 // Provider provides an authenticated client for a given URL.
@@ -30,6 +31,16 @@ type Provider interface {
 	Supports(url URL) bool
 }
 
+// PushProvider is implemented by providers which need a differently
+// authenticated client to push to a repository (e.g. a token with a push
+// scope). Providers not implementing it use the client of GetClient.
+type PushProvider interface {
+
+	// GetPushClient returns an net/http Client that is authenticated to
+	// push to the repository of the URL
+	GetPushClient(url URL, auth string) (*http.Client, error)
+}
+
 // LookupProvider takes an image.URL and returns the associated provider
 func LookupProvider(url URL) (Provider, error) {
 	for _, name := range priority {
@@ -40,9 +51,31 @@ func LookupProvider(url URL) (Provider, error) {
 		}
 	}
 
+	if fallback != nil && fallback.Supports(url) {
+		return fallback, nil
+	}
+
 	return nil, fmt.Errorf("no provider for %s", url)
 }
 
+// ProviderName returns the name the given provider was registered with, or
+// "fallback" for the fallback provider
+func ProviderName(provider Provider) string {
+	for name, p := range registry {
+		if p == provider {
+			return name
+		}
+	}
+
+	return "fallback"
+}
+
+// RegisterFallbackProvider registers a provider which is used if no other
+// provider supports a URL, regardless of the order of registration
+func RegisterFallbackProvider(provider Provider) {
+	fallback = provider
+}
+
 // RegisterProvider registers a provider with the given name. Providers are
 // meant to be registered once during initialization and doing so concurrently
 // is not safe. If a provider with the same name exists, it is overwritten.
@@ -55,4 +88,5 @@ func RegisterProvider(name string, provider Provider) {
 func ClearProviderRegistry() {
 	registry = make(map[string]Provider)
 	priority = []string{}
+	fallback = nil
 }
@@ -0,0 +1,550 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadChunkSize is the size of the chunks sent during blob uploads
+const uploadChunkSize = 16 * 1024 * 1024
+
+var (
+	// OCILayerMimeType is the mime type of gzip compressed OCI layers
+	OCILayerMimeType = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// OCIConfigMimeType is the mime type of OCI image configs
+	OCIConfigMimeType = "application/vnd.oci.image.config.v1+json"
+)
+
+// Pusher uploads blobs and manifests to the repository of an image
+type Pusher struct {
+	client    *http.Client
+	url       URL
+	ownership *TarOwnership
+	ctx       context.Context
+}
+
+// NewPusher returns a new pusher for the repository of the given URL
+func NewPusher(ctx context.Context, url URL, auth string) (*Pusher, error) {
+	provider, err := LookupProvider(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *http.Client
+
+	if pp, ok := provider.(PushProvider); ok {
+		client, err = pp.GetPushClient(url, auth)
+	} else {
+		client, err = provider.GetClient(url, auth)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pusher{
+		client: client,
+		url:    url,
+		ctx:    ctx,
+	}, nil
+}
+
+// WithOwnership overrides the owners recorded in the layers built by Push
+func (p *Pusher) WithOwnership(o *TarOwnership) {
+	p.ownership = o
+}
+
+func (p *Pusher) String() string {
+	return p.url.String()
+}
+
+// do sends the request and returns an error if the status is not expected
+func (p *Pusher) do(req *http.Request, expected ...int) (*http.Response, error) {
+	res, err := p.client.Do(req.WithContext(p.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %v", req.URL, err)
+	}
+
+	for _, status := range expected {
+		if res.StatusCode == status {
+			return res, nil
+		}
+	}
+
+	defer res.Body.Close()
+	return nil, NewRequestError(res)
+}
+
+// location resolves the Location header of an upload response
+func (p *Pusher) location(res *http.Response) (*neturl.URL, error) {
+	base, err := neturl.Parse(res.Request.URL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := neturl.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload location: %v", err)
+	}
+
+	return base.ResolveReference(loc), nil
+}
+
+// HasBlob returns true if the blob exists in the repository
+func (p *Pusher) HasBlob(digest string) (bool, error) {
+	req := mustNewRequest("HEAD", p.url.Endpoint("blobs", digest))
+
+	res, err := p.client.Do(req.WithContext(p.ctx))
+	if err != nil {
+		return false, fmt.Errorf("error requesting %s: %v", req.URL, err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode == 200, nil
+}
+
+// UploadBlob uploads a blob using the chunked upload API, unless the blob
+// exists in the repository already
+func (p *Pusher) UploadBlob(digest string, r io.Reader) error {
+
+	if exists, err := p.HasBlob(digest); err != nil || exists {
+		return err
+	}
+
+	// start the upload session
+	req := mustNewRequest("POST", p.url.Endpoint("blobs", "uploads", ""))
+	res, err := p.do(req, 202)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	loc, err := p.location(res)
+	if err != nil {
+		return err
+	}
+
+	// send the chunks, each response contains the location of the next one
+	buf := make([]byte, uploadChunkSize)
+	offset := 0
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("error reading %s: %v", digest, err)
+		}
+
+		req, err := http.NewRequest("PATCH", loc.String(), bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+n-1))
+		req.Header.Set("Content-Length", strconv.Itoa(n))
+
+		res, err := p.do(req, 202, 204)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if loc, err = p.location(res); err != nil {
+			return err
+		}
+
+		offset += n
+	}
+
+	// complete the upload
+	query := loc.Query()
+	query.Set("digest", digest)
+	loc.RawQuery = query.Encode()
+
+	res, err = p.do(mustNewRequest("PUT", loc.String()), 201, 204)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// PutManifest uploads a manifest under the given reference (a tag or a
+// digest) and returns the digest of the manifest
+func (p *Pusher) PutManifest(reference string, mediaType string, data []byte) (string, error) {
+	req, err := http.NewRequest("PUT", p.url.Endpoint("manifests", reference), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", mediaType)
+
+	res, err := p.do(req, 200, 201)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// GetManifest fetches the raw manifest (or manifest list) of the given
+// reference, together with its mime type
+func (p *Pusher) GetManifest(reference string) ([]byte, string, error) {
+	accept := append(append([]string{}, manifestMimeTypes...), manifestListMimeTypes...)
+
+	req := mustNewRequest("GET", p.url.Endpoint("manifests", reference))
+	req.Header.Set("Accept", strings.Join(accept, ", "))
+
+	res, err := p.do(req, 200)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	return data, res.Header.Get("Content-Type"), nil
+}
+
+// Tag stores the manifest of the URL's reference under the given tag in the
+// same repository, without transferring any blobs. The digest of the tagged
+// manifest is returned.
+func (p *Pusher) Tag(tag string) (string, error) {
+	data, mime, err := p.GetManifest(p.url.Reference())
+	if err != nil {
+		return "", fmt.Errorf("error fetching manifest: %v", err)
+	}
+
+	return p.PutManifest(tag, mime, data)
+}
+
+// Delete resolves the URL's reference to a digest and deletes the manifest
+// with that digest from the registry, returning the deleted digest. Note
+// that the registry has to permit deletions.
+func (p *Pusher) Delete() (string, error) {
+	digest := p.url.Digest
+
+	if digest == "" {
+		data, _, err := p.GetManifest(p.url.Tag)
+		if err != nil {
+			return "", fmt.Errorf("error resolving %s: %v", p.url.Tag, err)
+		}
+
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+
+	req := mustNewRequest("DELETE", p.url.Endpoint("manifests", digest))
+
+	res, err := p.client.Do(req.WithContext(p.ctx))
+	if err != nil {
+		return "", fmt.Errorf("error requesting %s: %v", req.URL, err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 200, 202:
+		return digest, nil
+	case 405:
+		return "", fmt.Errorf("%s does not permit deletions", p.url.Host)
+	default:
+		return "", fmt.Errorf("DELETE %s failed with %s", req.URL, res.Status)
+	}
+}
+
+// Push builds a single-layer image from a directory or a tarball (optionally
+// gzip compressed) and pushes it under the tag of the URL. The digest of the
+// pushed manifest is returned.
+func (p *Pusher) Push(src string, platform Platform) (string, error) {
+	descriptor, err := p.pushImage(src, platform, true)
+	if err != nil {
+		return "", err
+	}
+
+	return descriptor.Digest, nil
+}
+
+// PlatformSource is a directory or tarball pushed as image of a platform
+type PlatformSource struct {
+	Platform Platform
+	Source   string
+}
+
+// PushIndex builds an image for each of the given platforms and pushes them
+// by digest, together with an OCI index referencing them under the tag of
+// the URL. The digest of the pushed index is returned.
+func (p *Pusher) PushIndex(sources []PlatformSource) (string, error) {
+	index := &ManifestList{
+		SchemaVersion: 2,
+		MediaType:     OCIIndexMimeType,
+		Manifests:     make([]PlatformManifest, 0, len(sources)),
+	}
+
+	for _, s := range sources {
+		for _, m := range index.Manifests {
+			if m.Platform == s.Platform {
+				return "", fmt.Errorf("platform %s given more than once", &s.Platform)
+			}
+		}
+
+		descriptor, err := p.pushImage(s.Source, s.Platform, false)
+		if err != nil {
+			return "", fmt.Errorf("error pushing %s: %v", &s.Platform, err)
+		}
+
+		index.Manifests = append(index.Manifests, PlatformManifest{
+			ManifestLayer: descriptor,
+			Platform:      s.Platform,
+		})
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := p.PutManifest(p.url.Tag, OCIIndexMimeType, data)
+	if err != nil {
+		return "", fmt.Errorf("error uploading index: %v", err)
+	}
+
+	return digest, nil
+}
+
+// pushImage pushes the image and returns the descriptor of its manifest,
+// which is stored under the tag of the URL or, if not tagged, its digest
+func (p *Pusher) pushImage(src string, platform Platform, tagged bool) (*ManifestLayer, error) {
+	layer, err := buildLayer(src, p.ownership)
+	if err != nil {
+		return nil, err
+	}
+	defer layer.Close()
+
+	if err := p.UploadBlob(layer.Digest, layer.file); err != nil {
+		return nil, fmt.Errorf("error uploading layer: %v", err)
+	}
+
+	now := time.Now().UTC()
+	config, err := json.Marshal(&ImageConfig{
+		Created:      &now,
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Variant:      platform.Variant,
+		RootFS:       RootFS{Type: "layers", DiffIDs: []string{layer.DiffID}},
+		History:      []History{{Created: &now, CreatedBy: "roots push"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	if err := p.UploadBlob(configDigest, bytes.NewReader(config)); err != nil {
+		return nil, fmt.Errorf("error uploading config: %v", err)
+	}
+
+	manifest, err := json.Marshal(&Manifest{
+		SchemaVersion: 2,
+		MediaType:     OCIManifestMimeType,
+		Config: ManifestLayer{
+			MediaType: OCIConfigMimeType,
+			Digest:    configDigest,
+			Size:      len(config),
+		},
+		Layers: []ManifestLayer{{
+			MediaType: OCILayerMimeType,
+			Digest:    layer.Digest,
+			Size:      int(layer.Size),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reference := p.url.Tag
+	if !tagged {
+		reference = fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	}
+
+	digest, err := p.PutManifest(reference, OCIManifestMimeType, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading manifest: %v", err)
+	}
+
+	return &ManifestLayer{
+		MediaType: OCIManifestMimeType,
+		Digest:    digest,
+		Size:      len(manifest),
+	}, nil
+}
+
+// builtLayer is a gzip compressed layer in a temporary file
+type builtLayer struct {
+	file   *os.File
+	Digest string
+	DiffID string
+	Size   int64
+}
+
+// Close removes the temporary file of the layer
+func (l *builtLayer) Close() error {
+	l.file.Close()
+	return os.Remove(l.file.Name())
+}
+
+// buildLayer creates a gzip compressed layer from a directory or a tarball,
+// with the owners overridden as given (if not nil)
+func buildLayer(src string, ownership *TarOwnership) (*builtLayer, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(tempDir(), "roots-layer")
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &builtLayer{file: f}
+	digest, diffID := sha256.New(), sha256.New()
+
+	gzw := gzip.NewWriter(io.MultiWriter(f, digest))
+	tw := io.MultiWriter(gzw, diffID)
+
+	if info.IsDir() {
+		err = writeDirectoryTar(src, tw, ownership)
+	} else {
+		err = copyTarball(src, tw, ownership)
+	}
+
+	if err == nil {
+		err = gzw.Close()
+	}
+
+	if err == nil {
+		layer.Size, err = f.Seek(0, io.SeekCurrent)
+	}
+
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		layer.Close()
+		return nil, fmt.Errorf("error building layer from %s: %v", src, err)
+	}
+
+	layer.Digest = sum(digest)
+	layer.DiffID = sum(diffID)
+
+	return layer, nil
+}
+
+// sum returns the sha256 digest of the given hash
+func sum(h hash.Hash) string {
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+// copyTarball copies the uncompressed content of a tarball to the writer,
+// rewriting the headers if the owners are overridden
+func copyTarball(src string, w io.Writer, ownership *TarOwnership) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stream, err := tarStream(f, "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if ownership != nil {
+		return ownership.rewrite(stream, w)
+	}
+
+	_, err = io.Copy(w, stream)
+	return err
+}
+
+// writeDirectoryTar writes the content of the directory as tar stream, with
+// the owners overridden as given (if not nil)
+func writeDirectoryTar(src string, w io.Writer, ownership *TarOwnership) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+
+		h, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		h.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			h.Name += "/"
+		}
+
+		ownership.apply(h)
+
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
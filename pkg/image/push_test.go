@@ -0,0 +1,203 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// uploadServer is a minimal registry accepting chunked blob uploads
+type uploadServer struct {
+	mu        sync.Mutex
+	uploads   map[string][]byte
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "HEAD" && strings.Contains(r.URL.Path, "/blobs/"):
+		if _, ok := s.blobs[filepath.Base(r.URL.Path)]; !ok {
+			w.WriteHeader(404)
+		}
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/blobs/uploads/"):
+		id := fmt.Sprintf("%d", len(s.uploads))
+		s.uploads[id] = []byte{}
+		w.Header().Set("Location", "/upload/"+id)
+		w.WriteHeader(202)
+	case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/upload/"):
+		id := filepath.Base(r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		s.uploads[id] = append(s.uploads[id], body...)
+		w.Header().Set("Location", "/upload/"+id)
+		w.WriteHeader(202)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/upload/"):
+		data := s.uploads[filepath.Base(r.URL.Path)]
+		digest := r.URL.Query().Get("digest")
+
+		if digest != fmt.Sprintf("sha256:%x", sha256.Sum256(data)) {
+			w.WriteHeader(400)
+			return
+		}
+
+		s.blobs[digest] = data
+		w.WriteHeader(201)
+	case r.Method == "PUT" && strings.Contains(r.URL.Path, "/manifests/"):
+		body, _ := io.ReadAll(r.Body)
+		s.manifests[filepath.Base(r.URL.Path)] = body
+		w.WriteHeader(201)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+// TestPushDirectory tests pushing a directory as single-layer image
+func TestPushDirectory(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := &uploadServer{
+		uploads:   make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "hello"), []byte("world"), 0644))
+
+	url := URL{
+		Host:       server.URL,
+		Repository: "library",
+		Name:       "hello",
+		Tag:        "1.0",
+	}
+
+	pusher, err := NewPusher(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	digest, err := pusher.Push(src, Platform{OS: "linux", Architecture: "amd64"})
+	assert.NoError(t, err, "error pushing directory")
+
+	data := registry.manifests["1.0"]
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), digest)
+
+	m := &Manifest{}
+	assert.NoError(t, json.Unmarshal(data, m))
+	assert.Len(t, m.Layers, 1)
+	assert.Contains(t, registry.blobs, m.Layers[0].Digest)
+	assert.Contains(t, registry.blobs, m.Config.Digest)
+
+	c := &ImageConfig{}
+	assert.NoError(t, json.Unmarshal(registry.blobs[m.Config.Digest], c))
+	assert.Equal(t, "amd64", c.Architecture)
+	assert.Len(t, c.RootFS.DiffIDs, 1)
+}
+
+// TestPushIndex tests pushing an image per platform together with an index
+func TestPushIndex(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := &uploadServer{
+		uploads:   make(map[string][]byte),
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	amd64, arm64 := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(amd64, "arch"), []byte("amd64"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(arm64, "arch"), []byte("arm64"), 0644))
+
+	url := URL{Host: server.URL, Repository: "library", Name: "hello", Tag: "1.0"}
+
+	pusher, err := NewPusher(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	digest, err := pusher.PushIndex([]PlatformSource{
+		{Platform: Platform{OS: "linux", Architecture: "amd64"}, Source: amd64},
+		{Platform: Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Source: arm64},
+	})
+	assert.NoError(t, err)
+
+	data := registry.manifests["1.0"]
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), digest)
+
+	index := &ManifestList{}
+	assert.NoError(t, json.Unmarshal(data, index))
+	assert.Equal(t, OCIIndexMimeType, index.MediaType)
+	assert.Len(t, index.Manifests, 2)
+	assert.Equal(t, "v8", index.Manifests[1].Platform.Variant)
+
+	// the images are only pushed by digest
+	assert.Len(t, registry.manifests, 3)
+
+	for _, m := range index.Manifests {
+		manifest := &Manifest{}
+		assert.NoError(t, json.Unmarshal(registry.manifests[m.Digest], manifest))
+
+		c := &ImageConfig{}
+		assert.NoError(t, json.Unmarshal(registry.blobs[manifest.Config.Digest], c))
+		assert.Equal(t, m.Platform.Architecture, c.Architecture)
+		assert.Equal(t, m.Platform.Variant, c.Variant)
+	}
+
+	_, err = pusher.PushIndex([]PlatformSource{
+		{Platform: Platform{OS: "linux", Architecture: "amd64"}, Source: amd64},
+		{Platform: Platform{OS: "linux", Architecture: "amd64"}, Source: arm64},
+	})
+	assert.ErrorContains(t, err, "more than once")
+}
+
+// TestTag tests retagging an image without transferring blobs
+func TestTag(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	manifest := []byte(`{"schemaVersion": 2}`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/library/hello/manifests/1.0":
+			w.Header().Set("Content-Type", OCIManifestMimeType)
+			w.Write(manifest)
+		case r.Method == "PUT" && r.URL.Path == "/v2/library/hello/manifests/stable":
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, manifest, body)
+			assert.Equal(t, OCIManifestMimeType, r.Header.Get("Content-Type"))
+			w.WriteHeader(201)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: server.URL, Repository: "library", Name: "hello", Tag: "1.0"}
+	pusher, _ := NewPusher(context.Background(), url, "")
+
+	digest, err := pusher.Tag("stable")
+	assert.NoError(t, err, "error tagging")
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)), digest)
+}
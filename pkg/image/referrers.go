@@ -0,0 +1,150 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strings"
+)
+
+// Referrer is an artifact attached to an image through its subject, like a
+// signature, an SBOM, an attestation or a scan result
+type Referrer struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int               `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Referrers lists the artifacts attached to the image, optionally limited to
+// the given artifact type. Registries without the OCI referrers API are
+// queried through the referrers tag schema (sha256-<hex>) instead.
+//
+// Without bound platform, the artifacts attached to the manifest list (or the
+// single manifest) of the reference are listed. With bound platform, those
+// attached to the manifest of the platform.
+func (r *Remote) Referrers(artifactType string) ([]*Referrer, error) {
+	subject, err := r.subject()
+	if err != nil {
+		return nil, err
+	}
+
+	referrers, err := r.queryReferrers(subject, artifactType)
+
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) && requestErr.StatusCode == 404 {
+		referrers, err = r.taggedReferrers(subject)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// registries may ignore the filter
+	selected := []*Referrer{}
+	for _, ref := range referrers {
+		if artifactType == "" || ref.Type() == artifactType {
+			selected = append(selected, ref)
+		}
+	}
+
+	return selected, nil
+}
+
+// subject returns the digest of the manifest the referrers are attached to
+func (r *Remote) subject() (string, error) {
+	if r.platform != nil {
+		return r.Digest()
+	}
+
+	if r.url.Digest != "" {
+		return r.url.Digest, nil
+	}
+
+	accept := append(append([]string{}, manifestListMimeTypes...), manifestMimeTypes...)
+
+	digest, err := r.referenceDigest(strings.Join(accept, ", "))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	return digest, nil
+}
+
+// queryReferrers lists the referrers through the referrers API, following
+// the pagination of the registry
+func (r *Remote) queryReferrers(subject string, artifactType string) ([]*Referrer, error) {
+	referrers := []*Referrer{}
+
+	next := r.url.Endpoint("referrers", subject)
+	if artifactType != "" {
+		next += "?artifactType=" + neturl.QueryEscape(artifactType)
+	}
+
+	for next != "" {
+		res, err := r.requestURL("GET", OCIIndexMimeType, next)
+		if err != nil {
+			return nil, fmt.Errorf("error listing referrers: %w", err)
+		}
+
+		link := res.Header.Get("Link")
+
+		page := &struct {
+			Manifests []*Referrer `json:"manifests"`
+		}{}
+
+		if err := r.unmarshal(res, page); err != nil {
+			return nil, fmt.Errorf("error parsing referrers: %v", err)
+		}
+
+		referrers = append(referrers, page.Manifests...)
+
+		if next, err = nextPage(next, link); err != nil {
+			return nil, err
+		}
+	}
+
+	return referrers, nil
+}
+
+// taggedReferrers lists the referrers through the index tagged with the
+// digest of the subject, which is used by registries without referrers API
+func (r *Remote) taggedReferrers(subject string) ([]*Referrer, error) {
+	tag := strings.Replace(subject, ":", "-", 1)
+
+	res, err := r.request("GET", OCIIndexMimeType, "manifests", tag)
+
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) && requestErr.StatusCode == 404 {
+		return []*Referrer{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing referrers: %w", err)
+	}
+
+	index := &struct {
+		Manifests []*Referrer `json:"manifests"`
+	}{}
+
+	if err := r.unmarshal(res, index); err != nil {
+		return nil, fmt.Errorf("error parsing referrers: %v", err)
+	}
+
+	return index.Manifests, nil
+}
+
+// Type returns the artifact type of the referrer, or its media type if it
+// has none (e.g. signatures stored as plain images)
+func (ref *Referrer) Type() string {
+	if ref.ArtifactType != "" {
+		return ref.ArtifactType
+	}
+
+	return ref.MediaType
+}
+
+func (ref *Referrer) String() string {
+	return fmt.Sprintf("%s\t%s", ref.Type(), ref.Digest)
+}
@@ -0,0 +1,110 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dankinder/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// referrersIndex is an index of an SBOM and a signature
+var referrersIndex = []byte(fmt.Sprintf(`{
+	"schemaVersion": 2,
+	"mediaType": "%s",
+	"manifests": [
+		{
+			"mediaType": "%s",
+			"artifactType": "application/spdx+json",
+			"digest": "sha256:sbom",
+			"size": 100
+		},
+		{
+			"mediaType": "%s",
+			"artifactType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+			"digest": "sha256:signature",
+			"size": 200
+		}
+	]
+}`, OCIIndexMimeType, OCIManifestMimeType, OCIManifestMimeType))
+
+// referrersServer returns a registry with an image whose referrers are
+// listed through the referrers API or through the referrers tag schema
+func referrersServer(api bool) *httpmock.Server {
+	downstream := &httpmock.MockHandler{}
+
+	header := make(http.Header)
+	header.Add("Docker-Content-Digest", "sha256:image")
+	header.Add("Content-Type", OCIManifestMimeType)
+
+	downstream.On("Handle", "HEAD", "/v2/library/app/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+	})
+
+	referrers := httpmock.Response{Body: referrersIndex}
+	tagged := httpmock.Response{Status: 404}
+
+	if !api {
+		referrers, tagged = tagged, referrers
+	}
+
+	downstream.On("Handle", "GET", "/v2/library/app/referrers/sha256:image", mock.Anything).Return(referrers)
+	downstream.On("Handle", "GET", "/v2/library/app/referrers/sha256:image?artifactType=application%2Fspdx%2Bjson", mock.Anything).Return(referrers)
+	downstream.On("Handle", "GET", "/v2/library/app/manifests/sha256-image", mock.Anything).Return(tagged)
+
+	return httpmock.NewServer(downstream)
+}
+
+// TestReferrers tests listing the artifacts attached to an image
+func TestReferrers(t *testing.T) {
+	for _, api := range []bool{true, false} {
+		server := referrersServer(api)
+
+		remote := &Remote{
+			client: http.DefaultClient,
+			url:    URL{Host: server.URL(), Repository: "library", Name: "app", Tag: "latest"},
+			ctx:    context.Background(),
+		}
+
+		referrers, err := remote.Referrers("")
+		assert.NoError(t, err)
+		assert.Len(t, referrers, 2)
+		assert.Equal(t, "application/spdx+json\tsha256:sbom", referrers[0].String())
+		assert.Equal(t, 200, referrers[1].Size)
+
+		// the filter is applied even if the registry ignores it
+		referrers, err = remote.Referrers("application/spdx+json")
+		assert.NoError(t, err)
+		assert.Len(t, referrers, 1)
+		assert.Equal(t, "sha256:sbom", referrers[0].Digest)
+
+		server.Close()
+	}
+}
+
+// TestNoReferrers tests that images without referrers have an empty list
+func TestNoReferrers(t *testing.T) {
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "GET", "/v2/library/app/referrers/sha256:image", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+	downstream.On("Handle", "GET", "/v2/library/app/manifests/sha256-image", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	remote := &Remote{
+		client: http.DefaultClient,
+		url:    URL{Host: server.URL(), Repository: "library", Name: "app", Digest: "sha256:image"},
+		ctx:    context.Background(),
+	}
+
+	referrers, err := remote.Referrers("")
+	assert.NoError(t, err)
+	assert.Empty(t, referrers)
+}
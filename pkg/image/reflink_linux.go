@@ -0,0 +1,13 @@
+package image
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes the destination share the blocks of the source, if
+// supported by the filesystem
+func reflink(dst *os.File, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package image
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink is not supported outside of Linux
+func reflink(dst *os.File, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,274 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// RegistriesConfig holds the settings of containers-registries.conf(5), as
+// used by podman and skopeo. See:
+// https://github.com/containers/image/blob/main/docs/containers-registries.conf.5.md
+type RegistriesConfig struct {
+
+	// UnqualifiedSearchRegistries are tried in order for short names
+	UnqualifiedSearchRegistries []string `toml:"unqualified-search-registries"`
+
+	// Registries configures the mirrors and blocks of specific registries
+	Registries []RegistryConfig `toml:"registry"`
+
+	// Aliases maps short names to fully qualified names, e.g. ubuntu to
+	// docker.io/library/ubuntu
+	Aliases map[string]string `toml:"aliases"`
+}
+
+// RegistryConfig configures the images matching a prefix
+type RegistryConfig struct {
+	Prefix             string         `toml:"prefix"`
+	Location           string         `toml:"location"`
+	Blocked            bool           `toml:"blocked"`
+	MirrorByDigestOnly bool           `toml:"mirror-by-digest-only"`
+	Mirrors            []MirrorConfig `toml:"mirror"`
+}
+
+// MirrorConfig is a mirror of a registry, which is tried before the registry
+type MirrorConfig struct {
+	Location string `toml:"location"`
+}
+
+// LoadRegistriesConfig reads the registries.conf at the given path, followed
+// by the drop-in files in the registries.conf.d directory next to it, in
+// alphabetical order. Drop-ins override the search registries and registries
+// with the same prefix, and add to the aliases.
+func LoadRegistriesConfig(path string) (*RegistriesConfig, error) {
+	c := &RegistriesConfig{Aliases: make(map[string]string)}
+
+	files, err := filepath.Glob(path + ".d/*.conf")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	if _, err := os.Stat(path); err == nil {
+		files = append([]string{path}, files...)
+	}
+
+	for _, file := range files {
+		if err := c.load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// load merges the given file into the config
+func (c *RegistriesConfig) load(path string) error {
+	file := &RegistriesConfig{}
+
+	meta, err := toml.DecodeFile(path, file)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	for _, r := range file.Registries {
+		if r.Prefix == "" && r.Location == "" {
+			return fmt.Errorf("error parsing %s: registry without prefix and location", path)
+		}
+
+		if strings.HasPrefix(r.Prefix, "*.") && r.Location != "" {
+			return fmt.Errorf("error parsing %s: wildcard prefix %s cannot have a location", path, r.Prefix)
+		}
+
+		c.setRegistry(r)
+	}
+
+	if meta.IsDefined("unqualified-search-registries") {
+		c.UnqualifiedSearchRegistries = file.UnqualifiedSearchRegistries
+	}
+
+	for name, alias := range file.Aliases {
+		c.Aliases[name] = alias
+	}
+
+	return nil
+}
+
+// setRegistry adds the registry or replaces the one with the same prefix
+func (c *RegistriesConfig) setRegistry(r RegistryConfig) {
+	for i := range c.Registries {
+		if c.Registries[i].prefix() == r.prefix() {
+			c.Registries[i] = r
+			return
+		}
+	}
+
+	c.Registries = append(c.Registries, r)
+}
+
+// prefix returns the prefix of the registry, which defaults to its location
+func (r *RegistryConfig) prefix() string {
+	if r.Prefix == "" {
+		return r.Location
+	}
+
+	return r.Prefix
+}
+
+// ResolveAlias returns the fully qualified name of the given short name, if
+// there's an alias for it. Tags and digests are kept.
+func (c *RegistriesConfig) ResolveAlias(name string) (string, bool) {
+	if IsQualified(name) {
+		return name, false
+	}
+
+	name = strings.Trim(name, " \n\t")
+
+	// split the tag and digest from the name
+	bare, suffix := name, ""
+
+	if i := strings.Index(bare, "@"); i >= 0 {
+		bare, suffix = bare[:i], bare[i:]
+	}
+
+	if i := strings.LastIndex(bare, ":"); i > strings.LastIndex(bare, "/") {
+		bare, suffix = bare[:i], bare[i:]+suffix
+	}
+
+	alias, ok := c.Aliases[bare]
+	if !ok {
+		return name, false
+	}
+
+	return alias + suffix, true
+}
+
+// Resolve returns the urls which should be tried in order to pull the given
+// image: Short names are expanded using their alias or the unqualified search
+// registries, mirrors come before their registry and blocked registries are
+// left out.
+//
+// An error is returned if no candidate remains.
+func (c *RegistriesConfig) Resolve(name string) ([]URL, error) {
+	name, _ = c.ResolveAlias(name)
+	names := []string{name}
+
+	if !IsQualified(name) && len(c.UnqualifiedSearchRegistries) > 0 {
+		names = make([]string, len(c.UnqualifiedSearchRegistries))
+
+		for i, registry := range c.UnqualifiedSearchRegistries {
+			names[i] = fmt.Sprintf("%s/%s", registry, name)
+		}
+	}
+
+	urls := []URL{}
+	errs := []error{}
+
+	for _, n := range names {
+		url, err := Parse(n)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		candidates, err := c.candidates(*url)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		urls = append(urls, candidates...)
+	}
+
+	if len(urls) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return urls, nil
+}
+
+// candidates returns the urls of the mirrors and the location of the given
+// fully qualified url
+func (c *RegistriesConfig) candidates(url URL) ([]URL, error) {
+	ref := url.Canonical()
+	registry, prefix := c.registry(ref)
+
+	if registry == nil {
+		return []URL{url}, nil
+	}
+
+	if registry.Blocked {
+		return nil, fmt.Errorf("%s is blocked by registries.conf", ref)
+	}
+
+	locations := []string{}
+
+	if url.Digest != "" || !registry.MirrorByDigestOnly {
+		for _, m := range registry.Mirrors {
+			locations = append(locations, m.Location)
+		}
+	}
+
+	// wildcard prefixes are not rewritten
+	if registry.Location != "" {
+		locations = append(locations, registry.Location)
+	} else {
+		locations = append(locations, prefix)
+	}
+
+	urls := []URL{}
+
+	for _, location := range locations {
+		rewritten := ref
+		if !strings.HasPrefix(prefix, "*.") {
+			rewritten = location + ref[len(prefix):]
+		}
+
+		u, err := Parse(rewritten)
+		if err != nil {
+			return nil, fmt.Errorf("invalid location %s: %v", location, err)
+		}
+
+		urls = append(urls, *u)
+	}
+
+	return urls, nil
+}
+
+// registry returns the registry with the longest prefix matching the given
+// reference, together with the prefix
+func (c *RegistriesConfig) registry(ref string) (*RegistryConfig, string) {
+	var match *RegistryConfig
+	longest := ""
+
+	for i := range c.Registries {
+		prefix := c.Registries[i].prefix()
+
+		if matchesPrefix(ref, prefix) && len(prefix) > len(longest) {
+			match, longest = &c.Registries[i], prefix
+		}
+	}
+
+	return match, longest
+}
+
+// matchesPrefix returns true if the reference is within the given prefix
+func matchesPrefix(ref string, prefix string) bool {
+	if strings.HasPrefix(prefix, "*.") {
+		host, _, _ := strings.Cut(ref, "/")
+		return strings.HasSuffix(host, prefix[1:])
+	}
+
+	if !strings.HasPrefix(ref, prefix) {
+		return false
+	}
+
+	rest := ref[len(prefix):]
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
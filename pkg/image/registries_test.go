@@ -0,0 +1,123 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const registriesConf = `
+unqualified-search-registries = ["registry.example.org", "docker.io"]
+
+[[registry]]
+prefix = "docker.io"
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.org"
+
+[[registry]]
+location = "registry.example.org"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "cache.example.org"
+
+[[registry]]
+prefix = "docker.io/library/debian:sid"
+blocked = true
+
+[[registry]]
+prefix = "*.evil.org"
+blocked = true
+`
+
+func resolve(t *testing.T, c *RegistriesConfig, name string) []string {
+	urls, err := c.Resolve(name)
+	assert.NoError(t, err)
+
+	names := make([]string, len(urls))
+	for i, u := range urls {
+		names[i] = u.String()
+	}
+
+	return names
+}
+
+// TestRegistriesResolve tests the expansion of names into candidates
+func TestRegistriesResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registries.conf")
+	assert.NoError(t, os.WriteFile(file, []byte(registriesConf), 0644))
+
+	c, err := LoadRegistriesConfig(file)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"registry.example.org/library/ubuntu:latest",
+		"mirror.example.org/library/ubuntu:latest",
+		"registry-1.docker.io/library/ubuntu:latest",
+	}, resolve(t, c, "ubuntu"))
+
+	assert.Equal(t, []string{
+		"cache.example.org/team/app:1@sha256:abc",
+		"registry.example.org/team/app:1@sha256:abc",
+	}, resolve(t, c, "registry.example.org/team/app:1@sha256:abc"))
+
+	assert.Equal(t, []string{
+		"gcr.io/team/app:latest",
+	}, resolve(t, c, "gcr.io/team/app"))
+
+	// the blocked tag is only found on the Docker Hub
+	assert.Equal(t, []string{
+		"registry.example.org/library/debian:sid",
+	}, resolve(t, c, "debian:sid"))
+
+	_, err = c.Resolve("docker.io/library/debian:sid")
+	assert.Error(t, err)
+
+	_, err = c.Resolve("registry.evil.org/team/app")
+	assert.Error(t, err)
+}
+
+// TestRegistriesAliases tests that drop-ins are merged and aliases resolved
+func TestRegistriesAliases(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registries.conf")
+	assert.NoError(t, os.WriteFile(file, []byte(registriesConf), 0644))
+	assert.NoError(t, os.Mkdir(file+".d", 0755))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(file+".d", "000-shortnames.conf"), []byte(`
+[aliases]
+"ubuntu" = "docker.io/library/ubuntu"
+"app" = "quay.io/team/app"
+`), 0644))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(file+".d", "100-local.conf"), []byte(`
+unqualified-search-registries = ["quay.io"]
+
+[aliases]
+"app" = "gcr.io/team/app"
+`), 0644))
+
+	c, err := LoadRegistriesConfig(file)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"quay.io"}, c.UnqualifiedSearchRegistries)
+
+	alias, ok := c.ResolveAlias("app:1.0@sha256:abc")
+	assert.True(t, ok)
+	assert.Equal(t, "gcr.io/team/app:1.0@sha256:abc", alias)
+
+	_, ok = c.ResolveAlias("gcr.io/team/app")
+	assert.False(t, ok, "qualified names are never aliased")
+
+	assert.Equal(t, []string{
+		"mirror.example.org/library/ubuntu:22.04",
+		"registry-1.docker.io/library/ubuntu:22.04",
+	}, resolve(t, c, "ubuntu:22.04"))
+
+	assert.Equal(t, []string{
+		"quay.io/library/debian:latest",
+	}, resolve(t, c, "debian"))
+}
@@ -2,10 +2,13 @@ package image
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 // Remote represents an image on a remote repository
@@ -13,7 +16,27 @@ type Remote struct {
 	client   *http.Client
 	url      URL
 	platform *Platform
+	strict   bool
+	first    bool
+	selected func(p *Platform, host bool)
 	ctx      context.Context
+
+	// external holds the layers of the last manifest which have urls
+	external map[string]ManifestLayer
+
+	// manifests stores manifest responses for conditional requests
+	manifests *ManifestCache
+
+	// retries is the number of times failed requests are retried
+	retries int
+
+	// pinned is the digest the reference was resolved to, see Pin
+	pinned string
+
+	// schema1 allows legacy manifests, whose config is stored in
+	// legacyConfig, as it is part of the manifest
+	schema1      bool
+	legacyConfig *ImageConfig
 }
 
 func (r *Remote) String() string {
@@ -49,14 +72,34 @@ func NewRemote(ctx context.Context, url URL, auth string) (*Remote, error) {
 	}, nil
 }
 
+// NewRepository returns a remote instance to list the tags of the repository
+// of the given URL. Unlike NewRemote, the tag of the URL need not exist.
+func NewRepository(ctx context.Context, url URL, auth string) (*Remote, error) {
+	provider, err := LookupProvider(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := provider.GetClient(url, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Remote{
+		url:    url,
+		client: client,
+		ctx:    ctx,
+	}, nil
+}
+
 // Platforms returns all the platforms the image supports. Nil is is
 // returned if the image does not have multi-platform support (i.e. there is
 // no manifest list).
 //
 // If the image has platforms, you should bind the required platform to the
 // Remote using WithPlatform, before using other methods, as you will otherwise
-// get whatever the registry deems to be the default platform of the manifest,
-// which might not be what you want.
+// get the platform of the host, or the first platform of the manifest list if
+// the host is not supported, which might not be what you want.
 func (r *Remote) Platforms() ([]*Platform, error) {
 
 	// try to get the manifest list (not all images have this)
@@ -74,26 +117,158 @@ func (r *Remote) Platforms() ([]*Platform, error) {
 	return platforms, nil
 }
 
+// PlatformManifests returns the manifests of all the platforms of the image
+// with their digests. Images without manifest list have a single platform,
+// which is read from their config.
+func (r *Remote) PlatformManifests() ([]PlatformManifest, error) {
+	l, err := r.ManifestList()
+	if err != nil {
+		return nil, err
+	}
+
+	if l != nil && len(l.Manifests) > 0 {
+		return l.Manifests, nil
+	}
+
+	m, err := r.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.config(m)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := r.rawManifest(m.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = ManifestMimeType
+	}
+
+	return []PlatformManifest{{
+		ManifestLayer: &ManifestLayer{MediaType: mediaType, Size: len(raw), Digest: m.Digest},
+		Platform:      Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant},
+	}}, nil
+}
+
+// Name returns the normalized url of the image
+func (r *Remote) Name() string {
+	return r.url.String()
+}
+
+// Platform returns the platform bound through WithPlatform, or nil
+func (r *Remote) Platform() *Platform {
+	return r.platform
+}
+
 // WithPlatform binds the given platform to the remote and uses it to
 // scope the Digest and Manifest methods
 func (r *Remote) WithPlatform(p *Platform) {
 	r.platform = p
 }
 
+// WithStrictPlatform requires the image to match the bound platform (or the
+// platform of the host, if none is bound). Without it, images without a
+// manifest for the host platform fall back to the first manifest.
+func (r *Remote) WithStrictPlatform() {
+	r.strict = true
+}
+
+// WithFirstPlatform selects the first manifest of the manifest list if no
+// platform is bound, instead of the one for the host platform. This was the
+// behaviour of earlier releases. It has no effect with WithStrictPlatform.
+func (r *Remote) WithFirstPlatform() {
+	r.first = true
+}
+
+// WithManifestCache stores the manifests fetched by the remote in the given
+// cache, to revalidate them instead of downloading them again
+func (r *Remote) WithManifestCache(c *ManifestCache) {
+	r.manifests = c
+}
+
+// WithRetries retries requests that failed due to rate limits, server errors
+// or network errors the given number of times, with exponential backoff
+func (r *Remote) WithRetries(retries int) {
+	r.retries = retries
+}
+
+// WithSchema1 allows pulling images with schema version 1 manifests, which
+// are still served by some old registries. Their layers are listed without
+// size and their config is derived from the history of the manifest.
+func (r *Remote) WithSchema1() {
+	r.schema1 = true
+}
+
+// Pin binds the remote to the given digest of its reference (e.g. the one
+// whose signature was verified), so that later requests get the same image,
+// even if the tag is pushed again in the meantime
+func (r *Remote) Pin(digest string) {
+	r.pinned = digest
+}
+
+// pinnedDigest returns the digest the remote is pinned to, or the digest of
+// its url, if any
+func (r *Remote) pinnedDigest() string {
+	if r.pinned != "" {
+		return r.pinned
+	}
+
+	return r.url.Digest
+}
+
+// reference returns the pinned digest or the tag of the remote
+func (r *Remote) reference() string {
+	if digest := r.pinnedDigest(); digest != "" {
+		return digest
+	}
+
+	return r.url.Tag
+}
+
+// OnPlatformSelected calls the given function once a manifest is selected
+// from the manifest list without bound platform. Host is false if the host
+// platform was not found (or not looked for) and the first one was taken.
+// The function is called at most once.
+func (r *Remote) OnPlatformSelected(fn func(p *Platform, host bool)) {
+	r.selected = fn
+}
+
+// selectManifest returns the digest of the given manifest and reports the selection
+func (r *Remote) selectManifest(m *PlatformManifest, host bool) string {
+	if r.selected != nil {
+		r.selected(&m.Platform, host)
+		r.selected = nil
+	}
+
+	return m.Digest
+}
+
 // ManifestList queries the remote for the manifest list and parses the result.
 // If the manifest list does not exist, the method returns nil, nil instead of
 // an error, as manifest lists are not available for most images today.
 func (r *Remote) ManifestList() (*ManifestList, error) {
 
 	// not having a manifest list is no error
-	res, err := r.request("GET", ManifestListMimeType, "manifests", r.url.Reference())
+	res, err := r.request("GET", strings.Join(manifestListMimeTypes, ", "), "manifests", r.reference())
 	if err != nil {
 		return nil, nil
 	}
 
+	// lists requested by digest have to match it
+	body, err := readManifest(res, r.pinnedDigest())
+	if err != nil {
+		return nil, err
+	}
+
 	// not being able to parse an existing list is however
 	lst := &ManifestList{}
-	if err := r.unmarshal(res, lst); err != nil {
+	if err := json.Unmarshal(body, lst); err != nil {
 		return nil, fmt.Errorf("error parsing manifest list: %v", err)
 	}
 
@@ -110,28 +285,93 @@ func (r *Remote) Manifest() (*Manifest, error) {
 		return nil, err
 	}
 
+	accept := manifestMimeTypes
+	if r.schema1 {
+		accept = append(append([]string{}, manifestMimeTypes...), schema1MimeTypes...)
+	}
+
 	// it should almost certainly be fetchable at this point
-	res, err := r.request("GET", ManifestMimeType, "manifests", digest)
+	res, err := r.request("GET", strings.Join(accept, ", "), "manifests", digest)
 	if err != nil {
-		return nil, fmt.Errorf("error requesting manifest@%s: %v", digest, err)
+		return nil, fmt.Errorf("error requesting manifest@%s: %w", digest, err)
 	}
 
+	m := &Manifest{Digest: digest}
+	contentType := res.Header.Get("Content-Type")
+
 	// if the server responds with a manifest list, our digest is not correct
-	if res.Header.Get("Content-Type") != ManifestMimeType {
-		return nil, fmt.Errorf("content type for %s cannot be %s", digest, res.Header.Get("Content-Type"))
+	if !isMimeType(contentType, manifestMimeTypes...) && !isMimeType(contentType, schema1MimeTypes...) {
+		res.Body.Close()
+		return nil, fmt.Errorf("content type for %s cannot be %s", digest, contentType)
 	}
 
-	// we must also be able to parse it
-	m := &Manifest{Digest: digest}
-	if err := r.unmarshal(res, &m); err != nil {
-		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	// signed schema version 1 manifests are hashed without their signatures,
+	// all others have to match the digest
+	expected := digest
+	if isMimeType(contentType, schema1MimeTypes[0]) {
+		expected = ""
+	}
+
+	body, err := readManifest(res, expected)
+	if err != nil {
+		return nil, err
+	}
+
+	// legacy registries may only have schema version 1 manifests
+	if isMimeType(contentType, schema1MimeTypes...) {
+		if m, err = r.legacyManifest(body, contentType, digest); err != nil {
+			return nil, err
+		}
+	} else {
+		r.legacyConfig = nil
+
+		if err := json.Unmarshal(body, m); err != nil {
+			return nil, fmt.Errorf("error parsing manifest: %v", err)
+		}
+	}
+
+	// images without list have a single platform, which is in the config
+	if r.strict && !m.IsArtifact() {
+		if err := r.requirePlatform(m); err != nil {
+			return nil, err
+		}
+	}
+
+	// layers with urls are downloaded from there
+	r.external = make(map[string]ManifestLayer)
+	for _, l := range m.Layers {
+		if len(l.URLs) > 0 {
+			r.external[l.Digest] = l
+		}
 	}
 
 	return m, nil
 }
 
+// requirePlatform returns an error if the platform of the image does not
+// match the bound platform, or the one of the host
+func (r *Remote) requirePlatform(m *Manifest) error {
+	c, err := r.config(m)
+	if err != nil {
+		return err
+	}
+
+	wanted := r.platform
+	if wanted == nil {
+		wanted = HostPlatform()
+	}
+
+	actual := &Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}
+	if !actual.Matches(wanted) {
+		return fmt.Errorf("%s is built for %s, not %s", r.url, actual, wanted)
+	}
+
+	return nil
+}
+
 // Digest gets the latest digest of the image. The current platform is
-// respected if one was set through WithPlatform.
+// respected if one was set through WithPlatform, otherwise the platform of
+// the host is preferred.
 func (r *Remote) Digest() (string, error) {
 	// due to https://github.com/docker/distribution/issues/2395 we always
 	// have to request the manifest list, even if it doesn't exist, as images
@@ -141,24 +381,16 @@ func (r *Remote) Digest() (string, error) {
 		return "", err
 	}
 
-	// if there's a list, but no platform, take the first item
-	//
-	// we could be cleverer here by picking the platform or we could let
-	// the user know that he should pick one
-	if r.platform == nil && lst != nil && len(lst.Manifests) != 0 {
-		return lst.Manifests[0].Digest, nil
-	}
-
 	// if there's no list and no platform, fall back to whatever the server
 	// gives us through the docker-content-digest header
 	if r.platform == nil && (lst == nil || len(lst.Manifests) == 0) {
-		res, err := r.request("HEAD", ManifestMimeType, "manifests", r.url.Reference())
+		digest, err := r.referenceDigest(strings.Join(manifestMimeTypes, ", "))
 
 		if err != nil {
-			return "", fmt.Errorf("failed to fetch manifest: %v", err)
+			return "", fmt.Errorf("failed to fetch manifest: %w", err)
 		}
 
-		return res.Header.Get("Docker-Content-Digest"), nil
+		return digest, nil
 	}
 
 	// if there is a platform, we require a list
@@ -166,14 +398,35 @@ func (r *Remote) Digest() (string, error) {
 		return "", fmt.Errorf("no multi-platform support: %s", r.url)
 	}
 
-	for _, m := range lst.Manifests {
-		if m.Platform == *r.platform {
+	// a bound platform has to be found
+	if r.platform != nil {
+		if m := lst.Select(r.platform); m != nil {
 			return m.Digest, nil
 		}
+
+		return "", fmt.Errorf("no manifest found for %s %s", r.url, r.platform)
+	}
+
+	// earlier releases always took the first item
+	if r.first && !r.strict {
+		return r.selectManifest(&lst.Manifests[0], false), nil
+	}
+
+	// without platform, we pick the one of the host
+	wanted := HostPlatform()
+
+	if m := lst.Select(wanted); m != nil {
+		return r.selectManifest(m, true), nil
+	}
+
+	// if the host platform is missing, take the first item, unless the
+	// platform has to match
+	if !r.strict {
+		return r.selectManifest(&lst.Manifests[0], false), nil
 	}
 
 	// there was no match
-	return "", fmt.Errorf("no manifest found for %s", r)
+	return "", fmt.Errorf("no manifest found for %s %s", r.url, wanted)
 }
 
 // Layers returns the layers of the image. The current plaform is
@@ -187,45 +440,348 @@ func (r *Remote) Layers() ([]ManifestLayer, error) {
 	return m.Layers, nil
 }
 
-// DownloadLayer downloads a layer to a Writer
+// Config gets the image configuration referenced by the manifest. The
+// current platform is respected if one was set through WithPlatform.
+func (r *Remote) Config() (*ImageConfig, error) {
+
+	m, err := r.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.config(m)
+}
+
+// config downloads the configuration referenced by the given manifest, or
+// returns the one found in schema version 1 manifests
+func (r *Remote) config(m *Manifest) (*ImageConfig, error) {
+	if m.Config.Digest == "" && r.legacyConfig != nil && isMimeType(m.MediaType, schema1MimeTypes...) {
+		return r.legacyConfig, nil
+	}
+
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest@%s has no config", m.Digest)
+	}
+
+	return downloadConfig(r, m.Config.Digest)
+}
+
+// DownloadLayer downloads a layer to a Writer, verifying its digest. Layers
+// of the last manifest which have urls may be downloaded from those.
 func (r *Remote) DownloadLayer(digest string, w io.Writer) error {
 
-	res, err := r.request("GET", "*", "blobs", digest)
+	h, err := newDigester(digest)
 	if err != nil {
-		return fmt.Errorf("failed to download %s: %v", digest, err)
+		return err
+	}
+
+	res, err := r.requestLayer(digest)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", digest, err)
 	}
 
 	// copy the downloads using the default buffer
-	defer res.Body.Close()
+	body := r.resuming(digest, res.Body, 0)
+	defer body.Close()
 
-	_, err = io.Copy(w, res.Body)
+	_, err = io.Copy(io.MultiWriter(w, h), body)
 	if err != nil {
 		return fmt.Errorf("error downloading %s: %v", digest, err)
 	}
 
-	return nil
+	return checkDigest(digest, h)
+}
+
+// OpenLayer opens the blob with the given digest, continuing at the given
+// offset if the registry supports range requests. The returned offset is the
+// one the stream starts at, which is 0 if the whole blob is sent. The digest
+// is not verified, as the stream may be incomplete.
+func (r *Remote) OpenLayer(digest string, offset int64) (io.ReadCloser, int64, error) {
+	body, start, err := r.openLayer(digest, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return r.resuming(digest, body, start), start, nil
+}
+
+// openLayer opens the blob with the given digest at the given offset, see
+// OpenLayer. Broken connections are not resumed.
+func (r *Remote) openLayer(digest string, offset int64) (io.ReadCloser, int64, error) {
+	_, external := r.external[digest]
+
+	if offset == 0 || external {
+		res, err := r.requestLayer(digest)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to download %s: %w", digest, err)
+		}
+
+		return res.Body, 0, nil
+	}
+
+	url := r.url.Endpoint("blobs", digest)
+
+	req, err := http.NewRequestWithContext(r.ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	res, err := retry(r.ctx, r.retries, func() (*http.Response, error) {
+		res, err := r.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error requesting %s: %w", url, err)
+		}
+
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+			defer res.Body.Close()
+			return nil, NewRequestError(res)
+		}
+
+		return res, nil
+	})
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download %s: %w", digest, err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent && strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		return res.Body, offset, nil
+	case res.StatusCode == 200:
+		return res.Body, 0, nil
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return r.openLayer(digest, 0)
+	}
+
+	defer res.Body.Close()
+	return nil, 0, fmt.Errorf("failed to download %s: %w", digest, NewRequestError(res))
+}
+
+// requestLayer requests the given layer from the registry and the urls of
+// the layer in turn, until one of them responds. Foreign layers are usually
+// not stored in the registry, so their urls are requested first.
+func (r *Remote) requestLayer(digest string) (*http.Response, error) {
+	l, ok := r.external[digest]
+	if !ok {
+		return r.request("GET", "*", "blobs", digest)
+	}
+
+	registry := r.url.Endpoint("blobs", digest)
+
+	candidates := append([]string{registry}, l.URLs...)
+	if l.IsForeign() {
+		candidates = append(append([]string{}, l.URLs...), registry)
+	}
+
+	errs := []error{}
+
+	for _, url := range candidates {
+		var res *http.Response
+		var err error
+
+		if url == registry {
+			res, err = r.requestURL("GET", "*", url)
+		} else {
+			res, err = r.requestExternal(url)
+		}
+
+		if err == nil {
+			return res, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// requestExternal requests the given url of a layer, which is outside of the
+// registry, so the credentials of the registry are not sent along
+func (r *Remote) requestExternal(url string) (*http.Response, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("unsupported layer url %s", url)
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+
+	return retry(r.ctx, r.retries, func() (*http.Response, error) {
+		res, err := HTTPClient().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error requesting %s: %w", url, err)
+		}
+
+		if res.StatusCode != 200 {
+			defer res.Body.Close()
+			return nil, NewRequestError(res)
+		}
+
+		return res, nil
+	})
 }
 
 func (r *Remote) request(method string, accept string, segments ...string) (*http.Response, error) {
-	req, err := http.NewRequest(method, r.url.Endpoint(segments...), nil)
+	return r.requestURL(method, accept, r.url.Endpoint(segments...))
+}
+
+// requestURL requests the given absolute url (e.g. the next page of a list)
+func (r *Remote) requestURL(method string, accept string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error requesting %s: %v", req.URL, err)
+		return nil, fmt.Errorf("error requesting %s: %w", url, err)
 	}
 
 	req = req.WithContext(r.ctx)
 
 	req.Header.Add("Accept", accept)
-	res, err := r.client.Do(req)
 
+	return retry(r.ctx, r.retries, func() (*http.Response, error) {
+		if r.manifests != nil && isManifestRequest(req) {
+			return r.manifests.do(r.client, req)
+		}
+
+		res, err := r.client.Do(req)
+
+		if err != nil {
+			return nil, fmt.Errorf("error requesting %s: %w", req.URL, err)
+		}
+
+		if res.StatusCode != 200 {
+			defer res.Body.Close()
+			return nil, NewRequestError(res)
+		}
+
+		return res, nil
+	})
+}
+
+// TagMismatchError is returned by VerifyTag if the tag of a reference points
+// to another digest than the one it was pinned to
+type TagMismatchError struct {
+	URL     URL
+	Current string
+}
+
+func (e *TagMismatchError) Error() string {
+	return fmt.Sprintf("%s is pinned to %s, but the tag points to %s",
+		e.URL.WithTag(e.URL.Tag).Familiar(), e.URL.Digest, e.Current)
+}
+
+// VerifyTag checks that the tag of a reference with tag and digest (e.g.
+// debian:bookworm@sha256:...) still points to the digest, by itself or as
+// part of the manifest list the tag points to. A mismatch is returned as
+// TagMismatchError and usually means that the tag was pushed again.
+// References without digest are not checked.
+func (r *Remote) VerifyTag() error {
+	if r.url.Digest == "" {
+		return nil
+	}
+
+	accept := strings.Join(append(append([]string{}, manifestListMimeTypes...), manifestMimeTypes...), ", ")
+
+	res, err := r.request("GET", accept, "manifests", r.url.Tag)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %w", r.url.WithTag(r.url.Tag).Familiar(), err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+
+	current := res.Header.Get("Docker-Content-Digest")
+	if current == "" {
+		current = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+
+	if current == r.url.Digest {
+		return nil
+	}
+
+	// the pinned digest may be one of the platforms of the list
+	if isMimeType(res.Header.Get("Content-Type"), manifestListMimeTypes...) {
+		lst := &ManifestList{}
+		if err := json.Unmarshal(body, lst); err != nil {
+			return fmt.Errorf("error parsing manifest list: %v", err)
+		}
+
+		for _, m := range lst.Manifests {
+			if m.Digest == r.url.Digest {
+				return nil
+			}
+		}
+	}
+
+	return &TagMismatchError{URL: r.url, Current: current}
+}
+
+// referenceDigest returns the digest of the manifest the reference of the
+// remote points to. Registries that do not send the Docker-Content-Digest
+// header on HEAD requests get a GET request, and the digest is computed from
+// the returned manifest.
+func (r *Remote) referenceDigest(accept string) (string, error) {
+	// manifests requested by digest are verified against it
+	if digest := r.pinnedDigest(); digest != "" {
+		return digest, nil
+	}
+
+	res, err := r.request("HEAD", accept, "manifests", r.url.Tag)
 	if err != nil {
-		return nil, fmt.Errorf("error requesting %s: %v", req.URL, err)
+		return "", err
 	}
+	res.Body.Close()
+
+	if digest := res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	res, err = r.request("GET", accept, "manifests", r.url.Tag)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, res.Body); err != nil {
+		return "", fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// readManifest reads the manifest in the response, which has to match the
+// digest it was requested by, if any. Registries and proxies are not trusted
+// to serve the manifest a (possibly signed) digest refers to.
+func readManifest(res *http.Response, digest string) ([]byte, error) {
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if digest == "" {
+		return body, nil
+	}
+
+	h, err := newDigester(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Write(body)
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("%s %s failed with %s", method, req.URL, res.Status)
+	if err := checkDigest(digest, h); err != nil {
+		return nil, fmt.Errorf("invalid manifest@%s: %v", digest, err)
 	}
 
-	return res, nil
+	return body, nil
 }
 
 func (r *Remote) unmarshal(res *http.Response, v interface{}) error {
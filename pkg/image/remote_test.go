@@ -1,12 +1,20 @@
 package image
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dankinder/httpmock"
+	"github.com/seantis/roots/pkg/registrytest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -82,6 +90,16 @@ func TestRemoteDigest(t *testing.T) {
 	assert.NoError(t, err, "error during mock lookup")
 	assert.Equal(t, "foobar", digest, "could not lookup mock digest")
 
+	// the only manifest is amd64, which is no match elsewhere
+	remote.WithStrictPlatform()
+	digest, err = remote.Digest()
+	if HostPlatform().Architecture == "amd64" {
+		assert.NoError(t, err, "error during strict mock lookup")
+		assert.Equal(t, "foobar", digest, "could not lookup mock digest")
+	} else {
+		assert.EqualError(t, err, fmt.Sprintf("no manifest found for %s %s", url, HostPlatform()))
+	}
+
 	remote.WithPlatform(&Platform{
 		Architecture: "arm",
 		OS:           "linux",
@@ -90,3 +108,470 @@ func TestRemoteDigest(t *testing.T) {
 	assert.EqualError(t, err, fmt.Sprintf("no manifest found for %s linux/arm", url), "unexpected error")
 	assert.Equal(t, "", digest, "could not lookup mock digest")
 }
+
+// TestRemoteDigestWithoutHeader tests that the digest is computed from the
+// manifest if the registry does not send it
+func TestRemoteDigestWithoutHeader(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "layers": []}`, ManifestMimeType))
+
+	header := make(http.Header)
+	header.Add("Content-Type", ManifestMimeType)
+
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "HEAD", "/v2/library/busybox/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+	})
+	downstream.On("Handle", "GET", "/v2/library/busybox/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+		Body:   manifest,
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "busybox",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	digest, err := remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)), digest)
+
+	img, err := LockImage("busybox", remote)
+	assert.NoError(t, err)
+	assert.Equal(t, digest, img.Digest)
+}
+
+// TestForeignLayers tests that foreign layers are downloaded from their urls,
+// falling back to the registry
+func TestForeignLayers(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	layer := []byte("foreign layer")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	mirrored := []byte("mirrored layer")
+	mirroredDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(mirrored))
+
+	downstream := &httpmock.MockHandler{}
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	manifest := []byte(fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "%[1]s",
+		"layers": [
+			{"mediaType": "%[2]s", "digest": "%[3]s", "urls": ["%[5]s/missing", "%[5]s/layer"]},
+			{"mediaType": "%[2]s", "digest": "%[4]s", "urls": ["ftp://example.org/layer"]}
+		]
+	}`, ManifestMimeType, ForeignLayerMimeTypes[0], digest, mirroredDigest, server.URL()))
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	header := make(http.Header)
+	header.Add("Docker-Content-Digest", manifestDigest)
+	header.Add("Content-Type", ManifestMimeType)
+
+	downstream.On("Handle", "HEAD", "/v2/library/nanoserver/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+	})
+	downstream.On("Handle", "GET", "/v2/library/nanoserver/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+	downstream.On("Handle", "GET", "/v2/library/nanoserver/manifests/"+manifestDigest, mock.Anything).Return(httpmock.Response{
+		Header: header,
+		Body:   manifest,
+	})
+	downstream.On("Handle", "GET", "/missing", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+	downstream.On("Handle", "GET", "/layer", mock.Anything).Return(httpmock.Response{
+		Body: layer,
+	})
+	downstream.On("Handle", "GET", "/v2/library/nanoserver/blobs/"+mirroredDigest, mock.Anything).Return(httpmock.Response{
+		Body: mirrored,
+	})
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "nanoserver",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	m, err := remote.Manifest()
+	assert.NoError(t, err)
+	assert.True(t, m.Layers[0].IsForeign())
+
+	var buf bytes.Buffer
+	assert.NoError(t, remote.DownloadLayer(digest, &buf))
+	assert.Equal(t, layer, buf.Bytes())
+
+	buf.Reset()
+	assert.NoError(t, remote.DownloadLayer(mirroredDigest, &buf))
+	assert.Equal(t, mirrored, buf.Bytes())
+}
+
+// TestRemoteHostPlatform tests that the host platform is selected from the
+// manifest list, unless the first platform is requested
+func TestRemoteHostPlatform(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	host := HostPlatform()
+	other := &Platform{OS: "windows", Architecture: "amd64"}
+
+	header := make(http.Header)
+	header.Add("Content-Type", ManifestListMimeType)
+
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "HEAD", "/v2/library/debian/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+	})
+	downstream.On("Handle", "GET", "/v2/library/debian/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+		Body: []byte(fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "%s",
+			"manifests": [
+				{"digest": "sha256:other", "platform": {"os": "%s", "architecture": "%s"}},
+				{"digest": "sha256:host", "platform": {"os": "%s", "architecture": "%s", "variant": "%s"}}
+			]
+		}`, ManifestListMimeType, other.OS, other.Architecture, host.OS, host.Architecture, host.Variant)),
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "debian",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	selected := []string{}
+	remote.OnPlatformSelected(func(p *Platform, host bool) {
+		selected = append(selected, fmt.Sprintf("%s %v", p, host))
+	})
+
+	digest, err := remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:host", digest)
+
+	// the selection is only reported once
+	_, err = remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{fmt.Sprintf("%s true", host)}, selected)
+
+	// the old behaviour takes the first manifest
+	remote.WithFirstPlatform()
+	digest, err = remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:other", digest)
+
+	// unless the platform is strict
+	remote.WithStrictPlatform()
+	digest, err = remote.Digest()
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:host", digest)
+}
+
+// TestRemoteConfig tests fetching the image config of a single-platform image
+func TestRemoteConfig(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	config := []byte(`{
+		"architecture": "amd64",
+		"os": "linux",
+		"config": {
+			"Env": ["PATH=/bin"],
+			"Entrypoint": ["/init"],
+			"Labels": {"foo": "bar"}
+		},
+		"rootfs": {"type": "layers", "diff_ids": ["sha256:abc"]}
+	}`)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+
+	manifest := []byte(fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "%s",
+		"config": {"mediaType": "application/vnd.docker.container.image.v1+json", "digest": "%s"},
+		"layers": []
+	}`, ManifestMimeType, digest))
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	header := make(http.Header)
+	header.Add("Docker-Content-Digest", manifestDigest)
+	header.Add("Content-Type", ManifestMimeType)
+
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "HEAD", "/v2/library/alpine/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Header: header,
+	})
+	downstream.On("Handle", "GET", "/v2/library/alpine/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Status: 404,
+	})
+	downstream.On("Handle", "GET", "/v2/library/alpine/manifests/"+manifestDigest, mock.Anything).Return(httpmock.Response{
+		Header: header,
+		Body:   manifest,
+	})
+	downstream.On("Handle", "GET", "/v2/library/alpine/blobs/"+digest, mock.Anything).Return(httpmock.Response{
+		Body: config,
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "alpine",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	c, err := remote.Config()
+	assert.NoError(t, err, "error fetching config")
+	assert.Equal(t, "amd64", c.Architecture)
+	assert.Equal(t, []string{"PATH=/bin"}, c.Config.Env)
+	assert.Equal(t, []string{"/init"}, c.Config.Entrypoint)
+	assert.Equal(t, map[string]string{"foo": "bar"}, c.Config.Labels)
+	assert.Equal(t, []string{"sha256:abc"}, c.RootFS.DiffIDs)
+
+	// a strict platform is validated against the config
+	remote.WithPlatform(&Platform{Architecture: "arm64", OS: "linux"})
+	remote.WithStrictPlatform()
+
+	_, err = remote.Manifest()
+	assert.EqualError(t, err, fmt.Sprintf("no multi-platform support: %s", url))
+
+	remote.WithPlatform(nil)
+	_, err = remote.Manifest()
+	if HostPlatform().Architecture == "amd64" {
+		assert.NoError(t, err)
+	} else {
+		assert.EqualError(t, err, fmt.Sprintf("%s is built for linux/amd64, not %s", url, HostPlatform()))
+	}
+}
+
+// TestRequestError tests that unexpected responses are kept in the error chain
+func TestRequestError(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	downstream := &httpmock.MockHandler{}
+	downstream.On("Handle", "HEAD", "/v2/library/private/manifests/latest", mock.Anything).Return(httpmock.Response{
+		Status: 401,
+	})
+
+	server := httpmock.NewServer(downstream)
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{Server: server})
+
+	url := URL{
+		Host:       server.URL(),
+		Name:       "private",
+		Repository: "library",
+		Tag:        "latest",
+	}
+
+	_, err := NewRemote(context.Background(), url, "")
+	assert.Error(t, err)
+
+	wrapped := fmt.Errorf("failed to connect: %w", err)
+
+	var requestErr *RequestError
+	assert.True(t, errors.As(wrapped, &requestErr))
+	assert.Equal(t, 401, requestErr.StatusCode)
+	assert.Equal(t, "HEAD", requestErr.Method)
+	assert.Equal(t, url.Endpoint("manifests", "latest"), requestErr.URL)
+}
+
+// TestRegistryErrors tests that the errors reported by registries are kept
+func TestRegistryErrors(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(403)
+
+		if r.Method == "GET" {
+			w.Write([]byte(`{"errors": [{"code": "DENIED", "message": "requested access to the resource is denied", "detail": null}]}`))
+		}
+	}))
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: server.URL, Repository: "library", Name: "secret", Tag: "latest"}
+
+	remote, err := NewRepository(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	_, err = remote.request("GET", ManifestMimeType, "manifests", "latest")
+
+	var requestErr *RequestError
+	assert.True(t, errors.As(err, &requestErr))
+	assert.Equal(t, 403, requestErr.StatusCode)
+	assert.Equal(t, "DENIED", requestErr.Code())
+	assert.Contains(t, err.Error(), "failed with 403 Forbidden: DENIED: requested access to the resource is denied")
+
+	// responses without body keep the status
+	_, err = remote.request("HEAD", ManifestMimeType, "manifests", "latest")
+	assert.True(t, errors.As(err, &requestErr))
+	assert.Empty(t, requestErr.Errors)
+	assert.Equal(t, "", requestErr.Code())
+}
+
+// TestLayerSizes tests that the uncompressed sizes of layers are determined
+// without downloading them
+func TestLayerSizes(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bytes.Repeat([]byte("roots"), 1000))
+	w.Close()
+
+	blob := gz.Bytes()
+	blobDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "layers": [
+		{"mediaType": "%s", "size": %d, "digest": "%s"},
+		{"mediaType": "%s", "size": 10240, "digest": "sha256:plain"},
+		{"mediaType": "%s", "size": 100, "digest": "sha256:zstd"}
+	]}`, ManifestMimeType, DockerGzipLayerMimeType, len(blob), blobDigest,
+		OCITarLayerMimeType, OCIZstdLayerMimeType))
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	downloaded := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/app/manifests/latest", "/v2/library/app/manifests/" + digest:
+			w.Header().Set("Content-Type", ManifestMimeType)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Write(manifest)
+		case "/v2/library/app/blobs/" + blobDigest:
+			downloaded = downloaded || r.Header.Get("Range") == ""
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: server.URL, Repository: "library", Name: "app", Tag: "latest"}
+
+	remote, err := NewRepository(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	sizes, err := remote.LayerSizes()
+	assert.NoError(t, err)
+	assert.False(t, downloaded)
+
+	assert.Equal(t, []LayerSize{
+		{Digest: blobDigest, MediaType: DockerGzipLayerMimeType, Size: int64(len(blob)), Uncompressed: 5000},
+		{Digest: "sha256:plain", MediaType: OCITarLayerMimeType, Size: 10240, Uncompressed: 10240},
+		{Digest: "sha256:zstd", MediaType: OCIZstdLayerMimeType, Size: 100},
+	}, sizes)
+}
+
+// TestVerifyTag tests that tags which moved away from the pinned digest are
+// detected, while digests of a platform within the tagged list are accepted
+func TestVerifyTag(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	img := registrytest.Image{OS: "linux", Architecture: "amd64"}
+	platform := registry.Push("library/app", "", img)
+	digest := registry.PushIndex("library/app", "1.0", img)
+
+	RegisterProvider("mock", &mockProvider{})
+
+	verify := func(pinned string) error {
+		url := URL{Host: registry.Host(), Repository: "library", Name: "app", Tag: "1.0", Digest: pinned}
+
+		remote, err := NewRepository(context.Background(), url, "")
+		assert.NoError(t, err)
+
+		return remote.VerifyTag()
+	}
+
+	assert.NoError(t, verify(""))
+	assert.NoError(t, verify(digest))
+	assert.NoError(t, verify(platform))
+
+	var mismatch *TagMismatchError
+	err := verify("sha256:" + strings.Repeat("b", 64))
+	assert.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, digest, mismatch.Current)
+}
+
+// TestPlatformManifests tests that the platforms of manifest lists and of
+// single manifests are listed with their digests
+func TestPlatformManifests(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	amd64 := registrytest.Image{OS: "linux", Architecture: "amd64"}
+	arm := registrytest.Image{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	single := registry.Push("library/app", "single", amd64)
+	registry.PushIndex("library/app", "multi", amd64, arm)
+
+	RegisterProvider("mock", &mockProvider{})
+
+	platforms := func(tag string) []PlatformManifest {
+		url := URL{Host: registry.Host(), Repository: "library", Name: "app", Tag: tag}
+
+		remote, err := NewRemote(context.Background(), url, "")
+		assert.NoError(t, err)
+
+		manifests, err := remote.PlatformManifests()
+		assert.NoError(t, err)
+
+		return manifests
+	}
+
+	manifests := platforms("multi")
+	assert.Len(t, manifests, 2)
+	assert.Equal(t, "linux/amd64", manifests[0].Platform.String())
+	assert.Equal(t, "linux/arm/v7", manifests[1].Platform.String())
+	assert.Equal(t, single, manifests[0].Digest)
+
+	manifests = platforms("single")
+	assert.Len(t, manifests, 1)
+	assert.Equal(t, "linux/amd64", manifests[0].Platform.String())
+	assert.Equal(t, single, manifests[0].Digest)
+	assert.NotZero(t, manifests[0].Size)
+}
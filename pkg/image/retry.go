@@ -0,0 +1,146 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultRetries is the number of times roots retries failed requests
+const DefaultRetries = 3
+
+// RetryDelay is the delay before the first retry, which is doubled for each
+// further retry, up to MaxRetryDelay. Delays requested by the registry
+// through Retry-After are honored up to MaxRetryDelay as well.
+var (
+	RetryDelay    = 500 * time.Millisecond
+	MaxRetryDelay = time.Minute
+)
+
+// retryable returns true if the request failed for a reason that might go
+// away on its own, like rate limits, server errors or network errors
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var re *RequestError
+	if errors.As(err, &re) {
+		return re.StatusCode == http.StatusTooManyRequests || re.StatusCode >= 500
+	}
+
+	var oe *net.OpError
+	if errors.As(err, &oe) {
+		return true
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryDelay returns the delay before the given retry (starting at 1), with
+// jitter, unless the registry asked for a specific delay
+func retryDelay(attempt int, err error) time.Duration {
+	var re *RequestError
+	if errors.As(err, &re) && re.RetryAfter > 0 {
+		return min(re.RetryAfter, MaxRetryDelay)
+	}
+
+	delay := RetryDelay << (attempt - 1)
+	if delay <= 0 || delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+
+	// spread the retries of concurrent downloads across the second half
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retry calls the given function until it succeeds, fails for a reason that
+// is not retryable or the retries are used up, waiting between the attempts
+func retry(ctx context.Context, retries int, fn func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if err == nil || attempt > retries || !retryable(err) {
+			return res, err
+		}
+
+		delay := retryDelay(attempt, err)
+		Logger.Warn("retrying request", "attempt", attempt, "retries", retries,
+			"delay", delay.Round(time.Millisecond), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// resumingBody is the body of a blob download, which is continued with a
+// range request if the connection breaks, as long as retries are left
+type resumingBody struct {
+	remote  *Remote
+	digest  string
+	body    io.ReadCloser
+	offset  int64
+	attempt int
+}
+
+// resuming returns the given body of the blob, which starts at the given
+// offset, resuming it on errors if the remote retries requests
+func (r *Remote) resuming(digest string, body io.ReadCloser, offset int64) io.ReadCloser {
+	if r.retries == 0 {
+		return body
+	}
+
+	return &resumingBody{remote: r, digest: digest, body: body, offset: offset}
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+
+	if err == nil || err == io.EOF || !retryable(err) || b.attempt >= b.remote.retries {
+		return n, err
+	}
+
+	b.attempt++
+
+	delay := retryDelay(b.attempt, err)
+	Logger.Warn("resuming download", "digest", b.digest, "offset", b.offset, "attempt", b.attempt,
+		"retries", b.remote.retries, "delay", delay.Round(time.Millisecond), "error", err)
+
+	select {
+	case <-b.remote.ctx.Done():
+		return n, err
+	case <-time.After(delay):
+	}
+
+	body, start, openErr := b.remote.openLayer(b.digest, b.offset)
+	if openErr != nil {
+		return n, err
+	}
+
+	// the registry starts over, which the reader cannot hide
+	if start != b.offset {
+		body.Close()
+		return n, err
+	}
+
+	b.body.Close()
+	b.body = body
+
+	return n, nil
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,91 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRetries tests that rate limits and server errors are retried and that
+// broken blob downloads are resumed with range requests
+func TestRetries(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	delay := RetryDelay
+	RetryDelay = time.Millisecond
+	defer func() { RetryDelay = delay }()
+
+	blob := bytes.Repeat([]byte("roots"), 1024)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	failures := map[string]int{"tags": 2, "blob": 1}
+	ranges := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/busybox/tags/list":
+			if failures["tags"] > 0 {
+				failures["tags"]--
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			w.Write([]byte(`{"name": "library/busybox", "tags": ["latest"]}`))
+		case "/v2/library/busybox/blobs/" + digest:
+			ranges = append(ranges, r.Header.Get("Range"))
+
+			// send half of the blob, then break the connection
+			if failures["blob"] > 0 {
+				failures["blob"]--
+				w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+				w.Write(blob[:len(blob)/2])
+				w.(http.Flusher).Flush()
+
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: server.URL, Repository: "library", Name: "busybox", Tag: "latest"}
+
+	remote, err := NewRepository(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	// without retries, the first failure is returned
+	_, err = remote.Tags()
+	assert.Error(t, err)
+
+	remote.WithRetries(3)
+
+	tags, err := remote.Tags()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, tags)
+
+	var buf bytes.Buffer
+	assert.NoError(t, remote.DownloadLayer(digest, &buf))
+	assert.Equal(t, blob, buf.Bytes())
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(blob)/2)}, ranges)
+
+	// the retries are used up eventually
+	failures["tags"] = 5
+
+	_, err = remote.Tags()
+	assert.Error(t, err)
+}
@@ -0,0 +1,379 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RefNameAnnotation holds the tag of a manifest in the index of an OCI layout
+const RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// ociLayoutVersion is the version of the OCI image layout written by roots
+const ociLayoutVersion = "1.0.0"
+
+// ociLayout is the content of the oci-layout file
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// ociIndex is the index.json of an OCI layout
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ManifestLayer `json:"manifests"`
+}
+
+// SaveOCILayout writes the image of the source into an OCI image layout in
+// the given directory and returns the digest of the saved manifest. The
+// manifest is tagged with the given name in the index (if not empty). An
+// existing layout is extended, blobs that are already present are kept.
+func SaveOCILayout(ctx context.Context, source Source, dir string, name string) (string, error) {
+	m, err := source.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	manifest, digest, err := manifestDocument(source, m)
+	if err != nil {
+		return "", err
+	}
+
+	blobs := filepath.Join(dir, "blobs")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", blobs, err)
+	}
+
+	layout, err := json.Marshal(&ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "oci-layout"), layout); err != nil {
+		return "", err
+	}
+
+	// artifacts may not have a config
+	descriptors := m.Layers
+	if m.Config.Digest != "" {
+		descriptors = append([]ManifestLayer{m.Config}, m.Layers...)
+	}
+
+	for _, d := range descriptors {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		if err := saveBlob(blobs, d.Digest, func(w io.Writer) error {
+			return source.DownloadLayer(d.Digest, w)
+		}); err != nil {
+			return "", fmt.Errorf("error saving %s: %w", d.Digest, err)
+		}
+	}
+
+	if err := saveBlob(blobs, digest, func(w io.Writer) error {
+		_, err := w.Write(manifest)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("error saving manifest: %v", err)
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = OCIManifestMimeType
+	}
+
+	descriptor := ManifestLayer{MediaType: mediaType, Size: len(manifest), Digest: digest}
+	if name != "" {
+		descriptor.Annotations = map[string]string{RefNameAnnotation: name}
+	}
+
+	if err := addToIndex(filepath.Join(dir, "index.json"), descriptor); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// manifestDocument returns the manifest as sent by the registry and its
+// digest. Sources without registry build their manifest, which is marshalled.
+func manifestDocument(source Source, m *Manifest) ([]byte, string, error) {
+	if remote, ok := source.(*Remote); ok {
+		manifest, err := remote.rawManifest(m.Digest)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return manifest, m.Digest, nil
+	}
+
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return nil, "", fmt.Errorf("error encoding manifest: %v", err)
+	}
+
+	return manifest, fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)), nil
+}
+
+// saveBlob writes the blob with the given digest to the blobs directory of a
+// layout, unless it is already present, verifying its digest
+func saveBlob(blobs string, digest string, write func(w io.Writer) error) error {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	path := filepath.Join(blobs, algorithm, hex)
+
+	if verifyFile(path, digest) == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h, err := newDigester(digest)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := write(io.MultiWriter(f, h)); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := checkDigest(digest, h); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// addToIndex adds the manifest to the index of a layout, replacing the one
+// with the same name (or digest, if the manifest has no name)
+func addToIndex(path string, descriptor ManifestLayer) error {
+	index := &ociIndex{SchemaVersion: 2, MediaType: OCIIndexMimeType}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, index); err != nil {
+			return fmt.Errorf("invalid index %s: %v", path, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	name := descriptor.Annotations[RefNameAnnotation]
+	manifests := []ManifestLayer{}
+
+	for _, m := range index.Manifests {
+		if name != "" && m.Annotations[RefNameAnnotation] == name {
+			continue
+		}
+
+		if name == "" && m.Digest == descriptor.Digest && m.Annotations[RefNameAnnotation] == "" {
+			continue
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	index.Manifests = append(manifests, descriptor)
+
+	data, err = json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// SaveArchive writes the image of the source into a tarball, as written by
+// `docker save`, and returns the id of the image (the digest of its config).
+// The image is tagged with the given name (e.g. debian:bookworm), if not
+// empty. The layers are stored uncompressed, as expected by `docker load`.
+func SaveArchive(ctx context.Context, source Source, file string, name string) (string, error) {
+	m, err := source.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	if m.IsArtifact() || m.Config.Digest == "" {
+		return "", fmt.Errorf("%s is not a container image", source)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(file), ".archive-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating %s: %v", file, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+
+	var config bytes.Buffer
+	if err := source.DownloadLayer(m.Config.Digest, &config); err != nil {
+		return "", fmt.Errorf("error downloading config: %w", err)
+	}
+
+	_, id, _ := strings.Cut(m.Config.Digest, ":")
+	entry := archiveManifest{Config: id + ".json"}
+
+	if err := writeTarFile(tw, entry.Config, config.Bytes()); err != nil {
+		return "", err
+	}
+
+	written := make(map[string]bool)
+
+	for _, l := range m.Layers {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		layer, err := saveArchiveLayer(tw, source, l, written)
+		if err != nil {
+			return "", fmt.Errorf("error saving %s: %w", l.Digest, err)
+		}
+
+		entry.Layers = append(entry.Layers, layer)
+	}
+
+	if name != "" {
+		entry.RepoTags = []string{name}
+	}
+
+	manifest, err := json.Marshal([]archiveManifest{entry})
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeTarFile(tw, "manifest.json", manifest); err != nil {
+		return "", err
+	}
+
+	// the legacy repositories file points to the top layer of each tag
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") && len(entry.Layers) > 0 {
+		top := path.Dir(entry.Layers[len(entry.Layers)-1])
+
+		repositories, err := json.Marshal(map[string]map[string]string{
+			name[:i]: {name[i+1:]: top},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if err := writeTarFile(tw, "repositories", repositories); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("error writing %s: %v", file, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("error writing %s: %v", file, err)
+	}
+
+	if err := os.Rename(f.Name(), file); err != nil {
+		return "", fmt.Errorf("error renaming %s: %v", file, err)
+	}
+
+	return m.Config.Digest, nil
+}
+
+// saveArchiveLayer writes the uncompressed layer to the archive, in a folder
+// named after its diff id, and returns the path of the layer in the archive
+func saveArchiveLayer(tw *tar.Writer, source Source, l ManifestLayer, written map[string]bool) (string, error) {
+	blob, err := os.CreateTemp(tempDir(), "layer-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(blob.Name())
+	defer blob.Close()
+
+	if err := source.DownloadLayer(l.Digest, blob); err != nil {
+		return "", err
+	}
+
+	stream, err := tarStream(blob, l.MediaType)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	// the size has to be known before the layer is added to the archive
+	uncompressed, err := os.CreateTemp(tempDir(), "layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(uncompressed.Name())
+	defer uncompressed.Close()
+
+	h := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(uncompressed, h), stream)
+	if err != nil {
+		return "", fmt.Errorf("error decompressing layer: %v", err)
+	}
+
+	id := fmt.Sprintf("%x", h.Sum(nil))
+	name := id + "/layer.tar"
+
+	if written[id] {
+		return name, nil
+	}
+
+	if _, err := uncompressed.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: id + "/", Mode: 0755}); err != nil {
+		return "", err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size}); err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(tw, uncompressed); err != nil {
+		return "", err
+	}
+
+	written[id] = true
+	return name, nil
+}
+
+// writeTarFile adds a file with the given content to the archive
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	h := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}
+
+	if err := tw.WriteHeader(h); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+
+	return nil
+}
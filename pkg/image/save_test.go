@@ -0,0 +1,124 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSaveOCILayout tests that images are written as OCI layout, with the
+// manifest as sent by the registry, and that the index is extended by tag
+func TestSaveOCILayout(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := registry.Push("team/app", "1.0", registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "world"}),
+	}})
+
+	RegisterProvider("mock", &mockProvider{})
+
+	dir := t.TempDir()
+
+	for _, tag := range []string{"1.0", "latest", "1.0"} {
+		url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+		remote, err := NewRemote(context.Background(), url, "")
+		assert.NoError(t, err)
+
+		saved, err := SaveOCILayout(context.Background(), remote, dir, tag)
+		assert.NoError(t, err)
+		assert.Equal(t, digest, saved)
+	}
+
+	layout, err := os.ReadFile(filepath.Join(dir, "oci-layout"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"imageLayoutVersion": "1.0.0"}`, string(layout))
+
+	index := &ociIndex{}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, index))
+
+	assert.Len(t, index.Manifests, 2)
+	assert.Equal(t, "latest", index.Manifests[0].Annotations[RefNameAnnotation])
+	assert.Equal(t, "1.0", index.Manifests[1].Annotations[RefNameAnnotation])
+	assert.Equal(t, digest, index.Manifests[1].Digest)
+
+	// the manifest, the config and the layer are stored by digest
+	blob := func(digest string) string {
+		return filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+	}
+
+	assert.NoError(t, verifyFile(blob(digest), digest))
+
+	m := &Manifest{}
+	data, err = os.ReadFile(blob(digest))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, m))
+
+	for _, d := range append([]ManifestLayer{m.Config}, m.Layers...) {
+		assert.NoError(t, verifyFile(blob(d.Digest), d.Digest))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+// TestSaveArchive tests that images are written as docker save tarballs,
+// which can be read again as archive
+func TestSaveArchive(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	registry.Push("team/app", "1.0", registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "world"}),
+		registrytest.Tar(map[string]string{"foo": "bar"}),
+	}})
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	config, err := remote.Config()
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "app.tar")
+	name := url.Familiar()
+
+	id, err := SaveArchive(context.Background(), remote, file, name)
+	assert.NoError(t, err)
+
+	archive, err := OpenArchive(file, name)
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	m, err := archive.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, id, m.Digest)
+	assert.Len(t, m.Layers, 2)
+
+	for i, l := range m.Layers {
+		assert.Equal(t, config.RootFS.DiffIDs[i], l.Digest)
+		assert.NoError(t, archive.DownloadLayer(l.Digest, io.Discard))
+	}
+
+	repositories := map[string]map[string]string{}
+	assert.NoError(t, json.Unmarshal(archive.read("repositories"), &repositories))
+	assert.Equal(t, strings.TrimPrefix(config.RootFS.DiffIDs[1], "sha256:"), repositories[registry.Host()+"/team/app"]["1.0"])
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// schema1MimeTypes are the mime types of the deprecated schema version 1
+// manifests, which are only pulled if allowed through WithSchema1
+var schema1MimeTypes = []string{
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+	"application/vnd.docker.distribution.manifest.v1+json",
+}
+
+// schema1LayerMimeType is the mime type of the layers of schema version 1
+// manifests, which are always gzip compressed tarballs
+const schema1LayerMimeType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+// schema1Manifest represents a schema version 1 manifest, which lists the
+// layers from the top to the base, together with their history
+// * https://github.com/distribution/distribution/blob/v2.8.3/docs/spec/manifest-v2-1.md
+type schema1Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Architecture  string `json:"architecture"`
+	FSLayers      []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// schema1History is the part of the v1Compatibility of each layer that is
+// used by roots, the one of the top layer also holds the config of the image
+type schema1History struct {
+	Created         *time.Time `json:"created"`
+	Author          string     `json:"author"`
+	Throwaway       bool       `json:"throwaway"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// legacyManifest converts the given schema version 1 manifest with the given
+// content type to a manifest with the layers in the order they are applied
+func (r *Remote) legacyManifest(body []byte, contentType string, digest string) (*Manifest, error) {
+	if !r.schema1 {
+		return nil, fmt.Errorf("%s only has a schema version 1 manifest, which is deprecated and has to be allowed explicitly", r.url)
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+
+	m, c, err := parseSchema1(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+
+	m.Digest = digest
+	m.MediaType = strings.TrimSpace(mediaType)
+	r.legacyConfig = c
+
+	return m, nil
+}
+
+// parseSchema1 returns the manifest and the config of the given schema
+// version 1 manifest. Throwaway layers, which do not change the file system,
+// are skipped.
+func parseSchema1(body []byte) (*Manifest, *ImageConfig, error) {
+	legacy := &schema1Manifest{}
+	if err := json.Unmarshal(body, legacy); err != nil {
+		return nil, nil, err
+	}
+
+	if legacy.SchemaVersion != 1 {
+		return nil, nil, fmt.Errorf("unexpected schema version %d", legacy.SchemaVersion)
+	}
+
+	if len(legacy.FSLayers) == 0 || len(legacy.FSLayers) != len(legacy.History) {
+		return nil, nil, fmt.Errorf("%d layers with %d history entries", len(legacy.FSLayers), len(legacy.History))
+	}
+
+	c := &ImageConfig{}
+	if err := json.Unmarshal([]byte(legacy.History[0].V1Compatibility), c); err != nil {
+		return nil, nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	if c.Architecture == "" {
+		c.Architecture = legacy.Architecture
+	}
+
+	if c.OS == "" {
+		c.OS = "linux"
+	}
+
+	m := &Manifest{SchemaVersion: 1, Layers: []ManifestLayer{}}
+	c.History = make([]History, 0, len(legacy.History))
+
+	for i := len(legacy.FSLayers) - 1; i >= 0; i-- {
+		h := &schema1History{}
+		if err := json.Unmarshal([]byte(legacy.History[i].V1Compatibility), h); err != nil {
+			return nil, nil, fmt.Errorf("invalid history: %v", err)
+		}
+
+		c.History = append(c.History, History{
+			Created:    h.Created,
+			CreatedBy:  strings.Join(h.ContainerConfig.Cmd, " "),
+			Author:     h.Author,
+			EmptyLayer: h.Throwaway,
+		})
+
+		if h.Throwaway {
+			continue
+		}
+
+		m.Layers = append(m.Layers, ManifestLayer{
+			MediaType: schema1LayerMimeType,
+			Digest:    legacy.FSLayers[i].BlobSum,
+		})
+	}
+
+	return m, c, nil
+}
@@ -0,0 +1,91 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSchema1 tests that schema version 1 manifests are refused, unless
+// allowed, in which case the layers are applied from the base to the top
+func TestSchema1(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	layer := func(files map[string]string) string {
+		var buf bytes.Buffer
+
+		gz := gzip.NewWriter(&buf)
+		gz.Write(registrytest.Tar(files))
+		gz.Close()
+
+		return registry.AddBlob("legacy/app", buf.Bytes())
+	}
+
+	base := layer(map[string]string{"hello": "base"})
+	top := layer(map[string]string{"hello": "top"})
+	empty := layer(map[string]string{})
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 1,
+		"name":          "legacy/app",
+		"tag":           "1.0",
+		"architecture":  "amd64",
+		"fsLayers": []map[string]string{
+			{"blobSum": empty}, {"blobSum": top}, {"blobSum": base},
+		},
+		"history": []map[string]string{
+			{"v1Compatibility": `{"architecture": "amd64", "os": "linux", "config": {"Cmd": ["/app"]}, "throwaway": true}`},
+			{"v1Compatibility": `{"container_config": {"Cmd": ["/bin/sh", "-c", "echo top > hello"]}}`},
+			{"v1Compatibility": `{"created": "2015-01-01T00:00:00Z"}`},
+		},
+	})
+	assert.NoError(t, err)
+
+	registry.AddManifest("legacy/app", "1.0", schema1MimeTypes[0], manifest)
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: registry.Host(), Repository: "legacy", Name: "app", Tag: "1.0"}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	_, err = remote.Manifest()
+	assert.ErrorContains(t, err, "schema version 1")
+
+	remote.WithSchema1()
+
+	m, err := remote.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{base, top}, []string{m.Layers[0].Digest, m.Layers[1].Digest})
+
+	c, err := remote.Config()
+	assert.NoError(t, err)
+	assert.Equal(t, "amd64", c.Architecture)
+	assert.Equal(t, []string{"/app"}, c.Config.Cmd)
+	assert.Len(t, c.History, 3)
+	assert.True(t, c.History[2].EmptyLayer)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+
+	result, err := store.Extract(context.Background(), remote, dst, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Layers)
+
+	content, err := os.ReadFile(filepath.Join(dst, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "top", string(content))
+}
@@ -0,0 +1,250 @@
+package image
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version parsed from a tag (e.g. v1.2.3 or 12.4).
+// Missing minor and patch versions are zero, build metadata is ignored.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+
+	// parts is the number of numeric parts found in the tag
+	parts int
+}
+
+// ParseVersion parses the given tag as semantic version
+func ParseVersion(tag string) (*Version, error) {
+	s := strings.TrimPrefix(tag, "v")
+	s, _, _ = strings.Cut(s, "+")
+
+	v := &Version{}
+	s, v.Prerelease, _ = strings.Cut(s, "-")
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("not a semantic version: %s", tag)
+	}
+
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return nil, fmt.Errorf("not a semantic version: %s", tag)
+		}
+
+		*numbers[i] = n
+	}
+
+	v.parts = len(parts)
+	return v, nil
+}
+
+func (v *Version) String() string {
+	if v.Prerelease != "" {
+		return fmt.Sprintf("%d.%d.%d-%s", v.Major, v.Minor, v.Patch, v.Prerelease)
+	}
+
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 if the version is lower, equal or higher than
+// the other version. Prereleases are lower than the release.
+func (v *Version) Compare(other *Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+// comparePrerelease compares the dot separated identifiers of prereleases,
+// numerically if both are numbers
+func comparePrerelease(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			return sign(an - bn)
+		case aerr == nil && berr != nil:
+			return -1
+		case aerr != nil && berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+
+	return sign(len(as) - len(bs))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
+
+// comparator is a single comparison of a constraint (e.g. >=1.2.0)
+type comparator struct {
+	op      string
+	version *Version
+}
+
+func (c *comparator) check(v *Version) bool {
+	cmp := v.Compare(c.version)
+
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	}
+
+	return cmp == 0
+}
+
+// Constraint is a set of version ranges, like ">=1.2, <2 || ~3.1". The
+// comparators separated by commas (or spaces) must all match, one of the
+// alternatives separated by || has to match.
+//
+// Supported are =, !=, >, >=, <, <=, ~ (patch updates, or minor updates if
+// only the major version is given) and ^ (updates that do not change the
+// leftmost non-zero part). Partial versions without operator match all
+// versions starting with them (e.g. 1.2 matches 1.2.5). Prereleases are only
+// matched if the constraint mentions a prerelease.
+type Constraint struct {
+	alternatives [][]*comparator
+	prereleases  bool
+}
+
+// ParseConstraint parses the given version constraint
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{}
+
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(alternative, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty version constraint: %s", s)
+		}
+
+		comparators := []*comparator{}
+
+		for _, f := range fields {
+			expanded, err := parseComparator(f)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range expanded {
+				c.prereleases = c.prereleases || e.version.Prerelease != ""
+			}
+
+			comparators = append(comparators, expanded...)
+		}
+
+		c.alternatives = append(c.alternatives, comparators)
+	}
+
+	return c, nil
+}
+
+// parseComparator parses a single comparison, ranges like ~1.2 are expanded
+// into a lower and an upper bound
+func parseComparator(s string) ([]*comparator, error) {
+	if s == "*" || s == "x" {
+		return []*comparator{{op: ">=", version: &Version{}}}, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, strings.TrimPrefix(s, prefix)
+			break
+		}
+	}
+
+	v, err := ParseVersion(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint: %v", err)
+	}
+
+	lower := &comparator{op: ">=", version: v}
+
+	switch {
+	case op == "~" && v.parts == 1:
+		return []*comparator{lower, {op: "<", version: &Version{Major: v.Major + 1}}}, nil
+	case op == "~":
+		return []*comparator{lower, {op: "<", version: &Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	case op == "^" && v.Major > 0:
+		return []*comparator{lower, {op: "<", version: &Version{Major: v.Major + 1}}}, nil
+	case op == "^" && v.Minor > 0:
+		return []*comparator{lower, {op: "<", version: &Version{Minor: v.Minor + 1}}}, nil
+	case op == "^":
+		return []*comparator{lower, {op: "<", version: &Version{Patch: v.Patch + 1}}}, nil
+	case (op == "" || op == "=") && v.parts == 1:
+		return []*comparator{lower, {op: "<", version: &Version{Major: v.Major + 1}}}, nil
+	case (op == "" || op == "=") && v.parts == 2:
+		return []*comparator{lower, {op: "<", version: &Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	}
+
+	return []*comparator{{op: op, version: v}}, nil
+}
+
+// Check returns true if the version satisfies the constraint
+func (c *Constraint) Check(v *Version) bool {
+	if v.Prerelease != "" && !c.prereleases {
+		return false
+	}
+
+	for _, comparators := range c.alternatives {
+		matched := true
+
+		for _, cmp := range comparators {
+			if !cmp.check(v) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseVersion tests parsing tags as semantic versions
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.2.3-rc.1+build")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3-rc.1", v.String())
+
+	v, err = ParseVersion("12")
+	assert.NoError(t, err)
+	assert.Equal(t, "12.0.0", v.String())
+
+	for _, tag := range []string{"latest", "bookworm", "1.2.3.4", "1..2", "", "v"} {
+		_, err = ParseVersion(tag)
+		assert.Error(t, err, tag)
+	}
+}
+
+// TestVersionCompare tests the ordering of versions
+func TestVersionCompare(t *testing.T) {
+	ordered := []string{"0.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10", "2"}
+
+	for i := 1; i < len(ordered); i++ {
+		a, _ := ParseVersion(ordered[i-1])
+		b, _ := ParseVersion(ordered[i])
+
+		assert.Equal(t, -1, a.Compare(b), "%s < %s", a, b)
+		assert.Equal(t, 1, b.Compare(a), "%s > %s", b, a)
+		assert.Equal(t, 0, a.Compare(a))
+	}
+}
+
+// TestConstraint tests matching versions against constraints
+func TestConstraint(t *testing.T) {
+	cases := map[string]map[string]bool{
+		">=1.2, <2":    {"1.2.0": true, "1.9.9": true, "2.0.0": false, "1.1": false},
+		"~1.2":         {"1.2.9": true, "1.3.0": false},
+		"~1":           {"1.9": true, "2.0": false},
+		"^1.2":         {"1.9": true, "2.0": false, "1.1": false},
+		"^0.2.3":       {"0.2.9": true, "0.3.0": false},
+		"1.2":          {"1.2.7": true, "1.3": false},
+		"=1.2.3":       {"1.2.3": true, "1.2.4": false},
+		"!=1.2.3":      {"1.2.3": false, "1.2.4": true},
+		"<1 || >=3":    {"0.5": true, "2": false, "3.1": true},
+		"*":            {"0.0.1": true, "1.2.3-rc.1": false},
+		">=1.0.0-rc.1": {"1.0.0-rc.2": true, "1.0.0-beta": false, "1.0.0": true},
+	}
+
+	for constraint, versions := range cases {
+		c, err := ParseConstraint(constraint)
+		assert.NoError(t, err, constraint)
+
+		for version, expected := range versions {
+			v, err := ParseVersion(version)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, c.Check(v), "%s %s", version, constraint)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=foo", "1.2 ||"} {
+		_, err := ParseConstraint(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,191 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ShareMode decides how the regular files of cached layers are shared
+// between the destinations they are extracted to
+type ShareMode string
+
+const (
+
+	// ShareNone writes each file to each destination
+	ShareNone ShareMode = ""
+
+	// ShareHardlink links the files of the destinations to the exploded
+	// layers in the cache, so that all of them share the same inode. Files
+	// changed in place (instead of being replaced) change for all of them.
+	ShareHardlink ShareMode = "hardlink"
+
+	// ShareReflink clones the files of the exploded layers, which shares
+	// their blocks until they are changed (e.g. on btrfs or xfs). Files are
+	// copied on filesystems without support for reflinks.
+	ShareReflink ShareMode = "reflink"
+)
+
+// ParseShareMode returns the share mode with the given name ("none",
+// "hardlink" or "reflink")
+func ParseShareMode(name string) (ShareMode, error) {
+	switch name {
+	case "", "none":
+		return ShareNone, nil
+	case string(ShareHardlink):
+		return ShareHardlink, nil
+	case string(ShareReflink):
+		return ShareReflink, nil
+	default:
+		return ShareNone, fmt.Errorf("unknown share mode: %s", name)
+	}
+}
+
+// ExplodedPath returns the path of the directory holding the extracted
+// layer with the given digest, whose files are shared with destinations
+func (s *Store) ExplodedPath(digest string) string {
+	return filepath.Join(s.Path, "exploded", digest)
+}
+
+// checkShareOptions returns an error if the files cannot be shared with the
+// given options
+func checkShareOptions(opts *ExtractOptions) error {
+	if opts.Share == ShareNone {
+		return nil
+	}
+
+	if opts.Stream || opts.Unpacker != nil {
+		return errors.New("sharing files requires layers to be cached and extracted into a directory")
+	}
+
+	// hard links share the owner and label of their inode, which would be
+	// changed for all destinations
+	if opts.Share == ShareHardlink && (len(opts.UIDMap) > 0 || len(opts.GIDMap) > 0 || opts.SELinuxLabel != "") {
+		return errors.New("sharing files through hard links cannot be combined with id maps or SELinux labels")
+	}
+
+	return nil
+}
+
+// explodeLayer extracts the given cached layer into the cache, unless this
+// happened already, and returns the resulting directory. The download lock
+// of the layer is held meanwhile, so each layer is only exploded once.
+func (s *Store) explodeLayer(ctx context.Context, file string, l ManifestLayer) (string, error) {
+	downloadLock, err := s.acquireLock(ctx, s.DownloadLockPath(l.Digest), nil)
+	if err != nil {
+		return "", err
+	}
+	defer downloadLock.MustUnlock()
+
+	dir := s.ExplodedPath(l.Digest)
+
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	// the directory is only moved into place once complete, the modes of
+	// its directories are left alone, so it can be purged
+	partial := dir + ".partial"
+
+	if err := os.RemoveAll(partial); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	x := newExtraction(partial, &ExtractOptions{})
+
+	if err := s.applyCachedLayer(ctx, file, l, x, partial); err != nil {
+		os.RemoveAll(partial)
+		return "", fmt.Errorf("error exploding %s: %v", l.Digest, err)
+	}
+
+	if err := os.Rename(partial, dir); err != nil {
+		os.RemoveAll(partial)
+		return "", err
+	}
+
+	Logger.Debug("exploded layer", "digest", l.Digest, "duration", time.Since(start).Round(time.Millisecond))
+
+	return dir, nil
+}
+
+// shareFile links or clones the given file from the exploded layer, instead
+// of writing it. Returns false if the file is not part of the exploded
+// layer (e.g. as it was replaced by a later entry of the same path).
+func (x *extraction) shareFile(file string, h *tar.Header) (bool, error) {
+	source := filepath.Join(x.exploded, h.Name)
+
+	info, err := os.Lstat(source)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != h.Size {
+		return false, nil
+	}
+
+	// when merging, files linked by an earlier pull are kept
+	if existing, err := os.Lstat(file); err == nil {
+		if x.opts.Merge && os.SameFile(info, existing) {
+			x.mu.Lock()
+			x.result.Unchanged++
+			x.mu.Unlock()
+
+			return true, nil
+		}
+
+		if !existing.IsDir() || x.opts.Merge {
+			if err := os.RemoveAll(file); err != nil {
+				return true, fmt.Errorf("error replacing %s: %v", file, err)
+			}
+		}
+	}
+
+	if x.opts.Share == ShareHardlink {
+		if err := os.Link(source, file); err != nil {
+			if errors.Is(err, syscall.EXDEV) {
+				return true, fmt.Errorf("error linking %s: the cache is on another filesystem", file)
+			}
+
+			return true, fmt.Errorf("error linking %s: %v", file, err)
+		}
+	} else if err := cloneFile(source, file, h.FileInfo().Mode()); err != nil {
+		return true, fmt.Errorf("error cloning %s: %v", file, err)
+	}
+
+	x.mu.Lock()
+	x.result.SharedFiles++
+	x.mu.Unlock()
+
+	return true, nil
+}
+
+// cloneFile creates the given file as a reflink of the source, or as a
+// copy if the filesystem does not support reflinks
+func cloneFile(source string, file string, mode os.FileMode) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := reflink(dst, src); err != nil {
+		if _, err := io.Copy(dst, src); err != nil {
+			return err
+		}
+	}
+
+	return dst.Close()
+}
@@ -0,0 +1,80 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestShareFiles tests that the files of destinations are linked or cloned
+// from the exploded layers, which are purged with their layers
+func TestShareFiles(t *testing.T) {
+	files := map[string][]byte{
+		"etc/passwd": []byte("root"),
+		"etc/motd":   []byte("hello"),
+		"etc/hosts":  []byte("localhost"),
+	}
+
+	source := newLayeredSource(
+		tarball(t, files, "etc/", "etc/passwd", "etc/motd"),
+		tarball(t, files, "etc/hosts"),
+	)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	// destinations sharing hard links have the same inodes
+	dsts := []string{t.TempDir(), t.TempDir()}
+
+	for _, dst := range dsts {
+		result, err := store.Extract(context.Background(), source, dst, &ExtractOptions{Share: ShareHardlink})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, result.SharedFiles)
+	}
+
+	for _, name := range []string{"etc/passwd", "etc/hosts"} {
+		a, err := os.Stat(filepath.Join(dsts[0], name))
+		assert.NoError(t, err)
+
+		b, err := os.Stat(filepath.Join(dsts[1], name))
+		assert.NoError(t, err)
+
+		assert.True(t, os.SameFile(a, b))
+	}
+
+	// clones are separate files (copies without support for reflinks)
+	cloned := t.TempDir()
+
+	result, err := store.Extract(context.Background(), source, cloned, &ExtractOptions{Share: ShareReflink})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.SharedFiles)
+
+	content, err := os.ReadFile(filepath.Join(cloned, "etc/motd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	a, _ := os.Stat(filepath.Join(dsts[0], "etc/motd"))
+	b, _ := os.Stat(filepath.Join(cloned, "etc/motd"))
+	assert.False(t, os.SameFile(a, b))
+
+	// hard links cannot be combined with id maps
+	_, err = store.Extract(context.Background(), source, t.TempDir(), &ExtractOptions{
+		Share:  ShareHardlink,
+		UIDMap: IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+	})
+	assert.ErrorContains(t, err, "cannot be combined")
+
+	// the exploded layers are kept as long as the destinations exist
+	assert.NoError(t, store.Purge())
+	assert.DirExists(t, store.ExplodedPath(source.manifest.Layers[0].Digest))
+
+	for _, dst := range append(dsts, cloned) {
+		assert.NoError(t, os.RemoveAll(dst))
+	}
+
+	assert.NoError(t, store.Purge())
+	assert.NoDirExists(t, store.ExplodedPath(source.manifest.Layers[0].Digest))
+}
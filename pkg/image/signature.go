@@ -0,0 +1,500 @@
+package image
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The media type and annotations of signatures created by cosign, which are
+// stored as image tagged sha256-<hex>.sig next to the signed image
+const (
+	CosignSignatureMimeType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// cosignSignatureType is the type of the payload signed by cosign
+const cosignSignatureType = "cosign container image signature"
+
+// The extensions of Fulcio certificates holding the OIDC issuer of the
+// identity, as raw string (v1) or as DER encoded UTF8String (v2)
+var (
+	fulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// SignatureVerifier checks the cosign signatures of images, either against a
+// public key, or against the identity in the certificate of keyless
+// signatures, which is issued by Fulcio and recorded in Rekor
+type SignatureVerifier struct {
+
+	// Key is the public key of the signer (ECDSA, RSA or Ed25519), as
+	// created by `cosign generate-key-pair`
+	Key crypto.PublicKey
+
+	// Identity and Issuer select keyless signatures by the subject of the
+	// certificate (e.g. an email address or the URI of a workflow) and the
+	// OIDC issuer that verified it (e.g. https://github.com/login/oauth)
+	Identity string
+	Issuer   string
+
+	// Roots are the certificate authorities trusted to issue the
+	// certificates of keyless signatures (the Fulcio root and intermediate)
+	Roots *x509.CertPool
+
+	// RekorKey is the public key of the transparency log, whose signed
+	// entry timestamp is required for keyless signatures, and verified for
+	// signatures made with a key if given
+	RekorKey crypto.PublicKey
+}
+
+// SignatureError is returned if an image has no valid signature
+type SignatureError struct {
+	Image  string
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("signature verification of %s failed: %s", e.Image, e.Reason)
+}
+
+// simpleSigning is the payload signed by cosign
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// rekorBundle is the proof of inclusion in the transparency log, which is
+// attached to signatures by cosign
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is the log entry signed by Rekor, its fields are in the order
+// of the canonical JSON encoding the signature is made over
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the body of the log entry of a signature
+type hashedRekord struct {
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// LoadPublicKey reads the PEM encoded public key at the given path
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found in %s", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return key, nil
+}
+
+// LoadCertificates reads the PEM encoded certificates at the given paths
+// into a pool
+func LoadCertificates(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in %s", path)
+		}
+	}
+
+	return pool, nil
+}
+
+// LoadSigstoreRoot configures the verifier with the Fulcio certificates and
+// the Rekor key in the given directory, as found in the targets directory of
+// the TUF repository of sigstore (e.g. ~/.sigstore/root/targets after
+// `cosign initialize`). Missing files are skipped.
+func (v *SignatureVerifier) LoadSigstoreRoot(dir string) error {
+	certificates := []string{}
+
+	for _, name := range []string{"fulcio_v1.crt.pem", "fulcio_intermediate_v1.crt.pem"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			certificates = append(certificates, filepath.Join(dir, name))
+		}
+	}
+
+	if len(certificates) > 0 {
+		roots, err := LoadCertificates(certificates...)
+		if err != nil {
+			return err
+		}
+
+		v.Roots = roots
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "rekor.pub")); err == nil {
+		key, err := LoadPublicKey(filepath.Join(dir, "rekor.pub"))
+		if err != nil {
+			return err
+		}
+
+		v.RekorKey = key
+	}
+
+	if len(certificates) == 0 && v.RekorKey == nil {
+		return fmt.Errorf("no sigstore certificates or keys found in %s", dir)
+	}
+
+	return nil
+}
+
+// Verify returns an error if the image of the remote has no valid signature.
+// Signatures of the manifest list and of the manifest of the bound platform
+// are accepted. Missing, tampered or unverifiable signatures are returned
+// as SignatureError. The remote is pinned to the digest of its reference,
+// which its manifests are verified against when they are fetched.
+func (v *SignatureVerifier) Verify(r *Remote) error {
+	if v.Key == nil && v.Identity == "" {
+		return errors.New("signatures are verified with a key or a certificate identity")
+	}
+
+	if v.Key == nil && (v.Issuer == "" || v.Roots == nil || v.RekorKey == nil) {
+		return errors.New("keyless signatures require an issuer, the Fulcio roots and the Rekor key")
+	}
+
+	digests, err := r.signedDigests()
+	if err != nil {
+		return err
+	}
+
+	reasons := []string{}
+
+	for _, digest := range digests {
+		signatures, err := r.signatures(digest)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range signatures {
+			err := v.verifyLayer(r, digest, l)
+			if err == nil {
+				return nil
+			}
+
+			reasons = append(reasons, err.Error())
+		}
+	}
+
+	if len(reasons) == 0 {
+		return &SignatureError{Image: r.url.String(), Reason: "no signatures found"}
+	}
+
+	return &SignatureError{Image: r.url.String(), Reason: strings.Join(reasons, "; ")}
+}
+
+// signedDigests returns the digests a signature may be attached to: the one
+// the reference points to (e.g. a manifest list) and the one of the manifest
+// of the platform. The remote is pinned to the former, so the manifest of
+// the platform is selected from the list with that digest, and the image
+// that is verified is the one pulled afterwards.
+func (r *Remote) signedDigests() ([]string, error) {
+	reference := r.pinnedDigest()
+
+	if reference == "" {
+		accept := strings.Join(append(append([]string{}, manifestListMimeTypes...), manifestMimeTypes...), ", ")
+
+		digest, err := r.referenceDigest(accept)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+
+		reference = digest
+	}
+
+	r.Pin(reference)
+
+	digest, err := r.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	if digest == reference {
+		return []string{digest}, nil
+	}
+
+	return []string{reference, digest}, nil
+}
+
+// signatures returns the signature layers of the cosign signature image of
+// the given digest, without signature image there are none
+func (r *Remote) signatures(digest string) ([]ManifestLayer, error) {
+	tag := strings.Replace(digest, ":", "-", 1) + ".sig"
+
+	res, err := r.request("GET", strings.Join(manifestMimeTypes, ", "), "manifests", tag)
+
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) && requestErr.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error requesting signatures of %s: %w", digest, err)
+	}
+
+	m := &Manifest{}
+	if err := r.unmarshal(res, m); err != nil {
+		return nil, fmt.Errorf("error parsing signatures: %v", err)
+	}
+
+	signatures := []ManifestLayer{}
+	for _, l := range m.Layers {
+		if l.MediaType == CosignSignatureMimeType {
+			signatures = append(signatures, l)
+		}
+	}
+
+	return signatures, nil
+}
+
+// verifyLayer checks that the given signature layer signs the given digest
+func (v *SignatureVerifier) verifyLayer(r *Remote, digest string, l ManifestLayer) error {
+	var payload bytes.Buffer
+	if err := r.DownloadLayer(l.Digest, &payload); err != nil {
+		return err
+	}
+
+	signed := &simpleSigning{}
+	if err := json.Unmarshal(payload.Bytes(), signed); err != nil {
+		return fmt.Errorf("invalid payload %s: %v", l.Digest, err)
+	}
+
+	if signed.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unknown signature type %q", signed.Critical.Type)
+	}
+
+	if signed.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature of %s attached to %s", signed.Critical.Image.DockerManifestDigest, digest)
+	}
+
+	encoded := l.Annotations[cosignSignatureAnnotation]
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("invalid signature in %s", l.Digest)
+	}
+
+	// the log entry is required for keyless signatures, as the certificate
+	// is only valid at the time it was recorded
+	var recorded *time.Time
+
+	if v.RekorKey != nil {
+		t, err := v.verifyBundle(l.Annotations[cosignBundleAnnotation], encoded, payload.Bytes())
+		if err != nil {
+			return err
+		}
+
+		recorded = &t
+	}
+
+	if v.Key != nil {
+		return verifySignature(v.Key, payload.Bytes(), signature)
+	}
+
+	certificate, err := v.verifyCertificate(l.Annotations, *recorded)
+	if err != nil {
+		return err
+	}
+
+	return verifySignature(certificate.PublicKey, payload.Bytes(), signature)
+}
+
+// verifyBundle checks that the signature was recorded in the transparency
+// log and returns the time it was recorded at
+func (v *SignatureVerifier) verifyBundle(annotation string, signature string, payload []byte) (time.Time, error) {
+	if annotation == "" {
+		return time.Time{}, errors.New("signature was not recorded in the transparency log")
+	}
+
+	bundle := &rekorBundle{}
+	if err := json.Unmarshal([]byte(annotation), bundle); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log bundle: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(v.RekorKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if id := sha256.Sum256(der); bundle.Payload.LogID != hex.EncodeToString(id[:]) {
+		return time.Time{}, fmt.Errorf("transparency log entry of unknown log %s", bundle.Payload.LogID)
+	}
+
+	entry, err := json.Marshal(&bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := verifySignature(v.RekorKey, entry, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %v", err)
+	}
+
+	// the entry has to be the one of this signature
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %v", err)
+	}
+
+	rekord := &hashedRekord{}
+	if err := json.Unmarshal(body, rekord); err != nil {
+		return time.Time{}, fmt.Errorf("invalid transparency log entry: %v", err)
+	}
+
+	hash := sha256.Sum256(payload)
+
+	if rekord.Spec.Signature.Content != signature || rekord.Spec.Data.Hash.Value != hex.EncodeToString(hash[:]) {
+		return time.Time{}, errors.New("transparency log entry does not match the signature")
+	}
+
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// verifyCertificate checks that the certificate of a keyless signature was
+// issued to the identity by one of the trusted roots, at the given time
+func (v *SignatureVerifier) verifyCertificate(annotations map[string]string, at time.Time) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(annotations[cosignCertificateAnnotation]))
+	if block == nil {
+		return nil, errors.New("signature has no certificate")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(annotations[cosignChainAnnotation]))
+
+	if _, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %v", err)
+	}
+
+	identities := append([]string{}, certificate.EmailAddresses...)
+	for _, uri := range certificate.URIs {
+		identities = append(identities, uri.String())
+	}
+
+	found := false
+	for _, identity := range identities {
+		found = found || identity == v.Identity
+	}
+
+	if !found {
+		return nil, fmt.Errorf("certificate issued to %s, not %s", strings.Join(identities, ", "), v.Identity)
+	}
+
+	if issuer := certificateIssuer(certificate); issuer != v.Issuer {
+		return nil, fmt.Errorf("identity verified by %s, not %s", issuer, v.Issuer)
+	}
+
+	return certificate, nil
+}
+
+// certificateIssuer returns the OIDC issuer recorded in a Fulcio certificate
+func certificateIssuer(certificate *x509.Certificate) string {
+	for _, ext := range certificate.Extensions {
+		if ext.Id.Equal(fulcioIssuerV2) {
+			var issuer string
+			if _, err := asn1.UnmarshalWithParams(ext.Value, &issuer, "utf8"); err == nil {
+				return issuer
+			}
+		}
+	}
+
+	for _, ext := range certificate.Extensions {
+		if ext.Id.Equal(fulcioIssuerV1) {
+			return string(ext.Value)
+		}
+	}
+
+	return ""
+}
+
+// verifySignature checks the signature of the data with the given key, as
+// created by cosign and Rekor (SHA-256 digests for ECDSA and RSA)
+func verifySignature(key crypto.PublicKey, data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,310 @@
+package image
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// signatureManifest returns the manifest of a cosign signature image with
+// the given signature layer
+func signatureManifest(registry *registrytest.Registry, repository string, payload []byte, annotations map[string]string) []byte {
+	config := []byte("{}")
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     registrytest.ManifestMimeType,
+		"config": ManifestLayer{
+			MediaType: registrytest.ConfigMimeType,
+			Size:      len(config),
+			Digest:    registry.AddBlob(repository, config),
+		},
+		"layers": []ManifestLayer{{
+			MediaType:   CosignSignatureMimeType,
+			Size:        len(payload),
+			Digest:      registry.AddBlob(repository, payload),
+			Annotations: annotations,
+		}},
+	})
+
+	return data
+}
+
+// cosignPayload returns the payload signed by cosign for the given digest
+func cosignPayload(digest string) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"team/app"},`+
+		`"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},`+
+		`"optional":null}`, digest))
+}
+
+// signData signs the given data with the key, like cosign
+func signData(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	assert.NoError(t, err)
+
+	return signature
+}
+
+// newKey generates an ECDSA key, as used by cosign
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	return key
+}
+
+// TestVerifySignatureWithKey tests that images signed with a key are
+// accepted, while unsigned images and other keys are refused
+func TestVerifySignatureWithKey(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := registry.Push("team/app", "1.0", registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "world"}),
+	}})
+
+	registry.Push("team/app", "unsigned", registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "unsigned"}),
+	}})
+
+	key := newKey(t)
+	payload := cosignPayload(digest)
+
+	registry.AddManifest("team/app", strings.Replace(digest, ":", "-", 1)+".sig", registrytest.ManifestMimeType,
+		signatureManifest(registry, "team/app", payload, map[string]string{
+			cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signData(t, key, payload)),
+		}))
+
+	RegisterProvider("mock", &mockProvider{})
+
+	verify := func(tag string, v *SignatureVerifier) error {
+		url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: tag}
+
+		remote, err := NewRemote(context.Background(), url, "")
+		assert.NoError(t, err)
+
+		return v.Verify(remote)
+	}
+
+	assert.NoError(t, verify("1.0", &SignatureVerifier{Key: &key.PublicKey}))
+
+	var signatureErr *SignatureError
+
+	err := verify("1.0", &SignatureVerifier{Key: &newKey(t).PublicKey})
+	assert.ErrorAs(t, err, &signatureErr)
+	assert.Contains(t, err.Error(), "invalid signature")
+
+	err = verify("unsigned", &SignatureVerifier{Key: &key.PublicKey})
+	assert.ErrorAs(t, err, &signatureErr)
+	assert.Contains(t, err.Error(), "no signatures found")
+
+	// without transparency log entry, the signature is refused if the log
+	// is required
+	err = verify("1.0", &SignatureVerifier{Key: &key.PublicKey, RekorKey: &newKey(t).PublicKey})
+	assert.ErrorAs(t, err, &signatureErr)
+	assert.Contains(t, err.Error(), "transparency log")
+}
+
+// TestVerifySignaturePinsDigest tests that the verified image is the one
+// pulled, even if the tag is pushed again or the registry serves another
+// manifest under the signed digest
+func TestVerifySignaturePinsDigest(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	tests := []struct {
+		name string
+		push func(tag string, content string) string
+	}{
+		{"manifest", func(tag string, content string) string {
+			return registry.Push("team/app", tag, registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+				registrytest.Tar(map[string]string{"hello": content}),
+			}})
+		}},
+		{"manifest list", func(tag string, content string) string {
+			return registry.PushIndex("team/app", tag, registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+				registrytest.Tar(map[string]string{"hello": content}),
+			}})
+		}},
+	}
+
+	key := newKey(t)
+	verifier := &SignatureVerifier{Key: &key.PublicKey}
+
+	RegisterProvider("mock", &mockProvider{})
+
+	for _, test := range tests {
+		digest := test.push("signed", "world")
+		other := test.push("", "malicious")
+
+		payload := cosignPayload(digest)
+		registry.AddManifest("team/app", strings.Replace(digest, ":", "-", 1)+".sig", registrytest.ManifestMimeType,
+			signatureManifest(registry, "team/app", payload, map[string]string{
+				cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signData(t, key, payload)),
+			}))
+
+		url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "signed"}
+
+		remote, err := NewRemote(context.Background(), url, "")
+		assert.NoError(t, err, test.name)
+		assert.NoError(t, verifier.Verify(remote), test.name)
+
+		// the tag is pushed again after the verification
+		test.push("signed", "malicious")
+
+		pinned, err := NewRemote(context.Background(), url.WithDigest(digest), "")
+		assert.NoError(t, err, test.name)
+		expected, err := pinned.Digest()
+		assert.NoError(t, err, test.name)
+
+		actual, err := remote.Digest()
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, expected, actual, test.name)
+
+		// the registry serves another manifest under the signed digest
+		registry.Tamper("team/app", digest, registry.Manifest("team/app", other))
+
+		_, err = remote.Manifest()
+		assert.ErrorContains(t, err, "digest mismatch", test.name)
+
+		remote, err = NewRemote(context.Background(), url.WithDigest(digest), "")
+		assert.NoError(t, err, test.name)
+		assert.ErrorContains(t, verifier.Verify(remote), "digest mismatch", test.name)
+	}
+}
+
+// TestVerifyKeylessSignature tests that keyless signatures are accepted if
+// the certificate was issued to the identity and recorded by the log
+func TestVerifyKeylessSignature(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := registry.Push("team/app", "1.0", registrytest.Image{OS: "linux", Architecture: "amd64", Layers: [][]byte{
+		registrytest.Tar(map[string]string{"hello": "world"}),
+	}})
+
+	// the certificate authority, which issues short-lived certificates
+	caKey := newKey(t)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	ca, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	issuer, err := asn1.MarshalWithParams("https://issuer.example.org", "utf8")
+	assert.NoError(t, err)
+
+	signed := time.Now().Add(-24 * time.Hour)
+	key := newKey(t)
+
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       signed.Add(-time.Minute),
+		NotAfter:        signed.Add(10 * time.Minute),
+		EmailAddresses:  []string{"dev@example.org"},
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerV2, Value: issuer}},
+	}, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	// the entry of the transparency log
+	payload := cosignPayload(digest)
+	signature := base64.StdEncoding.EncodeToString(signData(t, key, payload))
+	hash := sha256.Sum256(payload)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data":      map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(hash[:])}},
+			"signature": map[string]interface{}{"content": signature},
+		},
+	})
+
+	rekorKey := newKey(t)
+	rekorDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	assert.NoError(t, err)
+
+	logID := sha256.Sum256(rekorDER)
+
+	entry := rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: signed.Unix(),
+		LogID:          hex.EncodeToString(logID[:]),
+		LogIndex:       42,
+	}
+
+	canonical, _ := json.Marshal(&entry)
+	bundle, _ := json.Marshal(&rekorBundle{SignedEntryTimestamp: signData(t, rekorKey, canonical), Payload: entry})
+
+	registry.AddManifest("team/app", strings.Replace(digest, ":", "-", 1)+".sig", registrytest.ManifestMimeType,
+		signatureManifest(registry, "team/app", payload, map[string]string{
+			cosignSignatureAnnotation:   signature,
+			cosignCertificateAnnotation: string(certificate),
+			cosignBundleAnnotation:      string(bundle),
+		}))
+
+	RegisterProvider("mock", &mockProvider{})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	verify := func(identity string, issuer string, rekor crypto.PublicKey) error {
+		url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+		remote, err := NewRemote(context.Background(), url, "")
+		assert.NoError(t, err)
+
+		v := &SignatureVerifier{Identity: identity, Issuer: issuer, Roots: roots, RekorKey: rekor}
+		return v.Verify(remote)
+	}
+
+	assert.NoError(t, verify("dev@example.org", "https://issuer.example.org", &rekorKey.PublicKey))
+
+	err = verify("ops@example.org", "https://issuer.example.org", &rekorKey.PublicKey)
+	assert.ErrorContains(t, err, "certificate issued to dev@example.org, not ops@example.org")
+
+	err = verify("dev@example.org", "https://other.example.org", &rekorKey.PublicKey)
+	assert.ErrorContains(t, err, "identity verified by https://issuer.example.org")
+
+	err = verify("dev@example.org", "https://issuer.example.org", &newKey(t).PublicKey)
+	assert.ErrorContains(t, err, "unknown log")
+}
@@ -0,0 +1,102 @@
+package image
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// LayerSize holds the compressed and uncompressed size of a layer
+type LayerSize struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+
+	// Size is the size of the blob, as stored in the registry
+	Size int64 `json:"size"`
+
+	// Uncompressed is the size of the tar stream of the layer, which is
+	// roughly what the layer takes on disk once extracted (0 if unknown)
+	Uncompressed int64 `json:"uncompressed_size,omitempty"`
+}
+
+// LayerSizes returns the compressed and uncompressed sizes of the layers of
+// the image. Uncompressed layers have the same size either way. The size of
+// gzip compressed layers is read from the trailer of the blob, with a range
+// request for its last four bytes. Other layers, and layers of registries
+// which do not support range requests, have an unknown uncompressed size.
+func (r *Remote) LayerSizes() ([]LayerSize, error) {
+	layers, err := r.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.layerSizes(layers), nil
+}
+
+// layerSizes returns the sizes of the given layers of the manifest
+func (r *Remote) layerSizes(layers []ManifestLayer) []LayerSize {
+	sizes := make([]LayerSize, len(layers))
+
+	for i, l := range layers {
+		sizes[i] = LayerSize{
+			Digest:    l.Digest,
+			MediaType: l.MediaType,
+			Size:      int64(l.Size),
+		}
+
+		switch {
+		case isMimeType(l.MediaType, OCITarLayerMimeType, DockerLayerMimeType, ForeignLayerMimeTypes[1]):
+			sizes[i].Uncompressed = sizes[i].Size
+		case isMimeType(l.MediaType, OCILayerMimeType, DockerGzipLayerMimeType, ForeignLayerMimeTypes[0], ForeignLayerMimeTypes[2]):
+			if l.IsForeign() {
+				continue
+			}
+
+			if size, err := r.gzipSize(l.Digest, sizes[i].Size); err == nil {
+				sizes[i].Uncompressed = size
+			}
+		}
+	}
+
+	return sizes
+}
+
+// gzipSize returns the uncompressed size of the gzip compressed blob, which
+// is stored modulo 2^32 in its last four bytes. As the uncompressed size is
+// at least about the compressed size, layers over 4GiB are accounted for.
+func (r *Remote) gzipSize(digest string, compressed int64) (int64, error) {
+	url := r.url.Endpoint("blobs", digest)
+
+	req, err := http.NewRequestWithContext(r.ctx, "GET", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+
+	req.Header.Set("Range", "bytes=-4")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error requesting %s: %w", url, err)
+	}
+	defer res.Body.Close()
+
+	// the whole blob is not downloaded if the range is not supported
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("no range support for %s: %d", url, res.StatusCode)
+	}
+
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(res.Body, trailer); err != nil {
+		return 0, fmt.Errorf("error reading trailer of %s: %v", digest, err)
+	}
+
+	size := int64(binary.LittleEndian.Uint32(trailer))
+
+	// gzip adds at least 18 bytes of header and trailer
+	for size < compressed-18 {
+		size += 1 << 32
+	}
+
+	return size, nil
+}
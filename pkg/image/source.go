@@ -0,0 +1,33 @@
+package image
+
+import "io"
+
+// Source provides the manifest and the blobs of an image. Images are usually
+// pulled from a registry (see Remote), but they may also come from local
+// sources like the Docker daemon.
+type Source interface {
+	String() string
+
+	// Name returns the normalized name of the image, as recorded in links
+	Name() string
+
+	// Platform returns the platform bound to the source, or nil
+	Platform() *Platform
+
+	// Manifest returns the manifest of the image
+	Manifest() (*Manifest, error)
+
+	// DownloadLayer writes the blob with the given digest to the writer,
+	// verifying its digest
+	DownloadLayer(digest string, w io.Writer) error
+}
+
+// platformName returns the platform bound to the source as string, or an
+// empty string
+func platformName(src Source) string {
+	if p := src.Platform(); p != nil {
+		return p.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,27 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates the device node or FIFO described by the header
+func mknod(path string, h *tar.Header) error {
+	mode := uint32(h.Mode & 07777)
+
+	switch h.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	case tar.TypeFifo:
+		mode |= unix.S_IFIFO
+	default:
+		return fmt.Errorf("not a special file: %s", h.Name)
+	}
+
+	dev := unix.Mkdev(uint32(h.Devmajor), uint32(h.Devminor))
+	return unix.Mknod(path, mode, int(dev))
+}
@@ -0,0 +1,73 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// TestExtractSpecialFiles tests that special modes, device nodes, FIFOs and
+// extended attributes are restored, or skipped without the privileges
+func TestExtractSpecialFiles(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, h := range []*tar.Header{
+		{Name: "bin/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "dev/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "run/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "tmp/", Mode: 01777, Typeflag: tar.TypeDir},
+		{Name: "bin/su", Mode: 04755, Typeflag: tar.TypeReg, PAXRecords: map[string]string{
+			"SCHILY.xattr.user.roots": "test",
+		}},
+		{Name: "dev/null", Mode: 0666, Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		{Name: "run/initctl", Mode: 0600, Typeflag: tar.TypeFifo},
+	} {
+		assert.NoError(t, tw.WriteHeader(h))
+	}
+	assert.NoError(t, tw.Close())
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+	result, err := store.Extract(context.Background(), newLayeredSource(buf.Bytes()), dst, &ExtractOptions{
+		PreserveXattrs: true,
+	})
+	assert.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dst, "tmp"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeDir|os.ModeSticky|0777, info.Mode())
+
+	info, err = os.Stat(filepath.Join(dst, "bin/su"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, info.Mode())
+
+	info, err = os.Lstat(filepath.Join(dst, "run/initctl"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeNamedPipe|0600, info.Mode())
+
+	// device nodes require root, without it they are skipped
+	if info, err := os.Lstat(filepath.Join(dst, "dev/null")); err == nil {
+		assert.Equal(t, os.ModeDevice|os.ModeCharDevice|0666, info.Mode())
+		assert.Equal(t, 0, result.SkippedSpecialFiles)
+	} else {
+		assert.Equal(t, 1, result.SkippedSpecialFiles)
+	}
+
+	// not all filesystems support user xattrs
+	value := make([]byte, 16)
+	if n, err := unix.Lgetxattr(filepath.Join(dst, "bin/su"), "user.roots", value); err == nil {
+		assert.Equal(t, "test", string(value[:n]))
+		assert.Equal(t, 0, result.SkippedXattrs)
+	} else {
+		assert.Equal(t, 1, result.SkippedXattrs)
+	}
+}
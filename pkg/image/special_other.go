@@ -0,0 +1,13 @@
+//go:build !linux
+
+package image
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+// mknod is not supported outside of Linux
+func mknod(path string, h *tar.Header) error {
+	return errors.ErrUnsupported
+}
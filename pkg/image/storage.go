@@ -0,0 +1,333 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/vbatts/tar-split/tar/asm"
+	tarstorage "github.com/vbatts/tar-split/tar/storage"
+)
+
+// storageDrivers are the graph drivers of containers/storage whose layers
+// can be read, with the location of the layer contents
+var storageDrivers = map[string]func(root string, id string) string{
+	"overlay": func(root string, id string) string {
+		return filepath.Join(root, "overlay", id, "diff")
+	},
+	"vfs": func(root string, id string) string {
+		return filepath.Join(root, "vfs", "dir", id)
+	},
+}
+
+// StorageSource reads images from the local storage of podman and buildah
+// (containers/storage). The layers are reassembled from their extracted
+// contents and tar-split metadata, the digests of the layers are the digests
+// of the uncompressed layers (the diff ids).
+type StorageSource struct {
+	root     string
+	driver   string
+	name     string
+	image    *storageImage
+	layers   map[string]*storageLayer
+	manifest *Manifest
+}
+
+// storageImage is an entry in images.json
+type storageImage struct {
+	ID     string   `json:"id"`
+	Digest string   `json:"digest"`
+	Names  []string `json:"names"`
+	Layer  string   `json:"layer"`
+}
+
+// storageLayer is an entry in layers.json
+type storageLayer struct {
+	ID                 string `json:"id"`
+	Parent             string `json:"parent"`
+	UncompressedDigest string `json:"diff-digest"`
+	UncompressedSize   int64  `json:"diff-size"`
+}
+
+// DefaultStorageRoot returns the storage root of the current user
+func DefaultStorageRoot() string {
+	usr, err := user.Current()
+	if err != nil || usr.Uid == "0" || usr.HomeDir == "" {
+		return "/var/lib/containers/storage"
+	}
+
+	return filepath.Join(usr.HomeDir, ".local", "share", "containers", "storage")
+}
+
+// NewStorageSource looks up the image with the given name or id in the
+// storage at the given root
+func NewStorageSource(root string, name string) (*StorageSource, error) {
+	s := &StorageSource{root: root, name: name}
+
+	for driver := range storageDrivers {
+		if _, err := os.Stat(s.metadataPath(driver, "images", "images.json")); err == nil {
+			s.driver = driver
+			break
+		}
+	}
+
+	if s.driver == "" {
+		return nil, fmt.Errorf("no supported storage found at %s", root)
+	}
+
+	if err := s.findImage(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadLayers(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadManifest(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// metadataPath returns the path to the metadata of images or layers
+func (s *StorageSource) metadataPath(driver string, kind string, elem ...string) string {
+	return filepath.Join(append([]string{s.root, fmt.Sprintf("%s-%s", driver, kind)}, elem...)...)
+}
+
+// findImage finds the image by name, id or id prefix
+func (s *StorageSource) findImage() error {
+	var images []*storageImage
+	if err := readJSON(s.metadataPath(s.driver, "images", "images.json"), &images); err != nil {
+		return err
+	}
+
+	// podman stores fully qualified names, local images below localhost
+	names := []string{s.name}
+	if url, err := Parse(s.name); err == nil {
+		names = append(names, url.Canonical())
+	}
+
+	if !strings.ContainsAny(s.name[strings.LastIndex(s.name, "/")+1:], ":@") {
+		names = append(names, s.name+":latest")
+	}
+
+	for _, img := range images {
+		if img.ID == s.name || (len(s.name) >= 12 && strings.HasPrefix(img.ID, s.name)) {
+			s.image = img
+			return nil
+		}
+
+		for _, n := range img.Names {
+			for _, wanted := range names {
+				if n == wanted {
+					s.image = img
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("image %s not found in %s", s.name, s.root)
+}
+
+// loadLayers reads the layers of the image, indexed by digest
+func (s *StorageSource) loadLayers() error {
+	var layers []*storageLayer
+	if err := readJSON(s.metadataPath(s.driver, "layers", "layers.json"), &layers); err != nil {
+		return err
+	}
+
+	byID := make(map[string]*storageLayer, len(layers))
+	for _, l := range layers {
+		byID[l.ID] = l
+	}
+
+	s.layers = make(map[string]*storageLayer)
+
+	for id := s.image.Layer; id != ""; {
+		l, ok := byID[id]
+		if !ok {
+			return fmt.Errorf("layer %s of %s not found", id, s.name)
+		}
+
+		s.layers[l.UncompressedDigest] = l
+		id = l.Parent
+	}
+
+	return nil
+}
+
+// loadManifest builds the manifest from the config of the image
+func (s *StorageSource) loadManifest() error {
+	configDigest := fmt.Sprintf("sha256:%s", s.image.ID)
+
+	data, err := os.ReadFile(s.bigDataPath(configDigest))
+	if err != nil {
+		return fmt.Errorf("error reading config of %s: %v", s.name, err)
+	}
+
+	config := &ImageConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("error parsing config of %s: %v", s.name, err)
+	}
+
+	digest := s.image.Digest
+	if digest == "" {
+		digest = configDigest
+	}
+
+	s.manifest = &Manifest{
+		Digest:        digest,
+		SchemaVersion: 2,
+		MediaType:     ManifestMimeType,
+		Config: ManifestLayer{
+			MediaType: ImageConfigMimeTypes[0],
+			Size:      len(data),
+			Digest:    configDigest,
+		},
+		Layers: make([]ManifestLayer, len(config.RootFS.DiffIDs)),
+	}
+
+	for i, diffID := range config.RootFS.DiffIDs {
+		l, ok := s.layers[diffID]
+		if !ok {
+			return fmt.Errorf("layer %s of %s not found", diffID, s.name)
+		}
+
+		s.manifest.Layers[i] = ManifestLayer{
+			MediaType: DockerLayerMimeType,
+			Size:      int(l.UncompressedSize),
+			Digest:    diffID,
+		}
+	}
+
+	return nil
+}
+
+// bigDataPath returns the path to a file stored along the image, whose name
+// is encoded like containers/storage does
+func (s *StorageSource) bigDataPath(key string) string {
+	name := key
+
+	for _, ch := range key {
+		if ch != '.' && !(ch >= '0' && ch <= '9') && !(ch >= 'a' && ch <= 'z') {
+			name = "=" + base64.StdEncoding.EncodeToString([]byte(key))
+			break
+		}
+	}
+
+	return s.metadataPath(s.driver, "images", s.image.ID, name)
+}
+
+func (s *StorageSource) String() string {
+	return s.Name()
+}
+
+// Name returns the name of the image as given
+func (s *StorageSource) Name() string {
+	return fmt.Sprintf("containers-storage:%s", s.name)
+}
+
+// Platform returns nil, as the storage contains a single platform per image
+func (s *StorageSource) Platform() *Platform {
+	return nil
+}
+
+// Manifest returns the manifest of the image
+func (s *StorageSource) Manifest() (*Manifest, error) {
+	return s.manifest, nil
+}
+
+// DownloadLayer writes the config or the reassembled layer with the given
+// digest to the writer, verifying its digest
+func (s *StorageSource) DownloadLayer(digest string, w io.Writer) error {
+	h, err := newDigester(digest)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.openBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return fmt.Errorf("error reading %s: %v", digest, err)
+	}
+
+	return checkDigest(digest, h)
+}
+
+// openBlob opens the config or reassembles the layer with the given digest
+func (s *StorageSource) openBlob(digest string) (io.ReadCloser, error) {
+	if digest == s.manifest.Config.Digest {
+		data, err := os.ReadFile(s.bigDataPath(digest))
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	l, ok := s.layers[digest]
+	if !ok {
+		return nil, fmt.Errorf("layer %s not found in %s", digest, s)
+	}
+
+	f, err := os.Open(s.metadataPath(s.driver, "layers", fmt.Sprintf("%s.tar-split.gz", l.ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading tar-split of %s: %v", l.ID, err)
+	}
+
+	files := tarstorage.NewPathFileGetter(storageDrivers[s.driver](s.root, l.ID))
+	stream := asm.NewOutputTarStream(files, tarstorage.NewJSONUnpacker(gz))
+
+	return &multiCloser{stream, []io.Closer{stream, gz, f}}, nil
+}
+
+// multiCloser closes several closers once the reader is done
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var first error
+
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// readJSON parses the JSON file at the given path into v
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return nil
+}
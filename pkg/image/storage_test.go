@@ -0,0 +1,75 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vbatts/tar-split/tar/asm"
+	tarstorage "github.com/vbatts/tar-split/tar/storage"
+)
+
+// TestStorageSource tests extracting an image from a fake overlay storage
+func TestStorageSource(t *testing.T) {
+	root := t.TempDir()
+
+	layer := tarball(t, map[string][]byte{"hello": []byte("world")}, "hello")
+	diffID := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+
+	// the layer is kept as tar-split metadata plus its extracted contents
+	var split bytes.Buffer
+	gz := gzip.NewWriter(&split)
+
+	stream, err := asm.NewInputTarStream(bytes.NewReader(layer), tarstorage.NewJSONPacker(gz), tarstorage.NewDiscardFilePutter())
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, stream)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+
+	diff := filepath.Join(root, "overlay", "l1", "diff")
+	assert.NoError(t, os.MkdirAll(diff, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(diff, "hello"), []byte("world"), 0644))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "overlay-layers"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "overlay-layers", "l1.tar-split.gz"), split.Bytes(), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "overlay-layers", "layers.json"), []byte(fmt.Sprintf(
+		`[{"id": "l1", "diff-digest": %q, "diff-size": %d}]`, diffID, len(layer))), 0644))
+
+	config := []byte(fmt.Sprintf(`{"rootfs": {"type": "layers", "diff_ids": [%q]}}`, diffID))
+	id := fmt.Sprintf("%x", sha256.Sum256(config))
+
+	images := filepath.Join(root, "overlay-images")
+	assert.NoError(t, os.MkdirAll(filepath.Join(images, id), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(images, id, "="+base64.StdEncoding.EncodeToString([]byte("sha256:"+id))), config, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(images, "images.json"), []byte(fmt.Sprintf(
+		`[{"id": %q, "names": ["localhost/app:latest"], "layer": "l1"}]`, id)), 0644))
+
+	_, err = NewStorageSource(root, "localhost/other")
+	assert.Error(t, err)
+
+	source, err := NewStorageSource(root, "localhost/app")
+	assert.NoError(t, err)
+
+	byID, err := NewStorageSource(root, id[:12])
+	assert.NoError(t, err)
+	assert.Equal(t, source.manifest, byID.manifest)
+
+	cache, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+	_, err = cache.Extract(context.Background(), source, dst, nil)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dst, "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+}
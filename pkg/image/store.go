@@ -2,13 +2,18 @@ package image
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/seantis/roots/pkg/lock"
 )
@@ -17,6 +22,55 @@ import (
 // optionally caching layers and offering a way to purge the cache.
 type Store struct {
 	Path string
+
+	// Dedup stores downloaded layers as content-defined chunks, which are
+	// shared between all layers in the cache
+	Dedup bool
+
+	// Retention decides how many pulls per destination keep their layers
+	// in the cache, by default only the latest pull is kept
+	Retention *Retention
+
+	// MaxConcurrentDownloads limits the layers downloaded at the same time,
+	// across all extractions of the store (DefaultConcurrentDownloads if 0)
+	MaxConcurrentDownloads int
+
+	downloadsOnce sync.Once
+	downloads     chan struct{}
+}
+
+// DefaultConcurrentDownloads is the number of layers downloaded at the same
+// time by default, more tend to trigger the rate limits of registries
+const DefaultConcurrentDownloads = 3
+
+// Retention is a policy which keeps the layers of the last N pulls of each
+// destination, for destinations that are re-pulled in place
+type Retention struct {
+
+	// Default is the number of pulls kept for all destinations
+	Default int
+
+	// Destinations overrides the default for specific destinations
+	Destinations map[string]int
+}
+
+// Keep returns the number of pulls to keep for the given destination
+func (r *Retention) Keep(dst string) int {
+	keep := 1
+
+	if r != nil {
+		if n, ok := r.Destinations[dst]; ok {
+			keep = n
+		} else if r.Default > 0 {
+			keep = r.Default
+		}
+	}
+
+	if keep < 1 {
+		return 1
+	}
+
+	return keep
 }
 
 // StoreResult contains the result of a DownloadLayer call
@@ -24,27 +78,266 @@ type StoreResult struct {
 	Path   string
 	Digest string
 	Error  error
+
+	// Cached is true if the layer was found in the cache
+	Cached bool
+}
+
+// ExtractOptions controls how layers are written to the destination
+type ExtractOptions struct {
+
+	// PreserveOwnership restores the uid/gid recorded in the layers, which
+	// usually requires root privileges
+	PreserveOwnership bool
+
+	// UIDMap and GIDMap shift the recorded ids before they are applied, a
+	// non-empty map implies PreserveOwnership
+	UIDMap IDMap
+	GIDMap IDMap
+
+	// IgnoreChownErrors continues the extraction if the ownership of a
+	// file cannot be restored, counting the skipped files instead
+	IgnoreChownErrors bool
+
+	// SELinuxLabel is the SELinux context applied to all extracted files,
+	// unless SELinuxLayerLabels is set and the layer carries a label
+	SELinuxLabel string
+
+	// SELinuxLayerLabels applies the security.selinux xattrs found in the
+	// layers to the extracted files
+	SELinuxLayerLabels bool
+
+	// PreserveXattrs restores the other extended attributes recorded in the
+	// layers (e.g. security.capability), attributes that cannot be set are
+	// counted instead
+	PreserveXattrs bool
+
+	// Progress is called after each layer has been extracted, if set
+	Progress func(*LayerProgress)
+
+	// ExpansionFactor enables a check of the free disk space before the
+	// layers are downloaded, assuming that layers expand by this factor
+	ExpansionFactor float64
+
+	// Merge extracts into an existing destination, which is updated to match
+	// the image: files whose size and content are unchanged are not written
+	// again and files which are not part of the image are removed (except
+	// for the metadata of roots)
+	Merge bool
+
+	// Update extracts into a staging directory next to the destination,
+	// which then replaces the destination at once (atomically on Linux), so
+	// that the destination never holds a partially extracted tree. The
+	// metadata of roots in the destination is kept.
+	Update bool
+
+	// Workers is the number of regular files of a layer written concurrently,
+	// which helps with layers containing lots of small files. Directories
+	// and links are still created in order. Zero writes files one by one.
+	Workers int
+
+	// Stream extracts each layer while it is downloaded, instead of caching
+	// it first. The layers are downloaded one after the other, interrupted
+	// downloads are not resumed and layers are read only once, which custom
+	// unpackers have to support (see LayerStream.Rewindable).
+	Stream bool
+
+	// Share links (or clones) the regular files of the destination from
+	// the layers exploded in the cache, instead of writing them, which saves
+	// disk space if an image is extracted to many destinations
+	Share ShareMode
+
+	// Overlay extracts each layer into its own directory of the destination
+	// (l1, l2, ...), with the whiteouts in the format of overlayfs, and
+	// writes the lowerdir option to mount them (see OverlayLowerDirPath)
+	Overlay bool
+
+	// Unpacker applies the layers to the destination, which need not be a
+	// directory then. By default, the layers are extracted into it.
+	Unpacker Unpacker
+
+	// LockWaiting is called while the cache or the destination is locked by
+	// another process, first after lock.WaitReportInterval and then after
+	// each interval, with the holder of the lock and the time waited so far
+	LockWaiting func(file string, holder string, waited time.Duration)
+}
+
+// LayerProgress describes a layer that has been extracted
+type LayerProgress struct {
+
+	// Layer is the number of the layer, starting at 1, of Layers
+	Layer  int `json:"layer"`
+	Layers int `json:"layers"`
+
+	// Digest and Size describe the layer as found in the manifest
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+
+	// Cached is true if the layer was found in the cache
+	Cached bool `json:"cached"`
+}
+
+// ExtractResult summarises a completed extraction
+type ExtractResult struct {
+
+	// Digest is the digest of the extracted manifest
+	Digest string `json:"digest"`
+
+	// Platform is the platform bound to the remote, if any
+	Platform string `json:"platform,omitempty"`
+
+	// Layers is the number of extracted layers
+	Layers int `json:"layers"`
+
+	// ArtifactType is set if the manifest described an artifact, in which
+	// case the blobs were written to the destination as files
+	ArtifactType string `json:"artifact_type,omitempty"`
+
+	// CacheHits and CachedBytes count the layers that were found in the
+	// cache, CacheMisses and DownloadedBytes the ones that were downloaded
+	// (sizes are the compressed sizes of the manifest)
+	CacheHits       int   `json:"cache_hits"`
+	CacheMisses     int   `json:"cache_misses"`
+	CachedBytes     int64 `json:"cached_bytes"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+
+	// SkippedChowns is the number of files whose ownership could not be
+	// restored, together with the first error that was ignored
+	SkippedChowns int   `json:"skipped_chowns"`
+	ChownError    error `json:"-"`
+
+	// SkippedSpecialFiles is the number of device nodes and FIFOs that could
+	// not be created, SkippedXattrs the number of extended attributes that
+	// could not be set (both usually due to lacking privileges)
+	SkippedSpecialFiles int `json:"skipped_special_files,omitempty"`
+	SkippedXattrs       int `json:"skipped_xattrs,omitempty"`
+
+	// SharedFiles is the number of files linked or cloned from the layers
+	// exploded in the cache
+	SharedFiles int `json:"shared_files,omitempty"`
+
+	// Unchanged is the number of files that were kept while merging, as
+	// their content did not change, Removed the number of files and whole
+	// directories that were removed as they are not part of the image
+	Unchanged int `json:"unchanged,omitempty"`
+	Removed   int `json:"removed,omitempty"`
+
+	// LockWait is the time in seconds spent waiting for the cache and the
+	// destination to be unlocked by other processes
+	LockWait float64 `json:"lock_wait"`
+
+	// UpToDate is set if nothing was extracted, as the destination already
+	// held the image with the digest
+	UpToDate bool `json:"up_to_date,omitempty"`
+}
+
+// preservesOwnership returns true if the ownership should be restored
+func (o *ExtractOptions) preservesOwnership() bool {
+	return o.PreserveOwnership || len(o.UIDMap) > 0 || len(o.GIDMap) > 0
+}
+
+// Link records which layers were extracted to a destination and from which
+// image they originated
+type Link struct {
+	Destination string   `json:"destination"`
+	Image       string   `json:"image,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+	Layers      []string `json:"layers"`
+
+	// Previous lists the layers of earlier pulls to the same destination,
+	// most recent first, as far as they are retained
+	Previous [][]string `json:"previous,omitempty"`
+
+	// Token, Device, Inode and Resolved identify the destination directory
+	// (see TokenPath), so that purge recognizes it if it was replaced,
+	// renamed or pulled to through a symlink
+	Token    string `json:"token,omitempty"`
+	Device   uint64 `json:"device,omitempty"`
+	Inode    uint64 `json:"inode,omitempty"`
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// key identifies the pull recorded by the link
+func (l *Link) key() string {
+	return l.Destination + "\n" + l.Token
+}
+
+// retained returns the layers of all pulls retained by the given number
+func (l *Link) retained(keep int) []string {
+	layers := append([]string{}, l.Layers...)
+
+	for i := 0; i < keep-1 && i < len(l.Previous); i++ {
+		layers = append(layers, l.Previous[i]...)
+	}
+
+	return layers
 }
 
 // NewStore returns a new store
 func NewStore(folder string) (*Store, error) {
 
 	// ignore path creation errors - if it's serious, we'll know about it later
-	_ = os.Mkdir(path.Join(folder, "layers"), 0755)
-	_ = os.Mkdir(path.Join(folder, "links"), 0755)
+	_ = os.Mkdir(filepath.Join(folder, "layers"), 0755)
+	_ = os.Mkdir(filepath.Join(folder, "links"), 0755)
+	_ = os.Mkdir(filepath.Join(folder, "chunks"), 0755)
+	_ = os.Mkdir(filepath.Join(folder, "locks"), 0755)
+	_ = os.Mkdir(filepath.Join(folder, "exploded"), 0755)
 
 	return &Store{
 		Path: folder,
 	}, nil
 }
 
-// Purge removes all the unused data from the cache
+// purgeWorkers is the number of files stat-ed or removed concurrently
+const purgeWorkers = 16
+
+// Purge removes all the unused data from the cache. The cached manifests are
+// removed as well, as they cannot be related to the destinations (they only
+// spare requests and are stored again by the next pull).
 func (s *Store) Purge() error {
 
-	// lock the whole cache
+	// checking the destinations is slow on big caches, so we do that before
+	// locking the cache, based on a snapshot of the links
+	snapshot, err := s.readLinks()
+	if err != nil {
+		return err
+	}
+
+	alive, err := aliveLinks(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// the cached layers and the download locks are listed before locking
+	// the cache as well, layers downloaded in the meantime are kept
+	selector := fmt.Sprintf("%s/layers/*.*", s.Path)
+	cached, err := filepath.Glob(selector)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", selector, err)
+	}
+
+	selector = fmt.Sprintf("%s/locks/*.lock", s.Path)
+	locks, err := filepath.Glob(selector)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", selector, err)
+	}
+
+	selector = fmt.Sprintf("%s/exploded/*", s.Path)
+	exploded, err := filepath.Glob(selector)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", selector, err)
+	}
+
+	selector = fmt.Sprintf("%s/manifests/*.json", s.Path)
+	manifests, err := filepath.Glob(selector)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", selector, err)
+	}
+
+	// lock the whole cache, which waits for running pulls
 	defer s.lockCache().MustUnlock()
 
-	// load the destination folders and the layers connected to them
+	// reload the links, as pulls may have happened in the meantime
 	links, err := s.readLinks()
 	if err != nil {
 		return err
@@ -52,199 +345,974 @@ func (s *Store) Purge() error {
 
 	// keep a list of known layers
 	layers := make(map[string]bool)
+	stale := []string{}
 
-	for dst, digests := range links {
-		_, err := os.Stat(dst)
-
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return fmt.Errorf("error reading %s: %v", dst, err)
-			}
+	for _, link := range links {
+		dst := link.Destination
+		exists, checked := alive[link.key()]
 
-			// the destination does not exist anymore, remove the link
-			if err := os.Remove(s.LinkPath(dst)); err != nil {
-				return fmt.Errorf("error removing %s: %v", dst, err)
+		// links created after the snapshot are checked now
+		if !checked {
+			if exists, err = link.alive(); err != nil {
+				return err
 			}
+		}
 
+		// the destination does not exist anymore, remove the link
+		if !exists {
+			stale = append(stale, s.LinkPath(dst))
 			continue
 		}
 
-		// the destination still exists, add its digest to the known layers
-		for _, digest := range digests {
+		// the destination still exists, add the digests of the retained
+		// pulls to the known layers
+		for _, digest := range link.retained(s.Retention.Keep(dst)) {
 			layers[digest] = true
 		}
 	}
 
-	// go through all the cached layers and remove the unknown ones
-	selector := fmt.Sprintf("%s/layers/*.layer", s.Path)
-	cached, err := filepath.Glob(selector)
-	if err != nil {
-		return fmt.Errorf("error reading %s: %v", selector, err)
+	if err := removeFiles(stale); err != nil {
+		return err
 	}
 
-	for _, file := range cached {
-		digest := strings.TrimSuffix(filepath.Base(file), ".layer")
+	// go through all the cached layers and remove the unknown ones (this
+	// includes the partial files left behind by killed downloads), together
+	// with their download locks, which are not held by anyone while the
+	// cache is locked
+	unused := []string{}
+	for _, file := range append(cached, locks...) {
+		digest := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
 
 		if !layers[digest] {
-			if err := os.Remove(file); err != nil {
-				return fmt.Errorf("error removing %s: %v", file, err)
-			}
+			unused = append(unused, file)
 		}
 	}
 
-	return nil
+	if err := removeFiles(unused); err != nil {
+		return err
+	}
+
+	// remove the exploded layers that are no longer used, together with
+	// the ones left behind by killed pulls (destinations keep the files
+	// linked to them)
+	unused = []string{}
+	for _, dir := range exploded {
+		if filepath.Ext(dir) == ".partial" || !layers[filepath.Base(dir)] {
+			unused = append(unused, dir)
+		}
+	}
+
+	if err := removeDirs(unused); err != nil {
+		return err
+	}
+
+	if err := removeFiles(manifests); err != nil {
+		return err
+	}
+
+	// remove the chunks no longer used by any deduplicated layer
+	return s.purgeChunks()
+}
+
+// aliveLinks checks the destinations of the given links concurrently and
+// returns which of them are alive, by key. Errors other than a destination
+// not existing are returned.
+func aliveLinks(links []*Link) (map[string]bool, error) {
+	alive := make([]bool, len(links))
+
+	err := parallel(purgeWorkers, len(links), func(i int) (err error) {
+		alive[i], err = links[i].alive()
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(links))
+	for i, link := range links {
+		result[link.key()] = alive[i]
+	}
+
+	return result, nil
+}
+
+// removeFiles removes the given files concurrently
+func removeFiles(files []string) error {
+	return parallel(purgeWorkers, len(files), func(i int) error {
+		if err := os.Remove(files[i]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %v", files[i], err)
+		}
+
+		return nil
+	})
+}
+
+// removeDirs removes the given directories and their content concurrently
+func removeDirs(dirs []string) error {
+	return parallel(purgeWorkers, len(dirs), func(i int) error {
+		if err := os.RemoveAll(dirs[i]); err != nil {
+			return fmt.Errorf("error removing %s: %v", dirs[i], err)
+		}
+
+		return nil
+	})
 }
 
 // LinkPath returns the path to the link file in the cache
 func (s *Store) LinkPath(dst string) string {
-	return path.Join(s.Path, "links", fmt.Sprintf("%x.link", md5.Sum([]byte(dst))))
+	return filepath.Join(s.Path, "links", fmt.Sprintf("%x.link", md5.Sum([]byte(dst))))
+}
+
+// UsedPath returns the path to the file recording the last use of a layer
+func (s *Store) UsedPath(digest string) string {
+	return filepath.Join(s.Path, "layers", fmt.Sprintf("%s.used", digest))
+}
+
+// CacheLockPath returns the path of the lock shared by pulls and held
+// exclusively by purge
+func (s *Store) CacheLockPath() string {
+	return filepath.Join(s.Path, ".lock")
+}
+
+// DownloadLockPath returns the path of the lock held while the layer with
+// the given digest is downloaded
+func (s *Store) DownloadLockPath(digest string) string {
+	return filepath.Join(s.Path, "locks", fmt.Sprintf("%s.lock", digest))
 }
 
 // LayerPath returns the path to the layer file in the cache
 func (s *Store) LayerPath(digest string) string {
-	return path.Join(s.Path, "layers", fmt.Sprintf("%s.layer", digest))
+	return filepath.Join(s.Path, "layers", fmt.Sprintf("%s.layer", digest))
 }
 
-// Extract takes a remote, downloads the layers and stores them at dst
-func (s *Store) Extract(ctx context.Context, r *Remote, dst string) error {
+// PartialPath returns the path of the interrupted download of the layer
+// with the given digest
+func (s *Store) PartialPath(digest string) string {
+	return filepath.Join(s.Path, "layers", fmt.Sprintf("%s.partial", digest))
+}
+
+// Extract takes a source (e.g. a remote), downloads the layers and stores
+// them at dst, using the unpacker of the options if one is given. The
+// options may be nil, in which case the defaults are used.
+func (s *Store) Extract(ctx context.Context, r Source, dst string, opts *ExtractOptions) (*ExtractResult, error) {
+
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
 
 	// fetch the layers
-	layers, err := r.Layers()
+	manifest, err := r.Manifest()
 	if err != nil {
-		return fmt.Errorf("error querying layers for %s: %v", r, err)
+		return nil, fmt.Errorf("error querying layers for %s: %w", r, err)
 	}
 
+	layers := manifest.Layers
+
 	if len(layers) == 0 {
-		return fmt.Errorf("no layers found for %s", r)
+		return nil, fmt.Errorf("no layers found for %s", r)
 	}
 
-	// lock the whole destination as well as the cache
-	defer s.lockCache().MustUnlock()
-	defer s.lockDestination(dst).MustUnlock()
+	// lock the whole destination and share the cache with other pulls, the
+	// layers are locked while they are downloaded
+	locking := time.Now()
+
+	cacheLock, err := s.acquireSharedLock(ctx, s.CacheLockPath(), opts.LockWaiting)
+	if err != nil {
+		return nil, err
+	}
+	defer cacheLock.MustRUnlock()
 
-	// ensure the destination is empty
-	entries, err := os.ReadDir(dst)
+	dstLock, err := s.acquireLock(ctx, filepath.Clean(dst)+".lock", opts.LockWaiting)
 	if err != nil {
-		return fmt.Errorf("error extracting to %s: %v", dst, err)
+		return nil, err
+	}
+	defer dstLock.MustUnlock()
+
+	lockWait := time.Since(locking).Seconds()
+
+	// extract into a staging directory, which then replaces the destination
+	if opts.Update && opts.Unpacker == nil {
+		return s.update(ctx, r, manifest, dst, opts, lockWait)
+	}
+
+	result, link, err := s.extract(ctx, r, manifest, dst, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result.LockWait = lockWait
+
+	// record the destination in the cache
+	if err := s.saveLink(link); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// extract applies the layers of the manifest to the target, which is the
+// destination unless updating. The link recording the destination is
+// returned, to be saved once the destination holds the image.
+func (s *Store) extract(ctx context.Context, r Source, manifest *Manifest, target string, dst string, opts *ExtractOptions) (*ExtractResult, *Link, error) {
+	layers := manifest.Layers
+
+	// ensure the destination is empty, unless merging into it (unpackers
+	// take care of their own destinations)
+	if opts.Unpacker == nil || manifest.IsArtifact() {
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error extracting to %s: %v", target, err)
+		}
+
+		if len(entries) > 1 && (!opts.Merge || manifest.IsArtifact()) {
+			return nil, nil, fmt.Errorf("directory %s is not empty", target)
+		}
+	}
+
+	// until the merge is complete, the destination holds no known image
+	if opts.Merge {
+		if err := os.Remove(DigestPath(target)); err != nil && !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("error removing %s: %v", DigestPath(target), err)
+		}
+	}
+
+	// artifacts are not extracted, their blobs are written as files
+	if manifest.IsArtifact() {
+		result, err := s.extractArtifact(ctx, r, manifest, target)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result.Digest = manifest.Digest
+
+		return result, &Link{
+			Destination: dst,
+			Image:       r.Name(),
+			Digest:      manifest.Digest,
+		}, nil
 	}
 
-	if len(entries) > 1 {
-		return fmt.Errorf("directory %s is not empty", dst)
+	// fail early if a layer cannot be extracted
+	if err := requireLayerHandlers(layers); err != nil {
+		return nil, nil, err
 	}
 
-	// download the layers concurrently
+	if err := checkShareOptions(opts); err != nil {
+		return nil, nil, err
+	}
+
+	// fail early instead of running out of space during the extraction
+	if opts.ExpansionFactor > 0 {
+		if err := s.checkDiskSpace(layers, target, opts.ExpansionFactor); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// download the layers concurrently, unless they are streamed, in which
+	// case each is downloaded while it is extracted
 	results := make([]chan *StoreResult, len(layers))
 	for i, l := range layers {
-		results[i], err = s.downloadLayer(ctx, r, l.Digest)
+		if opts.Stream {
+			continue
+		}
+
+		var err error
+		results[i], err = s.downloadLayer(ctx, r, l.Digest, l.MediaType)
 
 		if err != nil {
-			return fmt.Errorf("error writing %s: %v", l.Digest, err)
+			return nil, nil, fmt.Errorf("error writing %s: %v", l.Digest, err)
 		}
 	}
 
 	// process the layers in order
 	digests := make([]string, len(results))
-	dirmodes := make(map[string]os.FileMode)
+	x := newExtraction(target, opts)
+	x.result.Digest = manifest.Digest
+	x.result.Platform = platformName(r)
+
+	var unpacker Unpacker = x
+	if opts.Unpacker != nil {
+		unpacker = opts.Unpacker
+	} else if opts.Overlay {
+		overlay, err := newOverlayLayers(x, dst)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		unpacker = overlay
+	}
+
+	for i := range results {
+		result := &StoreResult{Digest: layers[i].Digest}
+		start := time.Now()
+
+		if opts.Stream {
+			if err := s.streamLayer(ctx, r, layers[i], unpacker, target); err != nil {
+				return nil, nil, fmt.Errorf("error streaming %s: %w", result.Digest, err)
+			}
+		} else {
+			if result = <-results[i]; result.Error != nil {
+				return nil, nil, fmt.Errorf("error downloading %s: %w", result.Digest, result.Error)
+			}
+
+			start = time.Now()
+
+			// the files of the layer are shared from its exploded copy
+			if opts.Share != ShareNone {
+				exploded, err := s.explodeLayer(ctx, result.Path, layers[i])
+				if err != nil {
+					return nil, nil, err
+				}
+
+				x.exploded = exploded
+			}
+
+			err := s.applyCachedLayer(ctx, result.Path, layers[i], unpacker, target)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("error extracting %s: %v", result.Path, err)
+			}
+
+			if err := s.touchLayer(result.Digest); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		Logger.Debug("extracted layer", "digest", result.Digest, "layer", i+1, "layers", len(layers),
+			"duration", time.Since(start).Round(time.Millisecond))
+
+		digests[i] = result.Digest
+		x.result.Layers++
+
+		if result.Cached {
+			x.result.CacheHits++
+			x.result.CachedBytes += int64(layers[i].Size)
+		} else {
+			x.result.CacheMisses++
+			x.result.DownloadedBytes += int64(layers[i].Size)
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(&LayerProgress{
+				Layer:  i + 1,
+				Layers: len(layers),
+				Digest: layers[i].Digest,
+				Size:   int64(layers[i].Size),
+				Cached: result.Cached,
+			})
+		}
+	}
+
+	// e.g. set the correct permissions for all directories
+	if err := unpacker.Finish(ctx, target); err != nil {
+		return nil, nil, err
+	}
+
+	Logger.Debug("extracted image", "digest", manifest.Digest, "destination", dst,
+		"layers", x.result.Layers, "cache_hits", x.result.CacheHits, "cache_misses", x.result.CacheMisses,
+		"downloaded_bytes", x.result.DownloadedBytes)
+
+	return x.result, &Link{
+		Destination: dst,
+		Image:       r.Name(),
+		Digest:      manifest.Digest,
+		Layers:      digests,
+		Previous:    s.previousPulls(dst),
+	}, nil
+}
+
+// Validate downloads all layers of the source into the cache, verifies their
+// digests and decompresses them, without extracting anything. Layers that
+// fail the verification are removed from the cache.
+func (s *Store) Validate(ctx context.Context, r Source) error {
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		return fmt.Errorf("error querying layers for %s: %w", r, err)
+	}
+
+	layers := manifest.Layers
+
+	// artifacts are not cached, their blobs are only verified
+	if manifest.IsArtifact() {
+		for _, l := range layers {
+			if err := r.DownloadLayer(l.Digest, io.Discard); err != nil {
+				return fmt.Errorf("error validating %s: %v", l.Digest, err)
+			}
+		}
+
+		return nil
+	}
+
+	if len(layers) == 0 {
+		return fmt.Errorf("no layers found for %s", r)
+	}
+
+	if err := requireLayerHandlers(layers); err != nil {
+		return err
+	}
+
+	cacheLock, err := s.acquireSharedLock(ctx, s.CacheLockPath(), nil)
+	if err != nil {
+		return err
+	}
+	defer cacheLock.MustRUnlock()
+
+	results := make([]chan *StoreResult, len(layers))
+	for i, l := range layers {
+		results[i], err = s.downloadLayer(ctx, r, l.Digest, l.MediaType)
+
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", l.Digest, err)
+		}
+	}
 
 	for i := range results {
 		result := <-results[i]
 
 		if result.Error != nil {
-			return fmt.Errorf("error downloading %s: %v", result.Digest, result.Error)
+			return fmt.Errorf("error downloading %s: %w", result.Digest, result.Error)
+		}
+
+		// deduplicated layers are verified chunk by chunk while reading
+		var err error
+		if !strings.HasSuffix(result.Path, ".recipe") {
+			err = verifyFile(result.Path, result.Digest)
 		}
 
-		err := untarLayer(ctx, result.Path, dst, dirmodes)
+		if err == nil {
+			err = s.testCachedLayer(ctx, result.Path, layers[i].MediaType)
+		}
 
 		if err != nil {
-			return fmt.Errorf("error extracting %s: %v", result.Path, err)
+			_ = os.Remove(result.Path)
+			return fmt.Errorf("error validating %s: %v", result.Digest, err)
 		}
+	}
 
-		digests[i] = result.Digest
+	return nil
+}
+
+// LayerInfo describes a layer in the cache
+type LayerInfo struct {
+	Digest string `json:"digest"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+
+	// Deduplicated is true if the layer is stored as chunks, in which case
+	// the size is the uncompressed size of the layer
+	Deduplicated bool `json:"deduplicated"`
+
+	// LastUsed is the last time the layer was extracted, it is zero for
+	// layers cached before this was recorded
+	LastUsed time.Time `json:"last_used"`
+
+	// Cached is the time the layer was written to the cache
+	Cached time.Time `json:"cached"`
+
+	// Destinations are the existing destinations which pin the layer, it
+	// is purged once there are none
+	Destinations []string `json:"destinations"`
+}
+
+// Layers returns information about all layers in the cache
+func (s *Store) Layers() ([]*LayerInfo, error) {
+	defer s.rlockCache().MustRUnlock()
+
+	links, err := s.readLinks()
+	if err != nil {
+		return nil, err
 	}
 
-	// set the correct permissions for all directories
-	if err := setDirectoryPermissions(dirmodes); err != nil {
-		return fmt.Errorf("error setting directory permissions: %v", err)
+	pinned := make(map[string][]string)
+
+	for _, link := range links {
+		if alive, err := link.alive(); err != nil || !alive {
+			continue
+		}
+
+		for _, digest := range link.retained(s.Retention.Keep(link.Destination)) {
+			pinned[digest] = append(pinned[digest], link.Destination)
+		}
 	}
 
-	// record the destination in the cache
-	return s.saveLink(dst, digests)
+	selector := fmt.Sprintf("%s/layers/*.*", s.Path)
+	files, err := filepath.Glob(selector)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", selector, err)
+	}
+
+	layers := []*LayerInfo{}
+
+	for _, file := range files {
+		ext := filepath.Ext(file)
+
+		if ext != ".layer" && ext != ".recipe" {
+			continue
+		}
+
+		info := &LayerInfo{
+			Digest:       strings.TrimSuffix(filepath.Base(file), ext),
+			Path:         file,
+			Deduplicated: ext == ".recipe",
+		}
+
+		info.Destinations = append([]string{}, pinned[info.Digest]...)
+
+		stat, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", file, err)
+		}
+
+		info.Size = stat.Size()
+		info.Cached = stat.ModTime()
+
+		if info.Deduplicated {
+			entries, err := readRecipe(file)
+			if err != nil {
+				return nil, err
+			}
+
+			info.Size = 0
+			for _, e := range entries {
+				info.Size += e.Size
+			}
+		}
+
+		if stat, err := os.Stat(s.UsedPath(info.Digest)); err == nil {
+			info.LastUsed = stat.ModTime()
+		}
+
+		layers = append(layers, info)
+	}
+
+	return layers, nil
 }
 
-// downloadLayer downloads the given layer into the cache and sends a path
-// through the given channel, once the download is complete.
-// If the layer was downloaded already, the path will be sent to the channel
-// right away.
-func (s *Store) downloadLayer(ctx context.Context, r *Remote, digest string) (chan *StoreResult, error) {
+// touchLayer records the current time as the last use of the given layer
+func (s *Store) touchLayer(digest string) error {
+	file := s.UsedPath(digest)
+	now := time.Now()
 
-	// we need a buffer of 1 so we can send to the channel even if the other
-	// side has not yet started listening
-	out := make(chan *StoreResult, 1)
-	dst := s.LayerPath(digest)
+	if err := os.Chtimes(file, now, now); err == nil {
+		return nil
+	}
 
-	// if the layer already exists, send it right away
-	_, err := os.Stat(dst)
-	if err == nil {
-		out <- &StoreResult{
-			Path:   dst,
-			Error:  nil,
-			Digest: digest,
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		return fmt.Errorf("error recording use of %s: %v", digest, err)
+	}
+
+	return nil
+}
+
+// Links returns the links of all destinations known to the cache
+func (s *Store) Links() ([]*Link, error) {
+	defer s.rlockCache().MustRUnlock()
+	return s.readLinks()
+}
+
+// DestinationInfo describes a destination known to the cache
+type DestinationInfo struct {
+	*Link
+
+	// Exists is true if the destination directory still exists
+	Exists bool `json:"exists"`
+
+	// CachedLayers is the number of cached layers pinned by the destination
+	CachedLayers int `json:"cached_layers"`
+}
+
+// Destinations returns all destinations known to the cache
+func (s *Store) Destinations() ([]*DestinationInfo, error) {
+	defer s.rlockCache().MustRUnlock()
+
+	links, err := s.readLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*DestinationInfo, len(links))
+
+	for i, link := range links {
+		infos[i] = &DestinationInfo{Link: link}
+
+		if alive, err := link.alive(); err == nil && alive {
+			infos[i].Exists = true
+		}
+
+		for _, digest := range link.retained(s.Retention.Keep(link.Destination)) {
+			if s.cachedLayer(digest) != "" {
+				infos[i].CachedLayers++
+			}
 		}
-		return out, nil
 	}
 
-	// otherwise create the file
-	w, err := os.Create(dst)
+	return infos, nil
+}
+
+// Images returns the distinct image references that were extracted using
+// this cache, in the order they were first encountered
+func (s *Store) Images() ([]string, error) {
+	links, err := s.Links()
 	if err != nil {
 		return nil, err
 	}
 
-	// then download it in the background
+	seen := make(map[string]bool)
+	images := make([]string, 0, len(links))
+
+	for _, link := range links {
+		if link.Image == "" || seen[link.Image] {
+			continue
+		}
+
+		seen[link.Image] = true
+		images = append(images, link.Image)
+	}
+
+	return images, nil
+}
+
+// applyCachedLayer applies the given cached layer to the destination
+func (s *Store) applyCachedLayer(ctx context.Context, file string, l ManifestLayer, u Unpacker, dst string) error {
+	r, err := s.openLayer(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	layer, err := newLayerStream(l.Digest, l.MediaType, cachedMediaType(file, l.MediaType), r)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	return u.ApplyLayer(ctx, layer, dst)
+}
+
+// streamLayer applies the given layer to the destination while it is
+// downloaded, without writing it to the cache. Sources verify the digest
+// once the whole layer has been read, so a mismatch fails the extraction
+// after the layer has been applied.
+func (s *Store) streamLayer(ctx context.Context, r Source, l ManifestLayer, u Unpacker, dst string) error {
+	if err := s.acquireDownload(ctx); err != nil {
+		return err
+	}
+	defer s.releaseDownload()
+
+	Logger.Debug("streaming layer", "digest", l.Digest, "source", r.String())
+
+	blob, w := io.Pipe()
+	downloaded := make(chan error, 1)
+
+	// the outcome is known before the pipe is closed
 	go func() {
-		defer w.Close()
-		err := r.DownloadLayer(digest, w)
+		err := r.DownloadLayer(l.Digest, w)
+		downloaded <- err
+		w.CloseWithError(err)
+	}()
+
+	layer, err := newStreamedLayer(l.Digest, l.MediaType, blob)
+	if err == nil {
+		err = u.ApplyLayer(ctx, layer, dst)
+		layer.Close()
+	}
+
+	// the rest of the blob (e.g. the end of the tar stream) is read, so the
+	// download completes
+	if err == nil {
+		_, err = io.Copy(io.Discard, blob)
+	}
+
+	// a failed download is reported as such, instead of as broken layer,
+	// otherwise the download is stopped
+	if err != nil {
+		select {
+		case downloadErr := <-downloaded:
+			if downloadErr != nil {
+				return downloadErr
+			}
+		default:
+			blob.CloseWithError(err)
+			<-downloaded
+		}
+
+		return err
+	}
+
+	return <-downloaded
+}
+
+// testCachedLayer ensures that the given cached layer can be read
+func (s *Store) testCachedLayer(ctx context.Context, file string, mediaType string) error {
+	r, err := s.openLayer(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return testLayer(ctx, r, cachedMediaType(file, mediaType))
+}
+
+// cachedMediaType returns the media type of the given cached layer, which is
+// an uncompressed tar stream if it has been deduplicated
+func cachedMediaType(file string, mediaType string) string {
+	if strings.HasSuffix(file, ".recipe") {
+		return OCITarLayerMimeType
+	}
+
+	return mediaType
+}
+
+// cachedLayer returns the path to the layer in the cache, or an empty string
+// if the layer has not been cached yet
+func (s *Store) cachedLayer(digest string) string {
+	for _, file := range []string{s.LayerPath(digest), s.RecipePath(digest)} {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// downloadLayer downloads the given layer into the cache and sends a path
+// through the given channel, once the download is complete.
+// If the layer was downloaded already, the path is sent to the channel as
+// soon as no one else is writing it.
+func (s *Store) downloadLayer(ctx context.Context, r Source, digest string, mediaType string) (chan *StoreResult, error) {
+
+	// we need a buffer of 1 so we can send to the channel even if the other
+	// side has not yet started listening
+	out := make(chan *StoreResult, 1)
+
+	go func() {
+		path, cached, err := s.fetchLayerOnce(ctx, r, digest, mediaType)
 
 		out <- &StoreResult{
-			Path:   dst,
+			Path:   path,
 			Error:  err,
 			Digest: digest,
+			Cached: cached,
 		}
 	}()
 
 	return out, nil
 }
 
-// saveLink takes a destination and a list of layer digests and records it in
-// the cache. The resulting files are used to only Purge what is necessary.
-//
-// note that this function does not do any locking -> it assumes the cache
-// has been locked already
-func (s *Store) saveLink(dst string, digests []string) error {
+// fetchLayerOnce returns the path of the given layer in the cache, which is
+// downloaded first if necessary (true is returned if it was cached). This
+// happens while holding the download lock of the layer, so if another pull
+// downloads the same layer, it is waited for and its download is used.
+func (s *Store) fetchLayerOnce(ctx context.Context, r Source, digest string, mediaType string) (string, bool, error) {
+	downloadLock, err := s.acquireLock(ctx, s.DownloadLockPath(digest), func(_ string, holder string, waited time.Duration) {
+		Logger.Debug("waiting for download", "digest", digest, "holder", holder, "waited", waited.Round(time.Second))
+	})
+	if err != nil {
+		return "", false, err
+	}
+	defer downloadLock.MustUnlock()
+
+	if cached := s.cachedLayer(digest); cached != "" {
+		Logger.Debug("layer found in cache", "digest", digest)
+		return cached, true, nil
+	}
+
+	// otherwise download into a partial file, which is only moved into place
+	// once verified, so failed or interrupted downloads are never used. They
+	// are resumed by the next pull, if the source supports it (left over
+	// partial files are removed by purge).
+	partial := s.PartialPath(digest)
+	dst := s.LayerPath(digest)
 
-	file := s.LinkPath(dst)
-	f, err := os.Create(file)
+	w, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return fmt.Errorf("error creating %s: %v", file, err)
+		return "", false, err
 	}
 
-	// the first line is the header
-	if _, err := f.WriteString(fmt.Sprintf("%s\n", dst)); err != nil {
-		return fmt.Errorf("error writing %s: %v", file, err)
+	resumable, err := false, s.acquireDownload(ctx)
+
+	if err == nil {
+		Logger.Debug("downloading layer", "digest", digest, "source", r.String())
+		start := time.Now()
+
+		resumable, err = s.fetchLayer(r, digest, w)
+		s.releaseDownload()
+
+		if err == nil {
+			Logger.Debug("downloaded layer", "digest", digest, "duration", time.Since(start).Round(time.Millisecond))
+		} else {
+			Logger.Debug("download failed", "digest", digest, "error", err)
+		}
+	}
+
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
 	}
 
-	//  the other lines are the digests
-	for _, digest := range digests {
-		if _, err := f.WriteString(fmt.Sprintf("%s\n", digest)); err != nil {
-			return fmt.Errorf("error writing %s: %v", file, err)
+	if err == nil {
+		err = os.Rename(partial, dst)
+	}
+
+	if err != nil && !resumable {
+		os.Remove(partial)
+	}
+
+	path := dst
+	if err == nil && s.Dedup {
+		path, err = s.dedupLayer(digest, mediaType)
+	}
+
+	return path, false, err
+}
+
+// acquireDownload blocks until one of the download slots of the store is
+// free, or the context is done
+func (s *Store) acquireDownload(ctx context.Context) error {
+	s.downloadsOnce.Do(func() {
+		n := s.MaxConcurrentDownloads
+		if n <= 0 {
+			n = DefaultConcurrentDownloads
 		}
+
+		s.downloads = make(chan struct{}, n)
+	})
+
+	select {
+	case s.downloads <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseDownload frees the slot taken by acquireDownload
+func (s *Store) releaseDownload() {
+	<-s.downloads
+}
+
+// rangeSource is implemented by sources that can continue downloads (see
+// Remote.OpenLayer)
+type rangeSource interface {
+	OpenLayer(digest string, offset int64) (io.ReadCloser, int64, error)
+}
+
+// fetchLayer downloads the layer into the given partial file, continuing
+// where an earlier download stopped, if the source supports it. Returns true
+// if the partial file may be resumed after an error.
+func (s *Store) fetchLayer(r Source, digest string, f *os.File) (bool, error) {
+	rs, ok := r.(rangeSource)
+	if !ok {
+		if err := f.Truncate(0); err != nil {
+			return false, err
+		}
+
+		return false, r.DownloadLayer(digest, f)
+	}
+
+	h, err := newDigester(digest)
+	if err != nil {
+		return false, err
+	}
+
+	// the downloaded part is verified together with the rest
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return false, fmt.Errorf("error reading partial download of %s: %v", digest, err)
+	}
+
+	// the download was interrupted after the last byte
+	if offset > 0 && checkDigest(digest, h) == nil {
+		return false, nil
+	}
+
+	stream, start, err := rs.OpenLayer(digest, offset)
+	if err != nil {
+		return offset > 0, err
+	}
+	defer stream.Close()
+
+	// the source starts over
+	if start == 0 && offset > 0 {
+		if err := f.Truncate(0); err != nil {
+			return false, err
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+
+		h.Reset()
+	}
+
+	if _, err := io.Copy(io.MultiWriter(f, h), stream); err != nil {
+		return true, fmt.Errorf("error downloading %s: %v", digest, err)
+	}
+
+	// a complete download with the wrong content cannot be resumed
+	return false, checkDigest(digest, h)
+}
+
+// previousPulls returns the layers of the pulls to the given destination
+// that should be retained once a new pull is recorded
+//
+// note that this function does not do any locking -> it assumes the
+// destination has been locked already, which guards its link
+func (s *Store) previousPulls(dst string) [][]string {
+	data, err := os.ReadFile(s.LinkPath(dst))
+	if err != nil {
+		return nil
+	}
+
+	link, err := parseLink(data)
+	if err != nil {
+		return nil
+	}
+
+	previous := append([][]string{link.Layers}, link.Previous...)
+	keep := s.Retention.Keep(dst) - 1
+
+	if len(previous) > keep {
+		previous = previous[:keep]
+	}
+
+	if len(previous) == 0 {
+		return nil
+	}
+
+	return previous
+}
+
+// saveLink records the given link in the cache. The resulting files are used
+// to only Purge what is necessary. The file is replaced atomically, as the
+// links are read without holding the lock of the destination.
+//
+// note that this function does not do any locking -> it assumes the
+// destination has been locked already, which guards its link
+func (s *Store) saveLink(link *Link) error {
+
+	file := s.LinkPath(link.Destination)
+
+	if err := link.identify(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", file, err)
+	}
+
+	if err := writeFileAtomic(file, data); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// readLinks walks through the stored links and returns a map of the
-// destinations and the digests they're associated with
-func (s *Store) readLinks() (map[string][]string, error) {
+// readLinks walks through the stored links and returns them, sorted by
+// destination
+func (s *Store) readLinks() ([]*Link, error) {
 	selector := fmt.Sprintf("%s/links/*.link", s.Path)
 
 	files, err := filepath.Glob(selector)
@@ -252,51 +1320,112 @@ func (s *Store) readLinks() (map[string][]string, error) {
 		return nil, fmt.Errorf("error reading %s: %v", selector, err)
 	}
 
-	links := make(map[string][]string)
+	links := make([]*Link, 0, len(files))
 
 	for _, file := range files {
-		f, err := os.Open(file)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", file, err)
+		}
+
+		link, err := parseLink(data)
 		if err != nil {
 			return nil, fmt.Errorf("error reading %s: %v", file, err)
 		}
 
-		var dst string
+		links = append(links, link)
+	}
 
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Destination < links[j].Destination
+	})
 
-			// the first line contains the destination
-			if dst == "" {
-				dst = scanner.Text()
-				continue
-			}
+	return links, nil
+}
 
-			// subsequent lines contain layers
-			links[dst] = append(links[dst], scanner.Text())
+// parseLink parses the contents of a link file
+func parseLink(data []byte) (*Link, error) {
+	link := &Link{}
+
+	if bytes.HasPrefix(data, []byte("{")) {
+		if err := json.Unmarshal(data, link); err != nil {
+			return nil, err
 		}
 
-		// manually close instead of deferring, otherwise files are kept open
-		// until the function returns
-		f.Close()
+		return link, nil
+	}
+
+	// older versions wrote the destination on the first line, followed
+	// by one layer digest per line
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
 
-		if err := scanner.Err(); err != nil {
-			return nil, fmt.Errorf("error reading %s: %v", file, err)
+		// the first line contains the destination
+		if link.Destination == "" {
+			link.Destination = scanner.Text()
+			continue
 		}
+
+		// subsequent lines contain layers
+		link.Layers = append(link.Layers, scanner.Text())
 	}
 
-	return links, nil
+	return link, scanner.Err()
 }
 
+// lockCache locks the cache exclusively, once the pulls sharing it are done
 func (s *Store) lockCache() *lock.InterProcessLock {
-	l := &lock.InterProcessLock{Path: path.Join(s.Path, ".lock")}
+	l := &lock.InterProcessLock{Path: s.CacheLockPath()}
 	l.MustLock()
 
 	return l
 }
 
-func (s *Store) lockDestination(dst string) *lock.InterProcessLock {
-	l := &lock.InterProcessLock{Path: fmt.Sprintf("%s.lock", dst)}
-	l.MustLock()
+// rlockCache locks the cache for reading, together with pulls and other
+// readers
+func (s *Store) rlockCache() *lock.InterProcessLock {
+	l := &lock.InterProcessLock{Path: s.CacheLockPath()}
+
+	if err := l.RLockContext(context.Background()); err != nil {
+		panic(err)
+	}
 
 	return l
 }
+
+// acquireLock engages the lock at the given path, unless the context is done
+// before the lock could be acquired. While waiting, the given function is
+// called periodically (if set).
+func (s *Store) acquireLock(ctx context.Context, file string, waiting func(string, string, time.Duration)) (*lock.InterProcessLock, error) {
+	l := &lock.InterProcessLock{Path: file}
+
+	if waiting != nil {
+		l.Waiting = func(holder string, waited time.Duration) {
+			waiting(file, holder, waited)
+		}
+	}
+
+	if err := l.LockContext(ctx); err != nil {
+		return nil, fmt.Errorf("error locking %s: %v", file, err)
+	}
+
+	return l, nil
+}
+
+// acquireSharedLock engages the lock at the given path for reading, like
+// acquireLock
+func (s *Store) acquireSharedLock(ctx context.Context, file string, waiting func(string, string, time.Duration)) (*lock.InterProcessLock, error) {
+	l := &lock.InterProcessLock{Path: file}
+
+	if waiting != nil {
+		l.Waiting = func(holder string, waited time.Duration) {
+			waiting(file, holder, waited)
+		}
+	}
+
+	if err := l.RLockContext(ctx); err != nil {
+		return nil, fmt.Errorf("error locking %s: %v", file, err)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,706 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seantis/roots/pkg/lock"
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExtractFromRegistry tests a pull from a registry end-to-end, selecting
+// the platform of the host from an index
+func TestExtractFromRegistry(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := HostPlatform()
+
+	registry.PushIndex("team/app", "1.0",
+		registrytest.Image{OS: "linux", Architecture: "s390x", Layers: [][]byte{
+			registrytest.Tar(map[string]string{"platform": "s390x"}),
+		}},
+		registrytest.Image{OS: host.OS, Architecture: host.Architecture, Variant: host.Variant, Layers: [][]byte{
+			registrytest.Tar(map[string]string{"etc/": "", "etc/hostname": "app", "platform": "wrong"}),
+			registrytest.Tar(map[string]string{"platform": "host"}),
+		}},
+	)
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	store, _ := NewStore(t.TempDir())
+	dst := t.TempDir()
+
+	_, err = store.Extract(context.Background(), remote, dst, nil)
+	assert.NoError(t, err)
+
+	hostname, _ := os.ReadFile(filepath.Join(dst, "etc", "hostname"))
+	assert.Equal(t, "app", string(hostname))
+
+	platform, _ := os.ReadFile(filepath.Join(dst, "platform"))
+	assert.Equal(t, "host", string(platform))
+}
+
+// TestResumeDownload tests that interrupted downloads are continued with a
+// range request and that corrupt partial downloads are discarded
+func TestResumeDownload(t *testing.T) {
+	defer ClearProviderRegistry()
+
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := HostPlatform()
+	registry.Push("team/app", "1.0", registrytest.Image{
+		OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+		Layers: [][]byte{registrytest.Tar(map[string]string{"hello": strings.Repeat("world", 1000)})},
+	})
+
+	RegisterProvider("mock", &mockProvider{})
+
+	url := URL{Host: registry.Host(), Repository: "team", Name: "app", Tag: "1.0"}
+
+	remote, err := NewRemote(context.Background(), url, "")
+	assert.NoError(t, err)
+
+	layers, err := remote.Layers()
+	assert.NoError(t, err)
+	digest := layers[0].Digest
+
+	var blob bytes.Buffer
+	assert.NoError(t, remote.DownloadLayer(digest, &blob))
+
+	store, _ := NewStore(t.TempDir())
+	assert.NoError(t, os.MkdirAll(filepath.Join(store.Path, "layers"), 0755))
+
+	// half of the layer was downloaded before
+	half := int64(blob.Len() / 2)
+	assert.NoError(t, os.WriteFile(store.PartialPath(digest), blob.Bytes()[:half], 0644))
+
+	_, err = store.Extract(context.Background(), remote, t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	requests := registry.Requests()
+	assert.Equal(t, fmt.Sprintf("GET /v2/team/app/blobs/%s bytes=%d-", digest, half), requests[len(requests)-1])
+	assert.NoFileExists(t, store.PartialPath(digest))
+	assert.FileExists(t, store.LayerPath(digest))
+
+	// a partial download with the wrong content is removed
+	assert.NoError(t, os.Remove(store.LayerPath(digest)))
+	assert.NoError(t, os.WriteFile(store.PartialPath(digest), []byte("garbage"), 0644))
+
+	_, err = store.Extract(context.Background(), remote, t.TempDir(), nil)
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.NoFileExists(t, store.PartialPath(digest))
+
+	_, err = store.Extract(context.Background(), remote, t.TempDir(), nil)
+	assert.NoError(t, err)
+}
+
+// TestParseLink tests the parsing of current and legacy link files
+func TestParseLink(t *testing.T) {
+	link, err := parseLink([]byte(`{"destination":"/foo","image":"bar","layers":["a","b"]}`))
+	assert.NoError(t, err, "error parsing link")
+	assert.Equal(t, &Link{Destination: "/foo", Image: "bar", Layers: []string{"a", "b"}}, link)
+
+	link, err = parseLink([]byte("/foo\na\nb\n"))
+	assert.NoError(t, err, "error parsing legacy link")
+	assert.Equal(t, &Link{Destination: "/foo", Layers: []string{"a", "b"}}, link)
+}
+
+// TestDedupLayer tests that deduplicated layers read like the original
+func TestDedupLayer(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewStore(dir)
+
+	content := bytes.Repeat([]byte("roots"), 100000)
+
+	var archive bytes.Buffer
+	gzw := gzip.NewWriter(&archive)
+	gzw.Write(content)
+	gzw.Close()
+
+	assert.NoError(t, os.WriteFile(store.LayerPath("sha256:foo"), archive.Bytes(), 0644))
+
+	recipe, err := store.dedupLayer("sha256:foo", OCILayerMimeType)
+	assert.NoError(t, err, "error deduplicating layer")
+	assert.Equal(t, store.RecipePath("sha256:foo"), recipe)
+	assert.Equal(t, recipe, store.cachedLayer("sha256:foo"))
+
+	r, err := store.openLayer(recipe)
+	assert.NoError(t, err, "error opening recipe")
+
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err, "error reading recipe")
+	assert.Equal(t, content, data)
+
+	// without a link, purge removes the recipe and the chunks
+	assert.NoError(t, store.Purge())
+
+	chunks, _ := filepath.Glob(filepath.Join(dir, "chunks", "*", "*.chunk"))
+	assert.Empty(t, chunks)
+	assert.Equal(t, "", store.cachedLayer("sha256:foo"))
+}
+
+// TestPurgeRetention tests that purge keeps the layers of retained pulls,
+// while removing the cached manifests
+func TestPurgeRetention(t *testing.T) {
+	dir := t.TempDir()
+	dst := t.TempDir()
+
+	store, _ := NewStore(dir)
+	store.Retention = &Retention{Default: 2}
+
+	for _, digest := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, os.WriteFile(store.LayerPath(digest), nil, 0644))
+	}
+
+	assert.NoError(t, store.saveLink(&Link{
+		Destination: dst,
+		Layers:      []string{"a"},
+		Previous:    [][]string{{"b"}, {"c"}},
+	}))
+
+	// the manifests cached by pulls are removed as well
+	manifests := NewManifestCache(filepath.Join(dir, "manifests"))
+	assert.NoError(t, manifests.save("manifest.json", &cachedManifest{ETag: "a"}))
+
+	assert.NoError(t, store.Purge())
+
+	assert.Equal(t, store.LayerPath("a"), store.cachedLayer("a"))
+	assert.Equal(t, store.LayerPath("b"), store.cachedLayer("b"))
+	assert.Equal(t, "", store.cachedLayer("c"))
+	assert.Equal(t, "", store.cachedLayer("d"))
+	assert.Nil(t, manifests.load("manifest.json"))
+
+	// a new pull only records the retained pulls as previous
+	assert.Equal(t, [][]string{{"a"}}, store.previousPulls(dst))
+}
+
+// TestPurgeIdentity tests that destinations are recognized by their identity
+// rather than their path
+func TestPurgeIdentity(t *testing.T) {
+	dir := t.TempDir()
+	parent := t.TempDir()
+
+	store, _ := NewStore(dir)
+
+	for _, digest := range []string{"a", "b"} {
+		assert.NoError(t, os.WriteFile(store.LayerPath(digest), nil, 0644))
+	}
+
+	renamed := filepath.Join(parent, "renamed")
+	replaced := filepath.Join(parent, "replaced")
+
+	for digest, dst := range map[string]string{"a": renamed, "b": replaced} {
+		assert.NoError(t, os.Mkdir(dst, 0755))
+		assert.NoError(t, store.saveLink(&Link{Destination: dst, Layers: []string{digest}}))
+		assert.FileExists(t, TokenPath(dst))
+	}
+
+	// the renamed destination is found next to its old path
+	assert.NoError(t, os.Rename(renamed, filepath.Join(parent, "moved")))
+
+	// the replaced destination is a different directory at the same path
+	assert.NoError(t, os.RemoveAll(replaced))
+	assert.NoError(t, os.Mkdir(replaced, 0755))
+
+	assert.NoError(t, store.Purge())
+
+	assert.Equal(t, store.LayerPath("a"), store.cachedLayer("a"))
+	assert.Equal(t, "", store.cachedLayer("b"))
+}
+
+// TestHistory tests that pull events are appended and restored in order
+func TestHistory(t *testing.T) {
+	dst := t.TempDir()
+
+	events, err := ReadHistory(dst)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	assert.NoError(t, AppendHistory(dst, &PullEvent{Image: "a", Digest: "sha256:1"}))
+	assert.NoError(t, AppendHistory(dst, &PullEvent{Image: "b", Digest: "sha256:2"}))
+
+	events, err = ReadHistory(dst)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	// restoring into a wiped destination keeps the previous events
+	other := t.TempDir()
+	assert.NoError(t, RestoreHistory(other, events))
+	assert.NoError(t, AppendHistory(other, &PullEvent{Image: "c"}))
+
+	events, err = ReadHistory(other)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"},
+		[]string{events[0].Image, events[1].Image, events[2].Image})
+}
+
+// TestVerifyTree tests that changes to a recorded tree are detected
+func TestVerifyTree(t *testing.T) {
+	dst := t.TempDir()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "a"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "b"), []byte("b"), 0644))
+	assert.NoError(t, os.Symlink("a", filepath.Join(dst, "c")))
+	assert.NoError(t, WriteTree(dst))
+
+	changes, err := VerifyTree(dst)
+	assert.NoError(t, err)
+	assert.Empty(t, changes, "the metadata itself must not count as change")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "a"), []byte("x"), 0644))
+	assert.NoError(t, os.Chmod(filepath.Join(dst, "b"), 0600))
+	assert.NoError(t, os.Remove(filepath.Join(dst, "c")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "d"), []byte("d"), 0644))
+
+	changes, err = VerifyTree(dst)
+	assert.NoError(t, err)
+
+	summary := []string{}
+	for _, c := range changes {
+		summary = append(summary, c.String())
+	}
+
+	assert.Equal(t, []string{
+		"a: modified (content)",
+		"b: modified (mode)",
+		"c: removed",
+		"d: added",
+	}, summary)
+}
+
+// TestCompareTrees tests the comparison of two extractions
+func TestCompareTrees(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+
+	for _, dst := range []string{a, b} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dst, "a"), []byte("a"), 0644))
+		assert.NoError(t, os.Symlink("a", filepath.Join(dst, "b")))
+		assert.NoError(t, os.MkdirAll(filepath.Join(dst, MetadataDir), 0755))
+	}
+
+	// the metadata is ignored
+	assert.NoError(t, os.WriteFile(HistoryPath(a), []byte("{}\n"), 0644))
+
+	changes, err := CompareTrees(a, b)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// hardlinks are compared
+	assert.NoError(t, os.Link(filepath.Join(b, "a"), filepath.Join(b, "c")))
+	assert.NoError(t, os.WriteFile(filepath.Join(a, "c"), []byte("a"), 0644))
+
+	changes, err = CompareTrees(a, b)
+	assert.NoError(t, err)
+
+	summary := []string{}
+	for _, c := range changes {
+		summary = append(summary, c.String())
+	}
+
+	assert.Equal(t, []string{
+		"a: modified (hardlinks)",
+		"c: modified (hardlinks)",
+	}, summary)
+}
+
+// TestTreeHash tests that the tree hash only depends on the tree
+func TestTreeHash(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+
+	for _, dst := range []string{a, b} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dst, "etc"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dst, "etc", "hosts"), []byte("localhost"), 0644))
+		assert.NoError(t, os.Symlink("etc/hosts", filepath.Join(dst, "hosts")))
+	}
+
+	// the metadata is ignored
+	assert.NoError(t, os.MkdirAll(filepath.Join(a, MetadataDir), 0755))
+	assert.NoError(t, os.WriteFile(HistoryPath(a), []byte("{}\n"), 0644))
+
+	hash, err := TreeHash(a)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", hash)
+
+	other, err := TreeHash(b)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, other)
+
+	// changes within subdirectories change the hash
+	assert.NoError(t, os.Chmod(filepath.Join(b, "etc", "hosts"), 0600))
+
+	other, err = TreeHash(b)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
+
+// TestMergeExtraction tests that merging keeps unchanged files and removes
+// the paths which are no longer part of the image
+func TestMergeExtraction(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+	files := map[string][]byte{
+		"etc/hostname": []byte("host"),
+		"etc/motd":     []byte("hello"),
+		"etc/version":  []byte("1.0"),
+		"etc/stale":    []byte("stale"),
+		"tmp/stale":    []byte("stale"),
+	}
+
+	old := newLayeredSource(tarball(t, files, "etc/", "etc/hostname", "etc/motd", "etc/version", "etc/stale", "tmp/", "tmp/stale"))
+	_, err = store.Extract(context.Background(), old, dst, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dst, MetadataDir), 0755))
+	before, err := os.Stat(filepath.Join(dst, "etc/hostname"))
+	assert.NoError(t, err)
+
+	files["etc/motd"] = []byte("howdy")
+	files["etc/version"] = []byte("1.0.1")
+
+	updated := newLayeredSource(tarball(t, files, "etc/", "etc/hostname", "etc/motd", "etc/version"))
+	_, err = store.Extract(context.Background(), updated, dst, nil)
+	assert.ErrorContains(t, err, "not empty")
+
+	result, err := store.Extract(context.Background(), updated, dst, &ExtractOptions{Merge: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Equal(t, 2, result.Removed)
+
+	after, err := os.Stat(filepath.Join(dst, "etc/hostname"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(before, after), "unchanged files must not be rewritten")
+
+	for name, content := range map[string]string{"etc/motd": "howdy", "etc/version": "1.0.1"} {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+
+	for _, name := range []string{"etc/stale", "tmp"} {
+		_, err = os.Stat(filepath.Join(dst, name))
+		assert.True(t, os.IsNotExist(err), "stale paths must be removed")
+	}
+
+	_, err = os.Stat(filepath.Join(dst, MetadataDir))
+	assert.NoError(t, err, "the metadata must be kept")
+
+	_, err = os.Stat(filepath.Join(dst, "etc/motd.roots-merge"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestUpdateExtraction tests that updates replace the destination with a new
+// directory, keeping the history and the identity of the destination
+func TestUpdateExtraction(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "root")
+	assert.NoError(t, os.Mkdir(dst, 0750))
+
+	files := map[string][]byte{"etc/version": []byte("1.0"), "etc/stale": []byte("stale")}
+	old := newLayeredSource(tarball(t, files, "etc/", "etc/version", "etc/stale"))
+
+	_, err = store.Extract(context.Background(), old, dst, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, AppendHistory(dst, &PullEvent{Image: "old", Digest: "sha256:image"}))
+	assert.NoError(t, WriteTree(dst))
+	assert.NoError(t, WriteDigest(dst, "sha256:image"))
+
+	token := readToken(dst)
+	assert.NotEmpty(t, token)
+	before, err := os.Stat(dst)
+	assert.NoError(t, err)
+
+	files["etc/version"] = []byte("1.1")
+	updated := newLayeredSource(tarball(t, files, "etc/", "etc/version"))
+
+	_, err = store.Extract(context.Background(), updated, dst, &ExtractOptions{Update: true})
+	assert.NoError(t, err)
+
+	after, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(before, after), "the destination must be replaced")
+	assert.Equal(t, os.FileMode(0750), after.Mode().Perm())
+
+	version, _ := os.ReadFile(filepath.Join(dst, "etc/version"))
+	assert.Equal(t, "1.1", string(version))
+	assert.NoFileExists(t, filepath.Join(dst, "etc/stale"))
+
+	// the history and the token are kept, the tree and digest are not
+	history, err := ReadHistory(dst)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.NoFileExists(t, TreePath(dst))
+	assert.NoFileExists(t, DigestPath(dst))
+
+	assert.Equal(t, token, readToken(dst))
+
+	// the old tree is gone and the layers are still in use
+	entries, err := os.ReadDir(parent)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.Contains(entry.Name(), "roots-update"), entry.Name())
+	}
+
+	assert.NoError(t, store.Purge())
+	for _, layer := range updated.manifest.Layers {
+		assert.NotEmpty(t, store.cachedLayer(layer.Digest))
+	}
+}
+
+// slowSource is a layered source which records the downloads running at the
+// same time
+type slowSource struct {
+	*layeredSource
+
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (s *slowSource) DownloadLayer(digest string, w io.Writer) error {
+	s.mu.Lock()
+	s.running++
+	s.peak = max(s.peak, s.running)
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+
+	return s.layeredSource.DownloadLayer(digest, w)
+}
+
+// TestConcurrentDownloads tests that the store limits the number of layers
+// downloaded at the same time
+func TestConcurrentDownloads(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+	store.MaxConcurrentDownloads = 2
+
+	layers := make([][]byte, 8)
+	for i := range layers {
+		name := fmt.Sprintf("layer%d", i)
+		layers[i] = tarball(t, map[string][]byte{name: []byte(name)}, name)
+	}
+
+	source := &slowSource{layeredSource: newLayeredSource(layers...)}
+	dst := t.TempDir()
+
+	result, err := store.Extract(context.Background(), source, dst, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, result.CacheMisses)
+	assert.Equal(t, 2, source.peak)
+
+	for i := range layers {
+		assert.FileExists(t, filepath.Join(dst, fmt.Sprintf("layer%d", i)))
+	}
+}
+
+// TestDownloadLock tests that a layer downloaded by someone else is waited
+// for and then used, instead of being downloaded again
+func TestDownloadLock(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	layer := tarball(t, map[string][]byte{"foo": []byte("bar")}, "foo")
+	source := newLayeredSource(layer)
+	digest := source.manifest.Layers[0].Digest
+
+	// another process downloads the layer
+	download := &lock.InterProcessLock{Path: store.DownloadLockPath(digest)}
+	download.MustLock()
+
+	type extraction struct {
+		result *ExtractResult
+		err    error
+	}
+
+	done := make(chan extraction)
+	dst := t.TempDir()
+
+	go func() {
+		result, err := store.Extract(context.Background(), &failingSource{source}, dst, nil)
+		done <- extraction{result, err}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, os.WriteFile(store.LayerPath(digest), layer, 0644))
+	download.MustUnlock()
+
+	x := <-done
+	assert.NoError(t, x.err)
+	assert.Equal(t, 1, x.result.CacheHits)
+	assert.FileExists(t, filepath.Join(dst, "foo"))
+}
+
+// blockingSource is a layered source whose downloads wait until released
+type blockingSource struct {
+	*layeredSource
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSource) DownloadLayer(digest string, w io.Writer) error {
+	close(s.started)
+	<-s.release
+
+	return s.layeredSource.DownloadLayer(digest, w)
+}
+
+// TestConcurrentPulls tests that pulls of different layers share the cache,
+// while purge waits for them
+func TestConcurrentPulls(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	slow := &blockingSource{
+		layeredSource: newLayeredSource(tarball(t, map[string][]byte{"slow": []byte("slow")}, "slow")),
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := store.Extract(context.Background(), slow, t.TempDir(), nil)
+		done <- err
+	}()
+
+	<-slow.started
+
+	// another image is pulled while the first one is downloading
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fast := newLayeredSource(tarball(t, map[string][]byte{"fast": []byte("fast")}, "fast"))
+	_, err = store.Extract(ctx, fast, t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	// purge only removes the unused layers once the pull is done
+	purged := make(chan error)
+	go func() { purged <- store.Purge() }()
+
+	select {
+	case <-purged:
+		t.Fatal("purge must wait for running pulls")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(slow.release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-purged)
+}
+
+// TestStreamExtraction tests that streamed layers are extracted in a single
+// pass, with whiteouts applying to the lower layers only
+func TestStreamExtraction(t *testing.T) {
+	files := map[string][]byte{
+		"etc/passwd":           []byte("root"),
+		"etc/motd":             []byte("hello"),
+		"tmp/cache/a":          []byte("a"),
+		"var/log/old":          []byte("old"),
+		"etc/.wh.motd":         {},
+		"tmp/.wh.cache":        {},
+		"var/log/.wh..wh..opq": {},
+		"var/log/new":          []byte("new"),
+	}
+
+	source := newLayeredSource(
+		tarball(t, files, "etc/passwd", "etc/motd", "tmp/cache/a", "var/log/old"),
+		tarball(t, files, "var/log/new", "var/log/.wh..wh..opq", "etc/.wh.motd", "tmp/.wh.cache"),
+	)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	dst := t.TempDir()
+
+	result, err := store.Extract(context.Background(), source, dst, &ExtractOptions{Stream: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Layers)
+	assert.Equal(t, 2, result.CacheMisses)
+
+	assert.FileExists(t, filepath.Join(dst, "etc/passwd"))
+	assert.FileExists(t, filepath.Join(dst, "var/log/new"))
+	assert.NoFileExists(t, filepath.Join(dst, "etc/motd"))
+	assert.NoFileExists(t, filepath.Join(dst, "var/log/old"))
+	assert.NoDirExists(t, filepath.Join(dst, "tmp/cache"))
+
+	layers, err := store.Layers()
+	assert.NoError(t, err)
+	assert.Empty(t, layers)
+
+	// failed downloads fail the extraction
+	_, err = store.Extract(context.Background(), &failingSource{source}, t.TempDir(), &ExtractOptions{Stream: true})
+	assert.ErrorContains(t, err, "error streaming")
+}
+
+// TestExtractLogging tests that downloads, cache hits and the statistics of
+// extractions are logged at debug level
+func TestExtractLogging(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := Logger
+	Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	defer func() { Logger = logger }()
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	source := newLayeredSource(tarball(t, map[string][]byte{"foo": []byte("bar")}, "foo"))
+
+	for i := 0; i < 2; i++ {
+		_, err = store.Extract(context.Background(), source, t.TempDir(), nil)
+		assert.NoError(t, err)
+	}
+
+	messages := map[string]int{}
+	summaries := []map[string]any{}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]any{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+
+		messages[record["msg"].(string)]++
+
+		if record["msg"] == "extracted image" {
+			summaries = append(summaries, record)
+		}
+	}
+
+	assert.Equal(t, 1, messages["downloading layer"])
+	assert.Equal(t, 1, messages["downloaded layer"])
+	assert.Equal(t, 1, messages["layer found in cache"])
+	assert.Equal(t, 2, messages["extracted layer"])
+
+	assert.Len(t, summaries, 2)
+	assert.Equal(t, float64(1), summaries[0]["cache_misses"])
+	assert.Equal(t, float64(1), summaries[1]["cache_hits"])
+}
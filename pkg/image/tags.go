@@ -0,0 +1,205 @@
+package image
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// linkNext matches the next page in the Link header of paginated lists
+var linkNext = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// tagWorkers is the number of tags whose creation time is looked up
+// concurrently
+const tagWorkers = 8
+
+// Tag is a tag of a repository, with the creation time of the image if it
+// has been looked up
+type Tag struct {
+	Name    string     `json:"tag"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// TagFilter selects and orders the tags of a repository
+type TagFilter struct {
+
+	// Match only keeps the tags matching the expression
+	Match *regexp.Regexp
+
+	// Semver only keeps the tags that are semantic versions satisfying the
+	// constraint and orders them by version
+	Semver *Constraint
+
+	// Created orders the tags by the creation time of their images, which
+	// requires a request for each tag (tags without it come first)
+	Created bool
+
+	// Latest only keeps the last n tags, after ordering them
+	Latest int
+}
+
+// Tags lists the tags of the repository, following the pagination of the
+// registry. The tags are returned in the order of the registry.
+func (r *Remote) Tags() ([]string, error) {
+	tags := []string{}
+	next := r.url.Endpoint("tags", "list")
+
+	for next != "" {
+		res, err := r.requestURL("GET", "application/json", next)
+		if err != nil {
+			return nil, fmt.Errorf("error listing tags: %w", err)
+		}
+
+		link := res.Header.Get("Link")
+
+		page := &struct {
+			Tags []string `json:"tags"`
+		}{}
+
+		if err := r.unmarshal(res, page); err != nil {
+			return nil, fmt.Errorf("error parsing tags: %v", err)
+		}
+
+		tags = append(tags, page.Tags...)
+
+		if next, err = nextPage(next, link); err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// nextPage returns the absolute url of the next page in the given Link
+// header, or an empty string if there is none
+func nextPage(current string, link string) (string, error) {
+	match := linkNext.FindStringSubmatch(link)
+	if match == nil {
+		return "", nil
+	}
+
+	base, err := neturl.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(match[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid link %s: %v", match[1], err)
+	}
+
+	return next.String(), nil
+}
+
+// TagCreated returns the creation time of the image with the given tag, as
+// found in the annotations of the manifest or in the config of the image.
+// Nil is returned if neither records it.
+func (r *Remote) TagCreated(tag string) (*time.Time, error) {
+	tagged := *r
+	tagged.url.Tag = tag
+	tagged.url.Digest = ""
+	tagged.pinned = ""
+
+	m, err := tagged.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	if created, ok := m.Annotations[CreatedAnnotation]; ok {
+		if t, err := time.Parse(time.RFC3339, created); err == nil {
+			return &t, nil
+		}
+	}
+
+	if m.IsArtifact() {
+		return nil, nil
+	}
+
+	c, err := tagged.config(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Created, nil
+}
+
+// FilterTags lists the tags of the repository, selected and ordered by the
+// given filter
+func (r *Remote) FilterTags(filter *TagFilter) ([]*Tag, error) {
+	names, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []*Tag{}
+	versions := make(map[string]*Version)
+
+	for _, name := range names {
+		if filter.Match != nil && !filter.Match.MatchString(name) {
+			continue
+		}
+
+		if filter.Semver != nil {
+			v, err := ParseVersion(name)
+			if err != nil || !filter.Semver.Check(v) {
+				continue
+			}
+
+			versions[name] = v
+		}
+
+		tags = append(tags, &Tag{Name: name})
+	}
+
+	if filter.Semver != nil {
+		sort.SliceStable(tags, func(i, j int) bool {
+			return versions[tags[i].Name].Compare(versions[tags[j].Name]) < 0
+		})
+	}
+
+	if filter.Created {
+		err := parallel(tagWorkers, len(tags), func(i int) error {
+			if err := r.ctx.Err(); err != nil {
+				return err
+			}
+
+			created, err := r.TagCreated(tags[i].Name)
+			if err != nil {
+				return fmt.Errorf("error looking up %s: %w", tags[i].Name, err)
+			}
+
+			tags[i].Created = created
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(tags, func(i, j int) bool {
+			a, b := tags[i].Created, tags[j].Created
+
+			if a == nil || b == nil {
+				return a == nil && b != nil
+			}
+
+			return a.Before(*b)
+		})
+	}
+
+	if filter.Latest > 0 && len(tags) > filter.Latest {
+		tags = tags[len(tags)-filter.Latest:]
+	}
+
+	return tags, nil
+}
+
+func (t *Tag) String() string {
+	if t.Created == nil {
+		return t.Name
+	}
+
+	return fmt.Sprintf("%s\t%s", t.Name, t.Created.Format(time.RFC3339))
+}
@@ -0,0 +1,107 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/dankinder/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// tagsServer returns a registry with paginated tags, whose images record the
+// given creation times
+func tagsServer(created map[string]string) *httpmock.Server {
+	downstream := &httpmock.MockHandler{}
+
+	link := make(http.Header)
+	link.Add("Link", `</v2/library/app/tags/list?last=1.10.0&n=3>; rel="next"`)
+
+	downstream.On("Handle", "GET", "/v2/library/app/tags/list", mock.Anything).Return(httpmock.Response{
+		Header: link,
+		Body:   []byte(`{"name": "library/app", "tags": ["1.2.0", "1.9.1", "1.10.0"]}`),
+	})
+	downstream.On("Handle", "GET", "/v2/library/app/tags/list?last=1.10.0&n=3", mock.Anything).Return(httpmock.Response{
+		Body: []byte(`{"name": "library/app", "tags": ["2.0.0-rc.1", "latest", "2.0.0"]}`),
+	})
+
+	for tag, time := range created {
+		manifest := []byte(fmt.Sprintf(`{
+			"schemaVersion": 2,
+			"mediaType": "%s",
+			"config": {"mediaType": "application/vnd.oci.image.config.v1+json"},
+			"layers": [],
+			"annotations": {"%s": "%s"}
+		}`, OCIManifestMimeType, CreatedAnnotation, time))
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+		header := make(http.Header)
+		header.Add("Docker-Content-Digest", digest)
+		header.Add("Content-Type", OCIManifestMimeType)
+
+		downstream.On("Handle", "GET", "/v2/library/app/manifests/"+tag, mock.Anything).Return(httpmock.Response{
+			Status: 404,
+		})
+		downstream.On("Handle", "HEAD", "/v2/library/app/manifests/"+tag, mock.Anything).Return(httpmock.Response{
+			Header: header,
+		})
+		downstream.On("Handle", "GET", "/v2/library/app/manifests/"+digest, mock.Anything).Return(httpmock.Response{
+			Header: header,
+			Body:   manifest,
+		})
+	}
+
+	return httpmock.NewServer(downstream)
+}
+
+// TestTags tests listing and filtering the tags of a repository
+func TestTags(t *testing.T) {
+	server := tagsServer(map[string]string{
+		"1.9.1":  "2024-03-01T00:00:00Z",
+		"1.10.0": "2024-01-01T00:00:00Z",
+		"2.0.0":  "2024-02-01T00:00:00Z",
+	})
+	defer server.Close()
+
+	remote := &Remote{
+		client: http.DefaultClient,
+		url:    URL{Host: server.URL(), Repository: "library", Name: "app", Tag: "latest"},
+		ctx:    context.Background(),
+	}
+
+	tags, err := remote.Tags()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.9.1", "1.10.0", "2.0.0-rc.1", "latest", "2.0.0"}, tags)
+
+	names := func(tags []*Tag) []string {
+		result := []string{}
+		for _, t := range tags {
+			result = append(result, t.Name)
+		}
+		return result
+	}
+
+	filtered, err := remote.FilterTags(&TagFilter{Match: regexp.MustCompile(`^1\.`)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2.0", "1.9.1", "1.10.0"}, names(filtered))
+
+	constraint, err := ParseConstraint(">=1.9")
+	assert.NoError(t, err)
+
+	filtered, err = remote.FilterTags(&TagFilter{Semver: constraint})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.9.1", "1.10.0", "2.0.0"}, names(filtered))
+
+	filtered, err = remote.FilterTags(&TagFilter{Semver: constraint, Latest: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2.0.0"}, names(filtered))
+
+	filtered, err = remote.FilterTags(&TagFilter{Semver: constraint, Created: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.10.0", "2.0.0", "1.9.1"}, names(filtered))
+	assert.Equal(t, "1.10.0\t2024-01-01T00:00:00Z", filtered[0].String())
+}
@@ -0,0 +1,127 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// TarOwnership overrides the owners recorded in the tar streams written by
+// roots, like the options of GNU tar with the same names. Without it, the
+// names of the owners are looked up in the local passwd and group database.
+type TarOwnership struct {
+
+	// NumericOwner records only the numeric ids, without names
+	NumericOwner bool
+
+	// Owner and Group replace the owner and group of all entries, if set
+	Owner *TarOwner
+	Group *TarOwner
+}
+
+// TarOwner is a user or a group recorded in a tar header
+type TarOwner struct {
+	Name string
+	ID   int
+}
+
+// ParseTarOwner parses a user given as NAME:ID, NAME or ID (optionally
+// prefixed by '+' to denote a number). Names without id are looked up.
+func ParseTarOwner(s string) (*TarOwner, error) {
+	return parseTarOwner(s, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+
+		return u.Uid, nil
+	})
+}
+
+// ParseTarGroup parses a group given as NAME:ID, NAME or ID (optionally
+// prefixed by '+' to denote a number). Names without id are looked up.
+func ParseTarGroup(s string) (*TarOwner, error) {
+	return parseTarOwner(s, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+
+		return g.Gid, nil
+	})
+}
+
+func parseTarOwner(s string, lookup func(name string) (string, error)) (*TarOwner, error) {
+	name, id, found := strings.Cut(s, ":")
+
+	if !found {
+		if n, err := strconv.Atoi(strings.TrimPrefix(s, "+")); err == nil && n >= 0 {
+			return &TarOwner{ID: n}, nil
+		}
+
+		looked, err := lookup(s)
+		if err != nil {
+			return nil, fmt.Errorf("unknown owner %s: %v", s, err)
+		}
+
+		name, id = s, looked
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "+"))
+	if err != nil || n < 0 || name == "" {
+		return nil, fmt.Errorf("invalid owner %s", s)
+	}
+
+	return &TarOwner{Name: name, ID: n}, nil
+}
+
+// apply overrides the owners recorded in the given header
+func (o *TarOwnership) apply(h *tar.Header) {
+	if o == nil {
+		return
+	}
+
+	if o.Owner != nil {
+		h.Uid, h.Uname = o.Owner.ID, o.Owner.Name
+	}
+
+	if o.Group != nil {
+		h.Gid, h.Gname = o.Group.ID, o.Group.Name
+	}
+
+	if o.NumericOwner {
+		h.Uname, h.Gname = "", ""
+	}
+}
+
+// rewrite copies the tar stream to the writer, overriding the owners
+func (o *TarOwnership) rewrite(stream io.Reader, w io.Writer) error {
+	tr := tar.NewReader(stream)
+	tw := tar.NewWriter(w)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		o.apply(h)
+
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
@@ -0,0 +1,93 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseTarOwner tests the owner formats known from GNU tar
+func TestParseTarOwner(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		return "42", nil
+	}
+
+	for s, expected := range map[string]*TarOwner{
+		"0":        {ID: 0},
+		"+1000":    {ID: 1000},
+		"app:1000": {Name: "app", ID: 1000},
+		"app:+7":   {Name: "app", ID: 7},
+		"app":      {Name: "app", ID: 42},
+	} {
+		owner, err := parseTarOwner(s, lookup)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, owner, s)
+	}
+
+	for _, s := range []string{":1000", "app:", "app:x", "app:-1"} {
+		_, err := parseTarOwner(s, lookup)
+		assert.Error(t, err, s)
+	}
+}
+
+// TestLayerOwnership tests that the owners of built layers are overridden
+func TestLayerOwnership(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "hello"), []byte("world"), 0644))
+
+	headers := func(l *builtLayer) []*tar.Header {
+		gz, err := gzip.NewReader(l.file)
+		assert.NoError(t, err)
+
+		result := []*tar.Header{}
+		tr := tar.NewReader(gz)
+
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				return result
+			}
+
+			assert.NoError(t, err)
+			result = append(result, h)
+		}
+	}
+
+	ownership := &TarOwnership{
+		Owner: &TarOwner{Name: "app", ID: 1000},
+		Group: &TarOwner{ID: 100},
+	}
+
+	layer, err := buildLayer(src, ownership)
+	assert.NoError(t, err)
+	defer layer.Close()
+
+	h := headers(layer)
+	assert.Len(t, h, 1)
+	assert.Equal(t, 1000, h[0].Uid)
+	assert.Equal(t, "app", h[0].Uname)
+	assert.Equal(t, 100, h[0].Gid)
+	assert.Equal(t, "", h[0].Gname)
+
+	// tarballs are rewritten, numeric owners drop the names
+	tarball := filepath.Join(t.TempDir(), "layer.tar")
+	f, err := os.Create(tarball)
+	assert.NoError(t, err)
+	assert.NoError(t, writeDirectoryTar(src, f, &TarOwnership{Owner: &TarOwner{Name: "root", ID: 0}}))
+	f.Close()
+
+	layer, err = buildLayer(tarball, &TarOwnership{NumericOwner: true})
+	assert.NoError(t, err)
+	defer layer.Close()
+
+	h = headers(layer)
+	assert.Len(t, h, 1)
+	assert.Equal(t, 0, h[0].Uid)
+	assert.Equal(t, "", h[0].Uname)
+	assert.Equal(t, "", h[0].Gname)
+}
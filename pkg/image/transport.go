@@ -0,0 +1,186 @@
+package image
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// transport is the base of the clients connecting to registries, see
+// ConfigureTransport
+var transport http.RoundTripper = http.DefaultTransport
+
+// TransportOptions configure the connections to registries
+type TransportOptions struct {
+
+	// Proxy is the URL of the proxy used for all connections, instead of the
+	// one in HTTP_PROXY or HTTPS_PROXY. Credentials in the URL are sent to
+	// the proxy, also when tunneling with CONNECT. Hosts in NO_PROXY and
+	// loopback addresses are reached directly.
+	Proxy string
+
+	// CACert is the path to a PEM file with certificate authorities that are
+	// trusted in addition to the ones of the system (e.g. of a private
+	// registry)
+	CACert string
+
+	// InsecureSkipTLSVerify accepts any certificate, which is only meant for
+	// test setups with self-signed certificates
+	InsecureSkipTLSVerify bool
+}
+
+// Transport returns the transport underlying the clients of the providers,
+// which respects HTTP_PROXY, HTTPS_PROXY and NO_PROXY by default
+func Transport() http.RoundTripper {
+	return transport
+}
+
+// HTTPClient returns an unauthenticated client with the configured transport
+func HTTPClient() *http.Client {
+	return &http.Client{Transport: transport}
+}
+
+// ConfigureTransport replaces the transport used by the providers. It has to
+// be called before the first client is created, as providers reuse them.
+func ConfigureTransport(opts TransportOptions) error {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxy, err := parseProxy(opts.Proxy)
+		if err != nil {
+			return err
+		}
+
+		bypass := noProxy(os.Getenv("NO_PROXY") + "," + os.Getenv("no_proxy"))
+
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypass(req.URL.Hostname()) {
+				return nil, nil
+			}
+
+			return proxy, nil
+		}
+	}
+
+	if opts.CACert != "" || opts.InsecureSkipTLSVerify {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.InsecureSkipTLSVerify}
+	}
+
+	if opts.CACert != "" {
+		pool, err := loadCACert(opts.CACert)
+		if err != nil {
+			return err
+		}
+
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	transport = t
+	return nil
+}
+
+// loadCACert returns the certificates of the system, together with the ones
+// in the given PEM file
+func loadCACert(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// parseProxy parses the URL of a proxy, defaulting to http:// if there is
+// no scheme, like HTTP_PROXY
+func parseProxy(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+
+	proxy, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %v", value, err)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s, expected http, https or socks5", proxy.Scheme)
+	}
+
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %s: missing host", value)
+	}
+
+	return proxy, nil
+}
+
+// noProxy returns a function which is true for the hosts that should not be
+// reached through the proxy: loopback addresses and the entries of the given
+// comma separated list (as in NO_PROXY), which match the host and, unless
+// they are addresses, its subdomains
+func noProxy(list string) func(host string) bool {
+	entries := []string{}
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+
+		if entry == "*" {
+			entry = "."
+		}
+
+		if entry = strings.TrimPrefix(entry, "*"); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return func(host string) bool {
+		host = strings.ToLower(host)
+
+		if host == "localhost" {
+			return true
+		}
+
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+
+		for _, entry := range entries {
+			if entry == "." || host == strings.TrimPrefix(entry, ".") {
+				return true
+			}
+
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				if ip := net.ParseIP(host); ip != nil && network.Contains(ip) {
+					return true
+				}
+
+				continue
+			}
+
+			if net.ParseIP(entry) == nil && strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,97 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProxy tests that requests are sent through the configured proxy, with
+// the credentials of the proxy URL
+func TestProxy(t *testing.T) {
+	defer func() { transport = http.DefaultTransport }()
+
+	var requested, authorization string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		authorization = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	assert.NoError(t, ConfigureTransport(TransportOptions{
+		Proxy: "http://user:secret@" + proxy.Listener.Addr().String(),
+	}))
+
+	res, err := HTTPClient().Get("http://registry.example.org/v2/")
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, "http://registry.example.org/v2/", requested)
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")), authorization)
+
+	assert.ErrorContains(t, ConfigureTransport(TransportOptions{Proxy: "ftp://proxy"}), "unsupported proxy scheme")
+}
+
+// TestNoProxy tests that loopback addresses and the hosts, domains and
+// networks in NO_PROXY are reached directly
+func TestNoProxy(t *testing.T) {
+	bypass := noProxy("example.org, .internal,10.0.0.0/8, 192.168.1.1:5000")
+
+	assert.True(t, bypass("localhost"))
+	assert.True(t, bypass("127.0.0.1"))
+	assert.True(t, bypass("::1"))
+	assert.True(t, bypass("example.org"))
+	assert.True(t, bypass("registry.example.org"))
+	assert.True(t, bypass("registry.internal"))
+	assert.True(t, bypass("10.1.2.3"))
+	assert.True(t, bypass("192.168.1.1"))
+
+	assert.False(t, bypass("example.com"))
+	assert.False(t, bypass("notexample.org"))
+	assert.False(t, bypass("192.168.1.2"))
+	assert.False(t, bypass("ghcr.io"))
+
+	assert.True(t, noProxy("*")("ghcr.io"))
+	assert.False(t, noProxy("")("ghcr.io"))
+}
+
+// TestTLS tests that registries with certificates of a private authority are
+// trusted if the authority is given, or if verification is skipped
+func TestTLS(t *testing.T) {
+	defer func() { transport = http.DefaultTransport }()
+
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer registry.Close()
+
+	get := func(opts TransportOptions) error {
+		assert.NoError(t, ConfigureTransport(opts))
+
+		res, err := HTTPClient().Get(registry.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		return err
+	}
+
+	assert.ErrorContains(t, get(TransportOptions{}), "certificate")
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: registry.Certificate().Raw,
+	}), 0644)
+
+	assert.NoError(t, get(TransportOptions{CACert: path}))
+	assert.NoError(t, get(TransportOptions{InsecureSkipTLSVerify: true}))
+
+	os.WriteFile(path, []byte("no certificate"), 0644)
+	assert.ErrorContains(t, ConfigureTransport(TransportOptions{CACert: path}), "no certificates found")
+}
@@ -0,0 +1,380 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TreeEntry describes a single file of an extracted destination
+type TreeEntry struct {
+	Path   string      `json:"path"`
+	Type   string      `json:"type"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size,omitempty"`
+	UID    int         `json:"uid"`
+	GID    int         `json:"gid"`
+	SHA256 string      `json:"sha256,omitempty"`
+	Link   string      `json:"link,omitempty"`
+
+	// Links is the number of hardlinks, which is not recorded
+	Links uint64 `json:"-"`
+}
+
+// TreeChange is a difference between the recorded and the current tree
+type TreeChange struct {
+	Path string `json:"path"`
+
+	// Change is either "added", "removed" or "modified"
+	Change string `json:"change"`
+
+	// Fields lists the modified properties (type, mode, size, owner, content,
+	// link or hardlinks)
+	Fields []string `json:"fields,omitempty"`
+}
+
+// TreePath returns the path to the recorded tree of a destination
+func TreePath(dst string) string {
+	return filepath.Join(dst, MetadataDir, "mtree.json")
+}
+
+// ScanTree walks the destination and returns its entries sorted by path,
+// excluding the metadata written by roots
+func ScanTree(dst string) ([]*TreeEntry, error) {
+	entries := []*TreeEntry{}
+
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if rel == MetadataDir {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		e, err := newTreeEntry(path, filepath.ToSlash(rel), info)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s: %v", dst, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+// newTreeEntry describes the given file
+func newTreeEntry(path string, rel string, info fs.FileInfo) (*TreeEntry, error) {
+	e := &TreeEntry{
+		Path: rel,
+		Type: fileType(info.Mode()),
+		Mode: info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
+	}
+
+	e.UID, e.GID = fileOwner(info)
+	e.Links = fileLinks(info)
+
+	switch e.Type {
+	case "file":
+		e.Size = info.Size()
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, fmt.Errorf("error hashing %s: %v", path, err)
+		}
+
+		e.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	case "symlink":
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Link = target
+	}
+
+	return e, nil
+}
+
+// fileType returns the name of the type of the given mode
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "char"
+	case mode&fs.ModeDevice != 0:
+		return "block"
+	default:
+		return "file"
+	}
+}
+
+// TreeHash returns a Merkle-style hash over the destination (excluding the
+// metadata written by roots), which is stable across machines. It covers the
+// names, types, modes, owners, contents and symlink targets of all files.
+func TreeHash(dst string) (string, error) {
+	digest, err := hashDir(dst, true)
+	if err != nil {
+		return "", fmt.Errorf("error hashing %s: %v", dst, err)
+	}
+
+	return "sha256:" + digest, nil
+}
+
+// hashDir returns the hash of the given directory, which includes the hashes
+// of its children, sorted by name
+func hashDir(dir string, root bool) (string, error) {
+	children, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	for _, child := range children {
+		if root && child.Name() == MetadataDir {
+			continue
+		}
+
+		path := filepath.Join(dir, child.Name())
+
+		info, err := child.Info()
+		if err != nil {
+			return "", err
+		}
+
+		e, err := newTreeEntry(path, child.Name(), info)
+		if err != nil {
+			return "", err
+		}
+
+		digest := e.SHA256
+
+		switch e.Type {
+		case "dir":
+			if digest, err = hashDir(path, false); err != nil {
+				return "", err
+			}
+		case "symlink":
+			digest = fmt.Sprintf("%x", sha256.Sum256([]byte(e.Link)))
+		}
+
+		fmt.Fprintf(h, "%s %04o %d:%d %s %s\x00",
+			e.Type, unixMode(e.Mode), e.UID, e.GID, digest, e.Path)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// unixMode returns the permission bits of the given mode, as used by chmod
+func unixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+
+	return bits
+}
+
+// WriteTree records the current tree of the destination, so that it may be
+// verified later
+func WriteTree(dst string) error {
+	entries, err := ScanTree(dst)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	file := TreePath(dst)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(file), err)
+	}
+
+	return writeFileAtomic(file, data)
+}
+
+// ReadTree returns the recorded tree of the destination
+func ReadTree(dst string) ([]*TreeEntry, error) {
+	data, err := os.ReadFile(TreePath(dst))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*TreeEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", TreePath(dst), err)
+	}
+
+	return entries, nil
+}
+
+// VerifyTree compares the destination against its recorded tree and returns
+// the changes, sorted by path
+func VerifyTree(dst string) ([]*TreeChange, error) {
+	recorded, err := ReadTree(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := ScanTree(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffTrees(recorded, current, false), nil
+}
+
+// CompareTrees compares two destinations and returns the changes from the
+// first to the second, sorted by path. Unlike VerifyTree, the number of
+// hardlinks of files is compared as well.
+func CompareTrees(a string, b string) ([]*TreeChange, error) {
+	before, err := ScanTree(a)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := ScanTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffTrees(before, after, true), nil
+}
+
+// diffTrees returns the changes between two trees sorted by path, including
+// changes of the hardlinks if requested
+func diffTrees(before []*TreeEntry, after []*TreeEntry, hardlinks bool) []*TreeChange {
+	known := make(map[string]*TreeEntry, len(before))
+	for _, e := range before {
+		known[e.Path] = e
+	}
+
+	changes := []*TreeChange{}
+
+	for _, e := range after {
+		prev, found := known[e.Path]
+		if !found {
+			changes = append(changes, &TreeChange{Path: e.Path, Change: "added"})
+			continue
+		}
+
+		delete(known, e.Path)
+
+		if fields := prev.diff(e, hardlinks); len(fields) > 0 {
+			changes = append(changes, &TreeChange{
+				Path:   e.Path,
+				Change: "modified",
+				Fields: fields,
+			})
+		}
+	}
+
+	for path := range known {
+		changes = append(changes, &TreeChange{Path: path, Change: "removed"})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// diff returns the names of the properties that differ between the entries
+func (e *TreeEntry) diff(other *TreeEntry, hardlinks bool) []string {
+	fields := []string{}
+
+	if e.Type != other.Type {
+		return append(fields, "type")
+	}
+
+	if e.Mode != other.Mode {
+		fields = append(fields, "mode")
+	}
+
+	if e.UID != other.UID || e.GID != other.GID {
+		fields = append(fields, "owner")
+	}
+
+	if e.Size != other.Size {
+		fields = append(fields, "size")
+	}
+
+	if e.SHA256 != other.SHA256 {
+		fields = append(fields, "content")
+	}
+
+	if e.Link != other.Link {
+		fields = append(fields, "link")
+	}
+
+	if hardlinks && e.Links != other.Links {
+		fields = append(fields, "hardlinks")
+	}
+
+	return fields
+}
+
+// String returns the change in a human readable form
+func (c *TreeChange) String() string {
+	if len(c.Fields) == 0 {
+		return fmt.Sprintf("%s: %s", c.Path, c.Change)
+	}
+
+	return fmt.Sprintf("%s: %s (%s)", c.Path, c.Change, strings.Join(c.Fields, ", "))
+}
@@ -0,0 +1,138 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Unpacker applies the layers of an image to a target, e.g. by extracting
+// them into a directory, which is what roots does by default. Alternative
+// targets (tar streams, overlay directories, filesystem images) may be
+// plugged in through ExtractOptions.
+type Unpacker interface {
+
+	// ApplyLayer applies the given layer to the target. The layers of an
+	// image are applied in order, from the lowest to the highest.
+	ApplyLayer(ctx context.Context, layer *LayerStream, target string) error
+
+	// Finish is called once all layers have been applied to the target
+	Finish(ctx context.Context, target string) error
+}
+
+// LayerStream reads the uncompressed tar stream of a layer. Unpackers that
+// need multiple passes over the layer may rewind it using Reset, unless the
+// layer is streamed from the source (see ExtractOptions.Stream).
+type LayerStream struct {
+	Digest    string
+	MediaType string
+
+	// format is the media type of the cached blob, which differs from the
+	// media type of the layer if the blob is deduplicated
+	format  string
+	archive io.ReadSeeker
+	stream  io.ReadCloser
+}
+
+// newLayerStream returns the tar stream of the given blob
+func newLayerStream(digest string, mediaType string, format string, archive io.ReadSeeker) (*LayerStream, error) {
+	l := &LayerStream{
+		Digest:    digest,
+		MediaType: mediaType,
+		format:    format,
+		archive:   archive,
+	}
+
+	if err := l.Reset(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *LayerStream) Read(p []byte) (int, error) {
+	if l.stream == nil {
+		return 0, errors.New("layer stream is closed")
+	}
+
+	return l.stream.Read(p)
+}
+
+// newStreamedLayer returns the tar stream of the given blob, which is read
+// while it is downloaded and cannot be rewound
+func newStreamedLayer(digest string, mediaType string, blob io.Reader) (*LayerStream, error) {
+	handler, err := layerHandler(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := handler(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LayerStream{
+		Digest:    digest,
+		MediaType: mediaType,
+		format:    mediaType,
+		stream:    stream,
+	}, nil
+}
+
+// Rewindable returns true if the layer may be read again using Reset
+func (l *LayerStream) Rewindable() bool {
+	return l.archive != nil
+}
+
+// Reset rewinds the stream to the beginning of the layer
+func (l *LayerStream) Reset() error {
+	if !l.Rewindable() {
+		return errors.New("streamed layers cannot be rewound")
+	}
+
+	l.Close()
+
+	stream, err := tarStream(l.archive, l.format)
+	if err != nil {
+		return fmt.Errorf("failed to reset archive: %v", err)
+	}
+
+	l.stream = stream
+	return nil
+}
+
+// Close releases the decompressor of the stream, the blob is closed by the
+// store once the layer has been applied
+func (l *LayerStream) Close() error {
+	if l.stream == nil {
+		return nil
+	}
+
+	err := l.stream.Close()
+	l.stream = nil
+
+	return err
+}
+
+// ApplyLayer extracts the layer into the destination of the extraction,
+// which is the target
+func (x *extraction) ApplyLayer(ctx context.Context, layer *LayerStream, target string) error {
+	return untarLayer(ctx, layer, x)
+}
+
+// Finish removes the paths left from earlier extractions when merging and
+// sets the permissions of all created directories
+func (x *extraction) Finish(ctx context.Context, target string) error {
+	if x.opts.Merge {
+		if err := x.removeStale(); err != nil {
+			return fmt.Errorf("error merging into %s: %v", target, err)
+		}
+	}
+
+	if err := setDirectoryPermissions(x.dirmodes); err != nil {
+		return fmt.Errorf("error setting directory permissions: %v", err)
+	}
+
+	return nil
+}
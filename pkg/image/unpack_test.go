@@ -0,0 +1,80 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingUnpacker records the entries of the layers applied to it
+type recordingUnpacker struct {
+	layers   []string
+	entries  []string
+	finished bool
+}
+
+func (u *recordingUnpacker) ApplyLayer(ctx context.Context, layer *LayerStream, target string) error {
+	u.layers = append(u.layers, layer.Digest)
+
+	// the stream can be read more than once
+	for pass := 0; pass < 2; pass++ {
+		if err := layer.Reset(); err != nil {
+			return err
+		}
+
+		count := 0
+		tr := tar.NewReader(layer)
+
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if pass == 0 {
+				u.entries = append(u.entries, h.Name)
+			}
+
+			count++
+		}
+
+		if pass == 1 && count == 0 {
+			return io.ErrUnexpectedEOF
+		}
+	}
+
+	return nil
+}
+
+func (u *recordingUnpacker) Finish(ctx context.Context, target string) error {
+	u.finished = true
+	return nil
+}
+
+// TestUnpacker tests that the layers are handed to a custom unpacker in order
+func TestUnpacker(t *testing.T) {
+	files := map[string][]byte{"a": []byte("a"), "b": []byte("b")}
+	source := newLayeredSource(tarball(t, files, "a"), tarball(t, files, "b"))
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	unpacker := &recordingUnpacker{}
+	target := filepath.Join(t.TempDir(), "image.squashfs")
+
+	result, err := store.Extract(context.Background(), source, target, &ExtractOptions{Unpacker: unpacker})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Layers)
+
+	assert.Equal(t, []string{source.manifest.Layers[0].Digest, source.manifest.Layers[1].Digest}, unpacker.layers)
+	assert.Equal(t, []string{"a", "b"}, unpacker.entries)
+	assert.True(t, unpacker.finished)
+}
@@ -2,17 +2,19 @@ package image
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // detect relative paths that try to escape the destination directory
@@ -21,162 +23,610 @@ var unsafepath = regexp.MustCompile(`/?\.\./`)
 // walkHandler takes a tar.Header and handles it, returning an optional error
 type walkHandler func(*tar.Header, *tar.Reader) error
 
+// extraction holds the state shared by all layers extracted to a destination
+type extraction struct {
+	dst      string
+	opts     *ExtractOptions
+	result   *ExtractResult
+	dirmodes map[string]os.FileMode
+
+	// seen holds the paths of all entries (and their parents) when merging
+	seen map[string]bool
+
+	// exploded is the directory of the layer in the cache whose files are
+	// shared with the destination, if sharing is enabled
+	exploded string
+
+	// overlay keeps the whiteouts in the format of overlayfs, instead of
+	// applying them (see ExtractOptions.Overlay)
+	overlay bool
+
+	// mu guards the result while files are written concurrently
+	mu sync.Mutex
+}
+
+func newExtraction(dst string, opts *ExtractOptions) *extraction {
+	return &extraction{
+		dst:      dst,
+		opts:     opts,
+		result:   &ExtractResult{},
+		dirmodes: make(map[string]os.FileMode),
+		seen:     make(map[string]bool),
+	}
+}
+
 // untarLayer takes an OCI layer and extracts it into a directory, observing
 // any whiteouts that might be specified in the layer.
 // See: https://github.com/opencontainers/image-spec/blob/master/layer.md
-func untarLayer(ctx context.Context, archive, dst string, dirmodes map[string]os.FileMode) error {
-	r, err := os.Open(archive)
-	if err == nil {
-		defer r.Close()
-	} else {
+//
+// The layer is read only once, which is also what allows extracting layers
+// while they are downloaded. Whiteouts only remove the paths of the lower
+// layers, to which end the paths of the layer are kept in memory. Links are
+// created once all files have been written, the modes of the directories
+// are set once all layers have been extracted.
+func untarLayer(ctx context.Context, layer *LayerStream, x *extraction) error {
+	entries := newLayerEntries()
+	links := []*tar.Header{}
+
+	// create all regular files, possibly using multiple workers, and
+	// everything else in order
+	workers := newFileWorkers(x, x.opts.Workers)
+
+	err := walkTar(ctx, layer, func(h *tar.Header, r *tar.Reader) error {
+
+		// detect unsafe filenames and stop everything if found
+		if unsafepath.MatchString(h.Name) {
+			return fmt.Errorf("refusing to extract unsafe path: %s", h.Name)
+		}
+
+		// apply whiteout files, sparing the entries of the layer
+		if isWhiteoutPath(h.Name) {
+			if x.overlay {
+				return x.extractOverlayWhiteout(h)
+			}
+
+			return applyWhiteout(x.dst, h.Name, entries)
+		}
+
+		// the parents of the entry usually precede it
+		if h.Typeflag != tar.TypeDir {
+			parent := path.Dir(path.Clean("/" + h.Name))
+
+			if parent != "/" && !entries.contains(parent) {
+				if err := workers.settle(parent); err != nil {
+					return err
+				}
+
+				if err := os.MkdirAll(filepath.Join(x.dst, parent), 0755); err != nil {
+					return fmt.Errorf("error creating directory %s: %v", parent, err)
+				}
+			}
+		}
+
+		entries.add(h.Name)
+
+		if x.opts.Merge {
+			x.see(h.Name)
+		}
+
+		// entries handled directly may replace files queued for the workers
+		if h.Typeflag == tar.TypeDir || isSpecialFile(h) {
+			if err := workers.settle(h.Name); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case h.Typeflag == tar.TypeDir:
+			return x.extractDir(h)
+		case h.Typeflag == tar.TypeLink || h.Typeflag == tar.TypeSymlink:
+			links = append(links, h)
+			return nil
+		case isSpecialFile(h):
+			return x.extractSpecial(h)
+		case h.Typeflag == tar.TypeReg:
+			return workers.extract(h, r)
+		default:
+			return nil
+		}
+	})
+
+	if err := workers.wait(); err != nil {
 		return err
 	}
 
-	gzr, err := gzip.NewReader(r)
-	if err == nil {
-		defer gzr.Close()
-	} else {
+	if err != nil {
 		return err
 	}
 
-	reset := func() {
-		if _, err := r.Seek(0, 0); err != nil {
-			panic(fmt.Errorf("failed to seek %s: %v", archive, err))
+	// create links
+	for _, h := range links {
+		if err := x.extractLink(h); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// layerEntries holds the paths of the entries read from a layer so far and
+// the directories containing them
+type layerEntries struct {
+	paths   map[string]bool
+	parents map[string]bool
+}
+
+func newLayerEntries() *layerEntries {
+	return &layerEntries{
+		paths:   make(map[string]bool),
+		parents: make(map[string]bool),
+	}
+}
+
+// add records the given entry and its parents
+func (e *layerEntries) add(name string) {
+	p := path.Clean("/" + name)
+	e.paths[p] = true
+
+	for p = path.Dir(p); p != "/" && !e.parents[p]; p = path.Dir(p) {
+		e.parents[p] = true
+	}
+}
 
-		if err := gzr.Reset(r); err != nil {
-			panic(fmt.Errorf("failed to reset %s: %v", archive, err))
+// contains returns true if the given path is an entry or contains one
+func (e *layerEntries) contains(p string) bool {
+	return e.paths[p] || e.parents[p]
+}
+
+// extractDir creates the given directory, whose mode is set once all layers
+// have been extracted
+func (x *extraction) extractDir(h *tar.Header) error {
+	file := filepath.Join(x.dst, h.Name)
+
+	// the path may have been something else in a lower layer
+	if info, err := os.Lstat(file); err == nil && !info.IsDir() {
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("error replacing %s: %v", file, err)
 		}
 	}
 
-	// pre-process the archive
-	err = walkTar(ctx, gzr, func(h *tar.Header, r *tar.Reader) error {
+	if err := os.MkdirAll(file, 0755); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", file, err)
+	}
+
+	if err := x.chown(file, h); err != nil {
+		return err
+	}
 
-		// apply whiteout files
-		if isWhiteoutPath(h.Name) {
-			if err := applyWhiteout(dst, h.Name); err != nil {
-				return err
-			}
+	if err := x.relabel(file, h); err != nil {
+		return err
+	}
+
+	if err := x.restoreXattrs(file, h); err != nil {
+		return err
+	}
+
+	// store actual file mode of directories to set them later,
+	// including the setuid, setgid and sticky bits
+	x.dirmodes[file] = h.FileInfo().Mode()
+
+	return nil
+}
+
+// extractLink creates the given hard or symbolic link
+func (x *extraction) extractLink(h *tar.Header) error {
+	dst := x.dst
+	new := filepath.Join(dst, h.Name)
+
+	var old string
+	if h.Linkname[0] == '.' || !strings.Contains(h.Linkname, "/") {
+		old = filepath.Join(filepath.Dir(new), h.Linkname)
+	} else {
+		old = filepath.Join(dst, h.Linkname)
+	}
+
+	// when merging, unchanged symbolic links are kept
+	if x.opts.Merge && h.Typeflag == tar.TypeSymlink {
+		if target, err := os.Readlink(new); err == nil && target == h.Linkname {
+			return nil
 		}
+	}
 
-		// detect unsafe filenames and stop everything if found
-		if unsafepath.MatchString(h.Name) {
-			return fmt.Errorf("refusing to extract unsafe path: %s", h.Name)
+	// remove the link if it exists
+	if info, err := os.Lstat(new); err == nil && (!info.IsDir() || x.opts.Merge) {
+		if err := os.RemoveAll(new); err != nil {
+			return fmt.Errorf("error replacing %s: %v", new, err)
+		}
+	}
+
+	// create hard links
+	if h.Typeflag == tar.TypeLink {
+		if err := os.Link(old, new); err != nil {
+			return fmt.Errorf("error creating hard link %s->%s: %v", new, old, err)
 		}
+		return nil
+	}
 
-		// create directory structure
-		if h.Typeflag == tar.TypeDir {
-			file := filepath.Join(dst, h.Name)
+	// create symbolic links
+	if err := os.Symlink(h.Linkname, new); err != nil {
+		return fmt.Errorf("error creating symbolic link %s->%s: %v", new, old, err)
+	}
 
-			if err := os.MkdirAll(file, 0755); err != nil {
-				return fmt.Errorf("error creating directory %s: %v", file, err)
-			}
+	if err := x.chown(new, h); err != nil {
+		return err
+	}
+
+	if err := x.relabel(new, h); err != nil {
+		return err
+	}
+
+	return x.restoreXattrs(new, h)
+}
 
-			// store actual file mode of directories to set them later
-			dirmodes[file] = os.FileMode(h.Mode)
+// extractFile writes the given regular file, then restores its owner, label
+// and mode
+func (x *extraction) extractFile(h *tar.Header, r io.Reader) error {
+	file := filepath.Join(x.dst, h.Name)
+	mode := h.FileInfo().Mode()
+
+	// shared files are linked or cloned from the cache
+	shared := false
+	if x.exploded != "" {
+		var err error
+		if shared, err = x.shareFile(file, h); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	// when merging, unchanged files are kept
+	merged := false
+	if x.opts.Merge && !shared {
+		var err error
+		if merged, err = x.mergeFile(file, h, r); err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
+	if !merged && !shared {
+		if err := x.writeFile(file, mode, r); err != nil {
+			return err
+		}
+	}
+
+	// the owner has to be set first, as chown may clear setuid bits
+	if err := x.chown(file, h); err != nil {
 		return err
 	}
 
-	reset()
+	if err := x.relabel(file, h); err != nil {
+		return err
+	}
 
-	// create all regular files
-	err = walkTar(ctx, gzr, func(h *tar.Header, r *tar.Reader) error {
+	if err := x.restoreXattrs(file, h); err != nil {
+		return err
+	}
 
-		// skip anything but regular files
-		if h.Typeflag != tar.TypeReg {
-			return nil
+	if err := os.Chmod(file, mode); err != nil {
+		return fmt.Errorf("error setting mode for %s: %v", file, err)
+	}
+
+	return nil
+}
+
+// isSpecialFile returns true if the header describes a device node or FIFO
+func isSpecialFile(h *tar.Header) bool {
+	switch h.Typeflag {
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return true
+	default:
+		return false
+	}
+}
+
+// extractSpecial creates the given device node or FIFO, then restores its
+// owner, label and mode. Special files which cannot be created due to
+// lacking privileges (device nodes usually require root) are skipped.
+func (x *extraction) extractSpecial(h *tar.Header) error {
+	file := filepath.Join(x.dst, h.Name)
+
+	// remove the file if it exists (directories too, when merging)
+	if info, err := os.Lstat(file); err == nil && (!info.IsDir() || x.opts.Merge) {
+		if err := os.RemoveAll(file); err != nil {
+			return fmt.Errorf("error replacing %s: %v", file, err)
 		}
+	}
 
-		// skip whiteout files
-		if isWhiteoutPath(h.Name) {
-			return nil
+	if err := mknod(file, h); err != nil {
+		if !isPrivilegeError(err) {
+			return fmt.Errorf("error creating %s: %v", file, err)
 		}
 
-		// remove the file if it exists
-		file := filepath.Join(dst, h.Name)
+		x.mu.Lock()
+		x.result.SkippedSpecialFiles++
+		x.mu.Unlock()
 
-		if info, err := os.Stat(file); err == nil && !info.IsDir() {
-			if err := os.Remove(file); err != nil {
-				return fmt.Errorf("error replacing %s: %v", file, err)
-			}
+		return nil
+	}
+
+	if err := x.chown(file, h); err != nil {
+		return err
+	}
+
+	if err := x.relabel(file, h); err != nil {
+		return err
+	}
+
+	if err := x.restoreXattrs(file, h); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(file, h.FileInfo().Mode()); err != nil {
+		return fmt.Errorf("error setting mode for %s: %v", file, err)
+	}
+
+	return nil
+}
+
+// isPrivilegeError returns true if the error is due to lacking privileges or
+// missing support of the system or the filesystem
+func isPrivilegeError(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, errors.ErrUnsupported)
+}
+
+// writeFile replaces the given file with the content of the reader
+func (x *extraction) writeFile(file string, mode os.FileMode, r io.Reader) error {
+
+	// remove the file if it exists (directories too, when merging)
+	if info, err := os.Lstat(file); err == nil && (!info.IsDir() || x.opts.Merge) {
+		if err := os.RemoveAll(file); err != nil {
+			return fmt.Errorf("error replacing %s: %v", file, err)
 		}
+	}
+
+	// write the file, (re-)setting the mode at the end, which is the
+	// only way to make absolutely sure that is set correctly
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, mode)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", file, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("error copying %s: %v", file, err)
+	}
+
+	return f.Close()
+}
+
+// mergeBufferSize is the size of the blocks compared while merging
+const mergeBufferSize = 32 * 1024
+
+// mergeFile compares the existing file with the entry and keeps it if the
+// content has not changed. If it has, the file is replaced without reading
+// the entry twice. False is returned if there is no regular file of the
+// same size, in which case nothing has been read.
+func (x *extraction) mergeFile(file string, h *tar.Header, r io.Reader) (bool, error) {
+	info, err := os.Lstat(file)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != h.Size {
+		return false, nil
+	}
+
+	existing, err := os.Open(file)
+	if err != nil {
+		return false, nil
+	}
+	defer existing.Close()
 
-		// write the file, (re-)setting the mode at the end, which is the
-		// only way to make absolutely sure that is set correctly
-		mode := h.FileInfo().Mode()
+	entry, current := make([]byte, mergeBufferSize), make([]byte, mergeBufferSize)
 
-		f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, mode)
+	for offset := int64(0); offset < h.Size; {
+		n, err := io.ReadFull(r, entry[:min(int64(len(entry)), h.Size-offset)])
 		if err != nil {
-			return fmt.Errorf("error creating %s: %v", file, err)
+			return true, fmt.Errorf("error reading %s: %v", h.Name, err)
 		}
 
-		if _, err := io.Copy(f, r); err != nil {
-			return fmt.Errorf("error copying %s: %v", file, err)
+		if _, err := io.ReadFull(existing, current[:n]); err != nil || !bytes.Equal(entry[:n], current[:n]) {
+			prefix := io.MultiReader(io.NewSectionReader(existing, 0, offset), bytes.NewReader(entry[:n]), r)
+			return true, x.replaceFile(file, prefix, existing)
 		}
 
-		if err := os.Chmod(file, mode); err != nil {
-			return fmt.Errorf("error setting mode for %s: %v", file, err)
-		}
+		offset += int64(n)
+	}
 
-		return f.Close()
-	})
+	x.mu.Lock()
+	x.result.Unchanged++
+	x.mu.Unlock()
+
+	return true, nil
+}
+
+// replaceFile writes the content of the reader to a temporary file, which
+// then replaces the given file, once the existing file has been closed
+func (x *extraction) replaceFile(file string, r io.Reader, existing io.Closer) error {
+	temp := file + ".roots-merge"
 
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 	if err != nil {
-		return err
+		return fmt.Errorf("error creating %s: %v", temp, err)
 	}
 
-	reset()
+	_, err = io.Copy(f, r)
 
-	// create links
-	return walkTar(ctx, gzr, func(h *tar.Header, r *tar.Reader) error {
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 
-		// skip anything that isn't a link
-		if h.Typeflag != tar.TypeLink && h.Typeflag != tar.TypeSymlink {
-			return nil
+	existing.Close()
+
+	if err == nil {
+		err = os.Rename(temp, file)
+	}
+
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error replacing %s: %v", file, err)
+	}
+
+	return nil
+}
+
+// see records the given entry and its parents as part of the image
+func (x *extraction) see(name string) {
+	for p := path.Clean("/" + name); p != "/"; p = path.Dir(p) {
+		if x.seen[p] {
+			return
 		}
 
-		new := filepath.Join(dst, h.Name)
+		x.seen[p] = true
+	}
+}
 
-		var old string
-		if h.Linkname[0] == '.' || !strings.Contains(h.Linkname, "/") {
-			old = filepath.Join(filepath.Dir(new), h.Linkname)
-		} else {
-			old = filepath.Join(dst, h.Linkname)
+// removeStale removes the paths of the destination which have not been
+// seen in any layer, except for the metadata of roots
+func (x *extraction) removeStale() error {
+	return filepath.WalkDir(x.dst, func(file string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
 
-		// remove the link if it exists
-		if info, err := os.Lstat(new); err == nil && !info.IsDir() {
-			if err := os.Remove(new); err != nil {
-				return fmt.Errorf("error replacing %s: %v", new, err)
-			}
+		rel, err := filepath.Rel(x.dst, file)
+		if err != nil || rel == "." {
+			return err
 		}
 
-		// create hard links
-		if h.Typeflag == tar.TypeLink {
-			if err := os.Link(old, new); err != nil {
-				return fmt.Errorf("error creating hard link %s->%s: %v", new, old, err)
-			}
+		if rel == MetadataDir {
+			return filepath.SkipDir
+		}
+
+		if x.seen[path.Clean("/"+filepath.ToSlash(rel))] {
 			return nil
 		}
 
-		// create symbolic links
-		if err := os.Symlink(h.Linkname, new); err != nil {
-			return fmt.Errorf("error creating symbolic link %s->%s: %v", new, old, err)
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+
+		x.result.Removed++
+
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// selinuxXattr is the name of the PAX record holding the SELinux label
+const selinuxXattr = "SCHILY.xattr.security.selinux"
+
+// relabel applies the SELinux label recorded in the header or the default
+// label of the extraction to the given file, if either is enabled
+func (x *extraction) relabel(file string, h *tar.Header) error {
+	label := x.opts.SELinuxLabel
+
+	if x.opts.SELinuxLayerLabels && h.PAXRecords[selinuxXattr] != "" {
+		label = h.PAXRecords[selinuxXattr]
+	}
+
+	if label == "" {
+		return nil
+	}
+
+	if err := setxattr(file, "security.selinux", []byte(label)); err != nil {
+		return fmt.Errorf("error setting SELinux label of %s: %v", file, err)
+	}
+
+	return nil
+}
+
+// xattrPrefix is the prefix of the PAX records holding extended attributes
+const xattrPrefix = "SCHILY.xattr."
+
+// restoreXattrs applies the extended attributes recorded in the header (e.g.
+// file capabilities) to the given file, if enabled. The SELinux label is
+// left to relabel. Attributes which cannot be set due to lacking privileges
+// or support of the filesystem are skipped.
+func (x *extraction) restoreXattrs(file string, h *tar.Header) error {
+	if !x.opts.PreserveXattrs {
+		return nil
+	}
+
+	for key, value := range h.PAXRecords {
+		name, ok := strings.CutPrefix(key, xattrPrefix)
+		if !ok || key == selinuxXattr {
+			continue
+		}
+
+		if err := setxattr(file, name, []byte(value)); err != nil {
+			if !isPrivilegeError(err) {
+				return fmt.Errorf("error setting %s of %s: %v", name, file, err)
+			}
+
+			x.mu.Lock()
+			x.result.SkippedXattrs++
+			x.mu.Unlock()
 		}
+	}
+
+	return nil
+}
+
+// chown applies the (possibly shifted) ownership recorded in the header to
+// the given file, if ownership preservation is enabled
+func (x *extraction) chown(file string, h *tar.Header) error {
+	o := x.opts
 
+	if !o.preservesOwnership() {
 		return nil
+	}
+
+	uid, err := o.UIDMap.Map(h.Uid)
+	if err != nil {
+		return fmt.Errorf("error mapping owner of %s: %v", file, err)
+	}
+
+	gid, err := o.GIDMap.Map(h.Gid)
+	if err != nil {
+		return fmt.Errorf("error mapping group of %s: %v", file, err)
+	}
+
+	if err := os.Lchown(file, uid, gid); err != nil {
+		err = fmt.Errorf("error setting owner of %s: %v", file, err)
+
+		if !o.IgnoreChownErrors {
+			return err
+		}
+
+		x.mu.Lock()
+		defer x.mu.Unlock()
+
+		x.result.SkippedChowns++
+
+		if x.result.ChownError == nil {
+			x.result.ChownError = err
+		}
+	}
+
+	return nil
+}
+
+// testLayer reads the whole layer, to ensure it can be decompressed
+func testLayer(ctx context.Context, archive io.ReadSeeker, mediaType string) error {
+	stream, err := tarStream(archive, mediaType)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return walkTar(ctx, stream, func(h *tar.Header, r *tar.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
 	})
 }
 
-// walkTar takes a gzip.Reader and calls a handler function
-func walkTar(ctx context.Context, gzr *gzip.Reader, handler walkHandler) error {
-	tr := tar.NewReader(gzr)
+// walkTar takes a tar stream and calls a handler function for each entry
+func walkTar(ctx context.Context, stream io.Reader, handler walkHandler) error {
+	tr := tar.NewReader(stream)
 
 	for {
 		header, err := tr.Next()
@@ -236,72 +686,63 @@ func setDirectoryPermissions(dirmodes map[string]os.FileMode) error {
 	return nil
 }
 
-// applyWhiteout takes a destination and a relative whiteout path and applies it
-func applyWhiteout(dst, whiteout string) error {
-	if strings.HasSuffix(whiteout, ".wh..wh..opq") {
-		return applyOpaqueWhiteout(dst, whiteout)
-	}
+// applyWhiteout applies the given whiteout to the paths of the lower
+// layers, sparing the given entries of the layer of the whiteout (and the
+// directories containing them)
+func applyWhiteout(dst string, whiteout string, entries *layerEntries) error {
+	dir := path.Dir(path.Clean("/" + whiteout))
 
-	return applySimpleWhiteout(dst, whiteout)
-}
+	if strings.HasSuffix(whiteout, ".wh..wh..opq") {
+		children, err := os.ReadDir(filepath.Join(dst, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
 
-func applyOpaqueWhiteout(dst, whiteout string) error {
-	base := path.Join(dst, filepath.Dir(whiteout))
+			return err
+		}
 
-	f, err := os.Open(base)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+		for _, child := range children {
+			if err := removeLower(dst, path.Join(dir, child.Name()), entries); err != nil {
+				return err
+			}
 		}
 
-		return err
+		return nil
 	}
-	defer f.Close()
-
-	const buffer = 10
-
-	for {
-		lst, err := f.Readdir(buffer)
-
-		if err == io.EOF {
-			return nil
-		}
 
-		for _, info := range lst {
-			file := path.Join(base, info.Name())
+	return removeLower(dst, path.Join(dir, path.Base(whiteout)[4:]), entries)
+}
 
-			if info.IsDir() {
-				err = os.RemoveAll(file)
+// removeLower removes the given path, unless it is an entry of the current
+// layer. Directories containing such entries are kept, without the rest.
+func removeLower(dst string, name string, entries *layerEntries) error {
+	if entries.paths[name] {
+		return nil
+	}
 
-				if err != nil {
-					return err
-				}
-			}
+	file := filepath.Join(dst, name)
 
-			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
-				return err
-			}
+	if !entries.parents[name] {
+		if err := os.RemoveAll(file); err != nil && !os.IsNotExist(err) {
+			return err
 		}
-	}
-}
 
-func applySimpleWhiteout(dst, whiteout string) error {
-	file := path.Join(dst, filepath.Dir(whiteout), filepath.Base(whiteout)[4:])
-	info, err := os.Stat(file)
+		return nil
+	}
 
+	children, err := os.ReadDir(file)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-
 		return err
 	}
 
-	if info.IsDir() {
-		return os.RemoveAll(file)
+	for _, child := range children {
+		if err := removeLower(dst, path.Join(name, child.Name()), entries); err != nil {
+			return err
+		}
 	}
 
-	return os.Remove(file)
+	return nil
 }
 
 func isWhiteoutPath(p string) bool {
@@ -0,0 +1,108 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUntarLayerOrder tests that whiteouts and links are independent of
+// their position in the layer, which is read only once
+func TestUntarLayerOrder(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	entries := []struct {
+		header  tar.Header
+		content string
+	}{
+		{tar.Header{Name: "etc/hosts", Typeflag: tar.TypeLink, Linkname: "etc/hostname"}, ""},
+		{tar.Header{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}, "host"},
+		{tar.Header{Name: "etc/.wh.hostname", Typeflag: tar.TypeReg, Mode: 0644}, ""},
+		{tar.Header{Name: "var/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644}, ""},
+		{tar.Header{Name: "var/log/new", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}, "new"},
+	}
+
+	for _, e := range entries {
+		assert.NoError(t, tw.WriteHeader(&e.header))
+		_, err := tw.Write([]byte(e.content))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+
+	dst := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dst, "var/lib"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "var/log"), []byte("old"), 0644))
+
+	layer, err := newLayerStream("sha256:layer", OCITarLayerMimeType, OCITarLayerMimeType, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	defer layer.Close()
+
+	assert.NoError(t, untarLayer(context.Background(), layer, newExtraction(dst, &ExtractOptions{})))
+
+	content, err := os.ReadFile(filepath.Join(dst, "etc/hosts"))
+	assert.NoError(t, err)
+	assert.Equal(t, "host", string(content))
+
+	content, err = os.ReadFile(filepath.Join(dst, "var/log/new"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	assert.FileExists(t, filepath.Join(dst, "etc/hostname"))
+	assert.NoDirExists(t, filepath.Join(dst, "var/lib"))
+}
+
+// BenchmarkUntarLayer measures the extraction of a gzipped layer with many
+// small files, which is dominated by decompression
+func BenchmarkUntarLayer(b *testing.B) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for i := 0; i < 2000; i++ {
+		if i%100 == 0 {
+			assert.NoError(b, tw.WriteHeader(&tar.Header{
+				Name:     fmt.Sprintf("dir%d/", i/100),
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+			}))
+		}
+
+		content := bytes.Repeat([]byte{byte(i)}, 4096)
+
+		assert.NoError(b, tw.WriteHeader(&tar.Header{
+			Name:     fmt.Sprintf("dir%d/file%d", i/100, i),
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := tw.Write(content)
+		assert.NoError(b, err)
+	}
+
+	assert.NoError(b, tw.Close())
+	assert.NoError(b, gz.Close())
+
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		x := newExtraction(b.TempDir(), &ExtractOptions{})
+		layer, err := newLayerStream("sha256:layer", OCILayerMimeType, OCILayerMimeType, bytes.NewReader(buf.Bytes()))
+		assert.NoError(b, err)
+		b.StartTimer()
+
+		assert.NoError(b, untarLayer(context.Background(), layer, x))
+		layer.Close()
+	}
+}
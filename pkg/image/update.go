@@ -0,0 +1,128 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// update extracts the source into a staging directory next to the
+// destination, which then takes the place of the destination. The metadata
+// of roots is carried over, except for what describes the old tree.
+func (s *Store) update(ctx context.Context, r Source, manifest *Manifest, dst string, opts *ExtractOptions, lockWait float64) (*ExtractResult, error) {
+
+	// symbolic links to the destination are kept, the directory is replaced
+	target := filepath.Clean(dst)
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+
+	staging, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+".roots-update-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating staging directory for %s: %v", dst, err)
+	}
+
+	// after the swap, this is the old tree
+	defer os.RemoveAll(staging)
+
+	if err := stage(target, staging); err != nil {
+		return nil, fmt.Errorf("error preparing %s: %v", staging, err)
+	}
+
+	staged := *opts
+	staged.Merge = false
+
+	result, link, err := s.extract(ctx, r, manifest, staging, dst, &staged)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := replaceDirectory(staging, target); err != nil {
+		return nil, fmt.Errorf("error replacing %s: %v", dst, err)
+	}
+
+	result.LockWait = lockWait
+
+	// record the destination in the cache
+	if err := s.saveLink(link); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// stage prepares the staging directory of an update, which gets the mode of
+// the destination and the metadata of roots, except for the tree and the
+// digest of the old tree
+func stage(dst string, staging string) error {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(dst); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.Chmod(staging, mode); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dst, MetadataDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		file := filepath.Join(dst, MetadataDir, entry.Name())
+
+		if !entry.Type().IsRegular() || file == TreePath(dst) || file == DigestPath(dst) {
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Join(staging, MetadataDir), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join(staging, MetadataDir, entry.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceDirectory moves the staging directory to the destination. Existing
+// destinations are exchanged with it in a single step where supported (on
+// Linux), otherwise they are moved aside first, so the destination is missing
+// for a moment. The old tree is left at the staging path.
+func replaceDirectory(staging string, dst string) error {
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		return os.Rename(staging, dst)
+	}
+
+	err := exchange(staging, dst)
+	if err == nil || !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	old := staging + ".old"
+
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+
+	if err := os.Rename(staging, dst); err != nil {
+		os.Rename(old, dst)
+		return err
+	}
+
+	return os.Rename(old, staging)
+}
@@ -8,6 +8,45 @@ import (
 
 var localurl = regexp.MustCompile(`(?i)^http://(127\.[\d.]+|[0:]+1|localhost)`)
 
+// plainHTTP holds the hosts of the registries, besides the local ones, which
+// are reached through plain HTTP instead of HTTPS (see AllowPlainHTTP)
+var plainHTTP = make(map[string]bool)
+
+// AllowPlainHTTP reaches the registries of the given hosts (including the
+// port, e.g. registry:5000) through plain HTTP. Their images may be given
+// with or without the http:// prefix.
+func AllowPlainHTTP(hosts ...string) {
+	for _, host := range hosts {
+		host = strings.TrimSuffix(strings.TrimSpace(host), "/")
+
+		if strings.HasPrefix(strings.ToLower(host), "http://") {
+			host = host[len("http://"):]
+		}
+
+		if host != "" {
+			plainHTTP[strings.ToLower(host)] = true
+		}
+	}
+}
+
+// isPlainHTTP returns true if the given url (or host) starts with http:// and
+// points to a local address or to a host allowed through AllowPlainHTTP
+func isPlainHTTP(url string) bool {
+	if localurl.MatchString(url) {
+		return true
+	}
+
+	if !strings.HasPrefix(strings.ToLower(url), "http://") {
+		return false
+	}
+
+	host, _, _ := strings.Cut(url[len("http://"):], "/")
+	return plainHTTP[strings.ToLower(host)]
+}
+
+// dockerHubHost is the host serving the registry API of the Docker Hub
+const dockerHubHost = "registry-1.docker.io"
+
 // URL contains the result of a parsed container url like the following:
 // * ubuntu:latest
 // * gcr.io/google-containers/alpine
@@ -45,23 +84,90 @@ func (url URL) String() string {
 		url.Digest)
 }
 
-// Endpoint returns an API endpoint of the v2 registry API
-func (url URL) Endpoint(segments ...string) string {
-	// by default, no protocol is given and we force https
-	host := fmt.Sprintf("https://%s", url.Host)
+// Familiar returns the short form of the URL, as shown by docker and podman,
+// without the defaults (i.e. docker.io, library and latest). The result can
+// be parsed again to get the same URL.
+func (url URL) Familiar() string {
+	if len(url.Name) == 0 {
+		return "<empty>"
+	}
 
-	// the host may include the http protocol if it points to a local address
-	if localurl.MatchString(url.Host) {
-		host = url.Host
+	name := url.Name
+
+	if url.Host != dockerHubHost {
+		name = fmt.Sprintf("%s/%s/%s", url.Host, url.Repository, url.Name)
+	} else if url.Repository != "library" {
+		name = fmt.Sprintf("%s/%s", url.Repository, url.Name)
+	}
+
+	if url.Tag != "latest" {
+		name = fmt.Sprintf("%s:%s", name, url.Tag)
+	}
+
+	if len(url.Digest) > 0 {
+		name = fmt.Sprintf("%s@%s", name, url.Digest)
+	}
+
+	return name
+}
+
+// Canonical returns the fully qualified form of the URL, as used by other
+// container tools (i.e. with docker.io instead of registry-1.docker.io),
+// including the digest if there is one
+func (url URL) Canonical() string {
+	ref := fmt.Sprintf("%s/%s/%s:%s", url.Registry(), url.Repository, url.Name, url.Tag)
+
+	if len(url.Digest) > 0 {
+		ref = fmt.Sprintf("%s@%s", ref, url.Digest)
 	}
 
+	return ref
+}
+
+// WithTag returns a copy of the URL pointing to the given tag, without the
+// digest, which belongs to the previous tag
+func (url URL) WithTag(tag string) URL {
+	url.Tag, url.Digest = tag, ""
+	return url
+}
+
+// WithDigest returns a copy of the URL pinned to the given digest
+func (url URL) WithDigest(digest string) URL {
+	url.Digest = digest
+	return url
+}
+
+// Endpoint returns an API endpoint of the v2 registry API
+func (url URL) Endpoint(segments ...string) string {
 	return fmt.Sprintf("%s/v2/%s/%s/%s",
-		host,
+		url.base(),
 		url.Repository,
 		url.Name,
 		strings.Join(segments, "/"))
 }
 
+// base returns the protocol and host of the registry
+func (url URL) base() string {
+	// the host may include the http protocol if it points to a local address
+	// or to a registry allowed to use plain HTTP
+	if isPlainHTTP(url.Host) {
+		return url.Host
+	}
+
+	if plainHTTP[strings.ToLower(url.Host)] {
+		return fmt.Sprintf("http://%s", url.Host)
+	}
+
+	// by default, no protocol is given and we force https
+	return fmt.Sprintf("https://%s", url.Host)
+}
+
+// Registry returns the host of the registry, as used by other container
+// tools (i.e. docker.io instead of registry-1.docker.io)
+func (url URL) Registry() string {
+	return canonicalHost(url.Host)
+}
+
 // Reference returns either the digest or, if the digest is absent, the tag
 func (url URL) Reference() string {
 	if len(url.Digest) > 0 {
@@ -71,6 +177,44 @@ func (url URL) Reference() string {
 	return url.Tag
 }
 
+// IsQualified returns true if the given url includes a host name. Urls
+// without host name (short names) default to the Docker Hub.
+func IsQualified(url string) bool {
+	url, _, _ = strings.Cut(strings.Trim(url, " \n\t"), "@")
+	parts := strings.Split(url, "/")
+
+	return len(parts) > 1 && strings.ContainsAny(parts[0], ".:")
+}
+
+// IsTagged returns true if the given url names a tag, instead of defaulting
+// to latest
+func IsTagged(url string) bool {
+	url, _, _ = strings.Cut(strings.Trim(url, " \n\t"), "@")
+	parts := strings.Split(url, "/")
+
+	return strings.Contains(parts[len(parts)-1], ":")
+}
+
+// normalizeHost returns the host used to talk to the registry (i.e. the
+// Docker Hub if the host is empty, or one of its aliases)
+func normalizeHost(host string) string {
+	if len(host) == 0 || host == "docker.io" || host == "index.docker.io" {
+		return dockerHubHost
+	}
+
+	return host
+}
+
+// canonicalHost returns the name of the host as used by other containers
+// tools (i.e. docker.io instead of registry-1.docker.io)
+func canonicalHost(host string) string {
+	if host == dockerHubHost {
+		return "docker.io"
+	}
+
+	return host
+}
+
 // Parse parses the given URL and returns an error if it doesn't look correct
 func Parse(url string) (*URL, error) {
 	url = strings.Trim(url, " \n\t")
@@ -81,6 +225,16 @@ func Parse(url string) (*URL, error) {
 
 	p := &URL{}
 
+	// local registries may be given with the http scheme (see base), as may
+	// the ones allowed to use plain HTTP
+	scheme := ""
+	if isPlainHTTP(url) {
+		scheme, url = url[:len("http://")], url[len("http://"):]
+	} else if strings.HasPrefix(strings.ToLower(url), "http://") {
+		host, _, _ := strings.Cut(url[len("http://"):], "/")
+		return &URL{}, fmt.Errorf("%s is not a local registry, plain HTTP has to be allowed explicitly", host)
+	}
+
 	// if there's an @, we got our digest
 	if strings.Contains(url, "@") {
 		url, p.Digest = bisect(url, "@")
@@ -90,7 +244,7 @@ func Parse(url string) (*URL, error) {
 	parts := strings.Split(url, "/")
 
 	// if there is a slash and we got a dot or a colon we found a host name
-	if strings.Contains(url, "/") && strings.ContainsAny(parts[0], ".:") {
+	if strings.Contains(url, "/") && (scheme != "" || strings.ContainsAny(parts[0], ".:")) {
 		p.Host, parts = parts[0], parts[1:]
 	}
 
@@ -99,12 +253,13 @@ func Parse(url string) (*URL, error) {
 		parts[len(parts)-1], p.Tag = bisect(parts[len(parts)-1], ":")
 	}
 
-	// the rest should be the name and possibly the repository
-	switch len(parts) {
-	case 1:
+	// the rest should be the name and possibly the repository, which may
+	// be nested on registries other than the Docker Hub (e.g. org/team)
+	switch {
+	case len(parts) == 1:
 		p.Name = parts[0]
-	case 2:
-		p.Repository, p.Name = parts[0], parts[1]
+	case len(parts) == 2 || p.Host != "":
+		p.Repository, p.Name = strings.Join(parts[:len(parts)-1], "/"), parts[len(parts)-1]
 	default:
 		return &URL{}, fmt.Errorf("too many slashes in %s", url)
 	}
@@ -113,11 +268,13 @@ func Parse(url string) (*URL, error) {
 		return &URL{}, fmt.Errorf("could not find a name for %s", url)
 	}
 
-	// finally, we add some defaults that are set in practice
-	if len(p.Host) == 0 {
-		p.Host = "registry-1.docker.io"
+	if scheme != "" && p.Host == "" {
+		return &URL{}, fmt.Errorf("could not find a name for %s", url)
 	}
 
+	// finally, we add some defaults that are set in practice
+	p.Host = scheme + normalizeHost(p.Host)
+
 	if len(p.Tag) == 0 {
 		p.Tag = "latest"
 	}
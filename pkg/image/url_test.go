@@ -57,6 +57,39 @@ var cases = []struct {
 		},
 		"registry-1.docker.io/foo/bar:latest@sha256:0xdeadbeef",
 	},
+	{
+		"http://localhost:5000/team/app:1.0", URL{
+			Name:       "app",
+			Tag:        "1.0",
+			Repository: "team",
+			Host:       "http://localhost:5000",
+		},
+		"http://localhost:5000/team/app:1.0",
+	},
+	{
+		"http://127.0.0.1/app", URL{
+			Name:       "app",
+			Tag:        "latest",
+			Repository: "library",
+			Host:       "http://127.0.0.1",
+		},
+		"http://127.0.0.1/library/app:latest",
+	},
+	{
+		"quay.io/org/team/app:1.0", URL{
+			Name:       "app",
+			Tag:        "1.0",
+			Repository: "org/team",
+			Host:       "quay.io",
+		},
+		"quay.io/org/team/app:1.0",
+	},
+	{
+		"org/team/app", URL{}, "<empty>",
+	},
+	{
+		"http://localhost", URL{}, "<empty>",
+	},
 	{
 		"", URL{}, "<empty>",
 	},
@@ -84,3 +117,76 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestIsQualified(t *testing.T) {
+	assert.False(t, IsQualified("ubuntu"))
+	assert.False(t, IsQualified("team/app:1.0"))
+	assert.False(t, IsQualified("ubuntu@sha256:abc"))
+	assert.True(t, IsQualified("docker.io/ubuntu"))
+	assert.True(t, IsQualified("localhost:5000/app"))
+}
+
+func TestIsTagged(t *testing.T) {
+	assert.False(t, IsTagged("ubuntu"))
+	assert.False(t, IsTagged("localhost:5000/app@sha256:abc"))
+	assert.True(t, IsTagged("ubuntu:latest@sha256:abc"))
+	assert.True(t, IsTagged("localhost:5000/team/app:1.0"))
+}
+
+// TestURLForms tests the familiar and canonical forms, which round-trip
+func TestURLForms(t *testing.T) {
+	for ref, expected := range map[string][2]string{
+		"ubuntu":                          {"ubuntu", "docker.io/library/ubuntu:latest"},
+		"docker.io/library/ubuntu:18.04":  {"ubuntu:18.04", "docker.io/library/ubuntu:18.04"},
+		"foo/bar@sha256:abc":              {"foo/bar@sha256:abc", "docker.io/foo/bar:latest@sha256:abc"},
+		"gcr.io/google-containers/alpine": {"gcr.io/google-containers/alpine", "gcr.io/google-containers/alpine:latest"},
+		"localhost:5000/team/app:1.0@sha256:abc": {
+			"localhost:5000/team/app:1.0@sha256:abc", "localhost:5000/team/app:1.0@sha256:abc",
+		},
+	} {
+		url, err := Parse(ref)
+		assert.NoError(t, err)
+
+		assert.Equal(t, expected[0], url.Familiar(), ref)
+		assert.Equal(t, expected[1], url.Canonical(), ref)
+
+		for _, form := range []string{url.Familiar(), url.Canonical()} {
+			parsed, err := Parse(form)
+			assert.NoError(t, err)
+			assert.Equal(t, *url, *parsed, form)
+		}
+	}
+
+	url, _ := Parse("debian:bookworm@sha256:abc")
+	assert.Equal(t, "debian:trixie", url.WithTag("trixie").Familiar())
+	assert.Equal(t, "debian:bookworm@sha256:def", url.WithDigest("sha256:def").Familiar())
+	assert.Equal(t, "sha256:abc", url.Digest, "the url is not modified")
+}
+
+// TestPlainHTTP tests that plain HTTP is only used for local registries and
+// the ones allowed explicitly, with or without the http:// prefix
+func TestPlainHTTP(t *testing.T) {
+	defer func() { plainHTTP = make(map[string]bool) }()
+
+	_, err := Parse("http://registry:5000/team/app:1.0")
+	assert.ErrorContains(t, err, "registry:5000 is not a local registry")
+
+	url, err := Parse("registry:5000/team/app:1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://registry:5000/v2/team/app/manifests/1.0", url.Endpoint("manifests", "1.0"))
+
+	AllowPlainHTTP("Registry:5000", "http://10.0.0.5:5000/")
+
+	url, err = Parse("registry:5000/team/app:1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://registry:5000/v2/team/app/manifests/1.0", url.Endpoint("manifests", "1.0"))
+
+	url, err = Parse("http://10.0.0.5:5000/team/app:1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.5:5000", url.Host)
+	assert.Equal(t, "http://10.0.0.5:5000/v2/team/app/manifests/1.0", url.Endpoint("manifests", "1.0"))
+
+	url, err = Parse("registry.example.org/team/app:1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://registry.example.org/v2/team/app/manifests/1.0", url.Endpoint("manifests", "1.0"))
+}
@@ -3,14 +3,92 @@ package image
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
+// TempDir is the directory holding temporary files, like built layers and
+// images exported from other stores (defaults to the system temp dir)
+var TempDir = ""
+
+// tempDir returns the directory holding temporary files
+func tempDir() string {
+	if TempDir != "" {
+		return TempDir
+	}
+
+	return os.TempDir()
+}
+
 func bisect(text string, delimiter string) (string, string) {
 	split := strings.SplitN(text, delimiter, 2)
 	return split[0], split[1]
 }
 
+// writeFileAtomic writes the data to a temporary file next to the given
+// path and renames it, so that readers never observe a partial file
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", path, err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("error renaming %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// parallel calls fn for the indices 0..n-1 using the given number of workers
+// and returns the first error encountered, after all calls have returned
+func parallel(workers int, n int, fn func(i int) error) error {
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+
+	indices := make(chan int)
+
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				if err := fn(i); err != nil {
+					once.Do(func() { first = err })
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+
+	close(indices)
+	wg.Wait()
+
+	return first
+}
+
 // mustNewRequest calls http.NewRequest, but panics if there's an error (as those
 // are most certainly errors that we catch during testing)
 func mustNewRequest(method string, url string) *http.Request {
@@ -26,18 +104,25 @@ func requireSupportedMimeTypes(client *http.Client, url URL) error {
 	ref := url.Endpoint("manifests", url.Reference())
 
 	req := mustNewRequest("HEAD", ref)
-	req.Header.Add("Accept", fmt.Sprintf("%s, */*", ManifestMimeType))
+	req.Header.Add("Accept", fmt.Sprintf("%s, %s, */*",
+		strings.Join(manifestMimeTypes, ", "),
+		strings.Join(manifestListMimeTypes, ", ")))
 
 	res, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error requesting %s: %v", ref, err)
+		return fmt.Errorf("error requesting %s: %w", ref, err)
 	}
 	if res.StatusCode != 200 {
-		return fmt.Errorf("HEAD %s returned %s", ref, res.Status)
+		return NewRequestError(res)
 	}
 
 	mime := res.Header.Get("Content-Type")
-	if mime != ManifestMimeType && mime != ManifestListMimeType {
+	// schema version 1 manifests are refused when pulled, unless allowed
+	if isMimeType(mime, schema1MimeTypes...) {
+		return nil
+	}
+
+	if !isMimeType(mime, manifestMimeTypes...) && !isMimeType(mime, manifestListMimeTypes...) {
 		return fmt.Errorf("no schema version 2 support by %s", url)
 	}
 
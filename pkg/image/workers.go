@@ -0,0 +1,169 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"hash/fnv"
+	"io"
+	"path"
+	"sync"
+)
+
+// maxBufferedFile is the size up to which files are read into memory to be
+// written by a worker, larger files are written while reading the layer
+const maxBufferedFile = 1024 * 1024
+
+// fileWorkers write the regular files of a layer concurrently. Entries of
+// the same path are always handed to the same worker, so they are written
+// in the order of the layer, like they are without workers.
+type fileWorkers struct {
+	x       *extraction
+	queues  []chan *bufferedFile
+	pending sync.WaitGroup
+	done    sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+
+	// queued counts the entries of each path which are not written yet
+	queued map[string]int
+}
+
+// bufferedFile is a regular file read from the layer
+type bufferedFile struct {
+	header  *tar.Header
+	content []byte
+}
+
+// newFileWorkers starts the given number of workers, with less than two
+// workers the files are written directly
+func newFileWorkers(x *extraction, n int) *fileWorkers {
+	w := &fileWorkers{x: x, queued: make(map[string]int)}
+
+	if n < 2 {
+		return w
+	}
+
+	w.queues = make([]chan *bufferedFile, n)
+
+	for i := range w.queues {
+		w.queues[i] = make(chan *bufferedFile, 16)
+		w.done.Add(1)
+
+		go func(queue chan *bufferedFile) {
+			defer w.done.Done()
+
+			for f := range queue {
+				if w.failure() == nil {
+					w.fail(x.extractFile(f.header, bytes.NewReader(f.content)))
+				}
+
+				w.written(f.header.Name)
+				w.pending.Done()
+			}
+		}(w.queues[i])
+	}
+
+	return w
+}
+
+// extract hands the given file to a worker, or writes it directly if there
+// are no workers or if it is too large to be kept in memory
+func (w *fileWorkers) extract(h *tar.Header, r io.Reader) error {
+	if err := w.failure(); err != nil {
+		return err
+	}
+
+	if len(w.queues) == 0 {
+		return w.x.extractFile(h, r)
+	}
+
+	if h.Size > maxBufferedFile {
+
+		// the workers may still write an earlier entry of the same path
+		w.pending.Wait()
+
+		if err := w.failure(); err != nil {
+			return err
+		}
+
+		return w.x.extractFile(h, r)
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	name := path.Clean("/" + h.Name)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+
+	w.mu.Lock()
+	w.queued[name]++
+	w.mu.Unlock()
+
+	w.pending.Add(1)
+	w.queues[hash.Sum32()%uint32(len(w.queues))] <- &bufferedFile{h, content}
+
+	return nil
+}
+
+// settle waits for the workers to write the queued files of the given path,
+// before an entry of the same path is handled directly (e.g. a directory
+// replacing a file)
+func (w *fileWorkers) settle(name string) error {
+	if len(w.queues) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	busy := w.queued[path.Clean("/"+name)] > 0
+	w.mu.Unlock()
+
+	if busy {
+		w.pending.Wait()
+	}
+
+	return w.failure()
+}
+
+// written removes a file written by a worker from the queued paths
+func (w *fileWorkers) written(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	name = path.Clean("/" + name)
+
+	if w.queued[name]--; w.queued[name] == 0 {
+		delete(w.queued, name)
+	}
+}
+
+// wait stops the workers once all files are written, returning the first
+// error encountered by any of them
+func (w *fileWorkers) wait() error {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+
+	w.done.Wait()
+	return w.failure()
+}
+
+func (w *fileWorkers) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *fileWorkers) failure() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
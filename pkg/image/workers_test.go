@@ -0,0 +1,92 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExtractWorkers tests that concurrent workers produce the same tree
+func TestExtractWorkers(t *testing.T) {
+	files := map[string][]byte{"large": bytes.Repeat([]byte("x"), maxBufferedFile+1)}
+	order := []string{"a/", "b/"}
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("%c/%d", 'a'+i%2, i)
+		files[name] = []byte(name)
+		order = append(order, name)
+	}
+
+	// the same path twice in one layer, the last entry wins
+	layer := tarball(t, files, append(order, "large")...)
+	files["a/0"] = []byte("overwritten")
+	layer = append(layer[:len(layer)-1024], tarball(t, files, "a/0", "large")...)
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	hashes := []string{}
+
+	for _, workers := range []int{0, 4} {
+		dst := t.TempDir()
+
+		_, err := store.Extract(context.Background(), newLayeredSource(layer), dst, &ExtractOptions{Workers: workers})
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(dst, "a/0"))
+		assert.NoError(t, err)
+		assert.Equal(t, "overwritten", string(content))
+
+		hash, err := TreeHash(dst)
+		assert.NoError(t, err)
+		hashes = append(hashes, hash)
+	}
+
+	assert.Equal(t, hashes[0], hashes[1])
+}
+
+// TestExtractWorkersReplace tests that directories replace the files of the
+// same path, written by the workers or by a lower layer
+func TestExtractWorkersReplace(t *testing.T) {
+	files := map[string][]byte{}
+	names := []string{}
+
+	// files replaced by directories in the same layer and in the next one
+	lower, upper := []string{}, []string{}
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("%d", i)
+		files[name] = []byte(name)
+		files[name+"/file"] = []byte("child")
+		names = append(names, name)
+
+		if i%2 == 0 {
+			lower = append(lower, name, name+"/", name+"/file")
+		} else {
+			lower = append(lower, name)
+			upper = append(upper, name+"/", name+"/file")
+		}
+	}
+
+	store, err := NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, workers := range []int{0, 4} {
+		dst := t.TempDir()
+		source := newLayeredSource(tarball(t, files, lower...), tarball(t, files, upper...))
+
+		_, err := store.Extract(context.Background(), source, dst, &ExtractOptions{Workers: workers})
+		assert.NoError(t, err)
+
+		for _, name := range names {
+			content, err := os.ReadFile(filepath.Join(dst, name, "file"))
+			assert.NoError(t, err)
+			assert.Equal(t, "child", string(content))
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package image
+
+import "golang.org/x/sys/unix"
+
+// setxattr sets an extended attribute on the given path, without following
+// symbolic links
+func setxattr(path string, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
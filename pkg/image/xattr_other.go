@@ -0,0 +1,10 @@
+//go:build !linux
+
+package image
+
+import "errors"
+
+// setxattr is not supported outside of Linux
+func setxattr(path string, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
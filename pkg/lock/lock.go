@@ -1,19 +1,31 @@
 // Package lock provides interprocess locking using a combination of flock
-// and process-local mutex-es
+// and process-local mutex-es. Locks may be held exclusively or shared.
 package lock
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alexflint/go-filemutex"
 )
 
 var (
 	locksmu = &sync.Mutex{}
-	locks   = make(map[string]*sync.Mutex)
+	locks   = make(map[string]*sync.RWMutex)
 )
 
+// RetryInterval is the time LockContext waits between attempts to get a lock
+var RetryInterval = 100 * time.Millisecond
+
+// WaitReportInterval is the time LockContext waits before calling Waiting for
+// the first time, and between subsequent calls
+var WaitReportInterval = 5 * time.Second
+
 // InterProcessLock provides a mutex that works across the current process and
 // across all other processes. It works by first acquiring a local lock and
 // then a file lock.
@@ -22,20 +34,36 @@ var (
 // of interprocess locking in Linux -> we have to avoid reusing the same lock
 // file multiple times in the same process or closing one of the locks will
 // unlock all the others. See: http://0pointer.de/blog/projects/locking.html
+//
+// On Windows, the file lock is taken using LockFileEx. As paths are not case
+// sensitive there, the local locks are shared by paths differing in case.
+//
+// Like a sync.RWMutex, the lock is either held by a single writer (Lock) or
+// by any number of readers (RLockContext).
 type InterProcessLock struct {
-	Path     string
+	Path string
+
+	// Waiting is called by LockContext while the lock is held by someone
+	// else, with the holder (see Holder) and the time waited so far
+	Waiting func(holder string, waited time.Duration)
+
 	filelock *filemutex.FileMutex
+
+	// shared is the file holding the shared lock, if locked for reading
+	shared *os.File
 }
 
-func (l *InterProcessLock) localMutex() *sync.Mutex {
+func (l *InterProcessLock) localMutex() *sync.RWMutex {
 	locksmu.Lock()
 	defer locksmu.Unlock()
 
-	if locks[l.Path] == nil {
-		locks[l.Path] = &sync.Mutex{}
+	key := normalize(l.Path)
+
+	if locks[key] == nil {
+		locks[key] = &sync.RWMutex{}
 	}
 
-	return locks[l.Path]
+	return locks[key]
 }
 
 // Lock the lock, blocking until the lock has been acquired
@@ -57,15 +85,165 @@ func (l *InterProcessLock) Lock() error {
 		return fmt.Errorf("could not acquire file lock: %v", err)
 	}
 
+	l.writeHolder()
 	return nil
 }
 
-// Unlock the lock
+// LockContext locks the lock like Lock, but gives up with the error of the
+// context once the context is done
+func (l *InterProcessLock) LockContext(ctx context.Context) error {
+	local := l.localMutex()
+	w := &waiter{lock: l, start: time.Now()}
+
+	for !local.TryLock() {
+		if err := w.wait(ctx); err != nil {
+			return fmt.Errorf("could not acquire lock: %v", err)
+		}
+	}
+
+	if l.filelock != nil {
+		local.Unlock()
+		return fmt.Errorf("expected filelock to be nil")
+	}
+
+	filelock, err := filemutex.New(l.Path)
+	if err != nil {
+		local.Unlock()
+		return fmt.Errorf("could not acquire lock: %v", err)
+	}
+
+	for {
+		err := filelock.TryLock()
+
+		if err == nil {
+			l.filelock = filelock
+			l.writeHolder()
+			return nil
+		}
+
+		if err == filemutex.AlreadyLocked {
+			err = w.wait(ctx)
+		}
+
+		if err != nil {
+			filelock.Close()
+			local.Unlock()
+			return fmt.Errorf("could not acquire file lock: %v", err)
+		}
+	}
+}
+
+// RLockContext locks the lock for reading, together with other readers, but
+// gives up with the error of the context once the context is done. Readers
+// are not recorded as holders.
+func (l *InterProcessLock) RLockContext(ctx context.Context) error {
+	local := l.localMutex()
+	w := &waiter{lock: l, start: time.Now()}
+
+	for !local.TryRLock() {
+		if err := w.wait(ctx); err != nil {
+			return fmt.Errorf("could not acquire lock: %v", err)
+		}
+	}
+
+	if l.shared != nil {
+		local.RUnlock()
+		return fmt.Errorf("expected shared lock to be nil")
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		local.RUnlock()
+		return fmt.Errorf("could not acquire lock: %v", err)
+	}
+
+	for {
+		locked, err := tryLockShared(f)
+
+		if locked {
+			l.shared = f
+			return nil
+		}
+
+		if err == nil {
+			err = w.wait(ctx)
+		}
+
+		if err != nil {
+			f.Close()
+			local.RUnlock()
+			return fmt.Errorf("could not acquire file lock: %v", err)
+		}
+	}
+}
+
+// RUnlock unlocks a lock locked for reading
+func (l *InterProcessLock) RUnlock() error {
+	if l.shared == nil {
+		return fmt.Errorf("expected shared lock to be set")
+	}
+
+	if err := unlockShared(l.shared); err != nil {
+		return fmt.Errorf("could not unlock file lock: %v", err)
+	}
+
+	if err := l.shared.Close(); err != nil {
+		return fmt.Errorf("could not close file lock: %v", err)
+	}
+
+	l.shared = nil
+	l.localMutex().RUnlock()
+	return nil
+}
+
+// MustRUnlock removes the shared lock and panics if that fails
+func (l *InterProcessLock) MustRUnlock() {
+	if err := l.RUnlock(); err != nil {
+		panic(err)
+	}
+}
+
+// waiter keeps track of the time spent waiting for a lock
+type waiter struct {
+	lock     *InterProcessLock
+	start    time.Time
+	reported time.Duration
+}
+
+// wait blocks for the retry interval, or until the context is done. Once the
+// report interval has passed, the lock's Waiting function is called.
+func (w *waiter) wait(ctx context.Context) error {
+	waited := time.Since(w.start)
+
+	if w.lock.Waiting != nil && waited-w.reported >= WaitReportInterval {
+		w.reported = waited
+		w.lock.Waiting(Holder(w.lock.Path), waited)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(RetryInterval):
+		return nil
+	}
+}
+
+// Unlock the lock. The lock file is closed, as open files cannot be removed
+// on Windows, and the lock may be locked again afterwards.
 func (l *InterProcessLock) Unlock() error {
+
+	// the holder is only known while the lock is held
+	_ = os.Truncate(l.Path, 0)
+
 	if err := l.filelock.Unlock(); err != nil {
 		return fmt.Errorf("could not unlock file lock: %v", err)
 	}
 
+	if err := l.filelock.Close(); err != nil {
+		return fmt.Errorf("could not close file lock: %v", err)
+	}
+
+	l.filelock = nil
 	l.localMutex().Unlock()
 	return nil
 }
@@ -84,3 +262,28 @@ func (l *InterProcessLock) MustUnlock() {
 		panic(err)
 	}
 }
+
+// writeHolder records the current process in the lock file, so processes
+// waiting for the lock can tell who holds it. This is best-effort, on Windows
+// for example, the locked file cannot be written to.
+func (l *InterProcessLock) writeHolder() {
+	host, _ := os.Hostname()
+
+	holder := fmt.Sprintf("pid %d on %s since %s (%s)\n",
+		os.Getpid(), host, time.Now().Format(time.RFC3339), strings.Join(os.Args, " "))
+
+	_ = os.WriteFile(l.Path, []byte(holder), 0644)
+}
+
+// Holder returns the process holding the lock at the given path, as recorded
+// by that process
+func Holder(path string) string {
+	holder, err := os.ReadFile(path)
+	holder = bytes.TrimSpace(holder)
+
+	if err != nil || len(holder) == 0 {
+		return "an unknown process"
+	}
+
+	return string(holder)
+}
@@ -1,9 +1,12 @@
 package lock
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,3 +24,109 @@ func TestLockSimple(t *testing.T) {
 	assert.NoError(t, foo.Unlock(), "error unlocking foo")
 	assert.NoError(t, bar.Unlock(), "error unlocking bar")
 }
+
+// TestLockContext tests giving up on a lock that is held elsewhere
+func TestLockContext(t *testing.T) {
+	dir := t.TempDir()
+
+	foo := &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.NoError(t, foo.Lock(), "error locking foo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	other := &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.ErrorContains(t, other.LockContext(ctx), "deadline exceeded")
+
+	assert.NoError(t, foo.Unlock(), "error unlocking foo")
+
+	other = &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.NoError(t, other.LockContext(context.Background()), "error locking foo")
+	assert.NoError(t, other.Unlock(), "error unlocking foo")
+}
+
+// TestLockReuse tests that unlocked locks release the file and may be locked
+// again, and that equivalent paths share the same local lock
+func TestLockReuse(t *testing.T) {
+	dir := t.TempDir()
+
+	foo := &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.NoError(t, foo.Lock(), "error locking foo")
+	assert.NoError(t, foo.Unlock(), "error unlocking foo")
+
+	// open files cannot be removed on Windows
+	assert.NoError(t, os.Remove(foo.Path), "error removing foo")
+
+	assert.NoError(t, foo.Lock(), "error relocking foo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	other := &InterProcessLock{Path: dir + "//./foo"}
+	assert.ErrorContains(t, other.LockContext(ctx), "deadline exceeded")
+
+	assert.NoError(t, foo.Unlock(), "error unlocking foo")
+}
+
+// TestLockWaiting tests reporting the holder of a lock while waiting for it
+func TestLockWaiting(t *testing.T) {
+	defer func(interval time.Duration) { WaitReportInterval = interval }(WaitReportInterval)
+	WaitReportInterval = 50 * time.Millisecond
+
+	dir := t.TempDir()
+
+	foo := &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.NoError(t, foo.Lock(), "error locking foo")
+
+	holders := []string{}
+	waits := []time.Duration{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	other := &InterProcessLock{Path: path.Join(dir, "foo")}
+	other.Waiting = func(holder string, waited time.Duration) {
+		holders = append(holders, holder)
+		waits = append(waits, waited)
+	}
+
+	assert.Error(t, other.LockContext(ctx))
+	assert.NotEmpty(t, holders, "waiting must be reported")
+	assert.Contains(t, holders[0], fmt.Sprintf("pid %d", os.Getpid()))
+	assert.GreaterOrEqual(t, waits[0], WaitReportInterval)
+
+	assert.NoError(t, foo.Unlock(), "error unlocking foo")
+	assert.Equal(t, "an unknown process", Holder(foo.Path))
+}
+
+// TestSharedLock tests that readers share the lock, while writers wait for
+// the readers and the other way around
+func TestSharedLock(t *testing.T) {
+	dir := t.TempDir()
+
+	reader := &InterProcessLock{Path: path.Join(dir, "foo")}
+	other := &InterProcessLock{Path: path.Join(dir, "foo")}
+
+	assert.NoError(t, reader.RLockContext(context.Background()), "error locking foo for reading")
+	assert.NoError(t, other.RLockContext(context.Background()), "error sharing foo")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	writer := &InterProcessLock{Path: path.Join(dir, "foo")}
+	assert.ErrorContains(t, writer.LockContext(ctx), "deadline exceeded")
+
+	assert.NoError(t, reader.RUnlock(), "error unlocking foo")
+	assert.NoError(t, other.RUnlock(), "error unlocking foo")
+
+	assert.NoError(t, writer.LockContext(context.Background()), "error locking foo")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorContains(t, reader.RLockContext(ctx), "deadline exceeded")
+	assert.NoError(t, writer.Unlock(), "error unlocking foo")
+
+	assert.NoError(t, reader.RLockContext(context.Background()), "error relocking foo for reading")
+	assert.NoError(t, reader.RUnlock(), "error unlocking foo")
+}
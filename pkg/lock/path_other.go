@@ -0,0 +1,16 @@
+//go:build !windows
+
+package lock
+
+import (
+	"path/filepath"
+)
+
+// normalize returns the key of the local lock for the given path
+func normalize(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return filepath.Clean(path)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package lock
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// normalize returns the key of the local lock for the given path. Paths on
+// Windows are not case sensitive and may use either kind of slash.
+func normalize(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return strings.ToLower(filepath.Clean(path))
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// tryLockShared takes a shared flock on the given file, returning false if
+// it is locked exclusively by someone else
+func tryLockShared(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unlockShared releases the shared flock on the given file
+func unlockShared(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockShared takes a shared lock on the first byte of the given file (the
+// byte locked exclusively by the file mutex), returning false if it is locked
+// exclusively by someone else
+func tryLockShared(f *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unlockShared releases the shared lock on the given file
+func unlockShared(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,209 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// awsCredentials are the credentials used to sign requests to AWS APIs
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// imdsEndpoint returns the endpoint of the EC2 instance metadata service
+func imdsEndpoint() string {
+	if e := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"); e != "" {
+		return strings.TrimSuffix(e, "/")
+	}
+
+	return "http://169.254.169.254"
+}
+
+// imdsClient is used for the metadata service, which answers right away or
+// not at all (when not running on EC2)
+var imdsClient = &http.Client{Timeout: 2 * time.Second}
+
+// instanceCredentials returns the credentials of the instance profile using
+// the metadata service (IMDSv2)
+func instanceCredentials() (*awsCredentials, error) {
+	endpoint := imdsEndpoint()
+
+	// IMDSv2 requires a session token
+	req, _ := http.NewRequest("PUT", endpoint+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	token, err := imdsRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting metadata token: %v", err)
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, _ := http.NewRequest("GET", endpoint+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return imdsRequest(req)
+	}
+
+	role, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("error getting instance role: %v", err)
+	}
+
+	first, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+	if first == "" {
+		return nil, fmt.Errorf("the instance has no role")
+	}
+
+	data, err := get("/latest/meta-data/iam/security-credentials/" + first)
+	if err != nil {
+		return nil, fmt.Errorf("error getting credentials of %s: %v", first, err)
+	}
+
+	c := &awsCredentials{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing credentials of %s: %v", first, err)
+	}
+
+	return c, nil
+}
+
+// imdsRequest sends the given request to the metadata service and returns
+// the body of the response
+func imdsRequest(req *http.Request) ([]byte, error) {
+	res, err := imdsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s %s returned %s", req.Method, req.URL, res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+// signRequest signs the request using AWS Signature Version 4, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signRequest(req *http.Request, body []byte, c *awsCredentials, region string, service string, now time.Time) {
+	amzdate := now.UTC().Format("20060102T150405Z")
+	date := amzdate[:8]
+
+	req.Header.Set("X-Amz-Date", amzdate)
+	if c.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.Token)
+	}
+
+	// the canonical headers include the host and all headers set so far
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSum(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzdate, scope, hexSum([]byte(canonical))}, "\n")
+
+	key := hmacSum([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSum(key, region)
+	key = hmacSum(key, service)
+	key = hmacSum(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		c.AccessKeyID, scope, signedHeaders, hmacSum(key, toSign)))
+}
+
+// canonicalQuery returns the query sorted and escaped as required by SigV4
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", awsEscape(key), awsEscape(value)))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape escapes everything but unreserved characters
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hexSum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func hmacSum(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsRequest sends a signed JSON request to an AWS API and decodes the
+// response into v
+func awsRequest(endpoint string, target string, body []byte, c *awsCredentials, region string, service string, v interface{}) error {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signRequest(req, body, c, region, service, time.Now())
+
+	res, err := image.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %v", endpoint, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s failed with %s: %s", target, res.Status, bytes.TrimSpace(msg))
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignRequest tests the signatures against the AWS SigV4 test suite
+// (credentials, region, service and date of the suite)
+func TestSignRequest(t *testing.T) {
+	credentials := &awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		headers   map[string]string
+		body      string
+		signed    string
+		signature string
+	}{
+		{
+			name:      "get-vanilla",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/",
+			signed:    "host;x-amz-date",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signed:    "host;x-amz-date",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:      "post-vanilla",
+			method:    "POST",
+			url:       "https://example.amazonaws.com/",
+			signed:    "host;x-amz-date",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:      "post-x-www-form-urlencoded",
+			method:    "POST",
+			url:       "https://example.amazonaws.com/",
+			headers:   map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:      "Param1=value1",
+			signed:    "content-type;host;x-amz-date",
+			signature: "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		assert.NoError(t, err)
+
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+
+		signRequest(req, []byte(test.body), credentials, "us-east-1", "service", now)
+
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"), test.name)
+		assert.Equal(t, fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=%s, Signature=%s",
+			test.signed, test.signature), req.Header.Get("Authorization"), test.name)
+	}
+}
+
+// TestSignRequestToken tests that session tokens are sent and signed
+func TestSignRequestToken(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+
+	signRequest(req, nil, &awsCredentials{AccessKeyID: "id", SecretAccessKey: "secret", Token: "session"},
+		"us-east-1", "ecr", time.Now())
+
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+// TestInstanceCredentials tests getting the credentials of the instance role
+// from a metadata service, which requires IMDSv2 session tokens
+func TestInstanceCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles string
+		err   string
+	}{
+		{name: "role", roles: "app\nother\n"},
+		{name: "no role", roles: "", err: "the instance has no role"},
+		{name: "missing role", roles: "unknown", err: "error getting credentials of unknown"},
+	}
+
+	for _, test := range tests {
+		imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+				assert.Equal(t, "300", r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+				w.Write([]byte("session"))
+				return
+			}
+
+			if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/latest/meta-data/iam/security-credentials/":
+				w.Write([]byte(test.roles))
+			case "/latest/meta-data/iam/security-credentials/app":
+				w.Write([]byte(`{"AccessKeyId": "id", "SecretAccessKey": "secret", "Token": "token"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", imds.URL+"/")
+
+		c, err := instanceCredentials()
+		imds.Close()
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, &awsCredentials{AccessKeyID: "id", SecretAccessKey: "secret", Token: "token"}, c, test.name)
+	}
+}
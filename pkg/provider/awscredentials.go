@@ -0,0 +1,230 @@
+package provider
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ecsEndpoint is the endpoint of the credentials of ECS tasks
+const ecsEndpoint = "http://169.254.170.2"
+
+// defaultCredentials returns the credentials of the default chain of the AWS
+// SDKs: the environment, the shared credentials file, the web identity of
+// the pod (EKS), the role of the ECS task and the instance profile. If a
+// profile is given, only the shared credentials file is used.
+func defaultCredentials(profile string) (*awsCredentials, error) {
+	if profile != "" {
+		return profileCredentials(profile)
+	}
+
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	profile = os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	if c, err := profileCredentials(profile); err == nil {
+		return c, nil
+	} else if os.Getenv("AWS_PROFILE") != "" {
+		return nil, err
+	}
+
+	if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" {
+		return webIdentityCredentials()
+	}
+
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return containerCredentials()
+	}
+
+	return instanceCredentials()
+}
+
+// profileCredentials returns the static credentials of the given profile in
+// the shared credentials file (~/.aws/credentials) or the config file
+func profileCredentials(profile string) (*awsCredentials, error) {
+	home, _ := os.UserHomeDir()
+
+	files := []struct {
+		path    string
+		section string
+	}{
+		{os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), profile},
+		{os.Getenv("AWS_CONFIG_FILE"), "profile " + profile},
+	}
+
+	if files[0].path == "" {
+		files[0].path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	if files[1].path == "" {
+		files[1].path = filepath.Join(home, ".aws", "config")
+	}
+
+	// the default profile is not prefixed in the config file
+	if profile == "default" {
+		files[1].section = profile
+	}
+
+	for _, file := range files {
+		values, err := iniSection(file.path, file.section)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", file.path, err)
+		}
+
+		if values["aws_access_key_id"] != "" {
+			return &awsCredentials{
+				AccessKeyID:     values["aws_access_key_id"],
+				SecretAccessKey: values["aws_secret_access_key"],
+				Token:           values["aws_session_token"],
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no static credentials for profile %s", profile)
+}
+
+// iniSection returns the keys of the given section of an ini file, missing
+// files have no sections
+func iniSection(path string, section string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return values, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, "="); found && current == section {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// webIdentityResponse is the response of AssumeRoleWithWebIdentity
+type webIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+		SessionToken    string `xml:"SessionToken"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// webIdentityCredentials exchanges the web identity token of the pod (e.g.
+// the service account token of IAM roles for service accounts) for the
+// credentials of the role
+func webIdentityCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading web identity token: %v", err)
+	}
+
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("roots-%d", time.Now().Unix())
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	res, err := http.PostForm(endpoint, query)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %v", endpoint, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		msg, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity failed with %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	wr := &webIdentityResponse{}
+	if err := xml.NewDecoder(res.Body).Decode(wr); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+
+	return &awsCredentials{
+		AccessKeyID:     wr.Credentials.AccessKeyID,
+		SecretAccessKey: wr.Credentials.SecretAccessKey,
+		Token:           wr.Credentials.SessionToken,
+	}, nil
+}
+
+// containerCredentials returns the credentials of the role of the ECS task
+// (or of other containers offering a credentials endpoint)
+func containerCredentials() (*awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = ecsEndpoint + uri
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	data, err := imdsRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting container credentials: %v", err)
+	}
+
+	c := &awsCredentials{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing container credentials: %v", err)
+	}
+
+	return c, nil
+}
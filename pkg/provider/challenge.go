@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// challengeTransport authenticates requests the way the registry demands it.
+// Requests rejected with 401 are answered according to the WWW-Authenticate
+// challenge: with basic auth, or with a bearer token from the realm of the
+// challenge, which is then sent with all requests of the repository.
+type challengeTransport struct {
+	base     http.RoundTripper
+	username string
+	password string
+
+	mu sync.Mutex
+
+	// basic holds the hosts demanding basic auth
+	basic map[string]bool
+
+	// scopes holds the token url of each repository (by host and
+	// repository), tokens holds the transport of each token url
+	scopes map[string]string
+	tokens map[string]*tokenTransport
+}
+
+// clientWithChallenges returns an http.Client which answers the challenges
+// of registries, using the given credentials if not empty
+func clientWithChallenges(username string, password string) *http.Client {
+	return &http.Client{
+		Transport: &challengeTransport{
+			base:     image.Transport(),
+			username: username,
+			password: password,
+			basic:    make(map[string]bool),
+			scopes:   make(map[string]string),
+			tokens:   make(map[string]*tokenTransport),
+		},
+	}
+}
+
+func (t *challengeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.send(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !replayable(req) {
+		return res, err
+	}
+
+	if !t.answer(req, res.Header.Get("WWW-Authenticate")) {
+		return res, nil
+	}
+
+	res.Body.Close()
+	return t.send(req)
+}
+
+// send sends the request with the authentication known for its repository
+func (t *challengeTransport) send(req *http.Request) (*http.Response, error) {
+	key, ok := repositoryKey(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	basic := t.basic[req.URL.Host]
+	tokens := t.tokens[t.scopes[key]]
+	t.mu.Unlock()
+
+	if tokens != nil {
+		return tokens.RoundTrip(req)
+	}
+
+	if basic {
+		r := req.Clone(req.Context())
+		r.SetBasicAuth(t.username, t.password)
+
+		if err := rewind(req, r); err != nil {
+			return nil, err
+		}
+
+		return t.base.RoundTrip(r)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// answer records the authentication demanded by the challenge and returns
+// true if the request should be sent again
+func (t *challengeTransport) answer(req *http.Request, challenge string) bool {
+	key, ok := repositoryKey(req)
+	if !ok {
+		return false
+	}
+
+	scheme, params := parseChallenge(challenge)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch scheme {
+	case "basic":
+		if t.username == "" || t.basic[req.URL.Host] {
+			return false
+		}
+
+		t.basic[req.URL.Host] = true
+		return true
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || realm.Host == "" {
+			return false
+		}
+
+		query := realm.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+
+		for _, scope := range strings.Fields(params["scope"]) {
+			query.Add("scope", scope)
+		}
+
+		realm.RawQuery = query.Encode()
+		u := realm.String()
+
+		// the token has been renewed by the token transport already
+		if t.scopes[key] == u {
+			return false
+		}
+
+		if t.tokens[u] == nil {
+			username, password := t.username, t.password
+			t.tokens[u] = &tokenTransport{base: t.base, fetch: func() (*tokenResponse, error) {
+				return fetchToken(u, username, password)
+			}}
+		}
+
+		t.scopes[key] = u
+		return true
+	default:
+		return false
+	}
+}
+
+// repositoryKey returns the host and the repository of registry requests
+// (e.g. quay.io/org/app for /v2/org/app/manifests/latest). Requests to other
+// paths (e.g. redirects to the storage of blobs) are sent as they are.
+func repositoryKey(req *http.Request) (string, bool) {
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		return "", false
+	}
+
+	if repository, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		return req.URL.Host + "/" + repository, true
+	}
+
+	for _, kind := range []string{"/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(path, kind); i > 0 {
+			return req.URL.Host + "/" + path[:i], true
+		}
+	}
+
+	return req.URL.Host + "/", true
+}
+
+// parseChallenge splits a WWW-Authenticate header into the lowercase scheme
+// and its parameters, e.g. Bearer realm="https://auth.example.org/token",
+// service="registry.example.org",scope="repository:org/app:pull"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		name, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+
+			params[name], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[name], rest, _ = strings.Cut(value, ",")
+			params[name] = strings.TrimSpace(params[name])
+		}
+
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+
+	return strings.ToLower(scheme), params
+}
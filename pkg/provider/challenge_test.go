@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseChallenge tests splitting WWW-Authenticate headers
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			`Bearer realm="https://auth.example.org/token",service="registry.example.org",scope="repository:org/app:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.example.org/token", "service": "registry.example.org", "scope": "repository:org/app:pull"},
+		},
+		{
+			`Bearer realm="https://auth.example.org/token", scope="repository:a:pull repository:b:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.example.org/token", "scope": "repository:a:pull repository:b:pull"},
+		},
+		{
+			`Bearer realm=https://auth.example.org/token,Service=registry`,
+			"bearer",
+			map[string]string{"realm": "https://auth.example.org/token", "service": "registry"},
+		},
+		{
+			`Basic realm="Registry Realm"`,
+			"basic",
+			map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			`Basic`,
+			"basic",
+			map[string]string{},
+		},
+		{
+			``,
+			"",
+			map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		scheme, params := parseChallenge(test.challenge)
+		assert.Equal(t, test.scheme, scheme, test.challenge)
+		assert.Equal(t, test.params, params, test.challenge)
+	}
+}
+
+// TestRepositoryKey tests grouping registry requests by repository
+func TestRepositoryKey(t *testing.T) {
+	tests := []struct {
+		url string
+		key string
+		ok  bool
+	}{
+		{"https://quay.io/v2/org/app/manifests/latest", "quay.io/org/app", true},
+		{"https://quay.io/v2/org/app/blobs/sha256:abc", "quay.io/org/app", true},
+		{"https://quay.io/v2/org/team/app/tags/list", "quay.io/org/team/app", true},
+		{"https://quay.io/v2/", "quay.io/", true},
+		{"https://storage.example.org/blobs/abc", "", false},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		assert.NoError(t, err)
+
+		key, ok := repositoryKey(req)
+		assert.Equal(t, test.ok, ok, test.url)
+		assert.Equal(t, test.key, key, test.url)
+	}
+}
+
+// TestChallengeTransport tests answering the challenges of registries with
+// basic auth or with tokens, which are reused for the repository
+func TestChallengeTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		username  string
+		status    []int
+		requests  int
+		tokens    int
+	}{
+		{name: "bearer", challenge: "bearer", username: "user", status: []int{200, 200}, requests: 3, tokens: 1},
+		{name: "anonymous bearer", challenge: "bearer", status: []int{200, 200}, requests: 3, tokens: 1},
+		{name: "basic", challenge: "basic", username: "user", status: []int{200, 200}, requests: 3},
+		{name: "basic without credentials", challenge: "basic", status: []int{401, 401}, requests: 2},
+		{name: "unknown scheme", challenge: "negotiate", username: "user", status: []int{401, 401}, requests: 2},
+	}
+
+	for _, test := range tests {
+		requests, tokens := 0, 0
+
+		password := ""
+		if test.username != "" {
+			password = "pass"
+		}
+
+		var registry *httptest.Server
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				tokens++
+
+				username, secret, _ := r.BasicAuth()
+				assert.Equal(t, test.username, username, test.name)
+				assert.Equal(t, password, secret, test.name)
+				assert.Equal(t, "registry", r.URL.Query().Get("service"), test.name)
+				assert.Equal(t, "repository:org/app:pull", r.URL.Query().Get("scope"), test.name)
+
+				w.Write([]byte(`{"token": "secret"}`))
+				return
+			}
+
+			requests++
+
+			switch test.challenge {
+			case "bearer":
+				if r.Header.Get("Authorization") == "Bearer secret" {
+					return
+				}
+
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="registry",scope="repository:org/app:pull"`, registry.URL))
+			case "basic":
+				if username, password, ok := r.BasicAuth(); ok && username == "user" && password == "pass" {
+					return
+				}
+
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			default:
+				w.Header().Set("WWW-Authenticate", "Negotiate")
+			}
+
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+
+		client := clientWithChallenges(test.username, password)
+
+		// the second request is authenticated right away
+		status := []int{}
+		for _, path := range []string{"/v2/org/app/manifests/latest", "/v2/org/app/blobs/sha256:abc"} {
+			res, err := client.Get(registry.URL + path)
+			assert.NoError(t, err, test.name)
+			res.Body.Close()
+
+			status = append(status, res.StatusCode)
+		}
+
+		registry.Close()
+
+		assert.Equal(t, test.status, status, test.name)
+		assert.Equal(t, test.requests, requests, test.name)
+		assert.Equal(t, test.tokens, tokens, test.name)
+	}
+}
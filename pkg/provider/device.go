@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/seantis/roots/pkg/image"
+	"golang.org/x/oauth2"
+)
+
+// DevicePrompt is called with the url the user has to open and the code to
+// enter there, while the device flow waits for the authorization
+type DevicePrompt func(uri string, code string)
+
+// acrUsername is the username used with ACR refresh tokens
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// DeviceLogin runs the OAuth device authorization flow for the registry at
+// the given host and returns credentials in the form of 'username:password'
+// that can be passed to GetClient. GitHub (ghcr.io) and Azure (*.azurecr.io)
+// are supported, both require the client id of an OAuth application.
+func DeviceLogin(ctx context.Context, host string, clientID string, prompt DevicePrompt) (string, error) {
+	switch {
+	case ghhosts.MatchString(host):
+		token, err := deviceToken(ctx, &oauth2.Config{
+			ClientID: clientID,
+			Scopes:   []string{"read:packages"},
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: "https://github.com/login/device/code",
+				TokenURL:      "https://github.com/login/oauth/access_token",
+				AuthStyle:     oauth2.AuthStyleInParams,
+			},
+		}, prompt)
+
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("oauth2:%s", token.AccessToken), nil
+
+	case strings.HasSuffix(host, ".azurecr.io"):
+		tenant := os.Getenv("AZURE_TENANT_ID")
+		if tenant == "" {
+			tenant = "organizations"
+		}
+
+		login := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0", tenant)
+
+		token, err := deviceToken(ctx, &oauth2.Config{
+			ClientID: clientID,
+			Scopes:   []string{"https://containerregistry.azure.net/.default", "offline_access"},
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: login + "/devicecode",
+				TokenURL:      login + "/token",
+				AuthStyle:     oauth2.AuthStyleInParams,
+			},
+		}, prompt)
+
+		if err != nil {
+			return "", err
+		}
+
+		refresh, err := acrExchange(ctx, host, tenant, token.AccessToken)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%s:%s", acrUsername, refresh), nil
+	}
+
+	return "", fmt.Errorf("%s does not support the device flow", host)
+}
+
+// SupportsDeviceLogin returns true if DeviceLogin supports the given host
+func SupportsDeviceLogin(host string) bool {
+	return ghhosts.MatchString(host) || strings.HasSuffix(host, ".azurecr.io")
+}
+
+// deviceToken requests a device code, prompts the user and waits for the
+// access token
+func deviceToken(ctx context.Context, config *oauth2.Config, prompt DevicePrompt) (*oauth2.Token, error) {
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("the device flow requires a client id")
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, image.HTTPClient())
+
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting device code: %v", err)
+	}
+
+	prompt(auth.VerificationURI, auth.UserCode)
+
+	token, err := config.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for authorization: %v", err)
+	}
+
+	return token, nil
+}
+
+// acrExchange exchanges an Azure AD access token for an ACR refresh token
+func acrExchange(ctx context.Context, host string, tenant string, token string) (string, error) {
+	endpoint := fmt.Sprintf("https://%s/oauth2/exchange", host)
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {host},
+		"tenant":       {tenant},
+		"access_token": {token},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := image.HTTPClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting %s: %v", endpoint, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("POST %s failed with %s", endpoint, res.Status)
+	}
+
+	tr := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("error parsing response: %v", err)
+	}
+
+	if tr.RefreshToken == "" {
+		return "", fmt.Errorf("%s did not return a token", endpoint)
+	}
+
+	return tr.RefreshToken, nil
+}
@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// TestDeviceToken tests the device flow against an authorization server
+func TestDeviceToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		status   int
+		token    string
+		err      string
+	}{
+		{name: "authorized", clientID: "app", status: 200, token: "access"},
+		{name: "no client id", err: "requires a client id"},
+		{name: "unknown client", clientID: "app", status: 400, err: "error requesting device code"},
+	}
+
+	for _, test := range tests {
+		auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, test.clientID, r.Form.Get("client_id"), test.name)
+
+			w.Header().Set("Content-Type", "application/json")
+
+			switch r.URL.Path {
+			case "/device":
+				w.WriteHeader(test.status)
+				w.Write([]byte(`{"device_code": "device", "user_code": "CODE", "verification_uri": "https://example.org/device", "expires_in": 60, "interval": 1}`))
+			case "/token":
+				assert.Equal(t, "device", r.Form.Get("device_code"), test.name)
+				w.Write([]byte(`{"access_token": "access", "token_type": "bearer"}`))
+			}
+		}))
+
+		prompted := []string{}
+
+		token, err := deviceToken(context.Background(), &oauth2.Config{
+			ClientID: test.clientID,
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: auth.URL + "/device",
+				TokenURL:      auth.URL + "/token",
+				AuthStyle:     oauth2.AuthStyleInParams,
+			},
+		}, func(uri string, code string) {
+			prompted = append(prompted, uri, code)
+		})
+
+		auth.Close()
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			assert.Empty(t, prompted, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.token, token.AccessToken, test.name)
+		assert.Equal(t, []string{"https://example.org/device", "CODE"}, prompted, test.name)
+	}
+}
+
+// TestACRExchange tests exchanging Azure AD tokens for ACR refresh tokens
+func TestACRExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		refresh  string
+		err      string
+	}{
+		{name: "exchanged", status: 200, response: `{"refresh_token": "refresh"}`, refresh: "refresh"},
+		{name: "no token", status: 200, response: `{}`, err: "did not return a token"},
+		{name: "denied", status: 401, response: `{}`, err: "401"},
+	}
+
+	// the exchange is always sent over https
+	assert.NoError(t, image.ConfigureTransport(image.TransportOptions{InsecureSkipTLSVerify: true}))
+	t.Cleanup(func() { image.ConfigureTransport(image.TransportOptions{}) })
+
+	for _, test := range tests {
+		acr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/oauth2/exchange", r.URL.Path, test.name)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "access_token", r.Form.Get("grant_type"), test.name)
+			assert.Equal(t, r.Host, r.Form.Get("service"), test.name)
+			assert.Equal(t, "tenant", r.Form.Get("tenant"), test.name)
+			assert.Equal(t, "access", r.Form.Get("access_token"), test.name)
+
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.response))
+		}))
+
+		refresh, err := acrExchange(context.Background(), strings.TrimPrefix(acr.URL, "https://"), "tenant", "access")
+		acr.Close()
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.refresh, refresh, test.name)
+	}
+}
+
+// TestDeviceLoginHosts tests which registries support the device flow
+func TestDeviceLoginHosts(t *testing.T) {
+	tests := []struct {
+		host      string
+		supported bool
+	}{
+		{"ghcr.io", true},
+		{"example.azurecr.io", true},
+		{"docker.io", false},
+		{"123456789012.dkr.ecr.eu-west-1.amazonaws.com", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.supported, SupportsDeviceLogin(test.host), test.host)
+	}
+
+	_, err := DeviceLogin(context.Background(), "docker.io", "app", func(string, string) {})
+	assert.ErrorContains(t, err, "does not support the device flow")
+}
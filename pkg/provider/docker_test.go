@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDockerClients tests that images in the same namespace of the Docker
+// Hub get clients with tokens for their own repository
+func TestDockerClients(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "registry.docker.io", r.URL.Query().Get("service"))
+		fmt.Fprintf(w, `{"token": "%s"}`, r.URL.Query().Get("scope"))
+	}))
+	defer auth.Close()
+
+	previous := dockerTokenURL
+	dockerTokenURL = auth.URL + "/token"
+	t.Cleanup(func() { dockerTokenURL = previous })
+
+	// the registry only accepts tokens for the requested repository
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repository, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/")
+
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer repository:%s:pull", repository) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer registry.Close()
+
+	p := &DockerProvider{clients: make(map[string]*http.Client)}
+
+	for _, name := range []string{"ubuntu", "debian", "ubuntu"} {
+		url := image.URL{Host: "registry-1.docker.io", Repository: "library", Name: name, Tag: "latest"}
+
+		client, err := p.GetClient(url, "")
+		assert.NoError(t, err, name)
+
+		res, err := client.Get(fmt.Sprintf("%s/v2/library/%s/manifests/latest", registry.URL, name))
+		assert.NoError(t, err, name)
+		res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode, name)
+	}
+
+	assert.Len(t, p.clients, 2)
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// ECRProvider authenticates clients against the Amazon Elastic Container
+// Registry
+type ECRProvider struct {
+	clients map[string]*http.Client
+	mu      sync.Mutex
+}
+
+type ecrTokenResponse struct {
+	AuthorizationData []struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		ExpiresAt          float64 `json:"expiresAt"`
+	} `json:"authorizationData"`
+}
+
+// ecrTokenLifetime is the lifetime of registry tokens without expiry
+const ecrTokenLifetime = 12 * time.Hour
+
+func init() {
+	image.RegisterProvider("ecr", &ECRProvider{
+		clients: make(map[string]*http.Client),
+	})
+}
+
+var ecrhosts = regexp.MustCompile(`^(\d+)\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// Supports returns true if the URLs host is an ECR registry
+func (p *ECRProvider) Supports(url image.URL) bool {
+	return ecrhosts.MatchString(url.Host)
+}
+
+// GetClient returns a client authenticated with ECR. If 'auth' is given in
+// the form of 'username:password' (e.g. 'AWS:<token>'), it is used as is.
+// Otherwise 'auth' names the profile of the shared credentials file to use,
+// or if empty, the default credential chain is used (the environment, the
+// shared credentials file, web identity, ECS task or instance profile). The
+// credentials are exchanged for a registry token (GetAuthorizationToken),
+// which is renewed before it expires.
+func (p *ECRProvider) GetClient(url image.URL, auth string) (*http.Client, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The client for ECR is bound to the registry and the credentials
+	key := fmt.Sprintf("%s %s", url.Host, auth)
+
+	if p.clients[key] == nil {
+		client, err := p.newClient(url.Host, auth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.clients[key] = client
+	}
+
+	return p.clients[key], nil
+}
+
+// newClient returns a new client with the basic credentials of the registry
+func (p *ECRProvider) newClient(host string, auth string) (*http.Client, error) {
+	if strings.Contains(auth, ":") {
+		return clientWithHeaders(map[string]string{
+			"Authorization": fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(auth))),
+		}), nil
+	}
+
+	// the tokens are base64 encoded basic credentials (AWS:<password>)
+	return clientWithScheme("Basic", func() (*tokenResponse, error) {
+		return p.registryToken(host, auth)
+	})
+}
+
+// registryToken returns the authorization token of the given registry, using
+// the credentials of the given profile or of the default chain
+func (p *ECRProvider) registryToken(host string, profile string) (*tokenResponse, error) {
+	match := ecrhosts.FindStringSubmatch(host)
+	account, fips, region, china := match[1], match[2], match[3], match[4]
+
+	credentials, err := defaultCredentials(profile)
+	if err != nil {
+		return nil, fmt.Errorf("no credentials for %s: %v", host, err)
+	}
+
+	endpoint := fmt.Sprintf("https://api.ecr.%s.amazonaws.com%s/", region, china)
+	if fips != "" {
+		endpoint = fmt.Sprintf("https://ecr-fips.%s.amazonaws.com/", region)
+	}
+	body := []byte(fmt.Sprintf(`{"registryIds": [%q]}`, account))
+
+	tr := &ecrTokenResponse{}
+	err = awsRequest(endpoint, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken",
+		body, credentials, region, "ecr", tr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tr.AuthorizationData) == 0 {
+		return nil, fmt.Errorf("%s did not return a token", endpoint)
+	}
+
+	data := tr.AuthorizationData[0]
+
+	if _, err := base64.StdEncoding.DecodeString(data.AuthorizationToken); err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	lifetime := ecrTokenLifetime
+	if data.ExpiresAt > 0 {
+		lifetime = time.Until(time.Unix(int64(data.ExpiresAt), 0))
+	}
+
+	return &tokenResponse{Token: data.AuthorizationToken, ExpiresIn: int(lifetime.Seconds())}, nil
+}
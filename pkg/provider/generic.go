@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// GenericProvider is used for all registries without a specific provider
+type GenericProvider struct {
+	clients map[string]*http.Client
+	mu      sync.Mutex
+}
+
+func init() {
+	image.RegisterFallbackProvider(&GenericProvider{
+		clients: make(map[string]*http.Client),
+	})
+}
+
+// Supports returns true for all URLs
+func (p *GenericProvider) Supports(url image.URL) bool {
+	return true
+}
+
+// GetClient returns a client for any registry. Registries demanding token
+// auth are answered with a token from the realm of their WWW-Authenticate
+// challenge (e.g. quay.io, Harbor or GitLab). If 'auth' is given in the form
+// of 'username:password', it is used for basic auth or to get the token.
+func (p *GenericProvider) GetClient(url image.URL, auth string) (*http.Client, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The client is bound to the host and the credentials
+	key := fmt.Sprintf("%s %s", url.Host, auth)
+
+	if p.clients[key] == nil {
+		p.clients[key] = p.newClient(auth)
+	}
+
+	return p.clients[key], nil
+}
+
+// newClient returns a new client, which answers the challenges of the
+// registry with the credentials, if given
+func (p *GenericProvider) newClient(auth string) *http.Client {
+	username, password, found := strings.Cut(auth, ":")
+	if !found {
+		username, password = "", ""
+	}
+
+	return clientWithChallenges(username, password)
+}
@@ -1,16 +1,17 @@
 package provider
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/seantis/roots/pkg/image"
 )
 
-// GHProvider does not authenticate at the moment
+// GHProvider authenticates clients against the GitHub Container Registry
 type GHProvider struct {
 	clients map[string]*http.Client
 	mu      sync.Mutex
@@ -30,57 +31,64 @@ func (p *GHProvider) Supports(url image.URL) bool {
 	return ghhosts.MatchString(url.Host)
 }
 
-// GetClient returns a client for the GitHub Container Registry. Currently
-// there's no support for private repositories and 'auth' is ignored.
+// GetClient returns a client for the GitHub Container Registry. The 'auth'
+// string is a personal access token or the GITHUB_TOKEN of GitHub Actions,
+// optionally prefixed with the username ('user:token'). Without it, the
+// GITHUB_TOKEN env var is used, if set. Public images need no token.
 func (p *GHProvider) GetClient(url image.URL, auth string) (*http.Client, error) {
+	return p.getClient(url, auth, "pull")
+}
+
+// GetPushClient returns a client for the GitHub Container Registry that may
+// push to the repository, see GetClient
+func (p *GHProvider) GetPushClient(url image.URL, auth string) (*http.Client, error) {
+	return p.getClient(url, auth, "pull,push")
+}
+
+func (p *GHProvider) getClient(url image.URL, auth string, actions string) (*http.Client, error) {
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// The client for Docker is bound to the repository
-	if p.clients[url.Repository] == nil {
-		client, err := p.newClient(url.Repository, url.Name, auth)
+	if auth == "" {
+		auth = os.Getenv("GITHUB_TOKEN")
+	}
+
+	// The client for GitHub is bound to the repository, the credentials
+	// and the actions
+	key := fmt.Sprintf("%s/%s %s %s", url.Repository, url.Name, auth, actions)
+
+	if p.clients[key] == nil {
+		client, err := p.newClient(url.Repository, url.Name, auth, actions)
 
 		if err != nil {
 			return nil, err
 		}
 
-		p.clients[url.Repository] = client
+		p.clients[key] = client
 	}
 
-	return p.clients[url.Repository], nil
+	return p.clients[key], nil
 }
 
-// newClient spawns a new unauthenticated http client for GitHub Container
-// Repository
-func (p *GHProvider) newClient(repository string, name string, auth string) (*http.Client, error) {
+// newClient spawns a new http client for GitHub Container Repository, the
+// token is exchanged for a registry token with the given actions, which is
+// renewed when it expires
+func (p *GHProvider) newClient(repository string, name string, auth string, actions string) (*http.Client, error) {
 	// even public api connections need an authorization token
-	t := "https://ghcr.io/token?scope=repository:%s/%s:pull"
-	u := fmt.Sprintf(t, repository, name)
-
-	res, err := http.Get(u)
-	if err != nil {
-		return nil, fmt.Errorf("error getting access-token via %s: %v", u, err)
-	}
-
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("GET %s failed with %s", u, res.Status)
-	}
-
-	// we'll get it from the json response
-	tr := &dockerTokenResponse{}
-	err = json.NewDecoder(res.Body).Decode(&tr)
-
-	if err != nil {
-		return nil, fmt.Errorf("error parsing response: %e", err)
-	}
-
-	if len(tr.Token) == 0 {
-		return nil, fmt.Errorf("%s did not return a token", u)
+	t := "https://ghcr.io/token?service=ghcr.io&scope=repository:%s/%s:%s"
+	u := fmt.Sprintf(t, repository, name, actions)
+
+	// the username is not checked, but it must not be empty
+	username, token := "", ""
+	if auth != "" {
+		var found bool
+		if username, token, found = strings.Cut(auth, ":"); !found {
+			username, token = "token", auth
+		}
 	}
 
-	// we then use it to create a client with a proper bearer token set
-	return clientWithHeaders(map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %s", tr.Token),
-	}), err
+	return clientWithToken(func() (*tokenResponse, error) {
+		return fetchToken(u, username, token)
+	})
 }
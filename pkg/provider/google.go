@@ -2,12 +2,15 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/seantis/roots/pkg/image"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
@@ -32,7 +35,8 @@ func (p *GCRProvider) Supports(url image.URL) bool {
 }
 
 // GetClient returns a client authenticated with the Google Cloud Registry -
-// the auth string is supposed to be the path to a service account json file
+// the auth string is supposed to be the path to a service account json file,
+// or empty to use the default credentials (see newClient)
 // the required scope is limit to https://www.googleapis.com/auth/devstorage.read_only
 func (p *GCRProvider) GetClient(url image.URL, auth string) (*http.Client, error) {
 
@@ -54,22 +58,41 @@ func (p *GCRProvider) GetClient(url image.URL, auth string) (*http.Client, error
 }
 
 // newClient spawns a new http client for GCR given the path to an account json
-// file, or an empty string (for anonymous access)
+// file, the account json itself prefixed with '_json_key:' (as found in
+// Docker configs), or an empty string.
+//
+// Without auth, the default credentials are used: GOOGLE_APPLICATION_CREDENTIALS,
+// the gcloud credentials or, on GCE/GKE, the metadata server (which includes
+// workload identity). If there are none, the client is anonymous.
 func (p *GCRProvider) newClient(auth string) (*http.Client, error) {
+	// the tokens are requested and the registry is reached using the
+	// configured transport
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, image.HTTPClient())
 
-	// we try to get the Google's default client and fall back on the
-	// unauthenticated client if that doesn't work
 	if len(auth) != 0 {
-		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", auth)
-	}
+		data := []byte(strings.TrimPrefix(auth, "_json_key:"))
+
+		if !strings.HasPrefix(auth, "_json_key:") {
+			var err error
+			if data, err = os.ReadFile(auth); err != nil {
+				return nil, fmt.Errorf("error reading %s: %v", auth, err)
+			}
+		}
 
-	client, err := google.DefaultClient(context.Background(), gcrscope)
+		credentials, err := google.CredentialsFromJSON(ctx, data, gcrscope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service account: %v", err)
+		}
 
-	// we got logged in!
-	if err == nil {
-		return client, nil
+		return oauth2.NewClient(ctx, credentials.TokenSource), nil
 	}
 
+	credentials, err := google.FindDefaultCredentials(ctx, gcrscope)
+
 	// we are not authenticated
-	return &http.Client{}, nil
+	if err != nil {
+		return image.HTTPClient(), nil
+	}
+
+	return oauth2.NewClient(ctx, credentials.TokenSource), nil
 }
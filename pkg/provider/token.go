@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// defaultTokenLifetime is the lifetime of tokens without expires_in, as
+// defined by the token authentication spec
+const defaultTokenLifetime = 60 * time.Second
+
+// tokenMargin is the time before their expiry at which tokens are renewed
+const tokenMargin = 10 * time.Second
+
+// tokenResponse is the response of a token endpoint, see
+// https://distribution.github.io/distribution/spec/auth/token/
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// fetchToken requests a bearer token from the given url, using basic auth
+// if a username is given
+func fetchToken(url string, username string, password string) (*tokenResponse, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	res, err := image.HTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting access-token via %s: %v", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, image.NewRequestError(res)
+	}
+
+	tr := &tokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("error parsing response: %v", err)
+	}
+
+	// some registries only send the OAuth 2.0 compatible field
+	if tr.Token == "" {
+		tr.Token = tr.AccessToken
+	}
+
+	if tr.Token == "" {
+		return nil, fmt.Errorf("%s did not return a token", url)
+	}
+
+	return tr, nil
+}
+
+// tokenTransport sends a bearer token with each request. The token is
+// renewed before it expires, and once if the registry rejects it with 401,
+// in which case the request is sent again.
+type tokenTransport struct {
+	base  http.RoundTripper
+	fetch func() (*tokenResponse, error)
+
+	// scheme is used instead of Bearer if set (e.g. Basic for tokens which
+	// encode credentials)
+	scheme string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// clientWithToken returns an http.Client which authenticates with the tokens
+// returned by fetch, which is called right away to fail early
+func clientWithToken(fetch func() (*tokenResponse, error)) (*http.Client, error) {
+	return clientWithScheme("Bearer", fetch)
+}
+
+// clientWithScheme is like clientWithToken, but sends the tokens with the
+// given authorization scheme
+func clientWithScheme(scheme string, fetch func() (*tokenResponse, error)) (*http.Client, error) {
+	t := &tokenTransport{base: image.Transport(), fetch: fetch, scheme: scheme}
+
+	if _, err := t.current(""); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: t}, nil
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.current("")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.send(req, token)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	if !replayable(req) {
+		return res, nil
+	}
+
+	renewed, err := t.current(token)
+	if err != nil {
+		return res, nil
+	}
+
+	res.Body.Close()
+	return t.send(req, renewed)
+}
+
+// send sends a copy of the request with the given token
+func (t *tokenTransport) send(req *http.Request, token string) (*http.Response, error) {
+	scheme := t.scheme
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", fmt.Sprintf("%s %s", scheme, token))
+
+	if err := rewind(req, r); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// replayable returns true if the request may be sent again, which is not the
+// case for requests with a body that cannot be rewound
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind sets a fresh copy of the body of the request on its clone
+func rewind(req *http.Request, clone *http.Request) error {
+	if req.GetBody == nil {
+		return nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+
+	clone.Body = body
+	return nil
+}
+
+// current returns the current token, which is renewed if it expires soon or
+// if it is the given rejected token (unless it has been renewed since)
+func (t *tokenTransport) current(rejected string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && t.token != rejected && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+
+	tr, err := t.fetch()
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := defaultTokenLifetime
+	if tr.ExpiresIn > 0 {
+		lifetime = time.Duration(tr.ExpiresIn) * time.Second
+	}
+
+	margin := tokenMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+
+	t.token, t.expires = tr.Token, time.Now().Add(lifetime-margin)
+	return t.token, nil
+}
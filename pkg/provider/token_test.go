@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// authLog records the authorization and the body of the requests received
+// by a test server
+type authLog struct {
+	mu     sync.Mutex
+	auths  []string
+	bodies []string
+}
+
+func (l *authLog) add(r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.auths = append(l.auths, r.Header.Get("Authorization"))
+	l.bodies = append(l.bodies, string(body))
+}
+
+// TestTokenTransport tests that tokens are renewed once they expire or are
+// rejected, in which case requests are sent again if possible
+func TestTokenTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		accepted string
+		expire   bool
+		body     func() io.Reader
+		status   int
+		auths    []string
+		fetches  int
+	}{
+		{
+			name:     "valid token",
+			accepted: "token-1",
+			status:   http.StatusOK,
+			auths:    []string{"Bearer token-1"},
+			fetches:  1,
+		},
+		{
+			name:     "basic token",
+			scheme:   "Basic",
+			accepted: "token-1",
+			status:   http.StatusOK,
+			auths:    []string{"Basic token-1"},
+			fetches:  1,
+		},
+		{
+			name:     "expired token",
+			accepted: "token-2",
+			expire:   true,
+			status:   http.StatusOK,
+			auths:    []string{"Bearer token-2"},
+			fetches:  2,
+		},
+		{
+			name:     "rejected token",
+			accepted: "token-2",
+			body:     func() io.Reader { return bytes.NewReader([]byte("data")) },
+			status:   http.StatusOK,
+			auths:    []string{"Bearer token-1", "Bearer token-2"},
+			fetches:  2,
+		},
+		{
+			name:     "rejected token with body that cannot be replayed",
+			accepted: "token-2",
+			body:     func() io.Reader { return io.NopCloser(strings.NewReader("data")) },
+			status:   http.StatusUnauthorized,
+			auths:    []string{"Bearer token-1"},
+			fetches:  1,
+		},
+		{
+			name:     "renewed token rejected as well",
+			accepted: "token-3",
+			status:   http.StatusUnauthorized,
+			auths:    []string{"Bearer token-1", "Bearer token-2"},
+			fetches:  2,
+		},
+	}
+
+	for _, test := range tests {
+		log := &authLog{}
+
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.add(r)
+
+			if !strings.HasSuffix(r.Header.Get("Authorization"), " "+test.accepted) {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+
+		fetches := 0
+		client, err := clientWithScheme(test.scheme, func() (*tokenResponse, error) {
+			fetches++
+			return &tokenResponse{Token: fmt.Sprintf("token-%d", fetches), ExpiresIn: 300}, nil
+		})
+		assert.NoError(t, err, test.name)
+
+		if test.expire {
+			transport := client.Transport.(*tokenTransport)
+			transport.expires = time.Now().Add(-time.Second)
+		}
+
+		method, body := "GET", io.Reader(nil)
+		if test.body != nil {
+			method, body = "POST", test.body()
+		}
+
+		req, err := http.NewRequest(method, registry.URL+"/v2/", body)
+		assert.NoError(t, err, test.name)
+
+		res, err := client.Do(req)
+		assert.NoError(t, err, test.name)
+		res.Body.Close()
+		registry.Close()
+
+		assert.Equal(t, test.status, res.StatusCode, test.name)
+		assert.Equal(t, test.auths, log.auths, test.name)
+		assert.Equal(t, test.fetches, fetches, test.name)
+
+		// replayed requests carry the whole body each time
+		if test.body != nil {
+			for _, b := range log.bodies {
+				assert.Equal(t, "data", b, test.name)
+			}
+		}
+	}
+}
+
+// TestTokenLifetime tests that tokens are renewed before they expire
+func TestTokenLifetime(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		lifetime  time.Duration
+	}{
+		{0, defaultTokenLifetime - tokenMargin},
+		{300, 300*time.Second - tokenMargin},
+		{10, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		transport := &tokenTransport{fetch: func() (*tokenResponse, error) {
+			return &tokenResponse{Token: "token", ExpiresIn: test.expiresIn}, nil
+		}}
+
+		start := time.Now()
+
+		token, err := transport.current("")
+		assert.NoError(t, err)
+		assert.Equal(t, "token", token)
+		assert.WithinDuration(t, start.Add(test.lifetime), transport.expires, time.Second)
+	}
+}
+
+// TestFetchToken tests requesting tokens from a token endpoint
+func TestFetchToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		status   int
+		token    string
+		err      string
+	}{
+		{name: "token", response: `{"token": "a", "expires_in": 60}`, status: 200, token: "a"},
+		{name: "oauth token", response: `{"access_token": "b"}`, status: 200, token: "b"},
+		{name: "no token", response: `{}`, status: 200, err: "did not return a token"},
+		{name: "denied", response: `{}`, status: 401, err: "401"},
+	}
+
+	for _, test := range tests {
+		auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, _ := r.BasicAuth()
+			assert.Equal(t, "user:pass", username+":"+password, test.name)
+
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.response))
+		}))
+
+		tr, err := fetchToken(auth.URL+"/token", "user", "pass")
+		auth.Close()
+
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			continue
+		}
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.token, tr.Token, test.name)
+	}
+}
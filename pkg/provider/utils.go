@@ -1,6 +1,10 @@
 package provider
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/seantis/roots/pkg/image"
+)
 
 type boundHeadersTransport struct {
 	base    http.RoundTripper
@@ -21,7 +25,7 @@ func clientWithHeaders(headers map[string]string) *http.Client {
 	return &http.Client{
 		Transport: &boundHeadersTransport{
 			headers: headers,
-			base:    http.DefaultTransport,
+			base:    image.Transport(),
 		},
 	}
 }
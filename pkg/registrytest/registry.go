@@ -0,0 +1,488 @@
+// Package registrytest provides an in-process registry speaking the v2
+// registry API, to test pulls end-to-end without a real registry
+package registrytest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IndexMimeType is the media type of the indexes pushed by PushIndex
+	IndexMimeType = "application/vnd.oci.image.index.v1+json"
+
+	// ManifestMimeType is the media type of the manifests pushed by Push
+	ManifestMimeType = "application/vnd.oci.image.manifest.v1+json"
+
+	// ConfigMimeType is the media type of the image configs
+	ConfigMimeType = "application/vnd.oci.image.config.v1+json"
+
+	// LayerMimeType is the media type of the gzip compressed layers
+	LayerMimeType = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Registry is a registry serving the manifests, indexes and blobs added to
+// it. It runs on a local address, which roots talks to over plain http.
+type Registry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	manifests map[string]map[string]*manifest
+	blobs     map[string]map[string][]byte
+	requests  []string
+
+	// credentials and token of the simulated token auth (if enabled)
+	username string
+	password string
+	token    string
+}
+
+// manifest is a stored manifest or index
+type manifest struct {
+	mediaType string
+	body      []byte
+	digest    string
+}
+
+// Image describes an image pushed with Push
+type Image struct {
+	OS           string
+	Architecture string
+	Variant      string
+
+	// Layers are the uncompressed tar streams of the layers, from the lowest
+	// to the highest (see Tar)
+	Layers [][]byte
+}
+
+// NewRegistry starts a new, empty registry, which has to be closed
+func NewRegistry() *Registry {
+	r := &Registry{
+		manifests: make(map[string]map[string]*manifest),
+		blobs:     make(map[string]map[string][]byte),
+	}
+
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Close shuts down the registry
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// Host returns the host of the registry, including the http scheme (e.g.
+// http://127.0.0.1:34567), as used by image.URL and in references (e.g.
+// Host() + "/team/app:1.0")
+func (r *Registry) Host() string {
+	return r.server.URL
+}
+
+// RequireToken enables the token auth of the registry: requests have to pass
+// a bearer token, which is issued by the realm given in the challenge of the
+// registry for the given credentials
+func (r *Registry) RequireToken(username string, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.username, r.password = username, password
+	r.token = fmt.Sprintf("%x", sha256.Sum256([]byte(username+":"+password)))
+}
+
+// Token returns the token issued by the registry, if token auth is enabled
+func (r *Registry) Token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.token
+}
+
+// Requests returns the requests served so far, as "METHOD /path", followed by
+// the range of range requests (e.g. "GET /v2/app/blobs/sha256:... bytes=10-")
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string{}, r.requests...)
+}
+
+// AddBlob adds a blob to the given repository (e.g. library/ubuntu) and
+// returns its digest
+func (r *Registry) AddBlob(repository string, content []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := digestOf(content)
+
+	if r.blobs[repository] == nil {
+		r.blobs[repository] = make(map[string][]byte)
+	}
+
+	r.blobs[repository][digest] = content
+	return digest
+}
+
+// AddManifest adds a manifest or index with the given media type to the
+// repository and returns its digest. It is available by digest and, unless
+// the tag is empty, by tag.
+func (r *Registry) AddManifest(repository string, tag string, mediaType string, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := &manifest{mediaType: mediaType, body: body, digest: digestOf(body)}
+
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = make(map[string]*manifest)
+	}
+
+	r.manifests[repository][m.digest] = m
+
+	if tag != "" {
+		r.manifests[repository][tag] = m
+	}
+
+	return m.digest
+}
+
+// Manifest returns the body of the manifest or index with the given reference
+// in the repository, or nil if there is none
+func (r *Registry) Manifest(repository string, ref string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m := r.manifests[repository][ref]; m != nil {
+		return m.body
+	}
+
+	return nil
+}
+
+// Tamper replaces the body served for the given reference of the repository
+// with another one, keeping the digest and media type, like a compromised
+// registry or proxy would
+func (r *Registry) Tamper(repository string, ref string, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := *r.manifests[repository][ref]
+	m.body = body
+
+	r.manifests[repository][ref] = &m
+}
+
+// Push adds the given image to the repository, with an OCI manifest, gzip
+// compressed layers and a config, and returns the digest of the manifest
+func (r *Registry) Push(repository string, tag string, img Image) string {
+	return r.AddManifest(repository, tag, ManifestMimeType, r.image(repository, img))
+}
+
+// PushIndex adds the given images to the repository, referenced by an OCI
+// index with their platforms, and returns the digest of the index
+func (r *Registry) PushIndex(repository string, tag string, images ...Image) string {
+	type platform struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant,omitempty"`
+	}
+
+	type descriptor struct {
+		MediaType string   `json:"mediaType"`
+		Size      int      `json:"size"`
+		Digest    string   `json:"digest"`
+		Platform  platform `json:"platform"`
+	}
+
+	manifests := make([]descriptor, len(images))
+
+	for i, img := range images {
+		body := r.image(repository, img)
+
+		manifests[i] = descriptor{
+			MediaType: ManifestMimeType,
+			Size:      len(body),
+			Digest:    r.AddManifest(repository, "", ManifestMimeType, body),
+			Platform:  platform{Architecture: img.Architecture, OS: img.OS, Variant: img.Variant},
+		}
+	}
+
+	body := mustMarshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     IndexMimeType,
+		"manifests":     manifests,
+	})
+
+	return r.AddManifest(repository, tag, IndexMimeType, body)
+}
+
+// image adds the config and the layers of the image and returns its manifest
+func (r *Registry) image(repository string, img Image) []byte {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Size      int    `json:"size"`
+		Digest    string `json:"digest"`
+	}
+
+	layers := make([]descriptor, len(img.Layers))
+	diffIDs := make([]string, len(img.Layers))
+
+	for i, layer := range img.Layers {
+		compressed := gzipped(layer)
+
+		layers[i] = descriptor{
+			MediaType: LayerMimeType,
+			Size:      len(compressed),
+			Digest:    r.AddBlob(repository, compressed),
+		}
+
+		diffIDs[i] = digestOf(layer)
+	}
+
+	config := mustMarshal(map[string]interface{}{
+		"architecture": img.Architecture,
+		"os":           img.OS,
+		"variant":      img.Variant,
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+
+	digest := r.AddBlob(repository, config)
+
+	return mustMarshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ManifestMimeType,
+		"config":        descriptor{MediaType: ConfigMimeType, Size: len(config), Digest: digest},
+		"layers":        layers,
+	})
+}
+
+// serve handles the requests to the registry
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, strings.TrimSpace(req.Method+" "+req.URL.Path+" "+req.Header.Get("Range")))
+	token := r.token
+	r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	repository, kind, ref := route(path)
+
+	if token != "" && req.Header.Get("Authorization") != "Bearer "+token {
+		scope := ""
+		if repository != "" {
+			scope = fmt.Sprintf(`,scope="repository:%s:pull"`, repository)
+		}
+
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"%s`, r.server.URL, scope))
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the registry is read-only")
+		return
+	}
+
+	switch kind {
+	case "":
+		if path == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+	case "manifests":
+		r.serveManifest(w, req, repository, ref)
+	case "blobs":
+		r.serveBlob(w, req, repository, ref)
+	case "tags":
+		r.serveTags(w, repository)
+	}
+}
+
+// route splits the path below /v2/ into the repository, the kind of the
+// endpoint and the reference (e.g. library/ubuntu, manifests, latest)
+func route(path string) (string, string, string) {
+	if repository, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		return repository, "tags", ""
+	}
+
+	for _, kind := range []string{"manifests", "blobs"} {
+		if i := strings.LastIndex(path, "/"+kind+"/"); i > 0 {
+			return path[:i], kind, path[i+len(kind)+2:]
+		}
+	}
+
+	return "", "", ""
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	username, password, token := r.username, r.password, r.token
+	r.mu.Unlock()
+
+	u, p, ok := req.BasicAuth()
+	if !ok || u != username || p != password {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(mustMarshal(map[string]interface{}{
+		"token":        token,
+		"access_token": token,
+		"expires_in":   300,
+	}))
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repository string, ref string) {
+	r.mu.Lock()
+	m := r.manifests[repository][ref]
+	r.mu.Unlock()
+
+	if m == nil {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", m.digest)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, m.digest))
+	w.Header().Set("Content-Length", fmt.Sprint(len(m.body)))
+
+	if req.Header.Get("If-None-Match") == fmt.Sprintf(`"%s"`, m.digest) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if req.Method == "GET" {
+		w.Write(m.body)
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, repository string, digest string) {
+	r.mu.Lock()
+	blob, ok := r.blobs[repository][digest]
+	r.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// supports range requests
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, repository string) {
+	r.mu.Lock()
+	manifests, ok := r.manifests[repository]
+
+	tags := []string{}
+	for ref := range manifests {
+		if !strings.HasPrefix(ref, "sha256:") {
+			tags = append(tags, ref)
+		}
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	sort.Strings(tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(mustMarshal(map[string]interface{}{"name": repository, "tags": tags}))
+}
+
+// writeError writes an error in the format of the distribution spec
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	w.Write(mustMarshal(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	}))
+}
+
+// Tar returns an uncompressed tar stream with the given files and their
+// content, to be used as layer. Names ending in "/" are directories.
+func Tar(files map[string]string) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, name := range names {
+		h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}
+
+		if strings.HasSuffix(name, "/") {
+			h.Typeflag, h.Mode, h.Size = tar.TypeDir, 0755, 0
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			panic(err)
+		}
+
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipped(content []byte) []byte {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	w.Write(content)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func digestOf(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+func mustMarshal(v interface{}) []byte {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return body
+}
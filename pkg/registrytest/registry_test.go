@@ -0,0 +1,51 @@
+package registrytest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTokenAuth tests that requests without token are challenged and that
+// the token is issued for the right credentials only
+func TestTokenAuth(t *testing.T) {
+	registry := NewRegistry()
+	defer registry.Close()
+
+	registry.Push("library/app", "latest", Image{OS: "linux", Architecture: "amd64"})
+	registry.RequireToken("user", "secret")
+
+	res, err := http.Get(registry.Host() + "/v2/library/app/manifests/latest")
+	assert.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, 401, res.StatusCode)
+	assert.Contains(t, res.Header.Get("WWW-Authenticate"), `scope="repository:library/app:pull"`)
+
+	token := func(password string) int {
+		req, _ := http.NewRequest("GET", registry.Host()+"/token", nil)
+		req.SetBasicAuth("user", password)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	assert.Equal(t, 401, token("wrong"))
+	assert.Equal(t, 200, token("secret"))
+
+	req, _ := http.NewRequest("GET", registry.Host()+"/v2/library/app/tags/list", nil)
+	req.Header.Set("Authorization", "Bearer "+registry.Token())
+
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.JSONEq(t, `{"name": "library/app", "tags": ["latest"]}`, string(body))
+}
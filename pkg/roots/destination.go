@@ -0,0 +1,143 @@
+package roots
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+)
+
+// PrepareDestination creates the destination, removing it first if forced.
+// The history of destinations that are removed is returned, to be restored
+// after the pull, if requested.
+func PrepareDestination(dst string, force bool, keepHistory bool) ([]*image.PullEvent, error) {
+	history := []*image.PullEvent{}
+
+	if force && keepHistory {
+		var err error
+		if history, err = image.ReadHistory(dst); err != nil {
+			return nil, fmt.Errorf("could not read history of %s: %v", dst, err)
+		}
+	}
+
+	if force {
+
+		// let's not be responsible for wiping out an actual root fs
+		if strings.Count(dst, "/") <= 2 {
+			return nil, fmt.Errorf("not enough path separators to force-remove: %s", dst)
+		}
+
+		if err := os.RemoveAll(dst); err != nil {
+			return nil, fmt.Errorf("could note force-remove %s: %v", dst, err)
+		}
+
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return nil, fmt.Errorf("could not create destination at %s: %v", dst, err)
+	}
+
+	return history, nil
+}
+
+// UpToDate returns true if the destination holds the image of the source, as
+// recorded by the last pull, together with the digest of the source. Only
+// destinations with a recorded digest are compared, others cause no requests.
+func UpToDate(dst string, source image.Source) (bool, string, error) {
+	current, err := image.ReadDigest(dst)
+	if err != nil {
+		return false, "", fmt.Errorf("could not read digest of %s: %v", dst, err)
+	}
+
+	if current == "" {
+		return false, "", nil
+	}
+
+	digest, err := SourceDigest(source)
+	if err != nil {
+		return false, "", err
+	}
+
+	return digest == current, digest, nil
+}
+
+// SourceDigest returns the digest of the manifest of the source, for the
+// platform selected by the source
+func SourceDigest(source image.Source) (string, error) {
+	if remote, ok := source.(*image.Remote); ok {
+		return remote.Digest()
+	}
+
+	m, err := source.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	return m.Digest, nil
+}
+
+// RecordPull appends the pull to the history of the destination, after
+// restoring the history from before a forced pull, and records the tree and
+// the digest for later verification
+func RecordPull(dst string, history []*image.PullEvent, result *image.ExtractResult, source image.Source, start time.Time) error {
+	if err := image.RestoreHistory(dst, history); err != nil {
+		return fmt.Errorf("could not restore history of %s: %v", dst, err)
+	}
+
+	username := ""
+	if usr, err := user.Current(); err == nil {
+		username = usr.Username
+	}
+
+	err := image.AppendHistory(dst, &image.PullEvent{
+		Time:     start.UTC(),
+		Image:    source.Name(),
+		Digest:   result.Digest,
+		Platform: result.Platform,
+		Duration: time.Since(start).Seconds(),
+		User:     username,
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not record history of %s: %v", dst, err)
+	}
+
+	if err := image.WriteTree(dst); err != nil {
+		return fmt.Errorf("could not record tree of %s: %v", dst, err)
+	}
+
+	if err := image.WriteDigest(dst, result.Digest); err != nil {
+		return fmt.Errorf("could not record digest of %s: %v", dst, err)
+	}
+
+	return nil
+}
+
+// RecordConfig writes the config of the image extracted from the source to
+// the given file (by default image.ConfigPath of the destination), so that
+// the entrypoint and env can be read without contacting the registry
+func RecordConfig(dst string, file string, source image.Source, digest string) error {
+	if file == "" {
+		file = image.ConfigPath(dst)
+	}
+
+	config, err := image.SourceConfig(source)
+	if err != nil {
+		return fmt.Errorf("could not get config of %s: %w", source, err)
+	}
+
+	err = image.WriteConfig(file, &image.PulledConfig{
+		Image:       source.Name(),
+		Digest:      digest,
+		ImageConfig: config,
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not record config of %s: %v", dst, err)
+	}
+
+	return nil
+}
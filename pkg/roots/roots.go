@@ -0,0 +1,534 @@
+// Package roots pulls container images into directories, like the roots
+// command does. It resolves image names through registries.conf, looks up
+// credentials by host, supports the local sources of the command and keeps
+// the history of each destination, so that programs embedding roots behave
+// the same as roots itself.
+package roots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+
+	// the providers authenticate against the known registries
+	_ "github.com/seantis/roots/pkg/provider"
+)
+
+// localTransports are the prefixes of references which select local sources
+var localTransports = []string{"docker-daemon", "containerd", "containers-storage", "oci", "docker-archive"}
+
+// digestPattern matches the digests of the supported algorithms
+var digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+// Puller pulls images into directories. The zero value pulls from the Docker
+// Hub and the registries named in the references, without credentials.
+type Puller struct {
+
+	// Credentials are used for the registries without explicit auth
+	Credentials image.Credentials
+
+	// Registries resolves short names and mirrors, as configured in the
+	// registries.conf of the containers tools (see image.LoadRegistriesConfig)
+	Registries *image.RegistriesConfig
+
+	// Retries is the number of times failed registry requests are retried
+	// (see image.Remote.WithRetries)
+	Retries int
+
+	// Retention, Dedup and MaxConcurrentDownloads configure the cache (see
+	// image.Store)
+	Retention              *image.Retention
+	Dedup                  bool
+	MaxConcurrentDownloads int
+
+	// StorageRoot is the root of containers-storage (by default the one of
+	// the current user), ContainerdRoot and ContainerdNamespace select the
+	// containerd content store (by default the default namespace)
+	StorageRoot         string
+	ContainerdRoot      string
+	ContainerdNamespace string
+}
+
+// Options select the image a reference points to
+type Options struct {
+
+	// Auth is passed to the provider of the registry (e.g. user:password),
+	// by default the credentials of the puller are used
+	Auth string
+
+	// Platform selects the platform of multi-platform images, by default the
+	// one of the host is preferred
+	Platform *image.Platform
+
+	// StrictPlatform refuses images without matching platform, FirstPlatform
+	// takes the first one of the image if the host's is missing
+	StrictPlatform bool
+	FirstPlatform  bool
+
+	// AllowSchema1 pulls images of legacy registries which only have schema
+	// version 1 manifests (see image.Remote.WithSchema1)
+	AllowSchema1 bool
+}
+
+// PullOptions configure a pull
+type PullOptions struct {
+	Options
+
+	// Cache is the cache directory, by default DefaultCache
+	Cache string
+
+	// Store is used instead of the cache directory, e.g. to share a store
+	// between the pulls of a long-running process
+	Store *image.Store
+
+	// Force replaces the destination, instead of requiring it to be empty
+	Force bool
+
+	// NoHistory does not record the pull in the destination
+	NoHistory bool
+
+	// IfChanged skips the pull if the destination already holds the image,
+	// as recorded by the last pull (even if forced, merging or updating).
+	// Other pulls into such destinations are always skipped, as they would
+	// fail otherwise.
+	IfChanged bool
+
+	// ConfigFile is where the config of the image is written after the
+	// pull, by default image.ConfigPath of the destination
+	ConfigFile string
+
+	// Extract configures the extraction of the layers (may be nil)
+	Extract *image.ExtractOptions
+
+	// RequireDigest refuses references which are not pinned to a digest (see
+	// HasDigest) with a DigestRequiredError
+	RequireDigest bool
+
+	// Signature refuses images without valid cosign signature (may be nil),
+	// which is only supported for images pulled from registries
+	Signature *image.SignatureVerifier
+
+	// PreHook is called before the destination is prepared and PostHook
+	// once the pull is recorded (e.g. to stop and start the services using
+	// the destination). Extracted is called right after the extraction (e.g.
+	// to verify it). Errors returned by them fail the pull.
+	PreHook   func(source image.Source) error
+	Extracted func(source image.Source, result *image.ExtractResult) error
+	PostHook  func(source image.Source, result *image.ExtractResult) error
+}
+
+// DigestRequiredError is returned by pulls requiring a digest, if the
+// reference is not pinned to one. For images in registries, the reference
+// pinned to the current digest is suggested, if it can be resolved.
+type DigestRequiredError struct {
+	Ref    string
+	Pinned string
+}
+
+func (e *DigestRequiredError) Error() string {
+	if e.Pinned == "" {
+		return fmt.Sprintf("refusing to pull %s without digest", e.Ref)
+	}
+
+	return fmt.Sprintf("refusing to pull %s without digest, use %s", e.Ref, e.Pinned)
+}
+
+// DefaultCache returns the cache directory used by default, which is
+// /var/cache/roots for root or ~/.cache/seantis/roots for other users
+func DefaultCache() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("error looking up current user: %v", err)
+	}
+
+	if usr.Uid == "0" || usr.HomeDir == "" {
+		return "/var/cache/roots", nil
+	}
+
+	return filepath.Join(usr.HomeDir, ".cache", "seantis", "roots"), nil
+}
+
+// LocalSource splits references to local sources (e.g. docker-daemon:ubuntu)
+// into the transport and the name of the image
+func LocalSource(ref string) (string, string, bool) {
+	for _, transport := range localTransports {
+		if name, ok := strings.CutPrefix(ref, transport+":"); ok {
+			return transport, name, true
+		}
+	}
+
+	return "", "", false
+}
+
+// HasDigest returns true if the reference pins the image to a digest, either
+// as name@digest or, for the local sources that support it, as the digest
+// alone (e.g. oci:path:sha256:...). Images in docker archives are always
+// referenced by tag.
+func HasDigest(ref string) bool {
+	transport, name, local := LocalSource(ref)
+
+	switch {
+	case transport == "docker-archive":
+		return false
+	case transport == "oci":
+		_, tag, _ := strings.Cut(name, ":")
+		return digestPattern.MatchString(tag)
+	case local && digestPattern.MatchString(name):
+		return true
+	case local:
+		ref = name
+	}
+
+	u, err := image.Parse(ref)
+	if err != nil {
+		return false
+	}
+
+	return digestPattern.MatchString(u.Digest)
+}
+
+// Pull pulls the referenced image into the destination and records it in the
+// history of the destination
+func (p *Puller) Pull(ctx context.Context, ref string, dest string, opts *PullOptions) (*image.ExtractResult, error) {
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+
+	store, err := p.pullStore(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := p.Source(ctx, ref, &opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	if remote, ok := source.(*image.Remote); ok {
+		remote.WithManifestCache(image.NewManifestCache(filepath.Join(store.Path, "manifests")))
+	}
+
+	return p.pull(ctx, ref, source, store, dest, opts)
+}
+
+// PullSource pulls the given source of the referenced image into the
+// destination, like Pull. The source is opened by the caller (e.g. through
+// Source), the options selecting it are ignored.
+func (p *Puller) PullSource(ctx context.Context, ref string, source image.Source, dest string, opts *PullOptions) (*image.ExtractResult, error) {
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+
+	store, err := p.pullStore(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.pull(ctx, ref, source, store, dest, opts)
+}
+
+// pull verifies the source, extracts it into the destination unless it is
+// up to date and records the pull
+func (p *Puller) pull(ctx context.Context, ref string, source image.Source, store *image.Store, dest string, opts *PullOptions) (*image.ExtractResult, error) {
+	if opts.IfChanged && opts.NoHistory {
+		return nil, errors.New("pulls without history cannot be skipped if unchanged")
+	}
+
+	if opts.RequireDigest && !HasDigest(ref) {
+		err := &DigestRequiredError{Ref: ref}
+
+		if remote, ok := source.(*image.Remote); ok {
+			if u, perr := image.Parse(ref); perr == nil {
+				if digest, derr := remote.Digest(); derr == nil && digest != "" {
+					err.Pinned = u.WithDigest(digest).Familiar()
+				}
+			}
+		}
+
+		return nil, err
+	}
+
+	if opts.Signature != nil {
+		remote, ok := source.(*image.Remote)
+		if !ok {
+			return nil, fmt.Errorf("the signature of %s cannot be verified, it is not in a registry", ref)
+		}
+
+		// the remote is pinned to the verified digest, so the tag cannot
+		// be moved to another image before it is extracted
+		if err := opts.Signature.Verify(remote); err != nil {
+			return nil, err
+		}
+	}
+
+	inplace := opts.Extract != nil && (opts.Extract.Merge || opts.Extract.Update)
+
+	if opts.IfChanged || !(opts.Force || inplace) {
+		uptodate, digest, err := UpToDate(dest, source)
+		if err != nil {
+			return nil, err
+		}
+
+		if uptodate {
+			return &image.ExtractResult{Digest: digest, UpToDate: true}, nil
+		}
+	}
+
+	if opts.PreHook != nil {
+		if err := opts.PreHook(source); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+
+	history, err := PrepareDestination(dest, opts.Force, !opts.NoHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := store.Extract(ctx, source, dest, opts.Extract)
+	if err != nil {
+		return nil, fmt.Errorf("error during pull: %w", err)
+	}
+
+	if opts.Extracted != nil {
+		if err := opts.Extracted(source, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if !opts.NoHistory {
+		if err := RecordPull(dest, history, result, source, start); err != nil {
+			return nil, err
+		}
+	}
+
+	// artifacts have no image config
+	if result.ArtifactType == "" {
+		if err := RecordConfig(dest, opts.ConfigFile, source, result.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.PostHook != nil {
+		if err := opts.PostHook(source, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// pullStore returns the store of the options, or opens their cache
+func (p *Puller) pullStore(opts *PullOptions) (*image.Store, error) {
+	if opts.Store != nil {
+		return opts.Store, nil
+	}
+
+	cache := opts.Cache
+	if cache == "" {
+		var err error
+		if cache, err = DefaultCache(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		return nil, fmt.Errorf("could not create cache at %s: %v", cache, err)
+	}
+
+	return p.store(cache)
+}
+
+// Digest returns the digest of the manifest the reference points to, for the
+// selected platform
+func (p *Puller) Digest(ctx context.Context, ref string, opts *Options) (string, error) {
+	source, err := p.Source(ctx, ref, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return SourceDigest(source)
+}
+
+// Purge removes the layers from the given cache which are no longer used by
+// any destination, respecting the retention of the puller
+func (p *Puller) Purge(ctx context.Context, cacheDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store, err := p.store(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	return store.Purge()
+}
+
+// Source returns the source of the referenced image, which is a registry
+// unless the reference selects a local source
+func (p *Puller) Source(ctx context.Context, ref string, opts *Options) (image.Source, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	transport, name, ok := LocalSource(ref)
+	if !ok {
+		return p.Remote(ctx, ref, opts)
+	}
+
+	switch transport {
+	case "docker-daemon":
+		source, err := image.NewDaemonSource(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", name, err)
+		}
+
+		return source, nil
+	case "oci":
+		path, tag, _ := strings.Cut(name, ":")
+
+		source, err := image.NewLayoutSource(path, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+
+		if opts.Platform != nil {
+			source.WithPlatform(opts.Platform)
+		}
+
+		return source, nil
+	case "docker-archive":
+		path, tag, _ := strings.Cut(name, ":")
+
+		source, err := image.OpenArchive(path, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+
+		return source, nil
+	case "containers-storage":
+		root := p.StorageRoot
+		if root == "" {
+			root = image.DefaultStorageRoot()
+		}
+
+		source, err := image.NewStorageSource(root, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+
+		return source, nil
+	default:
+		root, namespace := p.ContainerdRoot, p.ContainerdNamespace
+		if root == "" {
+			root = image.DefaultContainerdRoot
+		}
+
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		source, err := image.NewContainerdSource(root, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+
+		if opts.Platform != nil {
+			source.WithPlatform(opts.Platform)
+		}
+
+		return source, nil
+	}
+}
+
+// Remote connects to the first candidate of the referenced image that can
+// be reached, with the platform selected by the options
+func (p *Puller) Remote(ctx context.Context, ref string, opts *Options) (*image.Remote, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	urls, err := p.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := []error{}
+
+	for _, url := range urls {
+
+		// credentials are routed by host
+		auth := opts.Auth
+		if auth == "" {
+			auth = p.Credentials.Lookup(url.Host)
+		}
+
+		remote, err := image.NewRemote(ctx, url, auth)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		remote.WithRetries(p.Retries)
+
+		if opts.Platform != nil {
+			remote.WithPlatform(opts.Platform)
+		}
+
+		if opts.StrictPlatform {
+			remote.WithStrictPlatform()
+		}
+
+		if opts.FirstPlatform {
+			remote.WithFirstPlatform()
+		}
+
+		if opts.AllowSchema1 {
+			remote.WithSchema1()
+		}
+
+		return remote, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Resolve returns the candidates of the given reference, as resolved by the
+// registries config (if any)
+func (p *Puller) Resolve(ref string) ([]image.URL, error) {
+	if p.Registries == nil {
+		url, err := image.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		return []image.URL{*url}, nil
+	}
+
+	return p.Registries.Resolve(ref)
+}
+
+// store opens the cache in the given directory
+func (p *Puller) store(cache string) (*image.Store, error) {
+	store, err := image.NewStore(cache)
+	if err != nil {
+		return nil, fmt.Errorf("could not create store at %s: %v", cache, err)
+	}
+
+	store.Dedup = p.Dedup
+	store.Retention = p.Retention
+	store.MaxConcurrentDownloads = p.MaxConcurrentDownloads
+
+	return store, nil
+}
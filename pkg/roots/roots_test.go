@@ -0,0 +1,249 @@
+package roots
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPuller tests pulling an image into a destination, including its
+// history, and purging the cache once the destination is gone
+func TestPuller(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := image.HostPlatform()
+	digest := registry.Push("team/app", "1.0", registrytest.Image{
+		OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+		Layers: [][]byte{registrytest.Tar(map[string]string{"hello": "world"})},
+	})
+
+	ref := registry.Host() + "/team/app:1.0"
+	ctx := context.Background()
+	cache := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "app")
+
+	puller := &Puller{}
+
+	d, err := puller.Digest(ctx, ref, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, digest, d)
+
+	result, err := puller.Pull(ctx, ref, dest, &PullOptions{Cache: cache})
+	assert.NoError(t, err)
+	assert.Equal(t, digest, result.Digest)
+
+	hello, _ := os.ReadFile(filepath.Join(dest, "hello"))
+	assert.Equal(t, "world", string(hello))
+
+	history, err := image.ReadHistory(dest)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, digest, history[0].Digest)
+
+	// the config is kept next to the history
+	config, err := image.ReadConfig(image.ConfigPath(dest))
+	assert.NoError(t, err)
+	assert.Equal(t, digest, config.Digest)
+	assert.Equal(t, host.OS, config.OS)
+	assert.Len(t, config.RootFS.DiffIDs, 1)
+
+	layers, _ := os.ReadDir(filepath.Join(cache, "layers"))
+	assert.NotEmpty(t, layers)
+
+	// the layers are kept until the destination is removed
+	assert.NoError(t, puller.Purge(ctx, cache))
+	remaining, _ := os.ReadDir(filepath.Join(cache, "layers"))
+	assert.Equal(t, len(layers), len(remaining))
+
+	assert.NoError(t, os.RemoveAll(dest))
+	assert.NoError(t, puller.Purge(ctx, cache))
+
+	remaining, _ = os.ReadDir(filepath.Join(cache, "layers"))
+	for _, entry := range remaining {
+		assert.False(t, strings.HasSuffix(entry.Name(), ".layer"), entry.Name())
+	}
+}
+
+// TestPullWithToken tests that the challenges of registries with token auth
+// are answered with the given credentials
+func TestPullWithToken(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := image.HostPlatform()
+	digest := registry.Push("team/private", "1.0", registrytest.Image{
+		OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+		Layers: [][]byte{registrytest.Tar(map[string]string{"secret": "data"})},
+	})
+
+	registry.RequireToken("user", "secret")
+
+	ref := registry.Host() + "/team/private:1.0"
+	ctx := context.Background()
+	puller := &Puller{}
+
+	_, err := puller.Digest(ctx, ref, &Options{Auth: "user:wrong"})
+	assert.Error(t, err)
+
+	dest := filepath.Join(t.TempDir(), "private")
+	result, err := puller.Pull(ctx, ref, dest, &PullOptions{
+		Options: Options{Auth: "user:secret"},
+		Cache:   t.TempDir(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, digest, result.Digest)
+
+	secret, _ := os.ReadFile(filepath.Join(dest, "secret"))
+	assert.Equal(t, "data", string(secret))
+
+	// the token is fetched once for each credentials, then sent with all requests
+	tokens := 0
+	for _, request := range registry.Requests() {
+		if request == "GET /token" {
+			tokens++
+		}
+	}
+
+	assert.Equal(t, 2, tokens)
+}
+
+// TestPullIfChanged tests that pulls of images the destination already holds
+// are skipped, while new digests are pulled
+func TestPullIfChanged(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := image.HostPlatform()
+	push := func(content string) string {
+		return registry.Push("team/app", "latest", registrytest.Image{
+			OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+			Layers: [][]byte{registrytest.Tar(map[string]string{"version": content})},
+		})
+	}
+
+	first := push("1")
+
+	ref := registry.Host() + "/team/app:latest"
+	ctx := context.Background()
+	dest := filepath.Join(t.TempDir(), "app")
+	opts := &PullOptions{Cache: t.TempDir()}
+	puller := &Puller{}
+
+	result, err := puller.Pull(ctx, ref, dest, opts)
+	assert.NoError(t, err)
+	assert.False(t, result.UpToDate)
+
+	digest, err := image.ReadDigest(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, first, digest)
+
+	// pulling the same digest again is a no-op
+	result, err = puller.Pull(ctx, ref, dest, opts)
+	assert.NoError(t, err)
+	assert.True(t, result.UpToDate)
+	assert.Equal(t, first, result.Digest)
+
+	opts.Force, opts.IfChanged = true, true
+
+	result, err = puller.Pull(ctx, ref, dest, opts)
+	assert.NoError(t, err)
+	assert.True(t, result.UpToDate)
+
+	// a new digest is pulled
+	second := push("2")
+
+	result, err = puller.Pull(ctx, ref, dest, opts)
+	assert.NoError(t, err)
+	assert.False(t, result.UpToDate)
+	assert.Equal(t, second, result.Digest)
+
+	version, _ := os.ReadFile(filepath.Join(dest, "version"))
+	assert.Equal(t, "2", string(version))
+
+	opts.NoHistory = true
+	_, err = puller.Pull(ctx, ref, dest, opts)
+	assert.Error(t, err)
+}
+
+// TestPullFromFiles tests that images saved as OCI layout or docker archive
+// are pulled like images from the registry
+func TestPullFromFiles(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	host := image.HostPlatform()
+	registry.PushIndex("team/app", "1.0", registrytest.Image{
+		OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+		Layers: [][]byte{registrytest.Tar(map[string]string{"hello": "world"})},
+	})
+
+	ctx := context.Background()
+	puller := &Puller{}
+
+	remote, err := puller.Remote(ctx, registry.Host()+"/team/app:1.0", nil)
+	assert.NoError(t, err)
+
+	layout := filepath.Join(t.TempDir(), "layout")
+	digest, err := image.SaveOCILayout(ctx, remote, layout, "1.0")
+	assert.NoError(t, err)
+
+	archive := filepath.Join(t.TempDir(), "app.tar")
+	_, err = image.SaveArchive(ctx, remote, archive, registry.Host()+"/team/app:1.0")
+	assert.NoError(t, err)
+
+	for _, ref := range []string{"oci:" + layout + ":1.0", "oci:" + layout, "docker-archive:" + archive} {
+		dest := filepath.Join(t.TempDir(), "app")
+
+		result, err := puller.Pull(ctx, ref, dest, &PullOptions{Cache: t.TempDir()})
+		assert.NoError(t, err, ref)
+
+		hello, _ := os.ReadFile(filepath.Join(dest, "hello"))
+		assert.Equal(t, "world", string(hello), ref)
+
+		if strings.HasPrefix(ref, "oci:") {
+			assert.Equal(t, digest, result.Digest)
+		}
+	}
+
+	_, err = puller.Source(ctx, "oci:"+layout+":2.0", nil)
+	assert.Error(t, err)
+}
+
+// TestHasDigest tests recognizing references pinned to a digest
+func TestHasDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+
+	tests := []struct {
+		ref    string
+		pinned bool
+	}{
+		{"ubuntu", false},
+		{"ubuntu:22.04", false},
+		{"ubuntu@" + digest, true},
+		{"ghcr.io/team/app:1.0@" + digest, true},
+		{"ubuntu@sha256:abc", false},
+		{"ubuntu@md5:" + strings.Repeat("ab", 16), false},
+		{"oci:/images/app:" + digest, true},
+		{"oci:/images/sha256:app:latest", false},
+		{"oci:/images/app", false},
+		{"docker-archive:/images/app.tar:app:" + digest, false},
+		{"containerd:" + digest, true},
+		{"containerd:docker.io/library/app:latest", false},
+		{"containerd:docker.io/library/app@" + digest, true},
+		{"containers-storage:localhost/app:sha256", false},
+		{"containers-storage:localhost/app@" + digest, true},
+		{"docker-daemon:app:latest", false},
+		{"docker-daemon:app@" + digest, true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.pinned, HasDigest(test.ref), test.ref)
+	}
+}
@@ -2,18 +2,28 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"os/user"
 	"path"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	cli "github.com/jawher/mow.cli"
 	"github.com/seantis/roots/pkg/image"
-	_ "github.com/seantis/roots/pkg/provider" // to register providers
+	"github.com/seantis/roots/pkg/provider"
+	"github.com/seantis/roots/pkg/roots"
 )
 
 var (
@@ -26,8 +36,43 @@ func main() {
 	app := cli.App("roots", "Download and extract containers")
 	ctx := newInterruptableContext()
 
-	// disable datetime output
-	log.SetFlags(0)
+	// log plain messages until the flags are parsed
+	setupLogging(false, false, "")
+
+	app.Spec = "[--verbose | --quiet] [--log-format] [--output] [--proxy] [--ca-cert] [--insecure-skip-tls-verify] [--insecure-http...]"
+
+	var (
+		verbose   = app.BoolOpt("v verbose", false, "Log the progress of downloads and extractions (also ROOTS_VERBOSE=yes)")
+		quiet     = app.BoolOpt("q quiet", false, "Only log warnings and errors (also ROOTS_QUIET=yes)")
+		logFormat = app.StringOpt("log-format", "", "Log as text or json (also ROOTS_LOG_FORMAT, default text)")
+		output    = app.StringOpt("o output", "", "Print results as text or json (also ROOTS_OUTPUT, default text)")
+		proxy     = app.StringOpt("proxy", "", "Connect to registries through this proxy (also ROOTS_PROXY, default HTTPS_PROXY)")
+		caCert    = app.StringOpt("ca-cert", "", "Trust the certificate authorities in this PEM file (also ROOTS_CA_CERT)")
+		insecure  = app.BoolOpt("insecure-skip-tls-verify", false, "Accept any TLS certificate, for tests only (also ROOTS_INSECURE_SKIP_TLS_VERIFY=yes)")
+		plainHTTP = app.StringsOpt("insecure-http", nil, "Reach the registry at this host:port through plain HTTP (also ROOTS_INSECURE_HTTP)")
+	)
+
+	app.Before = func() {
+		setupLogging(
+			*verbose || os.Getenv("ROOTS_VERBOSE") == "yes",
+			*quiet || os.Getenv("ROOTS_QUIET") == "yes",
+			valueOrEnv(*logFormat, "ROOTS_LOG_FORMAT", "text"))
+
+		setupOutput(valueOrEnv(*output, "ROOTS_OUTPUT", "text"))
+
+		setupTransport(*proxy, *caCert, *insecure, *plainHTTP)
+	}
+
+	var err error
+	if config, err = loadConfig(defaultConfigPath()); err != nil {
+		fatal(err)
+	}
+
+	if registries, err = loadRegistries(defaultRegistriesConfigPath()); err != nil {
+		fatal(err)
+	}
+
+	setRetries("")
 
 	app.Command("version", "Show version", func(cmd *cli.Cmd) {
 		cmd.Action = func() {
@@ -36,302 +81,3140 @@ func main() {
 	})
 
 	app.Command("digest", "Show the latest digest", func(cmd *cli.Cmd) {
-		cmd.Spec = "CONTAINER [--auth] [--arch] [--os]"
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--arch] [--os] [--variant] [--strict-platform] [--first-platform] [--layers] [--json]"
 
 		var (
-			url  = newURLArg(cmd)
-			auth = newAuthOpt(cmd)
-			arch = newArchOpt(cmd)
-			ops  = newOSOpt(cmd)
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			strict   = newStrictPlatformOpt(cmd)
+			first    = newFirstPlatformOpt(cmd)
+			layers   = newLayersOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
 		)
 
 		cmd.Action = func() {
-			digest, err := newRemote(ctx, url, auth, arch, ops).Digest()
+			useJSON(jsonout)
+
+			loadCredentials(authFile)
+
+			remote := newRemote(ctx, url, auth, arch, ops, variant, strict, first)
+
+			if *layers {
+				sizes, err := remote.LayerSizes()
+				if err != nil {
+					fatal(err)
+				}
+
+				printLayerSizes(sizes, *jsonout)
+				return
+			}
+
+			digest, err := remote.Digest()
 
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
+			}
+
+			if *jsonout {
+				printJSON(struct {
+					Image  string `json:"image"`
+					Digest string `json:"digest"`
+				}{remote.String(), digest})
+				return
 			}
 
 			fmt.Println(digest)
 		}
 	})
 
-	app.Command("purge", "Purge unused files from the cache", func(cmd *cli.Cmd) {
-		cmd.Spec = "[--cache]"
+	app.Command("platforms", "List the platforms of an image", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--json]"
 
 		var (
-			cache = newCacheOpt(cmd)
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
 		)
 
 		cmd.Action = func() {
-			// setup the cache
-			if *cache == "" {
-				*cache = os.Getenv("ROOTS_CACHE")
-			}
+			useJSON(jsonout)
 
-			if *cache == "" {
-				*cache = defaultCache()
+			if _, _, local := roots.LocalSource(*url); local {
+				fatalf("cannot list platforms of local image %s", *url)
 			}
 
-			entries, err := os.ReadDir(*cache)
+			loadCredentials(authFile)
+
+			remote := newRemote(ctx, url, auth, new(string), new(string), new(string), new(bool), new(bool))
+
+			manifests, err := remote.PlatformManifests()
 			if err != nil {
-				log.Fatalf("error accessing %s: %v", *cache, err)
+				fail(*url, fmt.Errorf("could not list platforms of %s: %w", *url, err))
 			}
 
-			if len(entries) == 0 {
-				log.Fatalf("not a cache directory: %s", *cache)
+			if *jsonout {
+				printJSON(manifests)
+				return
 			}
 
-			valid := false
-			for _, info := range entries {
-				if info.Name() == "layers" {
-					valid = true
-					break
-				}
-			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "PLATFORM\tDIGEST")
 
-			if !valid {
-				log.Fatalf("not a cache directory: %s", *cache)
+			for _, m := range manifests {
+				fmt.Fprintf(w, "%s\t%s\n", &m.Platform, m.Digest)
 			}
 
-			store, err := image.NewStore(*cache)
+			w.Flush()
+		}
+	})
+
+	app.Command("lock", "Pin the images in a file to their digests", func(cmd *cli.Cmd) {
+		cmd.Spec = "FILE [--auth] [--auth-file] [--lockfile]"
+
+		var (
+			file     = cmd.StringArg("FILE", "", "File with one image per line")
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			lockfile = newLockFileOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			loadCredentials(authFile)
+
+			names, err := readImageList(*file)
 			if err != nil {
-				log.Fatalf("could not create store at %s: %v", *cache, err)
+				fatalf("could not read %s: %v", *file, err)
 			}
 
-			if err := store.Purge(); err != nil {
-				log.Fatalf("error during purge of %s: %v", *cache, err)
+			lock := &image.LockFile{Images: make([]*image.LockedImage, len(names))}
+
+			for i, name := range names {
+				if _, _, local := roots.LocalSource(name); local {
+					fatalf("cannot lock local image %s", name)
+				}
+
+				auth := *auth
+				remote := newRemote(ctx, &name, &auth, new(string), new(string), new(string), new(bool), new(bool))
+
+				if lock.Images[i], err = image.LockImage(name, remote); err != nil {
+					fatalf("could not lock %s: %v", name, err)
+				}
+
+				log.Printf("locked %s to %s", name, lock.Images[i].Digest)
+			}
+
+			path := valueOrEnv(*lockfile, "ROOTS_LOCKFILE", "roots.lock")
+			if err := lock.Save(path); err != nil {
+				fatalf("could not write %s: %v", path, err)
 			}
 		}
 	})
 
-	app.Command("pull", "Download and extract", func(cmd *cli.Cmd) {
-		cmd.Spec = "CONTAINER DEST [--auth] [--arch] [--os] [--cache] [--force]"
+	app.Command("ping", "Check the connection and authentication to a registry", func(cmd *cli.Cmd) {
+		cmd.Spec = "TARGET [--auth] [--auth-file] [--json]"
 
 		var (
-			url   = newURLArg(cmd)
-			dest  = newDestArg(cmd)
-			auth  = newAuthOpt(cmd)
-			arch  = newArchOpt(cmd)
-			ops   = newOSOpt(cmd)
-			cache = newCacheOpt(cmd)
-			force = newForceOpt(cmd)
+			target   = cmd.StringArg("TARGET", "", "The registry host (e.g. ghcr.io), or an image to also check the authentication")
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
 		)
 
 		cmd.Action = func() {
+			useJSON(jsonout)
 
-			// setup the cache
-			if *cache == "" {
-				*cache = os.Getenv("ROOTS_CACHE")
-			}
+			loadCredentials(authFile)
 
-			if strings.ToLower(*cache) == "no" {
-				temp, err := os.MkdirTemp("", "store")
-				if err != nil {
-					log.Fatal(err)
-				}
-				defer os.RemoveAll(temp)
+			url := pingURL(*target)
+			credentials, mode := pingAuth(url, *auth)
 
-				*cache = temp
+			result, err := image.Ping(ctx, *url, credentials)
+
+			if *jsonout && err == nil {
+				printJSON(struct {
+					Auth string `json:"auth"`
+					*image.PingResult
+				}{mode, result})
+				return
 			}
 
-			if *cache == "" {
-				*cache = defaultCache()
+			if !*jsonout {
+				printPing(result, mode)
 			}
 
-			if err := os.MkdirAll(*cache, 0755); err != nil {
-				log.Fatalf("could not create cache at %s: %v", *cache, err)
+			// hosts are not images, the hint uses the host of the request
+			if err != nil && url.Name == "" {
+				fail("", err)
 			}
 
-			store, err := image.NewStore(*cache)
 			if err != nil {
-				log.Fatalf("could not create store at %s: %v", *cache, err)
+				fail(*target, err)
 			}
+		}
+	})
 
-			// create the destination
-			if *force {
+	app.Command("tags", "List the tags of a repository", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--match] [--semver] [--created] [--latest] [--json]"
 
-				// let's not be responsible for wiping out an actual root fs
-				if strings.Count(*dest, "/") <= 2 {
-					log.Fatalf("not enough path separators to force-remove: %s", *dest)
+		var (
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			match    = newMatchOpt(cmd)
+			semver   = newSemverOpt(cmd)
+			created  = newCreatedOpt(cmd)
+			latest   = newLatestOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			useJSON(jsonout)
+
+			loadCredentials(authFile)
+
+			filter := &image.TagFilter{Created: *created, Latest: *latest}
+
+			if *match != "" {
+				expr, err := regexp.Compile(*match)
+				if err != nil {
+					fatalf("invalid expression %s: %v", *match, err)
 				}
 
-				if err := os.RemoveAll(*dest); err != nil {
-					log.Fatalf("could note force-remove %s: %v", *dest, err)
+				filter.Match = expr
+			}
+
+			if *semver != "" {
+				constraint, err := image.ParseConstraint(*semver)
+				if err != nil {
+					fatal(err)
 				}
 
+				filter.Semver = constraint
 			}
 
-			if err := os.MkdirAll(*dest, 0755); err != nil {
-				log.Fatalf("could not create destination at %s: %v", *dest, err)
+			tags, err := newRepository(ctx, *url, *auth).FilterTags(filter)
+			if err != nil {
+				fail(*url, fmt.Errorf("could not list tags of %s: %w", *url, err))
 			}
 
-			// pull & extract the image
-			remote := newRemote(ctx, url, auth, arch, ops)
+			if *jsonout {
+				printJSON(tags)
+				return
+			}
 
-			if err := store.Extract(ctx, remote, *dest); err != nil {
-				log.Fatalf("error during pull: %v", err)
+			for _, t := range tags {
+				fmt.Println(t)
 			}
 		}
 	})
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatalf("error running command: %v", err)
-	}
-}
+	app.Command("referrers", "List the artifacts attached to an image", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--arch] [--os] [--variant] [--artifact-type] [--json]"
 
-func defaultCache() string {
-	usr, err := user.Current()
+		var (
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			artifact = newArtifactTypeOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
+		)
 
-	if err != nil {
-		log.Fatalf("error looking up current user: %v", err)
-	}
+		cmd.Action = func() {
+			useJSON(jsonout)
 
-	if usr.Uid == "0" || usr.HomeDir == "" {
-		return "/var/cache/roots"
-	}
+			if _, _, local := roots.LocalSource(*url); local {
+				fatalf("cannot list referrers of local image %s", *url)
+			}
 
-	return path.Join(usr.HomeDir, ".cache", "seantis", "roots")
-}
+			loadCredentials(authFile)
 
-func newInterruptableContext() context.Context {
-	ctx, cancel := context.WithCancel(context.Background())
+			remote := newRemote(ctx, url, auth, arch, ops, variant, new(bool), new(bool))
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		<-c
-		signal.Stop(c)
-		cancel()
-	}()
+			referrers, err := remote.Referrers(*artifact)
+			if err != nil {
+				fail(*url, fmt.Errorf("could not list referrers of %s: %w", *url, err))
+			}
 
-	return ctx
-}
+			if *jsonout {
+				printJSON(referrers)
+				return
+			}
 
-func newRemote(ctx context.Context, urlstring, auth, arch, ops *string) *image.Remote {
+			for _, r := range referrers {
+				fmt.Println(r)
+			}
+		}
+	})
 
-	if *auth == "" {
-		*auth = os.Getenv("ROOTS_AUTH")
-	}
+	app.Command("inspect", "Show the manifest and config of an image", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--arch] [--os] [--variant] [--strict-platform] [--first-platform]"
 
-	if *arch == "" {
-		*arch = os.Getenv("ROOTS_ARCH")
-	}
+		var (
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			strict   = newStrictPlatformOpt(cmd)
+			first    = newFirstPlatformOpt(cmd)
+		)
 
-	if *ops == "" {
-		*ops = os.Getenv("ROOTS_OS")
-	}
+		cmd.Action = func() {
+			jsonErrors = true
 
-	url, err := image.Parse(*urlstring)
-	if err != nil {
-		log.Fatalf("failed to parse image url %s: %v", *urlstring, err)
-	}
+			loadCredentials(authFile)
 
-	remote, err := image.NewRemote(ctx, *url, *auth)
-	if err != nil {
-		log.Fatalf("failed to connect to %s: %v", *urlstring, err)
-	}
+			inspection, err := newRemote(ctx, url, auth, arch, ops, variant, strict, first).Inspect()
+			if err != nil {
+				fail(*url, fmt.Errorf("could not inspect %s: %w", *url, err))
+			}
 
-	if len(*arch) > 0 || len(*ops) > 0 {
-		if len(*arch) == 0 {
-			*arch = runtime.GOARCH
+			printJSON(inspection)
 		}
+	})
+
+	app.Command("config", "Show the config of an image", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth] [--auth-file] [--arch] [--os] [--variant] [--strict-platform] [--first-platform] [--json]"
+
+		var (
+			url      = newURLArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			strict   = newStrictPlatformOpt(cmd)
+			first    = newFirstPlatformOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			useJSON(jsonout)
+
+			loadCredentials(authFile)
+
+			config, err := newRemote(ctx, url, auth, arch, ops, variant, strict, first).Config()
+			if err != nil {
+				fail(*url, fmt.Errorf("could not get config of %s: %w", *url, err))
+			}
 
-		if len(*ops) == 0 {
-			*ops = "linux"
+			if *jsonout {
+				printJSON(config)
+				return
+			}
+
+			printImageConfig(config)
 		}
+	})
 
-		remote.WithPlatform(&image.Platform{
-			Architecture: *arch,
-			OS:           *ops,
-		})
-	}
+	app.Command("diff", "Compare two images", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--config | --files] IMAGE1 IMAGE2 [--auth] [--auth-file] [--arch] [--os] [--variant] [--cache] [--json]"
 
-	return remote
-}
+		var (
+			configs  = newConfigOpt(cmd)
+			files    = newFilesOpt(cmd)
+			first    = cmd.StringArg("IMAGE1", "", "The image compared against")
+			second   = cmd.StringArg("IMAGE2", "", "The image compared to the first one")
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			cache    = newCacheOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
+		)
 
-func newURLArg(cmd *cli.Cmd) *string {
-	return cmd.StringArg("CONTAINER", "",
-		`The url of the container, example values:
+		cmd.Action = func() {
+			useJSON(jsonout)
 
-               - ubuntu:latest
-               - gcr.io/google-containers/etcd:3.3.10
-	`)
-}
+			loadCredentials(authFile)
 
-func newDestArg(cmd *cli.Cmd) *string {
-	return cmd.StringArg("DEST", "", "The destination folder")
-}
+			strict := false
+			remotes := make([]*image.Remote, 2)
 
-func newAuthOpt(cmd *cli.Cmd) *string {
-	return cmd.StringOpt("auth", "",
-		`Authentication for the following providers:
+			for i, name := range []*string{first, second} {
+				remotes[i] = newRemote(ctx, name, auth, arch, ops, variant, &strict, new(bool))
+			}
 
-               * Google Container Registry:
-                 Path to service worker json file, with the following scope:
-                 <https://www.googleapis.com/auth/devstorage.read_only>
+			if *configs {
+				diffConfigs(remotes, *jsonout)
+				return
+			}
 
-               This value can also be set through the env var ROOTS_AUTH,
-               though the flag takes precedence.
-	`)
-}
+			manifests := make([]*image.Manifest, 2)
 
-func newArchOpt(cmd *cli.Cmd) *string {
-	return cmd.StringOpt("arch", "",
-		`Force the given architecture, example values:
+			for i, r := range remotes {
+				manifest, err := r.Manifest()
+				if err != nil {
+					fail(r.String(), fmt.Errorf("could not get manifest of %s: %w", r, err))
+				}
 
-               * amd64
-               * arm
+				manifests[i] = manifest
+			}
 
-               See https://github.com/golang/go/blob/master/src/go/build/syslist.go
+			diff := image.DiffImages(manifests[0], manifests[1])
 
-               Requires multi-arch support by the container.
+			// the file lists are read from the layers in the cache
+			if *files {
+				*cache = valueOrEnv(*cache, "ROOTS_CACHE", config.Cache)
 
-               This value can also be set through the env var ROOTS_ARCH,
-               though the flag takes precedence.
-	`)
-}
+				if *cache == "" {
+					*cache = defaultCache()
+				}
 
-func newOSOpt(cmd *cli.Cmd) *string {
-	return cmd.StringOpt("os", "",
-		`Force the given OS, example values:
+				if strings.ToLower(*cache) == "no" {
+					fatalf("comparing files requires a cache")
+				}
 
-               * linux
-               * windows
+				if err := os.MkdirAll(*cache, 0755); err != nil {
+					fatalf("could not create cache at %s: %v", *cache, err)
+				}
 
-               See https://github.com/golang/go/blob/master/src/go/build/syslist.go
+				store, err := image.NewStore(*cache)
+				if err != nil {
+					fatalf("could not create store at %s: %v", *cache, err)
+				}
 
-               Requires multi-arch support by the container.
+				store.Retention = config.retention()
 
-               This value can also be set through the env var ROOTS_OS,
-               though the flag takes precedence.
-	`)
-}
+				changes, err := store.DiffFiles(ctx, remotes[0], remotes[1])
+				if err != nil {
+					fail(*second, fmt.Errorf("could not compare files: %w", err))
+				}
 
-func newCacheOpt(cmd *cli.Cmd) *string {
-	return cmd.StringOpt("cache", "",
-		`Sets the cache folder that should be used. Defaults:
+				diff.Files = changes
+			}
 
-               * For non-root users:
-                 ~/.cache/seantis/roots
+			if *jsonout {
+				printJSON(diff)
+			} else {
+				printImageDiff(diff, len(manifests[1].Layers))
+			}
 
-               * For root users:
-                 /var/cache/roots
+			if len(diff.Layers) > 0 || len(diff.Files) > 0 {
+				exit(1)
+			}
+		}
+	})
 
-               If the special value 'no' is given, a temporary folder will
-               be used during the lifetime of the process.
+	app.Command("purge", "Purge unused files from the cache", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--cache]"
 
-               This value can also be set through the env var ROOTS_CACHE,
-               though the flag takes precedence.
-	`)
-}
+		var (
+			cache = newCacheOpt(cmd)
+		)
 
-func newForceOpt(cmd *cli.Cmd) *bool {
-	return cmd.BoolOpt("force", false, `Remove the destination before pulling
+		cmd.Action = func() {
+			store := openExistingStore(cache)
 
-               Note that this only works if there are at least two path
-               separatores in the destination. So you can force remove
-               /var/roots/ubuntu, but not / or /var/lib.
+			if err := store.Purge(); err != nil {
+				fatalf("error during purge of %s: %v", *cache, err)
+			}
+		}
+	})
+
+	app.Command("pull", "Download and extract", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [DEST | --validate-only | --resolve | --dry-run] [--auth] [--auth-file] [--arch] [--os] [--variant] [--cache] [--force | --merge | --update] [--layout] [--preserve-owner] [--preserve-xattrs] [--id-map-file] [--ignore-chown-errors] [--selinux-label] [--dedup] [--json] [--no-history] [--write-config] [--policy] [--strict-platform] [--first-platform] [--require-digest] [--locked] [--lockfile] [--verify-reproducible] [--tree-hash] [--notify-url] [--pre-hook] [--post-hook] [--expansion-factor] [--extract-workers] [--share] [--tmpdir] [--verify-tag] [--if-changed] [--timeout] [--retries] [--max-concurrent-downloads] [--verify-signature] [--signature-key] [--certificate-identity] [--certificate-oidc-issuer] [--sigstore-root] [--allow-schema1]"
+
+		var (
+			url      = newURLArg(cmd)
+			dest     = newDestArg(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			cache    = newCacheOpt(cmd)
+			force    = newForceOpt(cmd)
+			merge    = newMergeOpt(cmd)
+			update   = newUpdateOpt(cmd)
+			layout   = newLayoutOpt(cmd)
+			preserve = newPreserveOwnerOpt(cmd)
+			xattrs   = newPreserveXattrsOpt(cmd)
+			idmap    = newIDMapFileOpt(cmd)
+			nochown  = newIgnoreChownErrorsOpt(cmd)
+			selinux  = newSELinuxLabelOpt(cmd)
+			validate = newValidateOnlyOpt(cmd)
+			dryrun   = newDryRunOpt(cmd)
+			dedup    = newDedupOpt(cmd)
+			jsonout  = newJSONOpt(cmd)
+			nohist   = newNoHistoryOpt(cmd)
+			confout  = newWriteConfigOpt(cmd)
+			policy   = newPolicyOpt(cmd)
+			resolve  = newResolveOpt(cmd)
+			strict   = newStrictPlatformOpt(cmd)
+			first    = newFirstPlatformOpt(cmd)
+			pinned   = newRequireDigestOpt(cmd)
+			locked   = newLockedOpt(cmd)
+			lockfile = newLockFileOpt(cmd)
+			repro    = newVerifyReproducibleOpt(cmd)
+			hashed   = newTreeHashOpt(cmd)
+			notify   = newNotifyURLOpt(cmd)
+			prehook  = newPreHookOpt(cmd)
+			posthook = newPostHookOpt(cmd)
+			factor   = newExpansionFactorOpt(cmd)
+			workers  = newExtractWorkersOpt(cmd)
+			share    = newShareOpt(cmd)
+			tmpdir   = newTmpDirOpt(cmd)
+			tagged   = newVerifyTagOpt(cmd)
+			changed  = newIfChangedOpt(cmd)
+			timeout  = newTimeoutOpt(cmd)
+			attempts = newRetriesOpt(cmd)
+			parallel = newMaxConcurrentDownloadsOpt(cmd)
+			signed   = newVerifySignatureOpt(cmd)
+			key      = newSignatureKeyOpt(cmd)
+			identity = newCertificateIdentityOpt(cmd)
+			issuer   = newCertificateIssuerOpt(cmd)
+			sigstore = newSigstoreRootOpt(cmd)
+			legacy   = newAllowSchema1Opt(cmd)
+		)
+
+		cmd.Action = func() {
+			useJSON(jsonout)
+			setRetries(*attempts)
+			allowSchema1 = *legacy || os.Getenv("ROOTS_ALLOW_SCHEMA1") == "yes"
+
+			if *changed && *nohist {
+				fatal("--if-changed relies on the history, it cannot be combined with --no-history")
+			}
+
+			ctx, cancel := withTimeout(ctx, valueOrEnv(*timeout, "ROOTS_TIMEOUT", ""))
+			defer cancel()
+
+			loadCredentials(authFile)
+
+			// only pull the digest pinned by the lockfile
+			if *locked {
+				*url = lockedURL(*url, valueOrEnv(*lockfile, "ROOTS_LOCKFILE", "roots.lock"))
+			}
+
+			// only show what the name expands to
+			if _, _, local := roots.LocalSource(*url); *resolve && local {
+				fmt.Println(*url)
+				return
+			}
+
+			if *resolve {
+				urls, err := resolveURLs(*url)
+				if err != nil {
+					fail(*url, fmt.Errorf("could not resolve %s: %w", *url, err))
+				}
+
+				for _, u := range urls {
+					fmt.Println(u.String())
+				}
+
+				return
+			}
+
+			// setup the cache
+			if *cache == "" {
+				*cache = os.Getenv("ROOTS_CACHE")
+			}
+
+			if *cache == "" {
+				*cache = config.Cache
+			}
+
+			setTempDir(*tmpdir)
+
+			// without cache, the layers are extracted while downloading, the
+			// temporary store only holds the locks
+			streaming := strings.ToLower(*cache) == "no"
+
+			if streaming {
+				temp, err := os.MkdirTemp(image.TempDir, "store")
+				if err != nil {
+					fatal(err)
+				}
+
+				// fatal and fail exit without running deferred functions
+				cleanup := func() { os.RemoveAll(temp) }
+				defer cleanup()
+				atExit(cleanup)
+
+				*cache = temp
+			}
+
+			if *cache == "" {
+				*cache = defaultCache()
+			}
+
+			if err := os.MkdirAll(*cache, 0755); err != nil {
+				fatalf("could not create cache at %s: %v", *cache, err)
+			}
+
+			store, err := image.NewStore(*cache)
+			if err != nil {
+				fatalf("could not create store at %s: %v", *cache, err)
+			}
+
+			store.Dedup = *dedup || os.Getenv("ROOTS_DEDUP") == "yes"
+			store.Retention = config.retention()
+			store.MaxConcurrentDownloads = maxConcurrentDownloads(*parallel)
+
+			// refuse images which are not allowed by the trust policy
+			checkPolicy(*url, valueOrEnv(*policy, "ROOTS_POLICY", config.Policy))
+
+			// refuse images which are not pinned to a digest
+			digestRequired := *pinned || os.Getenv("ROOTS_REQUIRE_DIGEST") == "yes" || config.RequireDigest
+			if digestRequired {
+				requireDigest(ctx, url, auth, arch, ops, variant, strict, first)
+			}
+
+			// only check that the image can be pulled
+			if *validate {
+				remote := newSource(ctx, url, auth, arch, ops, variant, strict, first)
+
+				if err := store.Validate(ctx, remote); err != nil {
+					fail(*url, fmt.Errorf("error during validation: %w", err))
+				}
+
+				log.Printf("%s is valid", remote)
+				return
+			}
+
+			// only show what would be extracted
+			if *dryrun {
+				remote := newSource(ctx, url, auth, arch, ops, variant, strict, first)
+
+				result, err := store.DryRun(ctx, remote)
+				if err != nil {
+					fail(*url, fmt.Errorf("error during dry run: %w", err))
+				}
+
+				if *jsonout {
+					printJSON(result)
+				} else {
+					printDryRun(result)
+				}
+
+				return
+			}
+
+			// without destination, one is derived from the image
+			if *dest == "" {
+				*dest = defaultDestination(*url)
+				log.Printf("pulling to %s", *dest)
+			}
+
+			start := time.Now()
+			remote := newSource(ctx, url, auth, arch, ops, variant, strict, first)
+
+			// keep the manifests next to the layers of the given cache
+			if r, ok := remote.(*image.Remote); ok {
+				r.WithManifestCache(manifestCache(*cache))
+			}
+
+			// report tags which no longer point to the pinned digest
+			if r, ok := remote.(*image.Remote); ok && image.IsTagged(*url) {
+				verifyTag(*url, r, *tagged || os.Getenv("ROOTS_VERIFY_TAG") == "yes")
+			}
+
+			// refuse images without valid signature
+			var verifier *image.SignatureVerifier
+			if *signed || os.Getenv("ROOTS_VERIFY_SIGNATURE") == "yes" || config.VerifySignature {
+				verifier = signatureVerifier(*key, *identity, *issuer, *sigstore)
+			}
+
+			opts := newExtractOptions(preserve, idmap)
+			opts.IgnoreChownErrors = *nochown
+			opts.PreserveXattrs = *xattrs
+			opts.Merge = *merge
+			opts.Update = *update
+			opts.Overlay = overlayLayout(*layout)
+
+			if *selinux != "" {
+				opts.SELinuxLabel = *selinux
+				opts.SELinuxLayerLabels = true
+			}
+
+			opts.ExpansionFactor = expansionFactor(*factor)
+			opts.Workers = extractWorkers(*workers)
+			opts.LockWaiting = logLockWait
+			opts.Stream = streaming
+			opts.Share = shareMode(*share)
+
+			*notify = valueOrEnv(*notify, "ROOTS_NOTIFY_URL", config.NotifyURL)
+
+			// pull & extract the image, unless the destination is up to date
+			// (pulls which would fail anyway without --force, --merge or
+			// --update are skipped)
+			result, err := newPuller().PullSource(ctx, *url, remote, *dest, &roots.PullOptions{
+				Store:         store,
+				Force:         *force,
+				NoHistory:     *nohist,
+				IfChanged:     *changed,
+				ConfigFile:    *confout,
+				Extract:       opts,
+				RequireDigest: digestRequired,
+				Signature:     verifier,
+
+				// e.g. stop the services using the destination
+				PreHook: func(source image.Source) error {
+					return runHooks("pre", *prehook, hookEnv("pre", source, *dest, nil))
+				},
+
+				Extracted: func(source image.Source, _ *image.ExtractResult) error {
+					if *repro {
+						verifyReproducible(ctx, store, source, *dest, opts)
+					}
+					return nil
+				},
+
+				// e.g. start the services using the destination again
+				PostHook: func(source image.Source, result *image.ExtractResult) error {
+					return runHooks("post", *posthook, hookEnv("post", source, *dest, result))
+				},
+			})
+
+			if err != nil {
+				notifyPull(*notify, remote, *dest, start, nil, err)
+				fail(*url, err)
+			}
+
+			if result.UpToDate {
+				if *jsonout {
+					printJSON(struct {
+						Image       string `json:"image"`
+						Destination string `json:"destination"`
+						*image.ExtractResult
+					}{remote.String(), *dest, result})
+					return
+				}
+
+				log.Printf("%s is up to date (%s)", *dest, result.Digest)
+				return
+			}
+
+			notifyPull(*notify, remote, *dest, start, result, nil)
+
+			if result.SkippedChowns > 0 {
+				log.Printf("skipped restoring the owner of %d files, first error: %v",
+					result.SkippedChowns, result.ChownError)
+			}
+
+			if result.SkippedSpecialFiles > 0 {
+				log.Printf("skipped %d device nodes or FIFOs that could not be created",
+					result.SkippedSpecialFiles)
+			}
+
+			if result.SkippedXattrs > 0 {
+				log.Printf("skipped %d extended attributes that could not be set",
+					result.SkippedXattrs)
+			}
+
+			hash := ""
+			if *hashed {
+				if hash, err = image.TreeHash(*dest); err != nil {
+					fatalf("could not hash %s: %v", *dest, err)
+				}
+			}
+
+			if *jsonout {
+				printJSON(struct {
+					Image       string  `json:"image"`
+					Destination string  `json:"destination"`
+					TreeHash    string  `json:"tree_hash,omitempty"`
+					Duration    float64 `json:"duration"`
+					*image.ExtractResult
+				}{remote.String(), *dest, hash, time.Since(start).Seconds(), result})
+				return
+			}
+
+			if hash != "" {
+				log.Printf("tree hash of %s: %s", *dest, hash)
+			}
+
+			if result.ArtifactType != "" {
+				log.Printf("pulled %s artifact %s: %d files (%s)",
+					result.ArtifactType, remote, result.Layers,
+					formatBytes(result.DownloadedBytes))
+				return
+			}
+
+			log.Printf("pulled %s: %d layers, %d from cache (%s), %d downloaded (%s)",
+				remote, result.Layers,
+				result.CacheHits, formatBytes(result.CachedBytes),
+				result.CacheMisses, formatBytes(result.DownloadedBytes))
+
+			if *merge {
+				log.Printf("merged into %s: %d files unchanged, %d paths removed",
+					*dest, result.Unchanged, result.Removed)
+			}
+
+			if opts.Share != image.ShareNone {
+				log.Printf("shared %d files with the cache (%s)", result.SharedFiles, opts.Share)
+			}
+
+			if result.LockWait >= 1 {
+				log.Printf("waited %.0fs for other processes to release the locks", result.LockWait)
+			}
+		}
+	})
+
+	app.Command("save", "Save an image to a directory or tarball", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER DEST [--format] [--auth] [--auth-file] [--arch] [--os] [--variant] [--strict-platform] [--first-platform] [--retries]"
+
+		var (
+			url      = newURLArg(cmd)
+			dest     = cmd.StringArg("DEST", "", "The directory or tarball to save to")
+			format   = newFormatOpt(cmd)
+			auth     = newAuthOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			arch     = newArchOpt(cmd)
+			ops      = newOSOpt(cmd)
+			variant  = newVariantOpt(cmd)
+			strict   = newStrictPlatformOpt(cmd)
+			first    = newFirstPlatformOpt(cmd)
+			attempts = newRetriesOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			setRetries(*attempts)
+			loadCredentials(authFile)
+
+			var save func(context.Context, image.Source, string, string) (string, error)
+
+			switch *format {
+			case "oci":
+				save = image.SaveOCILayout
+			case "docker":
+				save = image.SaveArchive
+			default:
+				fatalf("unknown format %s, expected oci or docker", *format)
+			}
+
+			source := newSource(ctx, url, auth, arch, ops, variant, strict, first)
+
+			digest, err := save(ctx, source, *dest, savedName(*url, *format))
+			if err != nil {
+				fatalf("error saving %s: %v", *url, err)
+			}
+
+			log.Printf("saved %s to %s", source, *dest)
+			fmt.Println(digest)
+		}
+	})
+
+	app.Command("push", "Push a directory or tarball as image", func(cmd *cli.Cmd) {
+		cmd.Spec = "(SRC | --platform...) CONTAINER [--auth] [--arch] [--os] [--variant] [--numeric-owner] [--owner] [--group] [--tmpdir]"
+
+		var (
+			src       = cmd.StringArg("SRC", "", "The directory or tarball to push")
+			url       = newURLArg(cmd)
+			auth      = newAuthOpt(cmd)
+			arch      = newArchOpt(cmd)
+			ops       = newOSOpt(cmd)
+			variant   = newVariantOpt(cmd)
+			platforms = newPushPlatformOpt(cmd)
+			numeric   = newNumericOwnerOpt(cmd)
+			owner     = newOwnerOpt(cmd)
+			group     = newGroupOpt(cmd)
+			tmpdir    = newTmpDirOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			setTempDir(*tmpdir)
+
+			pusher := newPusher(ctx, url, auth)
+			pusher.WithOwnership(newTarOwnership(*numeric, *owner, *group))
+
+			// multiple platforms are pushed as index
+			if len(*platforms) > 0 {
+				digest, err := pusher.PushIndex(platformSources(*platforms))
+				if err != nil {
+					fatalf("error during push: %v", err)
+				}
+
+				fmt.Println(digest)
+				return
+			}
+
+			platform := image.Platform{
+				Architecture: valueOrEnv(*arch, "ROOTS_ARCH", runtime.GOARCH),
+				OS:           valueOrEnv(*ops, "ROOTS_OS", "linux"),
+				Variant:      valueOrEnv(*variant, "ROOTS_VARIANT", ""),
+			}
+
+			digest, err := pusher.Push(*src, platform)
+			if err != nil {
+				fatalf("error during push: %v", err)
+			}
+
+			fmt.Println(digest)
+		}
+	})
+
+	app.Command("tag", "Tag an image on the registry", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER TAG [--auth]"
+
+		var (
+			url  = newURLArg(cmd)
+			tag  = cmd.StringArg("TAG", "", "The new tag in the same repository")
+			auth = newAuthOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			if strings.ContainsAny(*tag, ":/@") {
+				fatalf("not a tag: %s", *tag)
+			}
+
+			digest, err := newPusher(ctx, url, auth).Tag(*tag)
+			if err != nil {
+				fatalf("error during tag: %v", err)
+			}
+
+			fmt.Println(digest)
+		}
+	})
+
+	app.Command("delete", "Delete an image from the registry", func(cmd *cli.Cmd) {
+		cmd.Spec = "CONTAINER [--auth]"
+
+		var (
+			url  = newURLArg(cmd)
+			auth = newAuthOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			digest, err := newPusher(ctx, url, auth).Delete()
+			if err != nil {
+				fatalf("error during delete: %v", err)
+			}
+
+			fmt.Println(digest)
+		}
+	})
+
+	app.Command("login", "Log in to a registry", func(cmd *cli.Cmd) {
+		cmd.Spec = "--device HOST [--client-id]"
+
+		var (
+			_        = cmd.BoolOpt("device", false, "Use the OAuth device authorization flow")
+			host     = cmd.StringArg("HOST", "", "The registry host (ghcr.io or *.azurecr.io)")
+			clientID = newClientIDOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			id := valueOrEnv(*clientID, "ROOTS_CLIENT_ID", "")
+
+			auth, err := provider.DeviceLogin(ctx, *host, id, func(uri string, code string) {
+				fmt.Printf("To log in to %s, open %s and enter the code %s\n", *host, uri, code)
+			})
+
+			if err != nil {
+				fatalf("login failed: %v", err)
+			}
+
+			file := storedCredentialsPath()
+
+			stored := make(image.Credentials)
+			if _, err := os.Stat(file); err == nil {
+				if stored, err = image.LoadCredentials(file); err != nil {
+					fatal(err)
+				}
+			}
+
+			stored.Set(*host, auth)
+
+			if err := stored.Save(file); err != nil {
+				fatalf("could not store credentials: %v", err)
+			}
+
+			log.Printf("stored credentials for %s in %s", *host, file)
+		}
+	})
+
+	app.Command("list", "List destinations known to the cache", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--cache] [--json]"
+
+		var (
+			cache   = newCacheOpt(cmd)
+			jsonout = newJSONOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			useJSON(jsonout)
+
+			destinations, err := openExistingStore(cache).Destinations()
+			if err != nil {
+				fatalf("error reading cache: %v", err)
+			}
+
+			if *jsonout {
+				printJSON(destinations)
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "DESTINATION\tIMAGE\tDIGEST\tEXISTS\tCACHED LAYERS")
+
+			for _, d := range destinations {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n",
+					d.Destination,
+					valueOrUnknown(d.Image),
+					valueOrUnknown(d.Digest),
+					yesNo(d.Exists),
+					d.CachedLayers)
+			}
+
+			w.Flush()
+		}
+	})
+
+	app.Command("status", "Show the provenance of a destination", func(cmd *cli.Cmd) {
+		cmd.Spec = "DEST [--verify] [--json]"
+
+		var (
+			dest    = cmd.StringArg("DEST", "", "Destination directory")
+			verify  = newVerifyOpt(cmd)
+			jsonout = newJSONOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			useJSON(jsonout)
+
+			history, err := image.ReadHistory(*dest)
+			if err != nil {
+				fatalf("could not read history of %s: %v", *dest, err)
+			}
+
+			status := &destinationStatus{Destination: *dest, History: history}
+
+			if *verify {
+				if status.Changes, err = image.VerifyTree(*dest); err != nil {
+					fatalf("could not verify %s: %v", *dest, err)
+				}
+			}
+
+			if *jsonout {
+				printJSON(status)
+			} else {
+				printStatus(*dest, history, status.Changes, *verify)
+			}
+
+			if len(status.Changes) > 0 {
+				exit(1)
+			}
+		}
+	})
+
+	app.Command("serve", "Serve pull, digest, purge and status on a unix socket", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--socket] [--cache] [--auth-file] [--policy] [--dedup] [--notify-url]"
+
+		var (
+			socket   = newSocketOpt(cmd)
+			cache    = newCacheOpt(cmd)
+			authFile = newAuthFileOpt(cmd)
+			policy   = newPolicyOpt(cmd)
+			dedup    = newDedupOpt(cmd)
+			notify   = newNotifyURLOpt(cmd)
+		)
+
+		cmd.Action = func() {
+			loadCredentials(authFile)
+			setTempDir("")
+
+			*cache = valueOrEnv(*cache, "ROOTS_CACHE", config.Cache)
+
+			if *cache == "" {
+				*cache = defaultCache()
+			}
+
+			if strings.ToLower(*cache) == "no" {
+				fatalf("serve requires a cache")
+			}
+
+			if err := os.MkdirAll(*cache, 0755); err != nil {
+				fatalf("could not create cache at %s: %v", *cache, err)
+			}
+
+			store, err := image.NewStore(*cache)
+			if err != nil {
+				fatalf("could not create store at %s: %v", *cache, err)
+			}
+
+			store.Dedup = *dedup || os.Getenv("ROOTS_DEDUP") == "yes"
+			store.Retention = config.retention()
+			store.MaxConcurrentDownloads = maxConcurrentDownloads("")
+
+			s := newServer(ctx, store,
+				valueOrEnv(*policy, "ROOTS_POLICY", config.Policy),
+				valueOrEnv(*notify, "ROOTS_NOTIFY_URL", config.NotifyURL))
+
+			if err := s.serve(valueOrEnv(*socket, "ROOTS_SOCKET", defaultSocket())); err != nil {
+				fatal(err)
+			}
+		}
+	})
+
+	app.Command("tree-hash", "Show the hash of a directory tree", func(cmd *cli.Cmd) {
+		cmd.Spec = "DEST"
+
+		var (
+			dest = cmd.StringArg("DEST", "", "Directory to hash")
+		)
+
+		cmd.Action = func() {
+			hash, err := image.TreeHash(*dest)
+			if err != nil {
+				fatal(err)
+			}
+
+			fmt.Println(hash)
+		}
+	})
+
+	app.Command("cache", "Inspect the cache", func(cmd *cli.Cmd) {
+		cmd.Command("ls", "List cached layers", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--cache] [--json]"
+
+			var (
+				cache   = newCacheOpt(cmd)
+				jsonout = newJSONOpt(cmd)
+			)
+
+			cmd.Action = func() {
+				useJSON(jsonout)
+
+				layers, err := openExistingStore(cache).Layers()
+				if err != nil {
+					fatalf("error reading cache: %v", err)
+				}
+
+				if *jsonout {
+					printJSON(layers)
+					return
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				fmt.Fprintln(w, "DIGEST\tSIZE\tAGE\tLAST USED\tDESTINATIONS")
+
+				for _, l := range layers {
+					used := "unknown"
+					if !l.LastUsed.IsZero() {
+						used = l.LastUsed.Format(time.RFC3339)
+					}
+
+					destinations := "none"
+					if len(l.Destinations) > 0 {
+						destinations = strings.Join(l.Destinations, ", ")
+					}
+
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.Digest, formatBytes(l.Size),
+						formatAge(l.Cached), used, destinations)
+				}
+
+				w.Flush()
+			}
+		})
+
+		cmd.Command("info", "Summarize the cache", func(cmd *cli.Cmd) {
+			cmd.Spec = "[--cache] [--json]"
+
+			var (
+				cache   = newCacheOpt(cmd)
+				jsonout = newJSONOpt(cmd)
+			)
+
+			cmd.Action = func() {
+				useJSON(jsonout)
+
+				info, err := openExistingStore(cache).Info()
+				if err != nil {
+					fatalf("error reading cache: %v", err)
+				}
+
+				if *jsonout {
+					printJSON(info)
+					return
+				}
+
+				fmt.Printf("path: %s\n", info.Path)
+				fmt.Printf("layers: %d (%s)\n", info.Layers, formatBytes(info.LayerBytes))
+				fmt.Printf("unused layers: %d (%s)\n", info.UnusedLayers, formatBytes(info.UnusedBytes))
+				fmt.Printf("destinations: %d (%d missing)\n", info.Destinations, info.MissingDestinations)
+				fmt.Printf("total size: %s\n", formatBytes(info.Size))
+			}
+		})
+	})
+
+	app.Command("completion", "Generate shell completion scripts", func(cmd *cli.Cmd) {
+		cmd.Spec = "SHELL"
+
+		var (
+			shell = cmd.StringArg("SHELL", "", fmt.Sprintf(
+				"The shell to generate the script for (%s)",
+				strings.Join(completionShells, ", ")))
+		)
+
+		cmd.Action = func() {
+			if err := writeCompletion(os.Stdout, *shell); err != nil {
+				fatal(err)
+			}
+		}
+	})
+
+	// used by the completion scripts to offer previously pulled images
+	app.Command("__images", "List images known to the cache", func(cmd *cli.Cmd) {
+		cmd.Hidden = true
+
+		cmd.Action = func() {
+			cache := os.Getenv("ROOTS_CACHE")
+
+			if cache == "" {
+				cache = config.Cache
+			}
+
+			if cache == "" || strings.ToLower(cache) == "no" {
+				cache = defaultCache()
+			}
+
+			if _, err := os.Stat(cache); err != nil {
+				return
+			}
+
+			store, err := image.NewStore(cache)
+			if err != nil {
+				return
+			}
+
+			images, err := store.Images()
+			if err != nil {
+				return
+			}
+
+			for _, img := range images {
+				fmt.Println(img)
+			}
+		}
+	})
+
+	err = app.Run(os.Args)
+	if err != nil {
+		fatalf("error running command: %v", err)
+	}
+}
+
+// openExistingStore opens the given or default cache, which must exist
+func openExistingStore(cache *string) *image.Store {
+	if *cache == "" {
+		*cache = os.Getenv("ROOTS_CACHE")
+	}
+
+	if *cache == "" {
+		*cache = config.Cache
+	}
+
+	if *cache == "" {
+		*cache = defaultCache()
+	}
+
+	entries, err := os.ReadDir(*cache)
+	if err != nil {
+		fatalf("error accessing %s: %v", *cache, err)
+	}
+
+	if len(entries) == 0 {
+		fatalf("not a cache directory: %s", *cache)
+	}
+
+	valid := false
+	for _, info := range entries {
+		if info.Name() == "layers" {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		fatalf("not a cache directory: %s", *cache)
+	}
+
+	store, err := image.NewStore(*cache)
+	if err != nil {
+		fatalf("could not create store at %s: %v", *cache, err)
+	}
+
+	store.Retention = config.retention()
+	return store
+}
+
+func defaultCache() string {
+	cache, err := roots.DefaultCache()
+	if err != nil {
+		fatal(err)
+	}
+
+	return cache
+}
+
+func newInterruptableContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		signal.Stop(c)
+		cancel()
+	}()
+
+	return ctx
+}
+
+// verifyReproducible extracts the image a second time, replaying the layers
+// from the cache, and exits if the result differs from the destination
+func verifyReproducible(ctx context.Context, store *image.Store, remote image.Source, dst string, opts *image.ExtractOptions) {
+	tmp, err := os.MkdirTemp(path.Dir(path.Clean(dst)), ".roots-verify-")
+	if err != nil {
+		fatalf("could not create verification directory: %v", err)
+	}
+
+	cleanup := func() {
+		os.Remove(store.LinkPath(tmp))
+		os.Remove(tmp + ".lock")
+		os.RemoveAll(tmp)
+	}
+	defer cleanup()
+	atExit(cleanup)
+
+	if _, err := store.Extract(ctx, remote, tmp, opts); err != nil {
+		fatalf("error during second extraction: %v", err)
+	}
+
+	changes, err := image.CompareTrees(dst, tmp)
+	if err != nil {
+		fatalf("could not compare extractions: %v", err)
+	}
+
+	if len(changes) == 0 {
+		log.Printf("extraction of %s is reproducible", remote)
+		return
+	}
+
+	for _, c := range changes {
+		fmt.Println(c.String())
+	}
+
+	// log.Fatalf skips the deferred cleanup
+	os.Remove(store.LinkPath(tmp))
+	os.Remove(tmp + ".lock")
+	os.RemoveAll(tmp)
+
+	fatalf("extraction of %s is not reproducible, %d differences", remote, len(changes))
+}
+
+// readImageList returns the images listed in the given file, one per line,
+// ignoring empty lines and comments
+func readImageList(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names = append(names, line)
+	}
+
+	return names, nil
+}
+
+// lockedURL returns the url pinned to the digest of the given lockfile, or
+// exits if the image is not locked
+func lockedURL(url string, lockfile string) string {
+	if _, _, local := roots.LocalSource(url); local {
+		fatalf("cannot pull local image %s from lockfile", url)
+	}
+
+	lock, err := image.LoadLockFile(lockfile)
+	if err != nil {
+		fatalf("could not load lockfile: %v", err)
+	}
+
+	img := lock.Lookup(url)
+	if img == nil {
+		fatalf("%s is not locked in %s", url, lockfile)
+	}
+
+	return img.Pin(url)
+}
+
+// signatureVerifier returns the verifier of the given key or certificate
+// identity, and the sigstore root (each falling back to env and config)
+func signatureVerifier(key string, identity string, issuer string, root string) *image.SignatureVerifier {
+	verifier := &image.SignatureVerifier{
+		Identity: valueOrEnv(identity, "ROOTS_CERTIFICATE_IDENTITY", config.CertificateIdentity),
+		Issuer:   valueOrEnv(issuer, "ROOTS_CERTIFICATE_OIDC_ISSUER", config.CertificateOIDCIssuer),
+	}
+
+	if key = valueOrEnv(key, "ROOTS_SIGNATURE_KEY", config.SignatureKey); key != "" {
+		k, err := image.LoadPublicKey(key)
+		if err != nil {
+			fatalf("could not load signature key: %v", err)
+		}
+
+		verifier.Key = k
+	}
+
+	if root = valueOrEnv(root, "ROOTS_SIGSTORE_ROOT", config.SigstoreRoot); root != "" {
+		if err := verifier.LoadSigstoreRoot(root); err != nil {
+			fatalf("could not load sigstore root: %v", err)
+		}
+	}
+
+	if verifier.Key == nil && verifier.Identity == "" {
+		fatal("--verify-signature requires --signature-key or --certificate-identity")
+	}
+
+	return verifier
+}
+
+// verifyTag warns if the tag of the given remote no longer points to its
+// pinned digest, or exits if the tag is required to match
+func verifyTag(name string, remote *image.Remote, required bool) {
+	err := remote.VerifyTag()
+	if err == nil {
+		return
+	}
+
+	if required {
+		fail(name, err)
+	}
+
+	var mismatch *image.TagMismatchError
+	if !errors.As(err, &mismatch) {
+		log.Printf("warning: could not verify the tag of %s: %v", name, err)
+		return
+	}
+
+	log.Printf("warning: %v", err)
+}
+
+// requireDigest exits if the given url is not pinned to a digest, showing
+// the digest to pin it to, if it can be resolved
+func requireDigest(ctx context.Context, urlstring, auth, arch, ops, variant *string, strict, first *bool) {
+	if roots.HasDigest(*urlstring) {
+		return
+	}
+
+	required := &roots.DigestRequiredError{Ref: *urlstring}
+
+	if _, _, ok := roots.LocalSource(*urlstring); !ok {
+		u, err := image.Parse(*urlstring)
+		if err != nil {
+			fatalf("invalid image url %s: %v", *urlstring, err)
+		}
+
+		digest, err := newRemote(ctx, urlstring, auth, arch, ops, variant, strict, first).Digest()
+		if err == nil && digest != "" {
+			required.Pinned = u.WithDigest(digest).Familiar()
+		}
+	}
+
+	fail(*urlstring, required)
+}
+
+// newSource returns the source of the given image, which is a remote unless
+// the url selects a local source
+func newSource(ctx context.Context, urlstring, auth, arch, ops, variant *string, strict, first *bool) image.Source {
+	transport, name, ok := roots.LocalSource(*urlstring)
+	if !ok {
+		return newRemote(ctx, urlstring, auth, arch, ops, variant, strict, first)
+	}
+
+	source, err := openLocalSource(ctx, transport, name, newPlatform(arch, ops, variant))
+	if err != nil {
+		fail(*urlstring, err)
+	}
+
+	return source
+}
+
+// openLocalSource opens the image with the given name in a local source, the
+// platform is used to select an image from containerd indexes
+func openLocalSource(ctx context.Context, transport, name string, platform *image.Platform) (image.Source, error) {
+	return newPuller().Source(ctx, transport+":"+name, &roots.Options{Platform: platform})
+}
+
+func newRemote(ctx context.Context, urlstring, auth, arch, ops, variant *string, strict, first *bool) *image.Remote {
+
+	if *auth == "" {
+		*auth = os.Getenv("ROOTS_AUTH")
+	}
+
+	remote, err := connectRemote(ctx, *urlstring, *auth)
+	if err != nil {
+		fail(*urlstring, fmt.Errorf("failed to connect to %s: %w", *urlstring, err))
+	}
+
+	if p := newPlatform(arch, ops, variant); p != nil {
+		remote.WithPlatform(p)
+	}
+
+	if *strict || os.Getenv("ROOTS_STRICT_PLATFORM") == "yes" {
+		remote.WithStrictPlatform()
+	}
+
+	if *first || os.Getenv("ROOTS_FIRST_PLATFORM") == "yes" {
+		remote.WithFirstPlatform()
+	}
+
+	remote.WithManifestCache(manifestCache(valueOrEnv("", "ROOTS_CACHE", config.Cache)))
+
+	remote.OnPlatformSelected(func(p *image.Platform, host bool) {
+		if host {
+			log.Printf("selected %s of %s for this host", p, remote.Name())
+		} else {
+			log.Printf("selected %s of %s, the first platform of the image", p, remote.Name())
+		}
+	})
+
+	return remote
+}
+
+// manifestCache returns the manifest cache within the given cache (or the
+// default cache), nil if caching is disabled
+func manifestCache(cache string) *image.ManifestCache {
+	if strings.ToLower(cache) == "no" || os.Getenv("ROOTS_MANIFEST_CACHE") == "no" {
+		return nil
+	}
+
+	if cache == "" {
+		cache = defaultCache()
+	}
+
+	return image.NewManifestCache(filepath.Join(cache, "manifests"))
+}
+
+// newPlatform returns the platform selected by the given flags or env vars,
+// or nil if there is none
+func newPlatform(arch, ops, variant *string) *image.Platform {
+
+	if *arch == "" {
+		*arch = os.Getenv("ROOTS_ARCH")
+	}
+
+	if *ops == "" {
+		*ops = os.Getenv("ROOTS_OS")
+	}
+
+	if *variant == "" {
+		*variant = os.Getenv("ROOTS_VARIANT")
+	}
+
+	if len(*arch) == 0 && len(*ops) == 0 && len(*variant) == 0 {
+		return nil
+	}
+
+	if len(*arch) == 0 {
+		*arch = runtime.GOARCH
+	}
+
+	if len(*ops) == 0 {
+		*ops = "linux"
+	}
+
+	return &image.Platform{
+		Architecture: *arch,
+		OS:           *ops,
+		Variant:      *variant,
+	}
+}
+
+// platformSources parses the sources given as PLATFORM=SRC
+func platformSources(values []string) []image.PlatformSource {
+	sources := make([]image.PlatformSource, 0, len(values))
+
+	for _, value := range values {
+		platform, src, ok := strings.Cut(value, "=")
+		if !ok || src == "" {
+			fatalf("invalid platform source %s, expected os/arch[/variant]=src", value)
+		}
+
+		p, err := image.ParsePlatform(platform)
+		if err != nil {
+			fatal(err)
+		}
+
+		sources = append(sources, image.PlatformSource{Platform: *p, Source: src})
+	}
+
+	return sources
+}
+
+// jsonOutput prints the results of all commands as JSON (set by --output)
+var jsonOutput bool
+
+// setupOutput sets the format the results of commands are printed in
+func setupOutput(format string) {
+	switch format {
+	case "", "text":
+		jsonOutput = false
+	case "json":
+		jsonOutput = true
+	default:
+		fatalf("unknown output format %s, expected text or json", format)
+	}
+}
+
+// useJSON combines the --json flag of a command with --output json, errors
+// passed to fail are printed as JSON as well if either is set
+func useJSON(jsonout *bool) {
+	*jsonout = *jsonout || jsonOutput
+	jsonErrors = *jsonout
+}
+
+// printJSON writes the given value as indented JSON to stdout
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fatalf("error encoding output: %v", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+// valueOrUnknown returns the given value or "unknown" if it is empty
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+
+	return value
+}
+
+// yesNo formats a boolean for tabular output
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// formatAge returns the time passed since the given time, in the largest
+// unit that fits (e.g. 3d or 5h)
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+
+	age := time.Since(t)
+
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	case age >= time.Minute:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	}
+}
+
+// formatBytes returns a human readable representation of the given size
+func formatBytes(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// printLayerSizes prints the compressed and uncompressed sizes of the given
+// layers, with their totals
+func printLayerSizes(sizes []image.LayerSize, jsonout bool) {
+	if jsonout {
+		printJSON(sizes)
+		return
+	}
+
+	uncompressed := func(size int64) string {
+		if size == 0 {
+			return "unknown"
+		}
+
+		return formatBytes(size)
+	}
+
+	var total, totalUncompressed int64
+	complete := true
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tSIZE\tUNCOMPRESSED")
+
+	for _, l := range sizes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", l.Digest, formatBytes(l.Size), uncompressed(l.Uncompressed))
+
+		total += l.Size
+		totalUncompressed += l.Uncompressed
+		complete = complete && l.Uncompressed != 0
+	}
+
+	if !complete {
+		totalUncompressed = 0
+	}
+
+	fmt.Fprintf(w, "total\t%s\t%s\n", formatBytes(total), uncompressed(totalUncompressed))
+	w.Flush()
+}
+
+// checkPolicy exits if the policy at the given path (if any) does not allow
+// pulling the given image
+func checkPolicy(url string, path string) {
+	if err := policyError(url, path); err != nil {
+		report := newErrorReport(url, err)
+		report.Class = "policy"
+		report.exit()
+	}
+}
+
+// policyError returns an error if the policy at the given path (if any) does
+// not allow pulling the given image
+func policyError(url string, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	policy, err := image.LoadPolicy(path)
+	if err != nil {
+		return fmt.Errorf("could not load policy: %v", err)
+	}
+
+	if transport, name, ok := roots.LocalSource(url); ok {
+		err = policy.CheckTransport(transport, name)
+	} else {
+		var u *image.URL
+		if u, err = image.Parse(url); err != nil {
+			return fmt.Errorf("invalid image url %s: %v", url, err)
+		}
+
+		err = policy.Check(*u)
+	}
+
+	if err != nil {
+		return fmt.Errorf("policy violation: %v", err)
+	}
+
+	return nil
+}
+
+// logLockWait shows who holds the lock a pull is waiting for, as the pull
+// would otherwise appear to hang
+func logLockWait(file string, holder string, waited time.Duration) {
+	log.Printf("waiting for %s, held by %s (%s so far)", file, holder, waited.Round(time.Second))
+}
+
+// withTimeout returns a context with the given deadline (e.g. "15m"). As not
+// all blocking calls observe the context, the process is exited shortly after
+// the deadline, if it is still running.
+func withTimeout(ctx context.Context, timeout string) (context.Context, context.CancelFunc) {
+	if timeout == "" {
+		return context.WithCancel(ctx)
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		fatalf("invalid timeout: %s", timeout)
+	}
+
+	time.AfterFunc(d+5*time.Second, func() {
+		(&errorReport{
+			Error: fmt.Sprintf("timeout of %s exceeded", d),
+			Class: "timeout",
+			Hint:  "raise the limit with --timeout",
+		}).exit()
+	})
+
+	return context.WithTimeout(ctx, d)
+}
+
+// expansionFactor returns the factor used by the disk space check, which is
+// taken from the given flag, the env or the config
+func expansionFactor(flag string) float64 {
+	value := valueOrEnv(flag, "ROOTS_EXPANSION_FACTOR", "")
+	if value == "" {
+		if config.ExpansionFactor != 0 {
+			return config.ExpansionFactor
+		}
+
+		return image.DefaultExpansionFactor
+	}
+
+	factor, err := strconv.ParseFloat(value, 64)
+	if err != nil || factor < 0 {
+		fatalf("invalid expansion factor: %s", value)
+	}
+
+	return factor
+}
+
+// extractWorkers returns the number of files written concurrently, which is
+// taken from the given flag, the env or the config
+func extractWorkers(flag string) int {
+	value := valueOrEnv(flag, "ROOTS_EXTRACT_WORKERS", "")
+	if value == "" {
+		return config.ExtractWorkers
+	}
+
+	workers, err := strconv.Atoi(value)
+	if err != nil || workers < 1 {
+		fatalf("invalid number of extract workers: %s", value)
+	}
+
+	return workers
+}
+
+// overlayLayout returns true if the given layout extracts the layers into
+// separate directories for overlayfs
+func overlayLayout(layout string) bool {
+	switch layout {
+	case "", "flat":
+		return false
+	case "overlay":
+		return true
+	default:
+		fatalf("unknown layout: %s", layout)
+		return false
+	}
+}
+
+// shareMode returns how files are shared with the cache, which is taken from
+// the given flag, the env or the config
+func shareMode(flag string) image.ShareMode {
+	mode, err := image.ParseShareMode(valueOrEnv(flag, "ROOTS_SHARE", config.Share))
+	if err != nil {
+		fatal(err)
+	}
+
+	return mode
+}
+
+// maxConcurrentDownloads returns the number of layers downloaded at the same
+// time, which is taken from the given flag, the env or the config
+func maxConcurrentDownloads(flag string) int {
+	value := valueOrEnv(flag, "ROOTS_MAX_CONCURRENT_DOWNLOADS", "")
+	if value == "" {
+		return config.MaxConcurrentDownloads
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		fatalf("invalid number of concurrent downloads: %s", value)
+	}
+
+	return n
+}
+
+// retries is the number of times failed registry requests are retried
+var retries = image.DefaultRetries
+
+// setRetries sets the number of retries of failed registry requests, which
+// is taken from the given flag, the env or the config
+func setRetries(flag string) {
+	value := valueOrEnv(flag, "ROOTS_RETRIES", "")
+	if value == "" {
+		if config.Retries != 0 {
+			retries = config.Retries
+		}
+
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		fatalf("invalid number of retries: %s", value)
+	}
+
+	retries = n
+}
+
+// setupTransport configures the connections to registries, the proxy, the
+// TLS settings and the plain HTTP registries are taken from the given flags,
+// the env or the config (by default HTTP_PROXY, HTTPS_PROXY and the
+// certificates of the system are used)
+func setupTransport(proxy string, caCert string, insecure bool, plainHTTP []string) {
+	opts := image.TransportOptions{
+		Proxy:  valueOrEnv(proxy, "ROOTS_PROXY", config.Proxy),
+		CACert: valueOrEnv(caCert, "ROOTS_CA_CERT", config.CACert),
+		InsecureSkipTLSVerify: insecure ||
+			os.Getenv("ROOTS_INSECURE_SKIP_TLS_VERIFY") == "yes" ||
+			config.InsecureSkipTLSVerify,
+	}
+
+	if opts.InsecureSkipTLSVerify {
+		log.Printf("warning: TLS certificates are not verified")
+	}
+
+	if len(plainHTTP) == 0 && os.Getenv("ROOTS_INSECURE_HTTP") != "" {
+		plainHTTP = strings.Split(os.Getenv("ROOTS_INSECURE_HTTP"), ",")
+	}
+
+	if len(plainHTTP) == 0 {
+		plainHTTP = config.InsecureHTTP
+	}
+
+	image.AllowPlainHTTP(plainHTTP...)
+
+	if err := image.ConfigureTransport(opts); err != nil {
+		fatal(err)
+	}
+}
+
+// setTempDir sets the directory holding temporary files, which is taken from
+// the given flag, the env or the config (by default the system temp dir)
+func setTempDir(flag string) {
+	image.TempDir = valueOrEnv(flag, "ROOTS_TMPDIR", config.TmpDir)
+
+	if image.TempDir == "" {
+		return
+	}
+
+	if err := os.MkdirAll(image.TempDir, 0755); err != nil {
+		fatalf("could not create temporary directory at %s: %v", image.TempDir, err)
+	}
+}
+
+// imageName returns the name of the given image without transport. Images in
+// layouts and archives are named after their tag, or after their file.
+func imageName(url string) string {
+	transport, name, ok := roots.LocalSource(url)
+	if !ok {
+		return url
+	}
+
+	if transport != "oci" && transport != "docker-archive" {
+		return name
+	}
+
+	file, ref, _ := strings.Cut(name, ":")
+
+	if transport == "docker-archive" && ref != "" {
+		return ref
+	}
+
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	switch {
+	case strings.HasPrefix(ref, "sha256:"):
+		return base + "@" + ref
+	case ref != "":
+		return base + ":" + ref
+	default:
+		return base
+	}
+}
+
+// defaultDestination returns the destination of images pulled without one,
+// which is the destination template of the config (by default "{name}-{tag}")
+// within the destination dir of the config (by default the working directory)
+func defaultDestination(url string) string {
+	url = imageName(url)
+
+	u, err := image.Parse(url)
+	if err != nil {
+		fatalf("invalid image url %s: %v", url, err)
+	}
+
+	template := config.DestinationTemplate
+	if template == "" {
+		template = "{name}-{tag}"
+	}
+
+	name := strings.NewReplacer(
+		"{repository}", u.Repository,
+		"{name}", u.Name,
+		"{tag}", u.Tag,
+	).Replace(template)
+
+	dir := config.DestinationDir
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			fatalf("could not get working directory: %v", err)
+		}
+	}
+
+	return path.Join(dir, name)
+}
+
+// savedName returns the name of the given image in saved images, which is
+// the tag in OCI layouts and the name with tag in docker archives. Images
+// referenced by digest only have no name.
+func savedName(url string, format string) string {
+	url = imageName(url)
+
+	if name, _, ok := strings.Cut(url, "@"); ok && !strings.Contains(path.Base(name), ":") {
+		return ""
+	}
+
+	u, err := image.Parse(url)
+	if err != nil {
+		fatalf("invalid image url %s: %v", url, err)
+	}
+
+	if format == "oci" {
+		return u.Tag
+	}
+
+	tagged := image.URL{Host: u.Host, Repository: u.Repository, Name: u.Name, Tag: u.Tag}
+	if u.Tag == "latest" {
+		return tagged.Familiar() + ":latest"
+	}
+
+	return tagged.Familiar()
+}
+
+// destinationStatus is the provenance of a destination, as shown by status
+type destinationStatus struct {
+	Destination string              `json:"destination"`
+	History     []*image.PullEvent  `json:"history"`
+	Changes     []*image.TreeChange `json:"changes,omitempty"`
+}
+
+// printStatus prints the last pull and the changes of a destination
+func printStatus(dst string, history []*image.PullEvent, changes []*image.TreeChange, verified bool) {
+	if len(history) == 0 {
+		fmt.Printf("%s: no pulls recorded\n", dst)
+	} else {
+		last := history[len(history)-1]
+		fmt.Printf("%s: %s@%s pulled by %s at %s (%d pulls recorded)\n",
+			dst, last.Image, last.Digest, valueOrUnknown(last.User),
+			last.Time.Format(time.RFC3339), len(history))
+	}
+
+	if !verified {
+		return
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("no changes since the last pull")
+		return
+	}
+
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
+
+// pingURL returns the url of a registry host (e.g. ghcr.io or localhost:5000)
+// or of an image
+func pingURL(target string) *image.URL {
+	host, _, _ := strings.Cut(target, ":")
+
+	if !strings.Contains(target, "/") && (strings.Contains(host, ".") || host == "localhost") {
+		return &image.URL{Host: target}
+	}
+
+	url, err := image.Parse(target)
+	if err != nil {
+		fatalf("invalid image url %s: %v", target, err)
+	}
+
+	return url
+}
+
+// pingAuth returns the credentials used for the given url, with a description
+// of their origin
+func pingAuth(url *image.URL, auth string) (string, string) {
+	if auth != "" {
+		return auth, "--auth"
+	}
+
+	if auth = os.Getenv("ROOTS_AUTH"); auth != "" {
+		return auth, "ROOTS_AUTH"
+	}
+
+	if auth = credentials.Lookup(url.Host); auth != "" {
+		return auth, "stored credentials"
+	}
+
+	return "", "anonymous"
+}
+
+func printPing(result *image.PingResult, mode string) {
+	fmt.Printf("host: %s\n", result.Host)
+
+	if result.Challenge != "" {
+		fmt.Printf("challenge: %s\n", result.Challenge)
+	}
+
+	if result.Provider != "" {
+		fmt.Printf("auth: %s (%s provider)\n", mode, result.Provider)
+	}
+
+	for _, s := range result.Steps {
+		outcome := s.Status
+		if outcome == "" {
+			outcome = "ok"
+		}
+
+		if s.Error != "" {
+			outcome = "failed"
+		}
+
+		fmt.Printf("%s: %s in %dms\n", s.Name, outcome, int(s.Latency*1000))
+	}
+}
+
+func printImageConfig(c *image.ImageConfig) {
+	fmt.Printf("platform: %s\n", c.Platform())
+
+	if c.Created != nil {
+		fmt.Printf("created: %s\n", c.Created.Format(time.RFC3339))
+	}
+
+	// commands are shown in exec form, which keeps their quoting
+	for _, field := range []struct {
+		name  string
+		value []string
+	}{{"entrypoint", c.Config.Entrypoint}, {"cmd", c.Config.Cmd}} {
+		if field.value != nil {
+			command, _ := json.Marshal(field.value)
+			fmt.Printf("%s: %s\n", field.name, command)
+		}
+	}
+
+	for _, field := range []struct{ name, value string }{
+		{"workdir", c.Config.WorkingDir},
+		{"user", c.Config.User},
+		{"stop-signal", c.Config.StopSignal},
+	} {
+		if field.value != "" {
+			fmt.Printf("%s: %s\n", field.name, field.value)
+		}
+	}
+
+	for _, env := range c.Config.Env {
+		fmt.Printf("env: %s\n", env)
+	}
+
+	for _, key := range sortedKeys(c.Config.Labels) {
+		fmt.Printf("label: %s=%s\n", key, c.Config.Labels[key])
+	}
+
+	for _, port := range sortedKeys(c.Config.ExposedPorts) {
+		fmt.Printf("port: %s\n", port)
+	}
+
+	for _, volume := range sortedKeys(c.Config.Volumes) {
+		fmt.Printf("volume: %s\n", volume)
+	}
+
+	for _, id := range c.RootFS.DiffIDs {
+		fmt.Printf("diff-id: %s\n", id)
+	}
+}
+
+// sortedKeys returns the keys of the given map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// diffConfigs prints the changes between the configs of the given images
+// and exits with 1 if there are any
+func diffConfigs(remotes []*image.Remote, jsonout bool) {
+	configs := make([]*image.ImageConfig, len(remotes))
+
+	for i, r := range remotes {
+		config, err := r.Config()
+		if err != nil {
+			fail(r.String(), fmt.Errorf("could not get config of %s: %w", r, err))
+		}
+
+		configs[i] = config
+	}
+
+	changes := image.DiffConfigs(configs[0], configs[1])
+
+	if jsonout {
+		printJSON(changes)
+	} else {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+
+	if len(changes) > 0 {
+		exit(1)
+	}
+}
+
+// printImageDiff prints the changed layers, a summary of the sizes and the
+// changed files of the given diff
+func printImageDiff(diff *image.ImageDiff, layers int) {
+	for _, l := range diff.Layers {
+		switch l.Change {
+		case "added":
+			fmt.Printf("layer %d: added (%s, %s)\n", l.Index, l.New, formatBytes(l.NewSize))
+		case "removed":
+			fmt.Printf("layer %d: removed (%s, %s)\n", l.Index, l.Old, formatBytes(l.OldSize))
+		default:
+			fmt.Printf("layer %d: modified (%s, %s -> %s, %s)\n", l.Index,
+				l.Old, formatBytes(l.OldSize), l.New, formatBytes(l.NewSize))
+		}
+	}
+
+	sign := "+"
+	delta := diff.SizeDelta
+
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+
+	fmt.Printf("%d of %d layers shared, size %s%s, %s to download\n",
+		diff.Shared, layers, sign, formatBytes(delta), formatBytes(diff.Download))
+
+	for _, c := range diff.Files {
+		fmt.Println(c)
+	}
+}
+
+func printDryRun(result *image.DryRunResult) {
+	for i, l := range result.Layers {
+		fmt.Printf("# layer %d/%d %s: %d added, %d removed, %d replaced\n", i+1,
+			len(result.Layers), l.Digest, len(l.Added), len(l.Removed), len(l.Replaced))
+
+		for _, prefix := range []struct {
+			sign  string
+			paths []string
+		}{{"+", l.Added}, {"-", l.Removed}, {"~", l.Replaced}} {
+			for _, p := range prefix.paths {
+				fmt.Printf("%s %s\n", prefix.sign, p)
+			}
+		}
+	}
+
+	fmt.Printf("# %d files\n", len(result.Files))
+
+	for _, f := range result.Files {
+		fmt.Println(f)
+	}
+}
+
+// newTarOwnership returns the owner overrides of the written tar streams, or
+// nil if there are none
+func newTarOwnership(numeric bool, owner string, group string) *image.TarOwnership {
+	if !numeric && owner == "" && group == "" {
+		return nil
+	}
+
+	ownership := &image.TarOwnership{NumericOwner: numeric}
+
+	if owner != "" {
+		o, err := image.ParseTarOwner(owner)
+		if err != nil {
+			fatal(err)
+		}
+
+		ownership.Owner = o
+	}
+
+	if group != "" {
+		g, err := image.ParseTarGroup(group)
+		if err != nil {
+			fatal(err)
+		}
+
+		ownership.Group = g
+	}
+
+	return ownership
+}
+
+func newExtractOptions(preserve *bool, idmap *string) *image.ExtractOptions {
+	opts := &image.ExtractOptions{
+		PreserveOwnership: *preserve,
+	}
+
+	if *idmap == "" {
+		*idmap = os.Getenv("ROOTS_ID_MAP_FILE")
+	}
+
+	if *idmap == "" {
+		return opts
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		fatalf("error looking up current user: %v", err)
+	}
+
+	uidfile, gidfile := *idmap, *idmap
+	if *idmap == "auto" {
+		uidfile, gidfile = "/etc/subuid", "/etc/subgid"
+	}
+
+	if opts.UIDMap, err = image.LoadSubIDs(uidfile, usr.Username, usr.Uid); err != nil {
+		fatalf("failed to load uid map: %v", err)
+	}
+
+	if opts.GIDMap, err = image.LoadSubIDs(gidfile, usr.Username, usr.Uid); err != nil {
+		fatalf("failed to load gid map: %v", err)
+	}
+
+	return opts
+}
+
+// resolveURLs returns the urls that are tried in order for the given image,
+// as configured in registries.conf (aliases, search registries, mirrors)
+func resolveURLs(urlstring string) ([]image.URL, error) {
+	return newPuller().Resolve(urlstring)
+}
+
+// credentials are loaded from the auth file, if one is given
+var credentials image.Credentials
+
+// loadCredentials loads the credentials stored by login, followed by the
+// ones of the given auth file (or the one in ROOTS_AUTH_FILE), which take
+// precedence
+func loadCredentials(authFile *string) {
+	credentials = make(image.Credentials)
+
+	files := []string{storedCredentialsPath()}
+	if _, err := os.Stat(files[0]); err != nil {
+		files = nil
+	}
+
+	if file := valueOrEnv(*authFile, "ROOTS_AUTH_FILE", ""); file != "" {
+		files = append(files, file)
+	}
+
+	for _, file := range files {
+		loaded, err := image.LoadCredentials(file)
+		if err != nil {
+			fatalf("could not load credentials: %v", err)
+		}
+
+		for host, auth := range loaded {
+			credentials[host] = auth
+		}
+	}
+}
+
+// connectRemote connects to the first candidate of the given image that can
+// be reached
+func connectRemote(ctx context.Context, urlstring string, auth string) (*image.Remote, error) {
+	return newPuller().Remote(ctx, urlstring, &roots.Options{Auth: auth, AllowSchema1: allowSchema1})
+}
+
+// allowSchema1 pulls images with schema version 1 manifests (set by
+// --allow-schema1)
+var allowSchema1 bool
+
+// newPuller returns a puller with the credentials and the registries loaded
+// by roots, and the local sources configured through env vars
+func newPuller() *roots.Puller {
+	return &roots.Puller{
+		Credentials:         credentials,
+		Registries:          registries,
+		Retries:             retries,
+		StorageRoot:         os.Getenv("ROOTS_STORAGE_ROOT"),
+		ContainerdRoot:      os.Getenv("ROOTS_CONTAINERD_ROOT"),
+		ContainerdNamespace: os.Getenv("CONTAINERD_NAMESPACE"),
+	}
+}
+
+// newRepository returns a remote to list the tags of the given image's
+// repository, which is resolved like the image
+func newRepository(ctx context.Context, urlstring string, auth string) *image.Remote {
+	if _, _, local := roots.LocalSource(urlstring); local {
+		fatalf("cannot list tags of local image %s", urlstring)
+	}
+
+	urls, err := resolveURLs(urlstring)
+	if err != nil {
+		fatalf("invalid image url %s: %v", urlstring, err)
+	}
+
+	auth = valueOrEnv(auth, "ROOTS_AUTH", credentials.Lookup(urls[0].Host))
+
+	remote, err := image.NewRepository(ctx, urls[0], auth)
+	if err != nil {
+		fail(urlstring, fmt.Errorf("failed to connect to %s: %w", urlstring, err))
+	}
+
+	return remote
+}
+
+func newPusher(ctx context.Context, urlstring, auth *string) *image.Pusher {
+
+	if *auth == "" {
+		*auth = os.Getenv("ROOTS_AUTH")
+	}
+
+	url, err := image.Parse(*urlstring)
+	if err != nil {
+		fatalf("failed to parse image url %s: %v", *urlstring, err)
+	}
+
+	pusher, err := image.NewPusher(ctx, *url, *auth)
+	if err != nil {
+		fatalf("failed to connect to %s: %v", *urlstring, err)
+	}
+
+	return pusher
+}
+
+// valueOrEnv returns the value, or the env var if the value is empty, or
+// the fallback if both are empty
+func valueOrEnv(value string, env string, fallback string) string {
+	if value == "" {
+		value = os.Getenv(env)
+	}
+
+	if value == "" {
+		value = fallback
+	}
+
+	return value
+}
+
+func newURLArg(cmd *cli.Cmd) *string {
+	return cmd.StringArg("CONTAINER", "",
+		`The url of the container, example values:
+
+               - ubuntu:latest
+               - gcr.io/google-containers/etcd:3.3.10
+               - docker-daemon:ubuntu:latest (pull only)
+               - containerd:docker.io/library/ubuntu:latest (pull only)
+               - containers-storage:localhost/myapp (pull only)
+	`)
+}
+
+func newDestArg(cmd *cli.Cmd) *string {
+	return cmd.StringArg("DEST", "", "The destination folder")
+}
+
+func newAuthOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("auth", "",
+		`Authentication for the following providers:
+
+               * Google Container Registry:
+                 Path to service worker json file, with the following scope:
+                 <https://www.googleapis.com/auth/devstorage.read_only>
+                 If omitted, the metadata server is used on GCE/GKE.
+
+               * GitHub Container Registry:
+                 Personal access token or GITHUB_TOKEN ('user:token' or
+                 'token'). Defaults to the env var GITHUB_TOKEN.
+
+               * Amazon Elastic Container Registry:
+                 Omit to use the instance profile on EC2, or give the
+                 registry credentials in the form of 'AWS:token'.
+
+               * Other registries:
+                 Username and password in the form of 'user:password'
+
+               This value can also be set through the env var ROOTS_AUTH,
+               though the flag takes precedence.
+	`)
+}
+
+func newAuthFileOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("auth-file", "",
+		`Path to a Docker config (.dockerconfigjson) with credentials per host
+
+               A Kubernetes secret of the type kubernetes.io/dockerconfigjson
+               (JSON or YAML) may be given as well. --auth takes precedence.
+
+               This value can also be set through the env var ROOTS_AUTH_FILE,
+               though the flag takes precedence.
+	`)
+}
+
+func newClientIDOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("client-id", "",
+		`The client id of the OAuth application used for the device flow
+
+               This value can also be set through the env var ROOTS_CLIENT_ID,
+               though the flag takes precedence.
+	`)
+}
+
+func newArchOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("arch", "",
+		`Force the given architecture, example values:
+
+               * amd64
+               * arm
+
+               See https://github.com/golang/go/blob/master/src/go/build/syslist.go
+
+               Requires multi-arch support by the container.
+
+               This value can also be set through the env var ROOTS_ARCH,
+               though the flag takes precedence.
+	`)
+}
+
+func newOSOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("os", "",
+		`Force the given OS, example values:
+
+               * linux
+               * windows
+
+               See https://github.com/golang/go/blob/master/src/go/build/syslist.go
+
+               Requires multi-arch support by the container.
+
+               This value can also be set through the env var ROOTS_OS,
+               though the flag takes precedence.
+	`)
+}
+
+func newVariantOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("variant", "",
+		`Force the given CPU variant, example values:
+
+               * v6 (for arm)
+               * v7 (for arm)
+               * v8 (for arm64)
+
+               Without variant, the one of the host is preferred when the
+               architecture is the same, otherwise v7 for arm and v8 for
+               arm64, falling back to any variant of the architecture.
+
+               This value can also be set through the env var ROOTS_VARIANT,
+               though the flag takes precedence.
+	`)
+}
+
+func newStrictPlatformOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("strict-platform", false, `Fail if the image does not match the platform
+
+               Without --arch/--os, the manifest of the host platform is
+               selected from multi-arch images, falling back to the first
+               manifest if there is none. With this flag, the image must
+               match the given platform (or the one of the host), even if
+               it has no multi-arch support.
+
+               This value can also be enabled by setting the env var
+               ROOTS_STRICT_PLATFORM to 'yes'.
+	`)
+}
+
+func newFirstPlatformOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("first-platform", false, `Select the first manifest of multi-arch images
+
+               Without --arch/--os, the manifest of the host platform is
+               selected from multi-arch images. With this flag, the first
+               manifest is selected instead, as done by earlier releases.
+               It is ignored together with --strict-platform.
+
+               This value can also be enabled by setting the env var
+               ROOTS_FIRST_PLATFORM to 'yes'.
+	`)
+}
+
+func newVerifyReproducibleOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("verify-reproducible", false, `Extract the image twice and compare the results
+
+               The second extraction replays the layers from the cache
+               next to DEST. Differences in type, mode, owner, content,
+               links or hardlinks are listed and the pull fails. Timestamps
+               are not restored by roots and therefore not compared.
+	`)
+}
+
+func newTimeoutOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("timeout", "",
+		`Abort if the pull takes longer than this (e.g. 15m, 1h)
+
+               Covers the connection to the registry, waiting for locks,
+               downloads and the extraction.
+
+               This value can also be set through the env var ROOTS_TIMEOUT,
+               though the flag takes precedence.
+	`)
+}
+
+func newExpansionFactorOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("expansion-factor", "",
+		`Ratio of uncompressed to compressed layer size (default: 2)
+
+               Before downloading, roots checks that the free space of the
+               cache and destination filesystems suffices for the layers,
+               assuming they expand by this factor. Use 0 to disable.
+
+               This value can also be set through the env var
+               ROOTS_EXPANSION_FACTOR, or the config file, though the flag
+               takes precedence.
+	`)
+}
+
+func newRetriesOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("retries", "", `Retry failed registry requests this many times (default 3)
+
+               Rate limits, server errors and network errors are retried with
+               exponential backoff, honoring Retry-After. Broken downloads are
+               resumed. Also ROOTS_RETRIES or "retries" in the config.`)
+}
+
+func newTmpDirOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("tmpdir", "",
+		`Directory for temporary files (default: the system temp dir)
+
+               Holds the images exported from other stores and layers
+               built for push. Point it to a filesystem with enough space,
+               ideally the one of the destination, if the system temp dir
+               is a small tmpfs.
+
+               This value can also be set through the env var
+               ROOTS_TMPDIR, or the config file, though the flag takes
+               precedence.
+	`)
+}
+
+func newExtractWorkersOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("extract-workers", "",
+		`Number of files written concurrently within a layer (default: 1)
+
+               Speeds up layers with lots of small files, especially on
+               storage with high latency. Directories and links are still
+               created in order, so the result does not change.
+
+               This value can also be set through the env var
+               ROOTS_EXTRACT_WORKERS, or the config file, though the flag
+               takes precedence.
+	`)
+}
+
+func newShareOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("share", "",
+		`Share the files of the destination with the cache (default: none)
+
+               With 'hardlink', the files are hard links to the layers
+               exploded in the cache, which has to be on the same
+               filesystem. All destinations of an image then share the
+               same inodes: files changed in place change everywhere,
+               only files which are replaced are safe to change. With
+               'reflink', the files are clones sharing their blocks until
+               they are changed (btrfs, xfs), or copies elsewhere.
+
+               This value can also be set through the env var ROOTS_SHARE,
+               or the config file, though the flag takes precedence.
+	`)
+}
+
+func newMaxConcurrentDownloadsOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("max-concurrent-downloads", "",
+		`Number of layers downloaded at the same time (default: 3)
+
+               Images with many layers otherwise trigger the rate limits of
+               registries. Layers are extracted in order as they arrive.
+
+               This value can also be set through the env var
+               ROOTS_MAX_CONCURRENT_DOWNLOADS, or the config file, though
+               the flag takes precedence.
+	`)
+}
+
+func newPreHookOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("pre-hook", "",
+		`Executable to run before the destination is replaced
+
+               Runs after the executables in hooks.d/pre next to the
+               config file, with ROOTS_HOOK, ROOTS_IMAGE and
+               ROOTS_DESTINATION in its environment. If it fails, the
+               pull is aborted.
+	`)
+}
+
+func newPostHookOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("post-hook", "",
+		`Executable to run after the image has been extracted
+
+               Runs after the executables in hooks.d/post next to the
+               config file, with ROOTS_HOOK, ROOTS_IMAGE,
+               ROOTS_DESTINATION, ROOTS_DIGEST and ROOTS_PLATFORM in its
+               environment. If it fails, roots exits with an error.
+	`)
+}
+
+func newNotifyURLOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("notify-url", "",
+		`URL to POST a JSON summary to after each pull
+
+               The summary contains the image, digest, destination,
+               duration and result ('success' or 'error') of the pull.
+               Failed notifications are logged, the pull succeeds anyway.
+
+               This value can also be set through the env var
+               ROOTS_NOTIFY_URL, or the config file, though the flag takes
+               precedence.
+	`)
+}
+
+func newSocketOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("socket", "",
+		`Path of the unix socket to serve on. Defaults:
+
+               * For non-root users:
+                 $XDG_RUNTIME_DIR/roots.sock
+
+               * For root users:
+                 /run/roots.sock
+
+               This value can also be set through the env var ROOTS_SOCKET,
+               though the flag takes precedence.
+	`)
+}
+
+func newTreeHashOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("tree-hash", false, `Show the hash of the extracted tree
+
+               The hash covers the names, types, modes, owners, contents
+               and symlink targets of all files (see 'roots tree-hash').
+	`)
+}
+
+func newLockedOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("locked", false, `Only pull the digest pinned by the lockfile
+
+               Fails if the image is not in the lockfile, or if the pinned
+               digest is no longer available on the registry.
+	`)
+}
+
+func newLockFileOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("lockfile", "",
+		`Path to the lockfile written by 'roots lock' (default: roots.lock)
+
+               This value can also be set through the env var ROOTS_LOCKFILE,
+               though the flag takes precedence.
+	`)
+}
+
+func newRequireDigestOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("require-digest", false, `Refuse to pull images which are not pinned to a digest
+
+               Only references like debian@sha256:... are pulled. For tags,
+               the digest to pin them to is shown in the error.
+
+               This value can also be enabled by setting the env var
+               ROOTS_REQUIRE_DIGEST to 'yes', or through the config file.
+	`)
+}
+
+func newCacheOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("cache", "",
+		`Sets the cache folder that should be used. Defaults:
+
+               * For non-root users:
+                 ~/.cache/seantis/roots
+
+               * For root users:
+                 /var/cache/roots
+
+               If the special value 'no' is given, a temporary folder will
+               be used during the lifetime of the process.
+
+               This value can also be set through the env var ROOTS_CACHE,
+               or the config file, though the flag takes precedence.
+	`)
+}
+
+func newForceOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("force", false, `Remove the destination before pulling
+
+               Note that this only works if there are at least two path
+               separatores in the destination. So you can force remove
+               /var/roots/ubuntu, but not / or /var/lib.
+	`)
+}
+
+func newMergeOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("merge", false, `Pull into an existing destination, updating it in place
+
+               Files that did not change are not written again, paths
+               that are not part of the image are removed. The history
+               in the destination is kept.
+	`)
+}
+
+func newUpdateOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("update", false, `Replace an existing destination once the pull is complete
+
+               The image is extracted next to the destination, which is
+               then swapped with it (atomically on Linux), so the
+               destination never holds a partially extracted tree. The
+               old tree is removed and the history is kept.
+	`)
+}
+
+func newWriteConfigOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("write-config", "",
+		`File to write the image config to (default: DEST/.roots/config.json)
+
+               The config (entrypoint, cmd, env, working directory, labels,
+               ...) is written together with the name and the digest of
+               the image, so it can be read without contacting the
+               registry again.
+	`)
+}
+
+func newLayoutOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("layout", "flat", `Layout of the destination, 'flat' or 'overlay'
+
+               With 'overlay', each layer is extracted into its own
+               directory (l1, l2, ...) with overlayfs whiteouts, and the
+               lowerdir option to mount them is written to DEST/lowerdir.
+	`)
+}
+
+func newPushPlatformOpt(cmd *cli.Cmd) *[]string {
+	return cmd.StringsOpt("platform", nil, `Directory or tarball to push for a platform
+
+               Given as os/arch[/variant]=src, e.g. linux/arm64=./arm64.
+               Can be given multiple times, the images are pushed together
+               with an index referencing them under the tag.
+	`)
+}
+
+func newPreserveOwnerOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("preserve-owner", false, `Restore the owner and group of extracted files
+
+               Usually requires root privileges.
+	`)
+}
+
+func newPreserveXattrsOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("preserve-xattrs", false, `Restore the extended attributes of extracted files
+
+               E.g. file capabilities (security.capability), which usually
+               requires root privileges. Attributes that cannot be set are
+               skipped and counted in a summary at the end of the pull.
+	`)
+}
+
+func newIDMapFileOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("id-map-file", "",
+		`Shift the owner and group of extracted files using a map
+
+               The map is read from a file in the format of /etc/subuid,
+               the ranges of the current user are mapped to ids starting
+               at 0. The same file is used for uids and gids.
+
+               If the special value 'auto' is given, the ranges of the
+               current user are read from /etc/subuid and /etc/subgid.
+
+               Implies --preserve-owner.
+
+               This value can also be set through the env var
+               ROOTS_ID_MAP_FILE, though the flag takes precedence.
+	`)
+}
+
+func newIgnoreChownErrorsOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("ignore-chown-errors", false, `Continue if an owner cannot be restored
+
+               Files whose owner cannot be set (e.g. due to lacking
+               privileges) keep the owner of the current user and are
+               listed in a summary at the end of the pull.
+	`)
+}
+
+func newSELinuxLabelOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("selinux-label", "",
+		`Label the extracted files with the given SELinux context
+
+               Labels recorded in the layers (security.selinux xattrs)
+               take precedence, the given context is used for all other
+               files. Example values:
+
+               * system_u:object_r:container_file_t:s0
+	`)
+}
+
+func newValidateOnlyOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("validate-only", false, `Check that the image can be pulled
+
+               Downloads all layers into the cache, verifies their digests
+               and decompresses them, without writing to a destination.
+	`)
+}
+
+func newNumericOwnerOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("numeric-owner", false, `Record only numeric owners in the layer
+
+               Like GNU tar, the user and group names are omitted, so they
+               are not taken from the local passwd and group database.
+	`)
+}
+
+func newOwnerOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("owner", "", `Record this owner for all files in the layer
+
+               Given as NAME:UID, NAME (looked up locally) or UID, like the
+               option of GNU tar (e.g. --owner=0 or --owner=app:1000).
+	`)
+}
+
+func newGroupOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("group", "", `Record this group for all files in the layer
+
+               Given as NAME:GID, NAME (looked up locally) or GID, like the
+               option of GNU tar (e.g. --group=0 or --group=app:1000).
+	`)
+}
+
+func newDryRunOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("dry-run", false, `Show what would be extracted
+
+               Downloads all layers into the cache (or reads them from
+               there) and applies them in memory, without writing to a
+               destination. The paths each layer adds, removes or replaces
+               are shown (+, -, ~), followed by the resulting file list.
+	`)
+}
+
+func newDedupOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("dedup", false, `Store downloaded layers as deduplicated chunks
+
+               The uncompressed content of the layers is split into
+               chunks, which are shared between all layers in the cache.
+               This saves disk space if many similar images are cached,
+               at the cost of some processing time.
+
+               This value can also be enabled by setting the env var
+               ROOTS_DEDUP to 'yes'.
+	`)
+}
+
+func newVerifyTagOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("verify-tag", false, `Fail if the tag no longer points to the pinned digest
+
+               Images given with tag and digest are pulled by digest. If the
+               tag has since been pushed again, a warning is shown, unless
+               this flag turns it into an error.
+
+               This value can also be set through the env var
+               ROOTS_VERIFY_TAG=yes.`)
+}
+
+func newVerifySignatureOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("verify-signature", false, `Refuse images without valid cosign signature
+
+               The signatures stored next to the image in the registry are
+               checked against --signature-key, or against the identity of
+               keyless signatures (--certificate-identity and
+               --certificate-oidc-issuer), before anything is extracted.
+
+               This value can also be set through the env var
+               ROOTS_VERIFY_SIGNATURE=yes, or the config file.`)
+}
+
+func newSignatureKeyOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("signature-key", "", `Path to the cosign public key the image is signed with
+
+               This value can also be set through the env var
+               ROOTS_SIGNATURE_KEY, or the config file, though the flag
+               takes precedence.`)
+}
+
+func newCertificateIdentityOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("certificate-identity", "", `Identity of keyless signatures (e.g. an email address)
+
+               Keyless signatures are accepted if the Fulcio certificate was
+               issued to this identity, which may also be the URI of a
+               workflow (e.g. https://github.com/org/repo/.github/...).
+
+               This value can also be set through the env var
+               ROOTS_CERTIFICATE_IDENTITY, or the config file, though the
+               flag takes precedence.`)
+}
+
+func newCertificateIssuerOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("certificate-oidc-issuer", "", `OIDC issuer of the identity of keyless signatures
+
+               For example https://token.actions.githubusercontent.com for
+               GitHub Actions or https://github.com/login/oauth.
+
+               This value can also be set through the env var
+               ROOTS_CERTIFICATE_OIDC_ISSUER, or the config file, though
+               the flag takes precedence.`)
+}
+
+func newSigstoreRootOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("sigstore-root", "", `Directory with the Fulcio certificates and the Rekor key
+
+               Keyless signatures are verified against fulcio_v1.crt.pem,
+               fulcio_intermediate_v1.crt.pem and rekor.pub in the given
+               directory, as downloaded by cosign initialize (to the targets
+               directory in ~/.sigstore/root). With rekor.pub, signatures
+               made with a key have to be in the transparency log as well.
+
+               This value can also be set through the env var
+               ROOTS_SIGSTORE_ROOT, or the config file, though the flag
+               takes precedence.`)
+}
+
+func newAllowSchema1Opt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("allow-schema1", false, `Pull images with deprecated schema version 1 manifests
+
+               Some old registries only serve schema version 1 manifests,
+               which are refused by default. Their layers have no known size,
+               so the disk space check and the statistics do not include
+               them.
+
+               This value can also be set through the env var
+               ROOTS_ALLOW_SCHEMA1=yes.`)
+}
+
+func newLayersOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("layers", false, `List the layers with their sizes instead of the digest
+
+               Shows the compressed size of each layer, as downloaded, and
+               the uncompressed size, as extracted, if it can be determined.`)
+}
+
+func newJSONOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("json", false, "Print the result as JSON (also --output json)")
+}
+
+func newFormatOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("format", "oci", `The format the image is saved in:
+
+               * oci: An OCI image layout directory (oci-layout, index.json
+                 and blobs), which is extended if it exists
+               * docker: A tarball as written by docker save, which can be
+                 loaded with docker load or podman load`)
+}
+
+func newConfigOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("config", false, `Compare the configs of the images
+
+               Lists the added, removed and modified env vars, labels,
+               exposed ports and volumes, as well as changes to the
+               entrypoint, cmd, user, working dir, stop signal and platform.`)
+}
+
+func newFilesOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("files", false, `Compare the files of the images as well
+
+               Reads the layers of both images from the cache (downloading
+               them if necessary) and lists the added, removed and modified
+               files of the resulting trees.`)
+}
+
+func newMatchOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("match", "", "Only list the tags matching the regular expression")
+}
+
+func newSemverOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("semver", "", `Only list semantic versions matching the constraint
+
+               The constraint consists of comparisons like ">=1.2, <2", which
+               must all match, and alternatives separated by "||". Ranges
+               like "~1.2" (patch updates) and "^1.2" (minor updates) are
+               supported. The tags are ordered by version, prereleases are
+               omitted unless the constraint mentions one.`)
+}
+
+func newCreatedOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("created", false, `Order the tags by the creation time of their images
+
+               The time is read from the annotations of the manifest or from
+               the config of the image, which takes a request for each tag.`)
+}
+
+func newLatestOpt(cmd *cli.Cmd) *int {
+	return cmd.IntOpt("latest", 0, "Only list the last N tags, after ordering them")
+}
+
+func newArtifactTypeOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("artifact-type", "", `Only list artifacts of the given type
+
+               For example application/spdx+json for SPDX SBOMs or
+               application/vnd.dev.sigstore.bundle.v0.3+json for sigstore
+               bundles. Artifacts without type are matched by media type.
+	`)
+}
+
+func newIfChangedOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("if-changed", false, `Only pull if the digest of the image changed
+
+               The digest of the last pull is recorded in DEST/.roots/digest,
+               if it matches the image, nothing is done (not even the hooks
+               are run). Pulls without --force or --merge are skipped this
+               way by default, instead of failing as DEST is not empty.
+
+               Cannot be combined with --no-history.
+	`)
+}
+
+func newNoHistoryOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("no-history", false, `Do not record the pull in the destination
+
+               By default, each pull is appended to DEST/.roots/history.jsonl
+               (including forced pulls), with the image, digest, platform,
+               duration and user. The extracted tree is recorded in
+               DEST/.roots/mtree.json, for 'roots status --verify'.
+	`)
+}
+
+func newResolveOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("resolve", false, `Show what the image name expands to, without pulling
+
+               Lists the candidates in the order they are tried, after
+               applying the aliases, search registries and mirrors of
+               registries.conf.
+	`)
+}
+
+func newPolicyOpt(cmd *cli.Cmd) *string {
+	return cmd.StringOpt("policy", "",
+		`Path to a containers-policy.json(5) file enforced before pulling
+
+               Use /etc/containers/policy.json to share the policy of
+               podman and skopeo. Images requiring signatures (signedBy,
+               sigstoreSigned) are rejected, as roots cannot verify them.
+
+               This value can also be set through the env var ROOTS_POLICY,
+               or the config file, though the flag takes precedence.
+	`)
+}
+
+func newVerifyOpt(cmd *cli.Cmd) *bool {
+	return cmd.BoolOpt("verify", false, `Compare the destination against the tree recorded by the last pull
+
+               Reports added, removed and modified files (type, mode, size,
+               owner, content or link), exits with 1 if there are changes.
 	`)
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDefaultDestination tests the destinations of images pulled without one
+func TestDefaultDestination(t *testing.T) {
+	digest := "sha256:7039cc5d8722d610fe7788b6ee019147f349afad5ba8130bc239f964003c21a2"
+
+	tests := []struct {
+		image    string
+		template string
+		dest     string
+	}{
+		{"debian", "", "debian-latest"},
+		{"debian:bookworm", "", "debian-bookworm"},
+		{"debian@" + digest, "", "debian-latest"},
+		{"debian:bookworm@" + digest, "", "debian-bookworm"},
+		{"team/app:1.0", "", "app-1.0"},
+		{"quay.io/org/team/app:1.0", "", "app-1.0"},
+		{"quay.io/org/team/app:1.0", "{repository}/{name}", "org/team/app"},
+		{"localhost:5000/app", "", "app-latest"},
+		{"registry.example.org:5000/team/app:1.0", "", "app-1.0"},
+		{"registry.example.org:5000/team/app:1.0", "{repository}-{name}-{tag}", "team-app-1.0"},
+		{"docker.io/library/ubuntu:24.04", "{repository}-{name}", "library-ubuntu"},
+		{"docker-daemon:app:1.0", "", "app-1.0"},
+		{"oci:./images/app:2.0", "", "app-2.0"},
+		{"docker-archive:./app.tar", "", "app-latest"},
+	}
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	for _, test := range tests {
+		config = &Config{DestinationTemplate: test.template}
+		assert.Equal(t, filepath.Join(wd, test.dest), defaultDestination(test.image), test.image)
+
+		config = &Config{DestinationTemplate: test.template, DestinationDir: "/srv/images"}
+		assert.Equal(t, filepath.Join("/srv/images", test.dest), defaultDestination(test.image), test.image)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/roots"
+)
+
+// server offers pull, digest, purge and status through a REST API. As the
+// process is long-lived, the cache is shared between all requests and the
+// clients of the providers (including their tokens) are reused.
+type server struct {
+	ctx    context.Context
+	store  *image.Store
+	policy string
+	notify string
+
+	// requireDigest refuses pulls of references without digest
+	requireDigest bool
+
+	// signature refuses images without valid signature (may be nil)
+	signature *image.SignatureVerifier
+}
+
+// newServer returns a server for the given store, which applies the checks
+// of the config file and the environment to all pulls
+func newServer(ctx context.Context, store *image.Store, policy string, notify string) *server {
+	s := &server{
+		ctx:           ctx,
+		store:         store,
+		policy:        policy,
+		notify:        notify,
+		requireDigest: os.Getenv("ROOTS_REQUIRE_DIGEST") == "yes" || config.RequireDigest,
+	}
+
+	// the keys and roots are loaded once, exiting if they are missing
+	if os.Getenv("ROOTS_VERIFY_SIGNATURE") == "yes" || config.VerifySignature {
+		s.signature = signatureVerifier("", "", "", "")
+	}
+
+	return s
+}
+
+// pullRequest is the body of POST /pull
+type pullRequest struct {
+	Image          string `json:"image"`
+	Destination    string `json:"destination"`
+	Arch           string `json:"arch"`
+	OS             string `json:"os"`
+	Variant        string `json:"variant"`
+	Force          bool   `json:"force"`
+	PreserveOwner  bool   `json:"preserve_owner"`
+	PreserveXattrs bool   `json:"preserve_xattrs"`
+	NoHistory      bool   `json:"no_history"`
+}
+
+// pullProgress is streamed to the client as a JSON line for each extracted
+// layer ("layer"), followed by the outcome of the pull ("done" or "error")
+type pullProgress struct {
+	Event string `json:"event"`
+	*image.LayerProgress
+	Result *image.ExtractResult `json:"result,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// defaultSocket returns the path of the unix socket served by default
+func defaultSocket() string {
+	if usr, err := user.Current(); err == nil && usr.Uid == "0" {
+		return "/run/roots.sock"
+	}
+
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return path.Join(dir, "roots.sock")
+	}
+
+	return path.Join(os.TempDir(), "roots.sock")
+}
+
+// serve listens on the given unix socket until the context is cancelled
+func (s *server) serve(socket string) error {
+
+	// a socket left behind by a previous process prevents listening
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove %s: %v", socket, err)
+	}
+
+	listener, err := listen(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pull", s.pull)
+	mux.HandleFunc("GET /digest", s.digest)
+	mux.HandleFunc("POST /purge", s.purge)
+	mux.HandleFunc("GET /status", s.status)
+
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		<-s.ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("serving on %s", socket)
+
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// listen listens on the given unix socket, which only the owner may use to
+// drive pulls. The socket is created in a private directory and moved into
+// place once it is restricted, so no other user may connect in between.
+func listen(socket string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socket), ".roots-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create a directory next to %s: %v", socket, err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, filepath.Base(socket))
+
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %v", socket, err)
+	}
+
+	if err := os.Chmod(private, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict %s: %v", socket, err)
+	}
+
+	if err := os.Rename(private, socket); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not move %s into place: %v", socket, err)
+	}
+
+	return listener, nil
+}
+
+// source opens the given image, which is a remote unless the name selects a
+// local source
+func (s *server) source(ctx context.Context, name string, arch string, ops string, variant string) (image.Source, error) {
+	platform := newPlatform(&arch, &ops, &variant)
+
+	if transport, local, ok := roots.LocalSource(name); ok {
+		return openLocalSource(ctx, transport, local, platform)
+	}
+
+	remote, err := connectRemote(ctx, name, valueOrEnv("", "ROOTS_AUTH", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", name, err)
+	}
+
+	if platform != nil {
+		remote.WithPlatform(platform)
+	}
+
+	return remote, nil
+}
+
+// closeSource releases the files held by local sources, like the archives
+// exported by the docker daemon
+func closeSource(source image.Source) {
+	if c, ok := source.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (s *server) pull(w http.ResponseWriter, r *http.Request) {
+	req := &pullRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+
+	if req.Image == "" || !filepath.IsAbs(req.Destination) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("an image and an absolute destination are required"))
+		return
+	}
+
+	if err := policyError(req.Image, s.policy); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	source, err := s.source(r.Context(), req.Image, req.Arch, req.OS, req.Variant)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer closeSource(source)
+
+	start := time.Now()
+
+	// the progress is streamed once the first layer is extracted, errors
+	// before that are answered with a status code
+	streaming := false
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	send := func(p *pullProgress) {
+		if !streaming {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+
+		encoder.Encode(p)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	opts := &image.ExtractOptions{
+		PreserveOwnership: req.PreserveOwner,
+		PreserveXattrs:    req.PreserveXattrs,
+		ExpansionFactor:   expansionFactor(""),
+		Workers:           extractWorkers(""),
+		LockWaiting:       logLockWait,
+	}
+	opts.Progress = func(l *image.LayerProgress) {
+		send(&pullProgress{Event: "layer", LayerProgress: l})
+	}
+
+	result, err := newPuller().PullSource(r.Context(), req.Image, source, req.Destination, &roots.PullOptions{
+		Store:         s.store,
+		Force:         req.Force,
+		NoHistory:     req.NoHistory,
+		Extract:       opts,
+		RequireDigest: s.requireDigest,
+		Signature:     s.signature,
+		PreHook: func(source image.Source) error {
+			return runHooks("pre", "", hookEnv("pre", source, req.Destination, nil))
+		},
+		PostHook: func(source image.Source, result *image.ExtractResult) error {
+			return runHooks("post", "", hookEnv("post", source, req.Destination, result))
+		},
+	})
+
+	if err != nil || !result.UpToDate {
+		notifyPull(s.notify, source, req.Destination, start, result, err)
+	}
+
+	if err != nil {
+		log.Printf("error during pull of %s: %v", req.Image, err)
+
+		if !streaming {
+			writeError(w, pullStatus(err), err)
+			return
+		}
+
+		send(&pullProgress{Event: "error", Error: err.Error()})
+		return
+	}
+
+	if result.UpToDate {
+		log.Printf("%s is up to date (%s)", req.Destination, result.Digest)
+	} else {
+		log.Printf("pulled %s to %s", source, req.Destination)
+	}
+
+	send(&pullProgress{Event: "done", Result: result})
+}
+
+// pullStatus returns the status code of pulls failing before any layer was
+// extracted, by the class of the error
+func pullStatus(err error) int {
+	switch newErrorReport("", err).Class {
+	case "signature", "policy":
+		return http.StatusForbidden
+	case "auth", "not_found", "rate_limit", "registry", "network", "timeout":
+		return http.StatusBadGateway
+	}
+
+	return http.StatusConflict
+}
+
+func (s *server) digest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("image") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("an image is required"))
+		return
+	}
+
+	source, err := s.source(r.Context(), query.Get("image"), query.Get("arch"), query.Get("os"), query.Get("variant"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer closeSource(source)
+
+	// remotes resolve the digest without downloading the manifest
+	var digest string
+
+	if remote, ok := source.(*image.Remote); ok {
+		digest, err = remote.Digest()
+	} else {
+		var manifest *image.Manifest
+		if manifest, err = source.Manifest(); err == nil {
+			digest = manifest.Digest
+		}
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"digest": digest})
+}
+
+func (s *server) purge(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Purge(); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("error during purge: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	dst := r.URL.Query().Get("destination")
+
+	if !filepath.IsAbs(dst) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("an absolute destination is required"))
+		return
+	}
+
+	history, err := image.ReadHistory(dst)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("could not read history of %s: %v", dst, err))
+		return
+	}
+
+	status := &destinationStatus{Destination: dst, History: history}
+
+	if r.URL.Query().Get("verify") == "true" {
+		if status.Changes, err = image.VerifyTree(dst); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("could not verify %s: %v", dst, err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// writeJSON writes the given value as JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the given error as JSON response, including its class
+// and a hint, like the errors printed by pull --json
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, newErrorReport("", err))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seantis/roots/pkg/image"
+	"github.com/seantis/roots/pkg/registrytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer returns a server with a store in a temporary directory,
+// applying the given config
+func newTestServer(t *testing.T, c *Config) *server {
+	previous := config
+	config = c
+	t.Cleanup(func() { config = previous })
+
+	store, err := image.NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	return newServer(context.Background(), store, "", "")
+}
+
+// pushTestImage pushes a single layer image for the host platform
+func pushTestImage(registry *registrytest.Registry, repo string, tag string) string {
+	host := image.HostPlatform()
+
+	return registry.Push(repo, tag, registrytest.Image{
+		OS: host.OS, Architecture: host.Architecture, Variant: host.Variant,
+		Layers: [][]byte{registrytest.Tar(map[string]string{"hello": "world"})},
+	})
+}
+
+// servePull sends a pull request to the server, returning the response and
+// the events streamed by it
+func servePull(s *server, img string, dest string) (*httptest.ResponseRecorder, []*pullProgress) {
+	body, _ := json.Marshal(&pullRequest{Image: img, Destination: dest})
+
+	w := httptest.NewRecorder()
+	s.pull(w, httptest.NewRequest("POST", "/pull", strings.NewReader(string(body))))
+
+	events := []*pullProgress{}
+
+	if w.Header().Get("Content-Type") == "application/x-ndjson" {
+		lines := bufio.NewScanner(w.Body)
+		for lines.Scan() {
+			event := &pullProgress{}
+			json.Unmarshal(lines.Bytes(), event)
+			events = append(events, event)
+		}
+	}
+
+	return w, events
+}
+
+// TestServePull tests pulling an image through the server, which records
+// the config of the image like the pull command
+func TestServePull(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := pushTestImage(registry, "team/app", "1.0")
+
+	s := newTestServer(t, &Config{})
+	dest := filepath.Join(t.TempDir(), "app")
+
+	w, events := servePull(s, registry.Host()+"/team/app:1.0", dest)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"layer", "done"}, []string{events[0].Event, events[1].Event})
+	assert.Equal(t, digest, events[1].Result.Digest)
+	assert.FileExists(t, filepath.Join(dest, "hello"))
+
+	// the config is recorded like by pull
+	pulled, err := image.ReadConfig(image.ConfigPath(dest))
+	assert.NoError(t, err)
+	assert.Equal(t, digest, pulled.Digest)
+
+	// pulling the same image again does nothing
+	w, events = servePull(s, registry.Host()+"/team/app:1.0", dest)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, events, 1)
+	assert.True(t, events[0].Result.UpToDate)
+}
+
+// TestServeRequireDigest tests that the server refuses to pull tags if the
+// config requires a digest
+func TestServeRequireDigest(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	digest := pushTestImage(registry, "team/app", "1.0")
+
+	s := newTestServer(t, &Config{RequireDigest: true})
+	dest := filepath.Join(t.TempDir(), "app")
+
+	w, _ := servePull(s, registry.Host()+"/team/app:1.0", dest)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	report := &errorReport{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(report))
+	assert.Equal(t, "policy", report.Class)
+	assert.Contains(t, report.Error, "@"+digest)
+	assert.NoDirExists(t, dest)
+
+	w, events := servePull(s, registry.Host()+"/team/app@"+digest, dest)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "done", events[len(events)-1].Event)
+}
+
+// TestServeVerifySignature tests that the server refuses unsigned images if
+// the config requires signatures
+func TestServeVerifySignature(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	pushTestImage(registry, "team/app", "1.0")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	s := newTestServer(t, &Config{VerifySignature: true, SignatureKey: file})
+	dest := filepath.Join(t.TempDir(), "app")
+
+	w, _ := servePull(s, registry.Host()+"/team/app:1.0", dest)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	report := &errorReport{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(report))
+	assert.Equal(t, "signature", report.Class)
+	assert.Contains(t, report.Error, "no signatures found")
+	assert.NoDirExists(t, dest)
+}
+
+// TestServeDockerHub tests that the tokens of the Docker Hub are reused for
+// the image they were issued for, not for others in the same namespace
+func TestServeDockerHub(t *testing.T) {
+	registry := registrytest.NewRegistry()
+	defer registry.Close()
+
+	pushTestImage(registry, "library/ubuntu", "latest")
+	pushTestImage(registry, "library/debian", "latest")
+
+	upstream, err := url.Parse(registry.Host())
+	assert.NoError(t, err)
+
+	// the hub issues tokens for a single repository, like the Docker Hub
+	hub := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			fmt.Fprintf(w, `{"token": "%s"}`, r.URL.Query().Get("scope"))
+			return
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/"), "/", 3)
+		if len(parts) == 3 && r.Header.Get("Authorization") != fmt.Sprintf("Bearer repository:%s/%s:pull", parts[0], parts[1]) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		httputil.NewSingleHostReverseProxy(upstream).ServeHTTP(w, r)
+	}))
+	defer hub.Close()
+
+	// the proxy tunnels the connections to registry-1.docker.io and
+	// auth.docker.io to the hub
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := net.Dial("tcp", hub.Listener.Addr().String())
+		assert.NoError(t, err)
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+
+		conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+		go func() {
+			io.Copy(target, conn)
+			target.Close()
+		}()
+
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	assert.NoError(t, image.ConfigureTransport(image.TransportOptions{Proxy: proxy.URL, InsecureSkipTLSVerify: true}))
+	t.Cleanup(func() { image.ConfigureTransport(image.TransportOptions{}) })
+
+	s := newTestServer(t, &Config{})
+
+	for _, name := range []string{"ubuntu", "debian", "ubuntu"} {
+		dest := filepath.Join(t.TempDir(), name)
+
+		w, events := servePull(s, "docker.io/library/"+name, dest)
+		assert.Equal(t, http.StatusOK, w.Code, name)
+		assert.Equal(t, "done", events[len(events)-1].Event, name)
+		assert.FileExists(t, filepath.Join(dest, "hello"), name)
+	}
+}
+
+// TestServeSocket tests that the socket of the server is only accessible to
+// its owner and replaces sockets left behind
+func TestServeSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "roots.sock")
+
+	for i := 0; i < 2; i++ {
+		listener, err := listen(socket)
+		assert.NoError(t, err)
+
+		info, err := os.Stat(socket)
+		assert.NoError(t, err)
+		assert.Equal(t, os.ModeSocket|0600, info.Mode())
+
+		conn, err := net.Dial("unix", socket)
+		assert.NoError(t, err)
+		conn.Close()
+
+		listener.Close()
+		os.Remove(socket)
+	}
+
+	// the private directory is removed
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}